@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+//+kubebuilder:object:root=true
+
+// OperatorConfig is the configuration file of the operator. Besides the
+// settings of the controller manager it holds the defaults of the operator.
+// Flags given on the command line take precedence over the file.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec configures the manager, e.g. the
+	// metrics address, leader election and the concurrency per kind
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// DefaultImage is the mo-daemon image of HomeAgents which set none
+	// +optional
+	DefaultImage string `json:"defaultImage,omitempty"`
+
+	// AgentRulesClusterRole is the ClusterRole holding the API rules
+	// HomeAgents may grant their daemon
+	// +optional
+	AgentRulesClusterRole string `json:"agentRulesClusterRole,omitempty"`
+
+	// HardenedToolsImage is the image setcap runs from for hardened agents,
+	// the agent image if empty
+	// +optional
+	HardenedToolsImage string `json:"hardenedToolsImage,omitempty"`
+
+	// MaxConcurrentReconciles is the number of objects of a kind reconciled
+	// concurrently, unless set for the kind in groupKindConcurrency
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// RequeueInterval is the first interval a waiting HomeAgent is
+	// requeued after
+	// +optional
+	RequeueInterval *metav1.Duration `json:"requeueInterval,omitempty"`
+
+	// MaxRequeueInterval is the longest interval a waiting HomeAgent is
+	// requeued after
+	// +optional
+	MaxRequeueInterval *metav1.Duration `json:"maxRequeueInterval,omitempty"`
+
+	// WatchNamespaces restricts the operator to the namespaces, it watches
+	// every namespace if empty
+	// +optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// DiagnosticsBindAddress is the address pprof and the runtime
+	// statistics are served on, disabled if empty
+	// +optional
+	DiagnosticsBindAddress string `json:"diagnosticsBindAddress,omitempty"`
+
+	// TracingEndpoint is the OTLP/HTTP endpoint reconcile spans are
+	// exported to, tracing is disabled if empty
+	// +optional
+	TracingEndpoint string `json:"tracingEndpoint,omitempty"`
+
+	// Audit logs every change the operator makes to the cluster
+	// +optional
+	Audit bool `json:"audit,omitempty"`
+
+	// AuditHistory is the number of changes kept in the status of every
+	// HomeAgent if Audit is set
+	// +optional
+	AuditHistory int `json:"auditHistory,omitempty"`
+
+	// DryRun makes the operator only report the changes it would make
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// GrafanaDashboardNamespace is the namespace the ConfigMap of the
+	// Grafana dashboard is kept in, no dashboard is created if empty
+	// +optional
+	GrafanaDashboardNamespace string `json:"grafanaDashboardNamespace,omitempty"`
+
+	// FRRNamespace is the namespace of frr-k8s, which the FRRConfigurations
+	// advertising the home prefixes are kept in
+	// +optional
+	FRRNamespace string `json:"frrNamespace,omitempty"`
+
+	// BGPAllowedPrefixes are the networks HomeAgents may advertise prefixes
+	// of over BGP, none if empty
+	// +optional
+	BGPAllowedPrefixes []string `json:"bgpAllowedPrefixes,omitempty"`
+
+	// BGPAllowedPeers are the networks of the routers HomeAgents may peer
+	// with over BGP, none if empty
+	// +optional
+	BGPAllowedPeers []string `json:"bgpAllowedPeers,omitempty"`
+
+	// TelemetryEndpoint is the endpoint anonymous usage is reported to,
+	// nothing is reported if empty
+	// +optional
+	TelemetryEndpoint string `json:"telemetryEndpoint,omitempty"`
+
+	// FeatureGates switches optional behaviour of the operator on or off
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorConfig{})
+}
+
+// Complete returns the settings of the controller manager.
+func (c *OperatorConfig) Complete() (cfg.ControllerManagerConfigurationSpec, error) {
+	return c.ControllerManagerConfigurationSpec, nil
+}
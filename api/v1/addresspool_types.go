@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AllocationPolicy decides which free address is handed out next
+// +kubebuilder:validation:Enum=Sequential;Random
+type AllocationPolicy string
+
+const (
+	// AllocationSequential hands out the lowest free address.
+	AllocationSequential AllocationPolicy = "Sequential"
+	// AllocationRandom hands out a random free address.
+	AllocationRandom AllocationPolicy = "Random"
+)
+
+// AddressPoolSpec defines the desired state of AddressPool
+type AddressPoolSpec struct {
+	// CIDRs are the ranges addresses are handed out from.
+	// +kubebuilder:validation:MinItems=1
+	CIDRs []string `json:"cidrs"`
+
+	// Reserved addresses are never handed out, e.g. gateways or addresses
+	// managed outside of the operator.
+	// +optional
+	Reserved []string `json:"reserved,omitempty"`
+
+	// Policy decides which free address is handed out next, defaults to Sequential.
+	// +optional
+	Policy AllocationPolicy `json:"policy,omitempty"`
+}
+
+// AddressAllocation is an address handed out from the pool
+type AddressAllocation struct {
+	// Address is the allocated address.
+	Address string `json:"address"`
+
+	// Owner is the object holding the address, as "<Kind>/<name>".
+	Owner string `json:"owner"`
+}
+
+// AddressPoolStatus defines the observed state of AddressPool
+type AddressPoolStatus struct {
+	// Allocations are the addresses currently handed out
+	// +optional
+	Allocations []AddressAllocation `json:"allocations,omitempty"`
+
+	// Allocated is the number of addresses handed out
+	Allocated int32 `json:"allocated"`
+
+	// Capacity is the number of addresses the pool can hand out
+	// +optional
+	Capacity string `json:"capacity,omitempty"`
+
+	// Conditions represent the latest available observations of the AddressPool's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionReady is true once the resource is fully functional. A
+	// HomeAgent is ready once every replica of the current generation is,
+	// what kubectl wait and the health checks of GitOps tools look for.
+	ConditionReady = "Ready"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="CIDRs",type=string,JSONPath=`.spec.cidrs`
+//+kubebuilder:printcolumn:name="Allocated",type=integer,JSONPath=`.status.allocated`
+//+kubebuilder:printcolumn:name="Capacity",type=string,JSONPath=`.status.capacity`
+
+// AddressPool is the Schema for the addresspools API. The operator hands out
+// home addresses from it and keeps track of them in the status.
+type AddressPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AddressPoolSpec   `json:"spec,omitempty"`
+	Status AddressPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AddressPoolList contains a list of AddressPool
+type AddressPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AddressPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AddressPool{}, &AddressPoolList{})
+}
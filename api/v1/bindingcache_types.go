@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BindingCacheSpec defines the desired state of BindingCache
+type BindingCacheSpec struct {
+	// HomeAgentRef names the HomeAgent whose bindings are mirrored.
+	HomeAgentRef corev1.LocalObjectReference `json:"homeAgentRef"`
+}
+
+// BindingEntry is a binding held by one of the home agent replicas
+type BindingEntry struct {
+	// HomeAddress is the home address of the mobile node.
+	HomeAddress string `json:"homeAddress"`
+
+	// CareOfAddress is the address the mobile node is currently reachable at.
+	CareOfAddress string `json:"careOfAddress"`
+
+	// Lifetime is the remaining lifetime of the binding in seconds.
+	Lifetime int32 `json:"lifetime"`
+
+	// Flags are the flags the binding was registered with, e.g. "H", "L", "K".
+	// +optional
+	Flags []string `json:"flags,omitempty"`
+
+	// Replica is the pod holding the binding.
+	Replica string `json:"replica"`
+}
+
+// BindingCacheStatus defines the observed state of BindingCache
+type BindingCacheStatus struct {
+	// Bindings are the active bindings of all replicas
+	// +optional
+	Bindings []BindingEntry `json:"bindings,omitempty"`
+
+	// Count is the number of active bindings
+	Count int32 `json:"count"`
+
+	// LastSyncTime is the last time the bindings were read from the agents
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// UnreachableReplicas lists the replicas whose bindings could not be read
+	// during the last sync
+	// +optional
+	UnreachableReplicas []string `json:"unreachableReplicas,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Home Agent",type=string,JSONPath=`.spec.homeAgentRef.name`
+//+kubebuilder:printcolumn:name="Bindings",type=integer,JSONPath=`.status.count`
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+
+// BindingCache is the Schema for the bindingcaches API. It mirrors the
+// binding caches of a HomeAgent's replicas and is maintained by the operator.
+type BindingCache struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BindingCacheSpec   `json:"spec,omitempty"`
+	Status BindingCacheStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BindingCacheList contains a list of BindingCache
+type BindingCacheList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BindingCache `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BindingCache{}, &BindingCacheList{})
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HandoffEndpoint is a HomeAgent in a member cluster of a federation
+type HandoffEndpoint struct {
+	// Member is the name of the federation member.
+	Member string `json:"member"`
+
+	// HomeAgent is the name of the HomeAgent in the member.
+	HomeAgent string `json:"homeAgent"`
+}
+
+// BindingHandoffSpec defines the desired state of BindingHandoff
+type BindingHandoffSpec struct {
+	// FederationRef names the Federation in the same namespace the members
+	// belong to.
+	FederationRef corev1.LocalObjectReference `json:"federationRef"`
+
+	// HomeAddress is the home address of the roaming mobile node.
+	// +kubebuilder:validation:Format=ipv6
+	HomeAddress string `json:"homeAddress"`
+
+	// From is the HomeAgent currently holding the binding.
+	From HandoffEndpoint `json:"from"`
+
+	// To is the HomeAgent taking over the binding.
+	To HandoffEndpoint `json:"to"`
+
+	// Timeout bounds the handoff, defaults to 30s. A handoff which can't
+	// complete in time is rolled back to the source.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// HandoffPhase is the progress of a binding handoff
+type HandoffPhase string
+
+const (
+	// HandoffPending means the binding hasn't been exported yet.
+	HandoffPending HandoffPhase = "Pending"
+	// HandoffImporting means the binding was exported from the source and
+	// is being installed on the target.
+	HandoffImporting HandoffPhase = "Importing"
+	// HandoffDeregistering means the target holds the binding and the
+	// source is being deregistered.
+	HandoffDeregistering HandoffPhase = "Deregistering"
+	// HandoffCompleted means the target holds the binding.
+	HandoffCompleted HandoffPhase = "Completed"
+	// HandoffFailed means the handoff was given up, the source keeps the
+	// binding.
+	HandoffFailed HandoffPhase = "Failed"
+)
+
+// BindingHandoffStatus defines the observed state of BindingHandoff
+type BindingHandoffStatus struct {
+	// Phase is the progress of the handoff
+	// +optional
+	Phase HandoffPhase `json:"phase,omitempty"`
+
+	// SourceReplica is the pod of the source HomeAgent the binding was
+	// exported from
+	// +optional
+	SourceReplica string `json:"sourceReplica,omitempty"`
+
+	// TargetReplica is the pod of the target HomeAgent the binding was
+	// imported into
+	// +optional
+	TargetReplica string `json:"targetReplica,omitempty"`
+
+	// CareOfAddress is the care-of address of the exported binding
+	// +optional
+	CareOfAddress string `json:"careOfAddress,omitempty"`
+
+	// Lifetime is the remaining lifetime of the exported binding in seconds
+	// +optional
+	Lifetime int32 `json:"lifetime,omitempty"`
+
+	// Sequence is the sequence number of the exported binding
+	// +optional
+	Sequence int32 `json:"sequence,omitempty"`
+
+	// StartTime is when the handoff started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the handoff completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message explains the last error of the handoff
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Home Address",type=string,JSONPath=`.spec.homeAddress`
+//+kubebuilder:printcolumn:name="From",type=string,JSONPath=`.spec.from.member`
+//+kubebuilder:printcolumn:name="To",type=string,JSONPath=`.spec.to.member`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+
+// BindingHandoff is the Schema for the bindinghandoffs API. It moves the
+// binding of a mobile node roaming between the HomeAgents of two clusters
+// of a federation.
+type BindingHandoff struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BindingHandoffSpec   `json:"spec,omitempty"`
+	Status BindingHandoffStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BindingHandoffList contains a list of BindingHandoff
+type BindingHandoffList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BindingHandoff `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BindingHandoff{}, &BindingHandoffList{})
+}
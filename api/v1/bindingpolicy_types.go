@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PolicyAction decides whether a matching registration is accepted
+// +kubebuilder:validation:Enum=Allow;Deny
+type PolicyAction string
+
+const (
+	// PolicyAllow accepts the registration.
+	PolicyAllow PolicyAction = "Allow"
+	// PolicyDeny rejects the registration.
+	PolicyDeny PolicyAction = "Deny"
+)
+
+// BindingRule matches registrations by the NAI of the mobile node and its
+// home address. A rule without any match criteria matches every registration.
+type BindingRule struct {
+	// Action is taken for registrations matching the rule.
+	Action PolicyAction `json:"action"`
+
+	// NAI is a glob pattern matched against the network access identifier
+	// of the mobile node, e.g. "*@example.com".
+	// +optional
+	NAI string `json:"nai,omitempty"`
+
+	// HomeAddressRange is a CIDR the home address has to lie within.
+	// +optional
+	HomeAddressRange string `json:"homeAddressRange,omitempty"`
+
+	// RateLimit limits the traffic of every mobile node the rule allows,
+	// in place of spec.shaping.perNode of the HomeAgent.
+	// +optional
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// BindingPolicySpec defines the desired state of BindingPolicy
+type BindingPolicySpec struct {
+	// HomeAgentSelector selects the HomeAgents in the policy's namespace the
+	// policy applies to. An empty selector selects every HomeAgent.
+	// +optional
+	HomeAgentSelector metav1.LabelSelector `json:"homeAgentSelector,omitempty"`
+
+	// Rules are evaluated in order, the first matching rule decides.
+	// +optional
+	Rules []BindingRule `json:"rules,omitempty"`
+
+	// DefaultAction is taken for registrations no rule matches, defaults to Allow.
+	// +optional
+	DefaultAction PolicyAction `json:"defaultAction,omitempty"`
+
+	// Priority orders the policies selecting a HomeAgent. The rules of
+	// higher priority policies are evaluated first, and the policy with the
+	// highest priority setting a DefaultAction decides the default.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// MaxBindingsPerNode limits the number of simultaneous bindings of a
+	// single mobile node.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxBindingsPerNode int32 `json:"maxBindingsPerNode,omitempty"`
+}
+
+// BindingPolicyStatus defines the observed state of BindingPolicy
+type BindingPolicyStatus struct {
+	// HomeAgents are the names of the HomeAgents the policy applies to
+	// +optional
+	HomeAgents []string `json:"homeAgents,omitempty"`
+
+	// Conditions represent the latest available observations of the BindingPolicy's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Default",type=string,JSONPath=`.spec.defaultAction`
+//+kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+//+kubebuilder:printcolumn:name="Home Agents",type=string,JSONPath=`.status.homeAgents`
+
+// BindingPolicy is the Schema for the bindingpolicies API. It decides which
+// registrations the selected home agents accept.
+type BindingPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BindingPolicySpec   `json:"spec,omitempty"`
+	Status BindingPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BindingPolicyList contains a list of BindingPolicy
+type BindingPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BindingPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BindingPolicy{}, &BindingPolicyList{})
+}
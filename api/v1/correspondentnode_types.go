@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CorrespondentNodeSpec defines the desired state of CorrespondentNode
+type CorrespondentNodeSpec struct {
+	// Address is the address of the correspondent node.
+	// +kubebuilder:validation:Format=ipv6
+	Address string `json:"address"`
+
+	// HomeAgentSelector selects the HomeAgents in the same namespace which
+	// accept route optimization with the correspondent node. An empty
+	// selector selects every HomeAgent.
+	// +optional
+	HomeAgentSelector metav1.LabelSelector `json:"homeAgentSelector,omitempty"`
+
+	// AuthSecretRef selects the key binding updates exchanged with the
+	// correspondent node are authorized with.
+	AuthSecretRef corev1.SecretKeySelector `json:"authSecretRef"`
+}
+
+// RouteOptimizationSession is a route optimized session between a mobile
+// node and the correspondent node
+type RouteOptimizationSession struct {
+	// HomeAddress is the home address of the mobile node.
+	HomeAddress string `json:"homeAddress"`
+
+	// CareOfAddress is the care-of address the correspondent node sends to.
+	CareOfAddress string `json:"careOfAddress"`
+
+	// State is the state of the session as reported by mo-daemon, e.g.
+	// ReturnRoutability or Established.
+	State string `json:"state"`
+
+	// Replica is the agent pod the session was read from.
+	Replica string `json:"replica"`
+}
+
+// CorrespondentNodeStatus defines the observed state of CorrespondentNode
+type CorrespondentNodeStatus struct {
+	// HomeAgents are the names of the HomeAgents holding the authorization material
+	// +optional
+	HomeAgents []string `json:"homeAgents,omitempty"`
+
+	// Sessions are the route optimization sessions with the correspondent node
+	// +optional
+	Sessions []RouteOptimizationSession `json:"sessions,omitempty"`
+
+	// ActiveSessions is the number of sessions
+	ActiveSessions int32 `json:"activeSessions"`
+
+	// LastSyncTime is when the sessions were last read from the agents
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions represent the latest available observations of the CorrespondentNode's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// ConditionDistributed is true once the authorization material of a
+	// correspondent node has been handed to the selected home agents.
+	ConditionDistributed = "Distributed"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.spec.address`
+//+kubebuilder:printcolumn:name="Sessions",type=integer,JSONPath=`.status.activeSessions`
+//+kubebuilder:printcolumn:name="Home Agents",type=string,JSONPath=`.status.homeAgents`
+
+// CorrespondentNode is the Schema for the correspondentnodes API. It is a
+// peer mobile nodes may use route optimization with.
+type CorrespondentNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CorrespondentNodeSpec   `json:"spec,omitempty"`
+	Status CorrespondentNodeStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// CorrespondentNodeList contains a list of CorrespondentNode
+type CorrespondentNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CorrespondentNode `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CorrespondentNode{}, &CorrespondentNodeList{})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FederationMember is a cluster taking part in the federation
+type FederationMember struct {
+	// Name identifies the member within the federation.
+	Name string `json:"name"`
+
+	// KubeconfigSecretRef selects the kubeconfig the operator reaches the
+	// member cluster with, from a Secret in the namespace of the Federation.
+	KubeconfigSecretRef corev1.SecretKeySelector `json:"kubeconfigSecretRef"`
+
+	// Namespace is the namespace of the member cluster holding its
+	// HomeAgents, defaults to the namespace of the Federation.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// FederationSpec defines the desired state of Federation
+type FederationSpec struct {
+	// Members are the clusters taking part in the federation.
+	Members []FederationMember `json:"members"`
+
+	// CIDR is the range every member is assigned a share of.
+	CIDR string `json:"cidr"`
+
+	// MemberPrefixLength is the prefix length of the share of every member.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=128
+	MemberPrefixLength int32 `json:"memberPrefixLength"`
+
+	// HomeAgentSelector selects the HomeAgents of the members reported in
+	// the status. An empty selector selects every HomeAgent.
+	// +optional
+	HomeAgentSelector metav1.LabelSelector `json:"homeAgentSelector,omitempty"`
+}
+
+// FederatedHomeAgent is a HomeAgent of a member cluster
+type FederatedHomeAgent struct {
+	// Name is the name of the HomeAgent.
+	Name string `json:"name"`
+
+	// Replicas is the number of replicas reported by the HomeAgent.
+	Replicas int32 `json:"replicas"`
+
+	// Bindings is the number of bindings held by the replicas.
+	Bindings int32 `json:"bindings"`
+}
+
+// FederationMemberStatus is the observed state of a member cluster
+type FederationMemberStatus struct {
+	// Name is the name of the member.
+	Name string `json:"name"`
+
+	// Prefix is the share of the federation range assigned to the member.
+	// It is published to the member as an AddressPool named after the
+	// Federation.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Reachable is false if the member cluster could not be reached.
+	Reachable bool `json:"reachable"`
+
+	// Message explains why the member could not be reached.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// HomeAgents are the selected HomeAgents of the member.
+	// +optional
+	HomeAgents []FederatedHomeAgent `json:"homeAgents,omitempty"`
+
+	// Bindings is the number of bindings held in the member.
+	Bindings int32 `json:"bindings"`
+
+	// LastSyncTime is when the member was last read.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// FederationStatus defines the observed state of Federation
+type FederationStatus struct {
+	// Members reports the state of every member cluster
+	// +optional
+	Members []FederationMemberStatus `json:"members,omitempty"`
+
+	// Bindings is the number of bindings held across the federation
+	Bindings int32 `json:"bindings"`
+
+	// Conditions represent the latest available observations of the Federation's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="CIDR",type=string,JSONPath=`.spec.cidr`
+//+kubebuilder:printcolumn:name="Bindings",type=integer,JSONPath=`.status.bindings`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// Federation is the Schema for the federations API. The operator acting as
+// primary assigns every member cluster a share of the federation range and
+// keeps a global view of their HomeAgents.
+type Federation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FederationSpec   `json:"spec,omitempty"`
+	Status FederationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FederationList contains a list of Federation
+type FederationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Federation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Federation{}, &FederationList{})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HandoverSpec defines how the home agent handles mobile nodes moving
+// between points of attachment
+type HandoverSpec struct {
+	// SmoothHandoverWindow is how long the binding to the previous care-of
+	// address is kept alive after a new one has been registered.
+	// +optional
+	SmoothHandoverWindow *metav1.Duration `json:"smoothHandoverWindow,omitempty"`
+
+	// MaxSimultaneousBindings limits the number of care-of addresses a mobile
+	// node may hold at the same time during a handover.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxSimultaneousBindings int32 `json:"maxSimultaneousBindings,omitempty"`
+
+	// Buffering enables buffering packets for a mobile node while it is
+	// between care-of addresses.
+	// +optional
+	Buffering *BufferingSpec `json:"buffering,omitempty"`
+}
+
+// BufferingSpec defines the packet buffer used during handovers
+type BufferingSpec struct {
+	// Packets is the number of packets buffered per mobile node.
+	// +kubebuilder:validation:Minimum=1
+	Packets int32 `json:"packets"`
+
+	// Timeout is how long buffered packets are held before they are dropped.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// HandoverPolicySpec defines the desired state of HandoverPolicy
+type HandoverPolicySpec struct {
+	HandoverSpec `json:",inline"`
+}
+
+// HandoverPolicyStatus defines the observed state of HandoverPolicy
+type HandoverPolicyStatus struct {
+	// HomeAgents are the names of the HomeAgents using the policy
+	// +optional
+	HomeAgents []string `json:"homeAgents,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Window",type=string,JSONPath=`.spec.smoothHandoverWindow`
+//+kubebuilder:printcolumn:name="Max Bindings",type=integer,JSONPath=`.spec.maxSimultaneousBindings`
+//+kubebuilder:printcolumn:name="Home Agents",type=string,JSONPath=`.status.homeAgents`
+
+// HandoverPolicy is the Schema for the handoverpolicies API. HomeAgents and
+// MobilityDomains reference it to share their handover tuning.
+type HandoverPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HandoverPolicySpec   `json:"spec,omitempty"`
+	Status HandoverPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HandoverPolicyList contains a list of HandoverPolicy
+type HandoverPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HandoverPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HandoverPolicy{}, &HandoverPolicyList{})
+}
@@ -17,18 +17,1432 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // HomeAgentSpec defines the desired state of HomeAgent
+// +kubebuilder:validation:XValidation:rule="!has(self.zones) || !has(self.size) || (has(oldSelf.size) && self.size == oldSelf.size)",message="size has no effect with zones and can't be changed, set zones.replicasPerZone instead"
 type HomeAgentSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Size int32 `json:"size,omitempty"`
+
+	// ClassName names the HomeAgentClass providing defaults for the
+	// settings below which are left empty.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// Image is the mo-daemon image the agents run.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Architecture is the CPU architecture of the nodes the agents run on,
+	// any node of the cluster if empty. Every replica runs on a node of it,
+	// fleets mixing architectures need an agent per architecture or a
+	// multi-arch image.
+	// +optional
+	Architecture Architecture `json:"architecture,omitempty"`
+
+	// ArchitectureImages overrides the image for the architecture in
+	// Architecture. It doesn't apply with Version set, the release catalog
+	// holds the images of a release per architecture.
+	// +optional
+	ArchitectureImages *ArchitectureImages `json:"architectureImages,omitempty"`
+
+	// Version is the mo-daemon release the agents run, e.g. "1.4". The
+	// image is taken from the release catalog of the operator unless Image
+	// is set, and the configuration is rendered for the release.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Mode is the mobility protocol the agents serve, defaults to MIPv6-HA.
+	// +optional
+	Mode AgentMode `json:"mode,omitempty"`
+
+	// LMA configures the Local Mobility Anchor in PMIPv6-LMA mode.
+	// +optional
+	LMA *LMASpec `json:"lma,omitempty"`
+
+	// Protocols selects the versions of Mobile IP the agents serve, MIPv6
+	// only by default.
+	// +optional
+	Protocols *ProtocolsSpec `json:"protocols,omitempty"`
+
+	// Resources are the compute resources of the agent container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Tunnel configures the tunnels towards the mobile nodes.
+	// +optional
+	Tunnel *TunnelSpec `json:"tunnel,omitempty"`
+
+	// QoS configures the DSCP marking of the tunneled traffic.
+	// +optional
+	QoS *QoSSpec `json:"qos,omitempty"`
+
+	// Shaping limits the rate of the traffic tunneled to and from every
+	// mobile node.
+	// +optional
+	Shaping *ShapingSpec `json:"shaping,omitempty"`
+
+	// Firewall filters the traffic tunneled to and from the mobile nodes.
+	// +optional
+	Firewall *FirewallSpec `json:"firewall,omitempty"`
+
+	// ServiceMesh keeps the sidecar of a service mesh from intercepting the
+	// tunneled and registration traffic of the agents.
+	// +optional
+	ServiceMesh *ServiceMeshSpec `json:"serviceMesh,omitempty"`
+
+	// Logging ships the logs of mo-daemon to a central endpoint from a
+	// sidecar, for sites without a cluster-wide log agent.
+	// +optional
+	Logging *LoggingSpec `json:"logging,omitempty"`
+
+	// SecurityProfile selects the privileges the agent container runs with.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// SecurityContext refines the privileges of the agent pods beyond the
+	// security profile.
+	// +optional
+	SecurityContext *AgentSecurityContext `json:"securityContext,omitempty"`
+
+	// Hardened runs mo-daemon as a non-root user. An init container grants
+	// the daemon binary the capabilities it needs as file capabilities, the
+	// daemon itself runs with every other capability dropped.
+	// +optional
+	Hardened bool `json:"hardened,omitempty"`
+
+	// ServiceAccount configures the identity the agent pods run as. By
+	// default every HomeAgent gets a ServiceAccount of its own without any
+	// access to the Kubernetes API.
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// ImagePullSecrets are the Secrets the agent pods pull their images
+	// with, next to those of their ServiceAccount. Image verification reads
+	// the signatures with them too.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// PriorityClassName is the PriorityClass of the agent pods. The
+	// operator creates the prairie-critical class when it is referenced.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// DNSPolicy is the DNS policy of the agent pods, defaults to
+	// ClusterFirst. None takes the resolvers from DNSConfig alone, e.g. the
+	// split-horizon resolvers of a carrier network.
+	// +kubebuilder:validation:Enum=ClusterFirstWithHostNet;ClusterFirst;Default;None
+	// +optional
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+
+	// DNSConfig adds resolvers, search domains and options to those of
+	// DNSPolicy. It must list a nameserver with the None policy.
+	// +optional
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+
+	// HostAliases are added to the hosts file of the agent pods, e.g. for
+	// foreign agents or AAA servers which aren't in DNS.
+	// +optional
+	HostAliases []corev1.HostAlias `json:"hostAliases,omitempty"`
+
+	// Security configures the key material shared by the home agents.
+	// +optional
+	Security *SecuritySpec `json:"security,omitempty"`
+
+	// AAA delegates the authentication of the mobile nodes to AAA servers.
+	// +optional
+	AAA *AAASpec `json:"aaa,omitempty"`
+
+	// Discovery configures Dynamic Home Agent Address Discovery (DHAAD).
+	// +optional
+	Discovery *DiscoverySpec `json:"discovery,omitempty"`
+
+	// Forwarding configures which home link traffic is tunneled to away nodes.
+	// +optional
+	Forwarding *ForwardingSpec `json:"forwarding,omitempty"`
+
+	// Proxying configures the agents answering on the home link for the
+	// mobile nodes away from home.
+	// +optional
+	Proxying *ProxyingSpec `json:"proxying,omitempty"`
+
+	// Domain holds the settings shared with the other agents of a
+	// MobilityDomain. Fields left empty are filled in by the domain.
+	// +optional
+	Domain *DomainSettings `json:"domain,omitempty"`
+
+	// Handover tunes the handling of mobile nodes changing their care-of
+	// address. Fields left empty are taken from HandoverPolicyRef.
+	// +optional
+	Handover *HandoverSpec `json:"handover,omitempty"`
+
+	// HandoverPolicyRef names a HandoverPolicy in the same namespace.
+	// +optional
+	HandoverPolicyRef *corev1.LocalObjectReference `json:"handoverPolicyRef,omitempty"`
+
+	// NetworkRef names the PrairieNetwork in the same namespace the agent
+	// serves as home network.
+	// +optional
+	NetworkRef *corev1.LocalObjectReference `json:"networkRef,omitempty"`
+
+	// HomePrefixes are further prefixes the agents advertise on the home
+	// link, each with its own router advertisement parameters.
+	// +optional
+	HomePrefixes []HomePrefix `json:"homePrefixes,omitempty"`
+
+	// BGP advertises the home prefixes into the fabric, so traffic for the
+	// mobile nodes reaches the agents without manual router changes.
+	// +optional
+	BGP *BGPSpec `json:"bgp,omitempty"`
+
+	// DrainTimeout bounds how long a terminating replica may take to revoke
+	// or transfer its bindings, defaults to 30s.
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	// TerminationGracePeriodSeconds is how long a terminating replica is
+	// given before it's killed, defaults to the drain timeout plus 10s for
+	// mo-daemon to shut down. A shorter period cuts the drain short.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// Probes overrides the health checks of the agent container.
+	// +optional
+	Probes *ProbesSpec `json:"probes,omitempty"`
+
+	// Persistence keeps the binding database of every replica on its own
+	// PersistentVolumeClaim, so restarts don't lose registrations. The
+	// replicas then run as a StatefulSet instead of a Deployment.
+	// +optional
+	Persistence *PersistenceSpec `json:"persistence,omitempty"`
+
+	// Backup configures where HomeAgentBackups of the agent are written to
+	// and how often they are taken.
+	// +optional
+	Backup *BackupSpec `json:"backup,omitempty"`
+
+	// RestoreFrom names a completed HomeAgentBackup in the same namespace.
+	// Every replica which hasn't been seeded from it yet, including replicas
+	// started later on, imports the bindings of the backup.
+	// +optional
+	RestoreFrom *corev1.LocalObjectReference `json:"restoreFrom,omitempty"`
+
+	// Redundancy defines how the replicas back each other up.
+	// +optional
+	Redundancy *RedundancySpec `json:"redundancy,omitempty"`
+
+	// DisruptionBudget limits voluntary disruptions of the replicas, e.g.
+	// node drains. Without it at most one replica is disrupted at a time,
+	// an agent with a single replica is not protected.
+	// +optional
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// TopologySpread constrains how the replicas spread across the cluster.
+	// Constraints without a label selector select the replicas of the
+	// agent. Without any the replicas are spread across nodes and zones
+	// where possible.
+	// +optional
+	TopologySpread []corev1.TopologySpreadConstraint `json:"topologySpread,omitempty"`
+
+	// Zones runs a fixed number of replicas in each of the given zones,
+	// for geo-redundant agents. It takes precedence over Size, which can't
+	// be changed while Zones is set.
+	// +optional
+	Zones *ZonePlacementSpec `json:"zones,omitempty"`
+
+	// NodeFailure speeds up the reaction to failing nodes: replicas are
+	// evicted from unreachable nodes sooner, and in ActiveStandby mode a
+	// standby takes over as soon as the node of the active replica is not
+	// ready anymore.
+	// +optional
+	NodeFailure *NodeFailureSpec `json:"nodeFailure,omitempty"`
+
+	// Rollout controls how changes of the pod template are rolled out to
+	// the replicas.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+
+	// Hooks are Jobs the operator runs at points of the lifecycle of the
+	// HomeAgent.
+	// +optional
+	Hooks *HooksSpec `json:"hooks,omitempty"`
+
+	// Schedules change the size of the HomeAgent during recurring windows,
+	// e.g. to run fewer replicas overnight. If several windows are open the
+	// one opened last applies.
+	// +optional
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+
+	// Reconcile overrides the requeue intervals of the operator for this
+	// HomeAgent, e.g. for a flapping edge site which has to converge
+	// faster than the rest.
+	// +optional
+	Reconcile *ReconcileSpec `json:"reconcile,omitempty"`
+
+	// CommonLabels are put on every object the operator creates for the
+	// HomeAgent and on its pods, e.g. for cost allocation. The values are
+	// Go templates which may refer to {{ .Name }} and {{ .Namespace }} of
+	// the HomeAgent. Labels the operator sets itself can't be overridden.
+	// +optional
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+
+	// CommonAnnotations are put on every object the operator creates for
+	// the HomeAgent and on its pods, like CommonLabels.
+	// +optional
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+}
+
+// ReconcileSpec overrides the requeue intervals of a HomeAgent
+type ReconcileSpec struct {
+	// RequeueInterval is the first interval the HomeAgent is requeued
+	// after while waiting for its replicas, defaults to --requeue-interval.
+	// It doubles with every requeue.
+	// +optional
+	RequeueInterval *metav1.Duration `json:"requeueInterval,omitempty"`
+
+	// MaxRequeueInterval is the longest interval the HomeAgent is requeued
+	// after while waiting for its replicas, defaults to
+	// --max-requeue-interval.
+	// +optional
+	MaxRequeueInterval *metav1.Duration `json:"maxRequeueInterval,omitempty"`
+
+	// ResyncInterval reconciles the HomeAgent periodically, even if
+	// nothing changed, e.g. to catch up with replicas the operator isn't
+	// told about. Not set, the HomeAgent is only reconciled on changes.
+	// Intervals below 30s are raised to 30s.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+}
+
+// AgentMode is the mobility protocol a HomeAgent serves
+// +kubebuilder:validation:Enum=MIPv6-HA;PMIPv6-LMA
+type AgentMode string
+
+const (
+	// ModeHomeAgent serves Mobile IPv6, mobile nodes register their care-of
+	// addresses themselves.
+	ModeHomeAgent AgentMode = "MIPv6-HA"
+	// ModeLMA serves Proxy Mobile IPv6 as Local Mobility Anchor, Mobile
+	// Access Gateways register the mobile nodes attached to them.
+	ModeLMA AgentMode = "PMIPv6-LMA"
+)
+
+// Architecture is a CPU architecture, as in the kubernetes.io/arch node label
+// +kubebuilder:validation:Enum=amd64;arm64
+type Architecture string
+
+const (
+	ArchitectureAMD64 Architecture = "amd64"
+	ArchitectureARM64 Architecture = "arm64"
+)
+
+// ArchitectureImages holds the mo-daemon image built for each architecture
+type ArchitectureImages struct {
+	// AMD64 is the image for amd64 nodes.
+	// +optional
+	AMD64 string `json:"amd64,omitempty"`
+
+	// ARM64 is the image for arm64 nodes.
+	// +optional
+	ARM64 string `json:"arm64,omitempty"`
+}
+
+// LMASpec configures a Local Mobility Anchor
+type LMASpec struct {
+	// Address is the LMA address (LMAA) the Mobile Access Gateways send
+	// their proxy binding updates to.
+	Address string `json:"address"`
+
+	// MAGs are the Mobile Access Gateways allowed to register mobile nodes,
+	// any gateway may if empty.
+	// +optional
+	MAGs []MAGPeer `json:"mags,omitempty"`
+}
+
+// MAGPeer is a Mobile Access Gateway of a Local Mobility Anchor
+type MAGPeer struct {
+	// Name identifies the gateway.
+	Name string `json:"name"`
+
+	// Address is the proxy care-of address of the gateway.
+	Address string `json:"address"`
+}
+
+// HomePrefix is a prefix advertised on the home link. The parameters left
+// out take the defaults of mo-daemon.
+type HomePrefix struct {
+	// Prefix is the home network prefix in CIDR notation.
+	Prefix string `json:"prefix"`
+
+	AdvertisementSpec `json:",inline"`
+}
+
+// ProtocolsSpec selects the versions of Mobile IP served by the agents.
+// Serving both requires a dual-stack cluster.
+type ProtocolsSpec struct {
+	// MIPv4 enables Mobile IPv4 home agent service.
+	// +optional
+	MIPv4 *MIPv4Spec `json:"mipv4,omitempty"`
+
+	// MIPv6 configures Mobile IPv6, which is served unless disabled.
+	// +optional
+	MIPv6 *MIPv6Spec `json:"mipv6,omitempty"`
+}
+
+// MIPv4Spec configures Mobile IPv4
+type MIPv4Spec struct {
+	// HomeNetwork is the IPv4 home network of the mobile nodes in CIDR
+	// notation.
+	HomeNetwork string `json:"homeNetwork"`
+
+	// ReverseTunneling tunnels the traffic of the mobile nodes back
+	// through the agent, for foreign networks filtering on the source
+	// address (RFC 3024).
+	// +optional
+	ReverseTunneling bool `json:"reverseTunneling,omitempty"`
+
+	// MaxRegistrationLifetime caps the lifetime granted to registrations.
+	// +optional
+	MaxRegistrationLifetime *metav1.Duration `json:"maxRegistrationLifetime,omitempty"`
+}
+
+// MIPv6Spec configures Mobile IPv6
+type MIPv6Spec struct {
+	// Disabled turns Mobile IPv6 off, for agents serving MIPv4 only.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// MaxBindingLifetime caps the lifetime granted to bindings.
+	// +optional
+	MaxBindingLifetime *metav1.Duration `json:"maxBindingLifetime,omitempty"`
+}
+
+// ScalingSchedule is a recurring window with its own size
+type ScalingSchedule struct {
+	// Name identifies the window in the status.
+	Name string `json:"name"`
+
+	// Start is when the window opens, in cron format, e.g. "0 22 * * *".
+	Start string `json:"start"`
+
+	// Duration is how long the window stays open, e.g. "8h".
+	Duration metav1.Duration `json:"duration"`
+
+	// Size is the number of replicas while the window is open. With Zones
+	// it is the number of replicas in every zone.
+	// +kubebuilder:validation:Minimum=1
+	Size int32 `json:"size"`
+
+	// TimeZone is the time zone of Start, e.g. "Europe/Berlin", defaults
+	// to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ScheduleStatus reports the effective size of a HomeAgent with schedules
+type ScheduleStatus struct {
+	// Active is the name of the open window, empty outside of all windows.
+	// +optional
+	Active string `json:"active,omitempty"`
+
+	// Size is the size in effect, per zone with Zones.
+	Size int32 `json:"size"`
+
+	// SpecSize is spec.size when the open window opened. Once spec.size
+	// changes, e.g. through the scale subresource, it is in effect until
+	// the window closes.
+	// +optional
+	SpecSize *int32 `json:"specSize,omitempty"`
+
+	// NextChange is when the next window opens or the open one closes.
+	// +optional
+	NextChange *metav1.Time `json:"nextChange,omitempty"`
+}
+
+// HooksSpec defines the lifecycle hooks of a HomeAgent
+type HooksSpec struct {
+	// PreDelete runs when the HomeAgent is deleted, before its workload is
+	// torn down, e.g. to notify an AAA system that the home agent is
+	// decommissioned.
+	// +optional
+	PreDelete *HookSpec `json:"preDelete,omitempty"`
+
+	// PostCreate runs once, after the replicas first became ready, e.g. to
+	// seed subscriber data into mo-daemon.
+	// +optional
+	PostCreate *HookSpec `json:"postCreate,omitempty"`
+}
+
+// HookFailurePolicy is what happens when a hook fails
+// +kubebuilder:validation:Enum=Ignore;Fail
+type HookFailurePolicy string
+
+const (
+	// HookFailureIgnore goes on as if the hook succeeded.
+	HookFailureIgnore HookFailurePolicy = "Ignore"
+	// HookFailureFail keeps the HomeAgent until the hook succeeds or is
+	// removed from the spec.
+	HookFailureFail HookFailurePolicy = "Fail"
+)
+
+// HookPhase is the phase of a run of a hook
+type HookPhase string
+
+const (
+	// HookRunning is a hook whose Job is running.
+	HookRunning HookPhase = "Running"
+	// HookSucceeded is a hook whose Job succeeded.
+	HookSucceeded HookPhase = "Succeeded"
+	// HookFailed is a hook whose Job failed or timed out.
+	HookFailed HookPhase = "Failed"
+)
+
+// HooksStatus reports the hooks run for a HomeAgent
+type HooksStatus struct {
+	// PostCreate reports the post-create hook. It isn't run again once it
+	// succeeded, or failed with the Ignore policy.
+	// +optional
+	PostCreate *HookStatus `json:"postCreate,omitempty"`
+}
+
+// HookStatus reports a run of a hook
+type HookStatus struct {
+	// Job is the name of the Job of the hook.
+	Job string `json:"job"`
+
+	// Phase is the phase of the hook.
+	Phase HookPhase `json:"phase"`
+
+	// CompletionTime is when the hook succeeded or failed.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message tells why a hook failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// HookSpec defines a Job run as a hook. The Job runs a single container,
+// which gets the name and namespace of the HomeAgent in HOMEAGENT_NAME and
+// HOMEAGENT_NAMESPACE.
+type HookSpec struct {
+	// Image is the image of the container of the Job.
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+
+	// Command is the entrypoint of the container, defaults to the one of
+	// the image.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// Args are the arguments of the entrypoint.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// Env are environment variables set in the container.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount the Job runs as, defaults to
+	// the default ServiceAccount of the namespace.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// Timeout is how long the operator waits for the Job to finish before
+	// it goes on, defaults to 5m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// FailurePolicy is what happens when the Job fails or times out,
+	// defaults to Ignore.
+	// +kubebuilder:default=Ignore
+	// +optional
+	FailurePolicy HookFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// RolloutMode is how replicas are replaced during a rollout
+// +kubebuilder:validation:Enum=Default;SessionContinuity
+type RolloutMode string
+
+const (
+	// RolloutDefault leaves the rollout to the workload defaults.
+	RolloutDefault RolloutMode = "Default"
+	// RolloutSessionContinuity replaces one replica at a time, and the next
+	// one only once the new replica has re-synced the bindings from its
+	// peers.
+	RolloutSessionContinuity RolloutMode = "SessionContinuity"
+)
+
+// RolloutSpec defines how the replicas are replaced
+type RolloutSpec struct {
+	// Mode is the rollout mode, defaults to Default.
+	// +kubebuilder:default=Default
+	// +optional
+	Mode RolloutMode `json:"mode,omitempty"`
+
+	// ProgressDeadline is how long the rollout may go without a replica
+	// being replaced before it is reported as stalled, defaults to 10m.
+	// +optional
+	ProgressDeadline *metav1.Duration `json:"progressDeadline,omitempty"`
+
+	// MaxSyncLag is how far a new replica may lag behind its peers to count
+	// as re-synced, defaults to 1s.
+	// +optional
+	MaxSyncLag *metav1.Duration `json:"maxSyncLag,omitempty"`
+}
+
+// NodeFailureSpec defines the reaction to failing nodes
+type NodeFailureSpec struct {
+	// TolerationSeconds is how long replicas stay bound to a node which is
+	// unreachable or not ready, defaults to 30. Kubernetes defaults to 300.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TolerationSeconds *int64 `json:"tolerationSeconds,omitempty"`
+}
+
+// ZonePlacementSpec defines the zones the replicas run in
+type ZonePlacementSpec struct {
+	// Names are the zones the replicas run in.
+	// +kubebuilder:validation:MinItems=1
+	Names []string `json:"names"`
+
+	// ReplicasPerZone is the number of replicas in every zone, defaults
+	// to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ReplicasPerZone int32 `json:"replicasPerZone,omitempty"`
+
+	// TopologyKey is the node label holding the zone, defaults to
+	// topology.kubernetes.io/zone.
+	// +optional
+	TopologyKey string `json:"topologyKey,omitempty"`
+}
+
+// DisruptionBudgetSpec defines the PodDisruptionBudget of the replicas. At
+// most one of the fields may be set.
+type DisruptionBudgetSpec struct {
+	// MinAvailable is the number or percentage of replicas which must stay
+	// available.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is the number or percentage of replicas which may be
+	// unavailable.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// RedundancyMode decides which replicas serve mobile nodes
+// +kubebuilder:validation:Enum=ActiveActive;ActiveStandby
+type RedundancyMode string
+
+const (
+	// RedundancyActiveActive lets every replica serve mobile nodes.
+	RedundancyActiveActive RedundancyMode = "ActiveActive"
+	// RedundancyActiveStandby lets a single replica serve mobile nodes while
+	// the others stand by to take over.
+	RedundancyActiveStandby RedundancyMode = "ActiveStandby"
+)
+
+// RedundancySpec defines how the replicas back each other up
+type RedundancySpec struct {
+	// Mode decides which replicas serve mobile nodes, defaults to
+	// ActiveActive. In ActiveStandby mode the operator promotes a standby
+	// as soon as the active replica fails.
+	// +optional
+	Mode RedundancyMode `json:"mode,omitempty"`
+
+	// Sync makes the replicas replicate their bindings to each other
+	// continuously, so any replica can take over the mobile nodes of
+	// another one.
+	// +optional
+	Sync *SyncSpec `json:"sync,omitempty"`
+}
+
+// SyncSpec defines the binding replication between the replicas
+type SyncSpec struct {
+	// Port is the TCP port the replicas replicate bindings on, defaults
+	// to 8701.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// KeySecretRef selects the key the replication is authenticated with.
+	// When not set the operator generates one.
+	// +optional
+	KeySecretRef *corev1.SecretKeySelector `json:"keySecretRef,omitempty"`
+}
+
+// BackupSpec defines the backups of a HomeAgent
+type BackupSpec struct {
+	// Destination is where the snapshots are written to.
+	Destination BackupDestination `json:"destination"`
+
+	// Schedule is the interval backups are taken at. Without it backups are
+	// only taken on demand, by creating a HomeAgentBackup.
+	// +optional
+	Schedule *metav1.Duration `json:"schedule,omitempty"`
+
+	// KeepLast is the number of scheduled backups kept, defaults to 7.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	KeepLast int32 `json:"keepLast,omitempty"`
+}
+
+// BackupDestination defines where snapshots are written to. Exactly one of
+// the fields must be set.
+type BackupDestination struct {
+	// VolumeClaimName names a PersistentVolumeClaim in the same namespace
+	// which is mounted into every replica. It must support ReadWriteMany
+	// with more than one replica.
+	// +optional
+	VolumeClaimName string `json:"volumeClaimName,omitempty"`
+
+	// ObjectStore uploads the snapshots to an object store.
+	// +optional
+	ObjectStore *ObjectStoreDestination `json:"objectStore,omitempty"`
+}
+
+// ObjectStoreDestination defines a bucket snapshots are uploaded to
+type ObjectStoreDestination struct {
+	// URL is the bucket and prefix snapshots are uploaded to, e.g.
+	// s3://backups/prairie.
+	URL string `json:"url"`
+
+	// CredentialsSecretRef names a Secret in the same namespace holding the
+	// credentials of the object store, which is mounted into every replica.
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// PersistenceSpec defines the volume holding the binding database of a replica
+type PersistenceSpec struct {
+	// StorageClassName is the storage class of the volumes, the cluster
+	// default if not set.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Size is the size of every volume.
+	Size resource.Quantity `json:"size"`
+}
+
+// ProbesSpec defines the probes of the agent container. Probes left empty
+// check mo-daemon's health endpoints on the management port.
+type ProbesSpec struct {
+	// Liveness replaces the default liveness probe.
+	// +optional
+	Liveness *corev1.Probe `json:"liveness,omitempty"`
+
+	// Readiness replaces the default readiness probe.
+	// +optional
+	Readiness *corev1.Probe `json:"readiness,omitempty"`
+}
+
+// DiscoverySpec defines how mobile nodes discover the home agents
+type DiscoverySpec struct {
+	// DHAAD enables answering DHAAD requests with the list of replicas.
+	// +optional
+	DHAAD bool `json:"dhaad,omitempty"`
+
+	// AnycastAddress is the home agents anycast address of the home subnet.
+	// It is assigned to the HomeAgent's Service so discovery requests sent
+	// to it can be answered by any replica.
+	// +kubebuilder:validation:Format=ipv6
+	// +optional
+	AnycastAddress string `json:"anycastAddress,omitempty"`
+
+	// ExternalDNS publishes a DNS name for the anycast address through
+	// external-dns.
+	// +optional
+	ExternalDNS *ExternalDNSSpec `json:"externalDNS,omitempty"`
+}
+
+// ExternalDNSSource selects how the DNS name is handed to external-dns
+// +kubebuilder:validation:Enum=Service;DNSEndpoint
+type ExternalDNSSource string
+
+const (
+	// ExternalDNSService annotates the Service of the HomeAgent.
+	ExternalDNSService ExternalDNSSource = "Service"
+
+	// ExternalDNSEndpoint creates a DNSEndpoint for the crd source of
+	// external-dns.
+	ExternalDNSEndpoint ExternalDNSSource = "DNSEndpoint"
+)
+
+// ExternalDNSSpec defines the DNS name published for the home agent address
+type ExternalDNSSpec struct {
+	// Hostname is the fully qualified name of the home agent address.
+	// +kubebuilder:validation:Pattern=`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)+[a-z0-9]([-a-z0-9]*[a-z0-9])?\.?$`
+	Hostname string `json:"hostname"`
+
+	// TTL is the time to live of the record in seconds, the default of
+	// external-dns if not set.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TTL *int64 `json:"ttl,omitempty"`
+
+	// Source selects how the name is handed to external-dns, defaults to
+	// Service.
+	// +optional
+	Source ExternalDNSSource `json:"source,omitempty"`
+}
+
+// DomainSettings defines the settings shared by the agents of an administrative domain
+type DomainSettings struct {
+	// AuthRealm is the NAI realm mobile nodes authenticate in.
+	// +optional
+	AuthRealm string `json:"authRealm,omitempty"`
+
+	// KeySecretRef names a Secret holding the domain-wide key material.
+	// +optional
+	KeySecretRef *corev1.LocalObjectReference `json:"keySecretRef,omitempty"`
+
+	// PrefixPools are the home network prefixes of the domain in CIDR notation.
+	// +optional
+	PrefixPools []string `json:"prefixPools,omitempty"`
+}
+
+// ForwardingSpec defines the forwarding of non-unicast traffic from the home link
+type ForwardingSpec struct {
+	// Broadcast enables tunneling broadcast packets on the home link to
+	// mobile nodes away from home.
+	// +optional
+	Broadcast bool `json:"broadcast,omitempty"`
+
+	// Multicast enables tunneling multicast packets on the home link to
+	// mobile nodes away from home, e.g. for IPTV or service discovery.
+	// +optional
+	Multicast bool `json:"multicast,omitempty"`
+}
+
+// ProxyingSpec configures answering for mobile nodes away from home
+type ProxyingSpec struct {
+	// NDP enables proxy neighbor discovery: the agents answer neighbor
+	// solicitations for the home addresses of the mobile nodes away from
+	// home, so traffic for them on the home link reaches the agents.
+	// +optional
+	NDP *NDPProxySpec `json:"ndp,omitempty"`
+
+	// ARP enables proxy ARP: the agents defend the IPv4 home addresses of
+	// the mobile nodes away from home on the home link. It requires MIPv4.
+	// +optional
+	ARP *ARPProxySpec `json:"arp,omitempty"`
+
+	// Attachments are the Multus networks attaching the home link to the
+	// agent pods. They are rendered into the k8s.v1.cni.cncf.io/networks
+	// annotation of the pod template only.
+	// +optional
+	Attachments []NetworkAttachment `json:"attachments,omitempty"`
+
+	// HostNetwork runs the agent pods in the network namespace of the node,
+	// for nodes attached to the home link directly. The proxying interfaces
+	// are interfaces of the node then, and attachments can't be used.
+	// +optional
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+}
+
+// NetworkAttachment attaches a Multus network to the agent pods
+type NetworkAttachment struct {
+	// Network is the NetworkAttachmentDefinition, as <name> in the
+	// namespace of the HomeAgent or <namespace>/<name>.
+	// +kubebuilder:validation:MinLength=1
+	Network string `json:"network"`
+
+	// Interface is the name of the interface in the pods, net1, net2, ...
+	// in the order of the attachments if not set.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]{1,15}$`
+	// +optional
+	Interface string `json:"interface,omitempty"`
+}
+
+// ARPProxySpec configures proxy ARP on the home link
+type ARPProxySpec struct {
+	// Interface is the home link interface of the pods to defend the home
+	// addresses on. It has to be attached by Multus, or be an interface of
+	// the node in the host network.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]{1,15}$`
+	Interface string `json:"interface"`
+
+	// GratuitousARPs is the number of gratuitous ARP replies sent when a
+	// mobile node leaves home, to update the ARP caches on the home link.
+	// Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	GratuitousARPs *int32 `json:"gratuitousARPs,omitempty"`
+}
+
+// NDPProxySpec configures proxy neighbor discovery on the home link
+type NDPProxySpec struct {
+	// Interface is the home link interface of the pods to proxy on, every
+	// interface if not set.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]{1,15}$`
+	// +optional
+	Interface string `json:"interface,omitempty"`
+
+	// UnsolicitedAdvertisements is the number of unsolicited neighbor
+	// advertisements sent when a mobile node leaves home, to update the
+	// neighbor caches on the home link. Defaults to 3.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	UnsolicitedAdvertisements *int32 `json:"unsolicitedAdvertisements,omitempty"`
+}
+
+// TunnelEncapsulation is the encapsulation used for tunneled traffic
+// +kubebuilder:validation:Enum=IPv6-in-IPv6;GRE
+type TunnelEncapsulation string
+
+const (
+	TunnelIPv6InIPv6 TunnelEncapsulation = "IPv6-in-IPv6"
+	TunnelGRE        TunnelEncapsulation = "GRE"
+)
+
+// TunnelSpec defines the parameters of the tunnels to the mobile nodes
+type TunnelSpec struct {
+	// Encapsulation is the tunnel encapsulation, defaults to IPv6-in-IPv6.
+	// +optional
+	Encapsulation TunnelEncapsulation `json:"encapsulation,omitempty"`
+
+	// MTU is the MTU of the tunnel interfaces.
+	// +kubebuilder:validation:Minimum=1280
+	// +optional
+	MTU int32 `json:"mtu,omitempty"`
+}
+
+// DSCPMode selects how the outer header of tunneled packets is marked
+// +kubebuilder:validation:Enum=Preserve;Fixed
+type DSCPMode string
+
+const (
+	// DSCPModePreserve copies the DSCP of the inner packet to the outer header.
+	DSCPModePreserve DSCPMode = "Preserve"
+
+	// DSCPModeFixed marks the outer header with a fixed DSCP.
+	DSCPModeFixed DSCPMode = "Fixed"
+)
+
+// QoSSpec defines the DSCP marking of the traffic tunneled to and from the
+// mobile nodes
+type QoSSpec struct {
+	// Mode selects how traffic matched by no rule is marked, defaults to
+	// Preserve.
+	// +kubebuilder:default=Preserve
+	// +optional
+	Mode DSCPMode `json:"mode,omitempty"`
+
+	// DSCP is the marking of traffic matched by no rule in Fixed mode.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	// +optional
+	DSCP *int32 `json:"dscp,omitempty"`
+
+	// Rules remark traffic classes, selected by the DSCP of the inner packet.
+	// +optional
+	Rules []DSCPRule `json:"rules,omitempty"`
+}
+
+// DSCPRule marks the outer header of a traffic class with a fixed DSCP
+type DSCPRule struct {
+	// Name of the traffic class.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Match is the DSCP of the inner packets of the traffic class.
+	// +kubebuilder:validation:MinItems=1
+	Match []DSCPValue `json:"match"`
+
+	// DSCP is the marking of the outer header.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	DSCP int32 `json:"dscp"`
+}
+
+// DSCPValue is a differentiated services code point
+// +kubebuilder:validation:Minimum=0
+// +kubebuilder:validation:Maximum=63
+type DSCPValue int32
+
+// RateLimit shapes traffic with a token bucket
+type RateLimit struct {
+	// Rate is the sustained rate in bits per second, e.g. "10M".
+	Rate resource.Quantity `json:"rate"`
+
+	// Burst is the size of the bucket in bytes, 100ms of traffic at the
+	// rate if not set.
+	// +optional
+	Burst *resource.Quantity `json:"burst,omitempty"`
+}
+
+// ClassRateLimit limits the traffic of a mobile node in a traffic class
+type ClassRateLimit struct {
+	// Class is the name of a rule of spec.qos.
+	Class string `json:"class"`
+
+	RateLimit `json:",inline"`
+}
+
+// ShapingSpec defines the rate limits of the traffic tunneled to and from
+// the mobile nodes
+type ShapingSpec struct {
+	// PerNode limits the traffic of every mobile node. BindingPolicy rules
+	// with a rate limit override it for the nodes they allow.
+	// +optional
+	PerNode *RateLimit `json:"perNode,omitempty"`
+
+	// Classes limit the traffic of every mobile node in traffic classes of
+	// spec.qos, within its limit.
+	// +optional
+	Classes []ClassRateLimit `json:"classes,omitempty"`
+}
+
+// FirewallDirection is the direction of the traffic a firewall rule matches
+// +kubebuilder:validation:Enum=Ingress;Egress
+type FirewallDirection string
+
+const (
+	// FirewallIngress is traffic tunneled to the mobile nodes.
+	FirewallIngress FirewallDirection = "Ingress"
+
+	// FirewallEgress is traffic tunneled from the mobile nodes.
+	FirewallEgress FirewallDirection = "Egress"
+)
+
+// FirewallProtocol is the protocol a firewall rule matches
+// +kubebuilder:validation:Enum=TCP;UDP;SCTP;ICMP;ICMPv6
+type FirewallProtocol string
+
+// FirewallRule matches tunneled packets by direction, the address of the
+// correspondent and the protocol. Empty match fields match any packet.
+type FirewallRule struct {
+	// Name of the rule, reported by mo-daemon with the packets it drops.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Action is taken for packets matching the rule.
+	Action PolicyAction `json:"action"`
+
+	// Direction limits the rule to traffic to or from the mobile nodes.
+	// +optional
+	Direction FirewallDirection `json:"direction,omitempty"`
+
+	// Prefix is a CIDR the address of the correspondent has to lie within.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Protocol is the protocol of the packets.
+	// +optional
+	Protocol FirewallProtocol `json:"protocol,omitempty"`
+
+	// Port is the port of the correspondent, for TCP, UDP and SCTP.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// EndPort makes the rule match the ports from Port to EndPort.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	EndPort *int32 `json:"endPort,omitempty"`
+}
+
+// FirewallSpec defines the packet filter of the agents
+type FirewallSpec struct {
+	// Rules are evaluated in order, the first matching rule decides.
+	// +optional
+	Rules []FirewallRule `json:"rules,omitempty"`
+
+	// DefaultAction is taken for packets no rule matches, defaults to Allow.
+	// +optional
+	DefaultAction PolicyAction `json:"defaultAction,omitempty"`
+}
+
+// BGPSpec defines the BGP sessions the home prefixes are advertised over.
+// They are set up by frr-k8s on the nodes of the serving replicas.
+type BGPSpec struct {
+	// ASN is the AS number of the nodes.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	ASN int64 `json:"asn"`
+
+	// Neighbors are the routers the prefixes are advertised to.
+	// +kubebuilder:validation:MinItems=1
+	Neighbors []BGPNeighbor `json:"neighbors"`
+}
+
+// BGPNeighbor is a router the home prefixes are advertised to
+type BGPNeighbor struct {
+	// Address is the IP address of the router.
+	Address string `json:"address"`
+
+	// ASN is the AS number of the router.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	ASN int64 `json:"asn"`
+
+	// Port is the BGP port of the router, defaults to 179.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// EBGPMultiHop allows the router to be more than one hop away.
+	// +optional
+	EBGPMultiHop bool `json:"ebgpMultiHop,omitempty"`
+}
+
+// ServiceMeshMode is how the agent pods coexist with a service mesh
+// +kubebuilder:validation:Enum=Exclude;Bypass
+type ServiceMeshMode string
+
+const (
+	// ServiceMeshExclude keeps the sidecar out of the agent pods.
+	ServiceMeshExclude ServiceMeshMode = "Exclude"
+	// ServiceMeshBypass lets the sidecar be injected, the traffic of the
+	// agent bypasses it.
+	ServiceMeshBypass ServiceMeshMode = "Bypass"
+)
+
+// ServiceMeshSpec defines how the agent pods coexist with Istio or Linkerd
+type ServiceMeshSpec struct {
+	// Mode is Exclude, which disables the sidecar injection, or Bypass,
+	// which excludes the ports and prefixes of the agent from the
+	// interception of the sidecar. Defaults to Exclude.
+	// +optional
+	Mode ServiceMeshMode `json:"mode,omitempty"`
+
+	// ExcludeCIDRs are further destinations which bypass the sidecar in
+	// Bypass mode, next to the home prefixes.
+	// +optional
+	ExcludeCIDRs []string `json:"excludeCIDRs,omitempty"`
+}
+
+// LogShipper is the sidecar shipping the logs of mo-daemon
+// +kubebuilder:validation:Enum=FluentBit;Vector
+type LogShipper string
+
+const (
+	LogShipperFluentBit LogShipper = "FluentBit"
+	LogShipperVector    LogShipper = "Vector"
+)
+
+// LogOutputType is the kind of endpoint logs are shipped to
+// +kubebuilder:validation:Enum=HTTP;Loki;Elasticsearch
+type LogOutputType string
+
+const (
+	// LogOutputHTTP posts the records as JSON.
+	LogOutputHTTP          LogOutputType = "HTTP"
+	LogOutputLoki          LogOutputType = "Loki"
+	LogOutputElasticsearch LogOutputType = "Elasticsearch"
+)
+
+// LoggingSpec defines the log shipping sidecar of the agent pods
+type LoggingSpec struct {
+	// Shipper is the sidecar shipping the logs, defaults to FluentBit.
+	// +optional
+	Shipper LogShipper `json:"shipper,omitempty"`
+
+	// Image overrides the image of the shipper. It is verified and pinned
+	// like the agent image.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Output is the endpoint the logs are shipped to.
+	Output LogOutput `json:"output"`
+
+	// Resources are the compute resources of the sidecar.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// MaxSize is the size mo-daemon rotates its log file at, defaults to
+	// 10Mi.
+	// +optional
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+
+	// MaxFiles is the number of rotated log files mo-daemon keeps, defaults
+	// to 2.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	MaxFiles *int32 `json:"maxFiles,omitempty"`
+}
+
+// LogOutput defines the endpoint logs are shipped to
+type LogOutput struct {
+	// Type is the kind of endpoint.
+	Type LogOutputType `json:"type"`
+
+	// URL is the http or https URL of the endpoint, e.g.
+	// https://loki.example.com/loki/api/v1/push.
+	URL string `json:"url"`
+
+	// Index is the Elasticsearch index, defaults to mo-daemon.
+	// +optional
+	Index string `json:"index,omitempty"`
+
+	// CredentialsSecretRef names a Secret in the same namespace holding the
+	// username and password keys the shipper authenticates with.
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+
+	// Labels are added to every record, and as stream labels with Loki.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SecurityProfile is a predefined set of privileges for the agent container
+// +kubebuilder:validation:Enum=NetAdmin;Privileged
+type SecurityProfile string
+
+const (
+	// SecurityProfileNetAdmin only grants the NET_ADMIN capability.
+	SecurityProfileNetAdmin SecurityProfile = "NetAdmin"
+	// SecurityProfilePrivileged runs the agent as a privileged container.
+	SecurityProfilePrivileged SecurityProfile = "Privileged"
+)
+
+// ServiceAccountSpec defines the ServiceAccount of the agent pods
+type ServiceAccountSpec struct {
+	// Name is an existing ServiceAccount to run the pods as. The operator
+	// then doesn't create one.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Rules grant the daemon access to the Kubernetes API through a Role
+	// bound to the ServiceAccount.
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// AgentSecurityContext defines the security context of the agent pods
+type AgentSecurityContext struct {
+	// Pod is the security context of the pods, e.g. runAsUser or fsGroup.
+	// +optional
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+
+	// Container is the security context of the agent container. It takes
+	// precedence over the security profile. Capabilities are added to
+	// NET_ADMIN, which mo-daemon always needs unless it runs privileged.
+	// +optional
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+
+	// AppArmorProfile is the AppArmor profile of the agent container, e.g.
+	// runtime/default or localhost/mo-daemon.
+	// +kubebuilder:validation:Pattern=`^(runtime/default|unconfined|localhost/.+)$`
+	// +optional
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+
+	// DefaultProfiles confines the agent container with the seccomp and
+	// AppArmor profiles shipped for mo-daemon, unless profiles are set
+	// explicitly. The profiles have to be installed on the nodes, see
+	// config/security.
+	// +optional
+	DefaultProfiles bool `json:"defaultProfiles,omitempty"`
+}
+
+// SecuritySpec defines the security settings of a HomeAgent pool
+type SecuritySpec struct {
+	// Rotation enables periodic rotation of the SPI/key pairs used by the agents.
+	// +optional
+	Rotation *KeyRotationSpec `json:"rotation,omitempty"`
+
+	// IPsec protects the mobility tunnels with IPsec child SAs negotiated
+	// over IKE.
+	// +optional
+	IPsec *IPsecSpec `json:"ipsec,omitempty"`
+
+	// SPIFFE gives the agents workload identities from a SPIRE agent. The
+	// control channel and the binding synchronization are then
+	// authenticated with X.509 SVIDs instead of shared secrets.
+	// +optional
+	SPIFFE *SPIFFESpec `json:"spiffe,omitempty"`
+
+	// Vault keeps the SPI/key pairs in HashiCorp Vault instead of a Secret.
+	// The keys are handed to the replicas through the control channel.
+	// +optional
+	Vault *VaultSpec `json:"vault,omitempty"`
+}
+
+// VaultSpec defines where the keys are kept in Vault
+type VaultSpec struct {
+	// Role is the role of the Kubernetes auth method the operator logs in as.
+	// It has to be the namespace of the agent or start with "<namespace>-".
+	Role string `json:"role"`
+
+	// Path is the KV version 2 path of the keyring below the namespace of
+	// the agent, e.g. secret/<namespace>/ha-sample.
+	Path string `json:"path"`
+}
+
+// SPIFFESpec defines how the agents obtain their SVIDs
+type SPIFFESpec struct {
+	// TrustDomain is the SPIFFE trust domain of the agents and the operator.
+	TrustDomain string `json:"trustDomain"`
+
+	// SocketPath is the path of the SPIRE agent socket on the nodes,
+	// defaults to /run/spire/sockets/agent.sock.
+	// +optional
+	SocketPath string `json:"socketPath,omitempty"`
+
+	// CSIDriver mounts the socket through a CSI driver instead of a
+	// hostPath, e.g. csi.spiffe.io.
+	// +optional
+	CSIDriver string `json:"csiDriver,omitempty"`
+
+	// OperatorID is the SPIFFE ID the agents accept on the control channel.
+	// By default any ID of the trust domain is accepted.
+	// +optional
+	OperatorID string `json:"operatorID,omitempty"`
+}
+
+// AAASpec defines the AAA backends authenticating the mobile nodes. Mobile
+// nodes the backends aren't responsible for authenticate with the keys of
+// their MobileNodes.
+type AAASpec struct {
+	// RADIUS delegates the authentication to RADIUS servers.
+	// +optional
+	RADIUS *RADIUSSpec `json:"radius,omitempty"`
+
+	// Diameter delegates the authentication to Diameter peers. It excludes
+	// RADIUS.
+	// +optional
+	Diameter *DiameterSpec `json:"diameter,omitempty"`
+}
+
+// DiameterSpec defines the Diameter peers the agents authenticate with
+type DiameterSpec struct {
+	// Peers are connected to in order, the next one if a peer fails.
+	// +kubebuilder:validation:MinItems=1
+	Peers []DiameterPeer `json:"peers"`
+
+	// OriginRealm is the realm of the agents, e.g. "mobile.example.com".
+	OriginRealm string `json:"originRealm"`
+
+	// DestinationRealm is the realm of the AAA servers.
+	DestinationRealm string `json:"destinationRealm"`
+
+	// TLSSecretRef names a kubernetes.io/tls Secret holding the certificate
+	// and key of the agents. Its ca.crt, if any, verifies the peers. The
+	// peers are connected to over plain TCP if not set.
+	// +optional
+	TLSSecretRef *corev1.LocalObjectReference `json:"tlsSecretRef,omitempty"`
+}
+
+// DiameterPeer is a Diameter peer
+type DiameterPeer struct {
+	// Host is the address or DNS name of the peer.
+	Host string `json:"host"`
+
+	// Port is the port of the peer, defaults to 3868.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// RADIUSSpec defines the RADIUS servers the agents authenticate with
+type RADIUSSpec struct {
+	// Servers are asked in order, the next one if a server doesn't answer.
+	// +kubebuilder:validation:MinItems=1
+	Servers []RADIUSServer `json:"servers"`
+
+	// SecretRef selects the shared secret of the servers.
+	SecretRef corev1.SecretKeySelector `json:"secretRef"`
+
+	// Realms limits the delegation to mobile nodes whose NAI is in one of
+	// the realms, e.g. "example.com". Every mobile node if empty.
+	// +optional
+	Realms []string `json:"realms,omitempty"`
+
+	// StripRealm sends the NAI without its realm as User-Name.
+	// +optional
+	StripRealm bool `json:"stripRealm,omitempty"`
+
+	// Timeout is how long an answer is waited for, defaults to 3s.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Retries is the number of times a request is repeated before the next
+	// server is asked, defaults to 2.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// RADIUSServer is a RADIUS server
+type RADIUSServer struct {
+	// Host is the address or DNS name of the server.
+	Host string `json:"host"`
+
+	// AuthPort is the authentication port, defaults to 1812.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	AuthPort int32 `json:"authPort,omitempty"`
+
+	// AccountingPort enables accounting on the given port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	AccountingPort int32 `json:"accountingPort,omitempty"`
+}
+
+// IPsecSpec defines the IKE negotiation of the SAs protecting the tunnels.
+// IKE authenticates with either a pre-shared key or a certificate, exactly
+// one of them has to be set.
+type IPsecSpec struct {
+	// PreSharedKeySecretRef selects the pre-shared key.
+	// +optional
+	PreSharedKeySecretRef *corev1.SecretKeySelector `json:"preSharedKeySecretRef,omitempty"`
+
+	// CertificateSecretRef names a kubernetes.io/tls Secret holding the
+	// certificate and key of the agents.
+	// +optional
+	CertificateSecretRef *corev1.LocalObjectReference `json:"certificateSecretRef,omitempty"`
+
+	// IKEVersion is the IKE version, defaults to 2.
+	// +kubebuilder:validation:Enum=1;2
+	// +optional
+	IKEVersion int32 `json:"ikeVersion,omitempty"`
+
+	// IKEProposals are the accepted IKE proposals, e.g.
+	// aes256-sha256-modp2048. Defaults to the daemon defaults.
+	// +optional
+	IKEProposals []string `json:"ikeProposals,omitempty"`
+
+	// ESPProposals are the accepted ESP proposals of the child SAs, e.g.
+	// aes256gcm16. Defaults to the daemon defaults.
+	// +optional
+	ESPProposals []string `json:"espProposals,omitempty"`
+
+	// Lifetime is how long a child SA is used before it is rekeyed,
+	// defaults to 1h.
+	// +optional
+	Lifetime *metav1.Duration `json:"lifetime,omitempty"`
+}
+
+// KeyRotationSpec defines how often the SPI/key pairs are replaced
+type KeyRotationSpec struct {
+	// Period is the time between two rotations, e.g. "24h". Periods below
+	// 10 minutes are raised to 10 minutes.
+	Period metav1.Duration `json:"period"`
 }
 
 // HomeAgentStatus defines the observed state of HomeAgent
@@ -36,10 +1450,453 @@ type HomeAgentStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	NodeIps []string `json:"nodes,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status reports
+	// on. The conditions are stale while it lags behind the generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the HomeAgent's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Keys describes the SPI/key pairs currently distributed to the agents
+	// +optional
+	Keys *KeyStatus `json:"keys,omitempty"`
+
+	// ServiceName is the name of the Service carrying the anycast address
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// FQDN is the DNS name published for the anycast address
+	// +optional
+	FQDN string `json:"fqdn,omitempty"`
+
+	// AdvertisedPrefixes are the home prefixes advertised over BGP
+	// +optional
+	AdvertisedPrefixes []string `json:"advertisedPrefixes,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount the replicas run as
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// RoleName is the Role granting the replicas access to the Kubernetes API
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+
+	// Replicas reports the state of every replica as read through its
+	// management API
+	// +optional
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+
+	// Drain reports the progress of draining replicas before a scale-down
+	// +optional
+	Drain *DrainStatus `json:"drain,omitempty"`
+
+	// Active is the replica serving mobile nodes in ActiveStandby mode
+	// +optional
+	Active string `json:"active,omitempty"`
+
+	// RoleTransitions are the most recent changes of the active replica
+	// +optional
+	RoleTransitions []RoleTransition `json:"roleTransitions,omitempty"`
+
+	// Zones reports the ready replicas in every zone of spec.zones
+	// +optional
+	Zones []ZoneStatus `json:"zones,omitempty"`
+
+	// Rollout tracks the progress of a rollout in SessionContinuity mode
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// Changes are the most recent changes the operator made to the
+	// objects of the HomeAgent, if auditing is enabled. In a dry run they
+	// are the changes the operator would make.
+	// +optional
+	Changes []Change `json:"changes,omitempty"`
+
+	// Version is the mo-daemon release the replicas run, once rolled out
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Image is the mo-daemon image the replicas run, after class defaults,
+	// release and architecture are applied and the digest is pinned. The
+	// MobileAccessGateways of the HomeAgent run it as well.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// AvailableUpgrades are the newer releases in the release catalog of
+	// the operator
+	// +optional
+	AvailableUpgrades []string `json:"availableUpgrades,omitempty"`
+
+	// Hooks reports the hooks run for the HomeAgent
+	// +optional
+	Hooks *HooksStatus `json:"hooks,omitempty"`
+
+	// Schedule reports the size in effect if the HomeAgent has schedules
+	// +optional
+	Schedule *ScheduleStatus `json:"schedule,omitempty"`
+
+	// CurrentReplicas is the number of replicas running, for the scale
+	// subresource
+	// +optional
+	CurrentReplicas int32 `json:"currentReplicas,omitempty"`
+
+	// Selector selects the pods of the replicas, for the scale subresource
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// ResourceRecommendation right-sizes the agent container from its
+	// observed usage, if the resource analyzer of the operator is enabled
+	// +optional
+	ResourceRecommendation *ResourceRecommendation `json:"resourceRecommendation,omitempty"`
+
+	// Revisions are the most recent revisions of the spec and their
+	// outcome, oldest first
+	// +optional
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+// RevisionResult is the outcome of a revision of the spec
+// +kubebuilder:validation:Enum=Progressing;Succeeded;Failed;Superseded
+type RevisionResult string
+
+const (
+	// RevisionProgressing is a revision still being rolled out.
+	RevisionProgressing RevisionResult = "Progressing"
+	// RevisionSucceeded is a revision every replica became ready with.
+	RevisionSucceeded RevisionResult = "Succeeded"
+	// RevisionFailed is a revision the HomeAgent stalled with. It may
+	// still succeed once the replicas recover.
+	RevisionFailed RevisionResult = "Failed"
+	// RevisionSuperseded is a revision replaced by the next one before it
+	// succeeded.
+	RevisionSuperseded RevisionResult = "Superseded"
+)
+
+// Revision is a revision of the spec of a HomeAgent
+type Revision struct {
+	// Generation is the generation of the spec.
+	Generation int64 `json:"generation"`
+
+	// Image is spec.image of the revision, empty for the default image.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Version is spec.version of the revision.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Size is spec.size of the revision.
+	Size int32 `json:"size"`
+
+	// Result is the outcome of the revision.
+	Result RevisionResult `json:"result"`
+
+	// Message explains a failed revision.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Time is when the operator first saw the revision.
+	Time metav1.Time `json:"time"`
+
+	// CompletionTime is when the revision succeeded or failed.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
+// ResourceRecommendation recommends requests for the agent container
+type ResourceRecommendation struct {
+	// Requests are the recommended requests of the agent container.
+	Requests corev1.ResourceList `json:"requests"`
+
+	// Samples is the number of usage samples the recommendation is based
+	// on.
+	Samples int32 `json:"samples"`
+
+	// Time is when the recommendation was made.
+	Time metav1.Time `json:"time"`
+
+	// Findings are the requests of the agent which are missing, below the
+	// recommendation or more than twice as large.
+	// +optional
+	Findings []string `json:"findings,omitempty"`
+}
+
+// Change records a change the operator made to an object
+type Change struct {
+	// Time is when the change was made.
+	Time metav1.Time `json:"time"`
+
+	// Verb is the kind of change, e.g. create, update or delete.
+	Verb string `json:"verb"`
+
+	// Kind is the kind of the changed object.
+	Kind string `json:"kind"`
+
+	// Object is the namespaced name of the changed object.
+	Object string `json:"object"`
+
+	// Fields are the paths of the changed fields, their values are not
+	// recorded.
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+
+	// Reason is why the change was made, e.g. KeyRotation.
+	Reason string `json:"reason"`
+
+	// DryRun is set if the change was not made, as the operator runs in
+	// dry run mode.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// RolloutStatus is the progress of a rollout
+type RolloutStatus struct {
+	// UpdatedReplicas is the number of replicas running the new template.
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+
+	// ReadyReplicas is the number of ready replicas.
+	ReadyReplicas int32 `json:"readyReplicas"`
+
+	// LastProgressTime is when the rollout last replaced a replica.
+	LastProgressTime metav1.Time `json:"lastProgressTime"`
+}
+
+// ZoneStatus is the coverage of a single zone
+type ZoneStatus struct {
+	// Name is the name of the zone.
+	Name string `json:"name"`
+
+	// Replicas is the number of replicas scheduled to the zone.
+	Replicas int32 `json:"replicas"`
+
+	// ReadyReplicas is the number of ready replicas in the zone.
+	ReadyReplicas int32 `json:"readyReplicas"`
+
+	// Degraded is true while the zone has fewer ready replicas than
+	// spec.zones.replicasPerZone.
+	Degraded bool `json:"degraded"`
+}
+
+// RoleTransition records a replica taking over as the active one
+type RoleTransition struct {
+	// Time is when the transition happened.
+	Time metav1.Time `json:"time"`
+
+	// From is the previously active replica, empty if there was none.
+	// +optional
+	From string `json:"from,omitempty"`
+
+	// To is the replica promoted to active.
+	To string `json:"to"`
+
+	// Reason is why the transition happened, Elected, Failover or Manual.
+	Reason string `json:"reason"`
+}
+
+// DrainStatus tracks replicas being drained before they are removed
+type DrainStatus struct {
+	// Replicas are the names of the pods being drained.
+	Replicas []string `json:"replicas"`
+
+	// StartTime is when the drain started.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// RemainingBindings is the number of bindings the draining replicas
+	// still hold.
+	RemainingBindings int32 `json:"remainingBindings"`
+}
+
+// ReplicaStatus is the state of a single agent pod
+type ReplicaStatus struct {
+	// Name is the name of the pod.
+	Name string `json:"name"`
+
+	// Reachable is false if the management API of the replica did not answer.
+	Reachable bool `json:"reachable"`
+
+	// Version is the mo-daemon version the replica runs.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// ConfigHash identifies the configuration the replica runs with.
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Bindings is the number of bindings the replica holds.
+	// +optional
+	Bindings int32 `json:"bindings,omitempty"`
+
+	// Registrations is the number of registrations the replica accepted
+	// since it started.
+	// +optional
+	Registrations int64 `json:"registrations,omitempty"`
+
+	// Role is the role of the replica in ActiveStandby mode.
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// IPsec reports the SAs protecting the tunnels of the replica.
+	// +optional
+	IPsec *IPsecStatus `json:"ipsec,omitempty"`
+
+	// Peers reports the binding replication with every other replica.
+	// +optional
+	Peers []PeerSyncStatus `json:"peers,omitempty"`
+
+	// AAA reports the AAA servers the replica reaches.
+	// +optional
+	AAA *AAAStatus `json:"aaa,omitempty"`
+}
+
+// AAAStatus reports the AAA servers a replica reaches
+type AAAStatus struct {
+	// Reachable is the number of servers the replica reaches.
+	Reachable int32 `json:"reachable"`
+
+	// Unreachable are the servers the replica doesn't reach.
+	// +optional
+	Unreachable []string `json:"unreachable,omitempty"`
+}
+
+// IPsecStatus counts the SAs of a replica by their state
+type IPsecStatus struct {
+	// Established is the number of established SAs.
+	Established int32 `json:"established"`
+
+	// Connecting is the number of SAs being negotiated.
+	Connecting int32 `json:"connecting"`
+
+	// Failed is the number of SAs whose negotiation failed.
+	Failed int32 `json:"failed"`
+
+	// FailedPeers are the peers of the failed SAs.
+	// +optional
+	FailedPeers []string `json:"failedPeers,omitempty"`
+}
+
+// PeerSyncStatus is the replication state between a replica and a peer
+type PeerSyncStatus struct {
+	// Name is the name of the peer pod, or its address if it is unknown.
+	Name string `json:"name"`
+
+	// Connected is true while the replication session is established.
+	Connected bool `json:"connected"`
+
+	// Lag is how far the replica is behind the bindings of the peer.
+	// +optional
+	Lag *metav1.Duration `json:"lag,omitempty"`
+}
+
+// KeyStatus tracks the progress of the key rotation
+type KeyStatus struct {
+	// ActiveSPI is the SPI of the key the agents currently sign with.
+	ActiveSPI int64 `json:"activeSPI,omitempty"`
+
+	// PendingSPI is the SPI of a newly generated key which is still being
+	// distributed to the replicas.
+	// +optional
+	PendingSPI int64 `json:"pendingSPI,omitempty"`
+
+	// RetiringSPIs are keys that have been superseded but are still accepted
+	// until the new active key has propagated.
+	// +optional
+	RetiringSPIs []int64 `json:"retiringSPIs,omitempty"`
+
+	// LastRotationTime is the time the active key was put into use.
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+}
+
+const (
+	// ConditionKeyRotationProgressing is true while a new key is being
+	// distributed to the agents or an old one is being retired.
+	ConditionKeyRotationProgressing = "KeyRotationProgressing"
+
+	// ConditionNetworkAttached is true while the agent is attached to the
+	// PrairieNetwork it references.
+	ConditionNetworkAttached = "NetworkAttached"
+
+	// ConditionSecretsResolved is true while every Secret the agent mounts
+	// exists with the keys it reads.
+	ConditionSecretsResolved = "SecretsResolved"
+
+	// ConditionCapableNodes is false while no schedulable node has the
+	// kernel modules the agent needs, with the NodeCapabilities gate.
+	ConditionCapableNodes = "CapableNodes"
+
+	// ConditionDraining is true while replicas are drained ahead of a
+	// scale-down.
+	ConditionDraining = "Draining"
+	// ConditionRestored is true once every replica has been seeded from the
+	// backup named by spec.restoreFrom.
+	ConditionRestored = "Restored"
+	// ConditionZonesCovered is true while every zone of spec.zones runs
+	// its replicas.
+	ConditionZonesCovered = "ZonesCovered"
+	// ConditionDegraded is true while replicas are failing, e.g. crash
+	// looping or unable to pull their image.
+	ConditionDegraded = "Degraded"
+	// ConditionRolloutProgressing is true while a rollout in SessionContinuity
+	// mode is under way, false with reason ProgressDeadlineExceeded once it
+	// stalled.
+	ConditionRolloutProgressing = "RolloutProgressing"
+	// ConditionIPsecEstablished is false while the IPsec negotiation of
+	// some SA failed.
+	ConditionIPsecEstablished = "IPsecEstablished"
+	// ConditionAAAReachable is false while a replica reaches none of its
+	// AAA servers.
+	ConditionAAAReachable = "AAAReachable"
+	// ConditionImageVerified is set while image verification is enabled,
+	// false while the signature of an image of the agent is rejected.
+	ConditionImageVerified = "ImageVerified"
+	// ConditionDNSPublished is set while the name of the agent is published
+	// through a DNSEndpoint, false while the resource isn't installed.
+	ConditionDNSPublished = "DNSPublished"
+	// ConditionDryRun is true while the operator only reports the changes
+	// it would make to the HomeAgent, without making them.
+	ConditionDryRun = "DryRun"
+	// ConditionPostCreateHook is false while the post-create hook of a
+	// HomeAgent is running or failed.
+	ConditionPostCreateHook = "PostCreateHook"
+	// ConditionPreDeleteHook reports the pre-delete hook of a HomeAgent
+	// being deleted.
+	ConditionPreDeleteHook = "PreDeleteHook"
+
+	// ConditionReconciling is true while the operator works towards the
+	// spec, e.g. while replicas start or a rollout is under way. Like
+	// ConditionStalled it follows the kstatus conventions and is removed
+	// when false.
+	ConditionReconciling = "Reconciling"
+	// ConditionStalled is true while the HomeAgent can't make progress
+	// without intervention, e.g. with failing replicas or an unknown
+	// version.
+	ConditionStalled = "Stalled"
+)
+
+// Reasons of the Ready and Reconciling conditions
+const (
+	ReasonSucceeded   = "Succeeded"
+	ReasonProgressing = "Progressing"
+	ReasonRollingOut  = "RollingOut"
+	ReasonDraining    = "Draining"
+)
+
+// FailoverAnnotation on a HomeAgent in ActiveStandby mode asks for the
+// active role to be handed over to a standby: the one named in the value,
+// or if it's empty the one ready for the longest time. The operator removes
+// it once handled.
+const FailoverAnnotation = "prairie.kismi/failover"
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.size,statuspath=.status.currentReplicas,selectorpath=.status.selector
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.currentReplicas`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // HomeAgent is the Schema for the homeagents API
 type HomeAgent struct {
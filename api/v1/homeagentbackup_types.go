@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HomeAgentBackupSpec defines the desired state of HomeAgentBackup
+type HomeAgentBackupSpec struct {
+	// HomeAgentRef names the HomeAgent in the same namespace to back up. The
+	// snapshots are written to the backup destination of the HomeAgent.
+	HomeAgentRef corev1.LocalObjectReference `json:"homeAgentRef"`
+}
+
+// BackupPhase is the progress of a backup
+type BackupPhase string
+
+const (
+	// BackupPending means the backup hasn't been taken yet.
+	BackupPending BackupPhase = "Pending"
+	// BackupCompleted means every replica has been snapshotted.
+	BackupCompleted BackupPhase = "Completed"
+	// BackupFailed means the backup could not be taken.
+	BackupFailed BackupPhase = "Failed"
+)
+
+// Snapshot is the state of a single replica written by a backup
+type Snapshot struct {
+	// Replica is the agent pod the snapshot was taken of.
+	Replica string `json:"replica"`
+
+	// Location is where mo-daemon wrote the snapshot to.
+	Location string `json:"location"`
+
+	// Bindings is the number of bindings in the snapshot.
+	Bindings int32 `json:"bindings"`
+}
+
+// HomeAgentBackupStatus defines the observed state of HomeAgentBackup
+type HomeAgentBackupStatus struct {
+	// Phase is the progress of the backup
+	// +optional
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// Snapshots are the snapshots of the replicas
+	// +optional
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+
+	// CompletionTime is when the backup was completed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message explains a failed backup
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Home Agent",type=string,JSONPath=`.spec.homeAgentRef.name`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
+
+// HomeAgentBackup is the Schema for the homeagentbackups API. Creating one
+// snapshots the binding state of every replica of a HomeAgent.
+type HomeAgentBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HomeAgentBackupSpec   `json:"spec,omitempty"`
+	Status HomeAgentBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HomeAgentBackupList contains a list of HomeAgentBackup
+type HomeAgentBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HomeAgentBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HomeAgentBackup{}, &HomeAgentBackupList{})
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HomeAgentClassSpec defines the defaults applied to HomeAgents of the class
+type HomeAgentClassSpec struct {
+	// Image is the default mo-daemon image.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ArchitectureImages are the default images per architecture.
+	// +optional
+	ArchitectureImages *ArchitectureImages `json:"architectureImages,omitempty"`
+
+	// Resources are the default compute resources of the agent container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Tunnel holds the default tunnel parameters.
+	// +optional
+	Tunnel *TunnelSpec `json:"tunnel,omitempty"`
+
+	// SecurityProfile is the default security profile.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+//+kubebuilder:printcolumn:name="Profile",type=string,JSONPath=`.spec.securityProfile`
+
+// HomeAgentClass is the Schema for the homeagentclasses API. Like a
+// StorageClass it carries cluster-wide defaults HomeAgents opt into through
+// spec.className.
+type HomeAgentClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HomeAgentClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HomeAgentClassList contains a list of HomeAgentClass
+type HomeAgentClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HomeAgentClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HomeAgentClass{}, &HomeAgentClassList{})
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MobileAccessGatewaySpec defines the desired state of MobileAccessGateway
+type MobileAccessGatewaySpec struct {
+	// HomeAgentRef names the HomeAgent in the same namespace the gateways
+	// register the mobile nodes with. It has to run in PMIPv6-LMA mode.
+	HomeAgentRef corev1.LocalObjectReference `json:"homeAgentRef"`
+
+	// Image of the gateway daemon, defaults to the image of the HomeAgent.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// AccessInterface is the interface of the access nodes the mobile
+	// nodes attach to.
+	// +kubebuilder:default=eth0
+	// +optional
+	AccessInterface string `json:"accessInterface,omitempty"`
+
+	// NodeSelector picks the access nodes to run a gateway on, every node
+	// if empty.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the gateway pods, access nodes are often tainted to
+	// keep other workloads off.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+// GatewayNode is an access node running a gateway
+type GatewayNode struct {
+	// Node is the name of the access node.
+	Node string `json:"node"`
+
+	// Address is the proxy care-of address of the gateway, the address of
+	// the node.
+	Address string `json:"address"`
+
+	// Ready tells whether the gateway on the node is ready.
+	Ready bool `json:"ready"`
+}
+
+// MobileAccessGatewayStatus defines the observed state of MobileAccessGateway
+type MobileAccessGatewayStatus struct {
+	// Nodes are the access nodes running a gateway. They are the MAG peers
+	// of the HomeAgent.
+	// +optional
+	Nodes []GatewayNode `json:"nodes,omitempty"`
+
+	// ReadyNodes is the number of nodes with a ready gateway
+	// +optional
+	ReadyNodes int32 `json:"readyNodes,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// gateways
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Home Agent",type=string,JSONPath=`.spec.homeAgentRef.name`
+//+kubebuilder:printcolumn:name="Ready Nodes",type=integer,JSONPath=`.status.readyNodes`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// MobileAccessGateway is the Schema for the mobileaccessgateways API. It runs
+// the Mobile Access Gateways of a Proxy Mobile IPv6 domain on the access
+// nodes, registered with a HomeAgent in PMIPv6-LMA mode.
+type MobileAccessGateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MobileAccessGatewaySpec   `json:"spec,omitempty"`
+	Status MobileAccessGatewayStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MobileAccessGatewayList contains a list of MobileAccessGateway
+type MobileAccessGatewayList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MobileAccessGateway `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MobileAccessGateway{}, &MobileAccessGatewayList{})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MobileNodeSpec defines the desired state of MobileNode
+type MobileNodeSpec struct {
+	// HomeAddress is the permanent address of the mobile node on its home
+	// network. When left empty an address is allocated from AddressPoolRef.
+	// +kubebuilder:validation:Format=ipv6
+	// +optional
+	HomeAddress string `json:"homeAddress,omitempty"`
+
+	// AddressPoolRef names the AddressPool in the same namespace the home
+	// address is allocated from if HomeAddress is not set. A HomeAddress is
+	// checked against the pool and recorded in it.
+	// +optional
+	AddressPoolRef *corev1.LocalObjectReference `json:"addressPoolRef,omitempty"`
+
+	// HomeAgentRef names the HomeAgent in the same namespace serving the mobile node.
+	HomeAgentRef corev1.LocalObjectReference `json:"homeAgentRef"`
+
+	// AuthSecretRef selects the key the mobile node authenticates its
+	// registrations with.
+	AuthSecretRef corev1.SecretKeySelector `json:"authSecretRef"`
+
+	// AllowedServices lists the services the mobile node may use. An empty
+	// list allows every service.
+	// +optional
+	AllowedServices []string `json:"allowedServices,omitempty"`
+}
+
+// MobileNodeState is the registration state of a mobile node
+type MobileNodeState string
+
+const (
+	// MobileNodePending means the subscriber could not be provisioned yet.
+	MobileNodePending MobileNodeState = "Pending"
+	// MobileNodeProvisioned means the subscriber is known to the home agent.
+	MobileNodeProvisioned MobileNodeState = "Provisioned"
+	// MobileNodeRegistered means the mobile node holds an active binding.
+	MobileNodeRegistered MobileNodeState = "Registered"
+)
+
+const (
+	// ConditionProvisioned is true once the subscriber has been handed to
+	// the referenced home agent.
+	ConditionProvisioned = "Provisioned"
+)
+
+// MobileNodeStatus defines the observed state of MobileNode
+type MobileNodeStatus struct {
+	// State is the registration state of the mobile node
+	// +optional
+	State MobileNodeState `json:"state,omitempty"`
+
+	// HomeAddress is the home address in use, either the configured or the
+	// allocated one
+	// +optional
+	HomeAddress string `json:"homeAddress,omitempty"`
+
+	// AddressPool is the AddressPool the home address is recorded in
+	// +optional
+	AddressPool string `json:"addressPool,omitempty"`
+
+	// HomeAgent is the HomeAgent the subscriber is currently provisioned into
+	// +optional
+	HomeAgent string `json:"homeAgent,omitempty"`
+
+	// GREKey is the GRE key allocated to the mobile node while its
+	// HomeAgent uses GRE encapsulation, unique among its subscribers
+	// +optional
+	GREKey int64 `json:"greKey,omitempty"`
+
+	// Conditions represent the latest available observations of the MobileNode's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Home Address",type=string,JSONPath=`.status.homeAddress`
+//+kubebuilder:printcolumn:name="Home Agent",type=string,JSONPath=`.spec.homeAgentRef.name`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+
+// MobileNode is the Schema for the mobilenodes API
+type MobileNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MobileNodeSpec   `json:"spec,omitempty"`
+	Status MobileNodeStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MobileNodeList contains a list of MobileNode
+type MobileNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MobileNode `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MobileNode{}, &MobileNodeList{})
+}
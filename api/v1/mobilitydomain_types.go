@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MobilityDomainSpec defines the desired state of MobilityDomain
+type MobilityDomainSpec struct {
+	// Selector selects the HomeAgents in the domain's namespace that are
+	// members of the domain.
+	Selector metav1.LabelSelector `json:"selector"`
+
+	// Defaults are propagated to every member which does not set them itself.
+	DomainSettings `json:",inline"`
+
+	// HandoverPolicyRef names the HandoverPolicy used by members which do
+	// not reference one themselves.
+	// +optional
+	HandoverPolicyRef *corev1.LocalObjectReference `json:"handoverPolicyRef,omitempty"`
+}
+
+// MobilityDomainStatus defines the observed state of MobilityDomain
+type MobilityDomainStatus struct {
+	// Members are the names of the HomeAgents belonging to the domain
+	// +optional
+	Members []string `json:"members,omitempty"`
+
+	// Conditions represent the latest available observations of the MobilityDomain's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// MobilityDomainAnnotation is set on HomeAgents to the domain whose
+	// defaults were propagated to them.
+	MobilityDomainAnnotation = "prairie.kismi/mobility-domain"
+
+	// MobilityDomainFieldsAnnotation lists the settings of a HomeAgent its
+	// domain set, which follow the domain and are cleared when the agent
+	// leaves it.
+	MobilityDomainFieldsAnnotation = "prairie.kismi/mobility-domain-fields"
+
+	// ConditionPropagated is true once the defaults of a domain have been
+	// applied to all of its members.
+	ConditionPropagated = "Propagated"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Realm",type=string,JSONPath=`.spec.authRealm`
+//+kubebuilder:printcolumn:name="Members",type=string,JSONPath=`.status.members`
+
+// MobilityDomain is the Schema for the mobilitydomains API. It groups home
+// agents into an administrative domain sharing authentication and addressing.
+type MobilityDomain struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MobilityDomainSpec   `json:"spec,omitempty"`
+	Status MobilityDomainStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MobilityDomainList contains a list of MobilityDomain
+type MobilityDomainList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MobilityDomain `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MobilityDomain{}, &MobilityDomainList{})
+}
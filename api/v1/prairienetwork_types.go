@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AdvertisementSpec defines the router advertisements sent on the home link
+type AdvertisementSpec struct {
+	// Interval is the time between unsolicited router advertisements.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// ValidLifetime is the valid lifetime advertised for the prefix.
+	// +optional
+	ValidLifetime *metav1.Duration `json:"validLifetime,omitempty"`
+
+	// PreferredLifetime is the preferred lifetime advertised for the prefix.
+	// +optional
+	PreferredLifetime *metav1.Duration `json:"preferredLifetime,omitempty"`
+}
+
+// PrairieNetworkSpec defines the desired state of PrairieNetwork
+type PrairieNetworkSpec struct {
+	// Prefix is the home network prefix in CIDR notation.
+	Prefix string `json:"prefix"`
+
+	// VLAN is the VLAN ID of the home link, untagged if not set.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	VLAN int32 `json:"vlan,omitempty"`
+
+	// Gateway is the default router of the home network. It has to lie
+	// within Prefix.
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+
+	// Advertisement enables router advertisements for the prefix on the
+	// home link.
+	// +optional
+	Advertisement *AdvertisementSpec `json:"advertisement,omitempty"`
+}
+
+// PrairieNetworkStatus defines the observed state of PrairieNetwork
+type PrairieNetworkStatus struct {
+	// HomeAgents are the names of the HomeAgents attached to the network
+	// +optional
+	HomeAgents []string `json:"homeAgents,omitempty"`
+
+	// Conditions represent the latest available observations of the PrairieNetwork's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Prefix",type=string,JSONPath=`.spec.prefix`
+//+kubebuilder:printcolumn:name="VLAN",type=integer,JSONPath=`.spec.vlan`
+//+kubebuilder:printcolumn:name="Home Agents",type=string,JSONPath=`.status.homeAgents`
+
+// PrairieNetwork is the Schema for the prairienetworks API. It describes a
+// home network HomeAgents serve mobile nodes on.
+type PrairieNetwork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PrairieNetworkSpec   `json:"spec,omitempty"`
+	Status PrairieNetworkStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PrairieNetworkList contains a list of PrairieNetwork
+type PrairieNetworkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PrairieNetwork `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PrairieNetwork{}, &PrairieNetworkList{})
+}
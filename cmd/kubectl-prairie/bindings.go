@@ -0,0 +1,63 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// bindings lists the bindings of a HomeAgent from its BindingCache.
+func bindings(ctx context.Context, p *plugin, args []string) error {
+	flags := flag.NewFlagSet("bindings", flag.ExitOnError)
+	replica := flags.String("replica", "", "Only list the bindings held by this replica.")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	cache := &prairiev1.BindingCache{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0]}, cache); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOME ADDRESS\tCARE-OF ADDRESS\tLIFETIME\tFLAGS\tREPLICA")
+	for _, binding := range cache.Status.Bindings {
+		if *replica != "" && binding.Replica != *replica {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%ds\t%s\t%s\n", binding.HomeAddress, binding.CareOfAddress, binding.Lifetime,
+			orNone(strings.Join(binding.Flags, "")), binding.Replica)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if cache.Status.LastSyncTime != nil {
+		fmt.Fprintf(p.out, "\n%d bindings, read from the replicas at %s\n", cache.Status.Count, cache.Status.LastSyncTime.Format("15:04:05"))
+	}
+	if len(cache.Status.UnreachableReplicas) > 0 {
+		fmt.Fprintf(p.out, "Unreachable replicas: %s\n", strings.Join(cache.Status.UnreachableReplicas, ", "))
+	}
+	return nil
+}
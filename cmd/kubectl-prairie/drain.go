@@ -0,0 +1,55 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// drain evicts a replica of a HomeAgent. The eviction respects the
+// disruption budget of the agent, and the preStop hook of the replica
+// hands its bindings over before it terminates. The workload replaces it.
+func drain(ctx context.Context, p *plugin, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("drain", flag.ExitOnError), args, 2, 2)
+	if err != nil {
+		return err
+	}
+	agent, replica := args[0], args[1]
+
+	pod := &corev1.Pod{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: replica}, pod); err != nil {
+		return err
+	}
+	if pod.Labels["parent"] != agent {
+		return fmt.Errorf("pod %s is not a replica of HomeAgent %s", replica, agent)
+	}
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	}
+	if err := p.clientset.CoreV1().Pods(pod.Namespace).EvictV1(ctx, eviction); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Replica %s evicted, it hands its bindings over before it terminates.\n", replica)
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// failover asks the operator to hand the active role of a HomeAgent over
+// to a standby, through the failover annotation.
+func failover(ctx context.Context, p *plugin, args []string) error {
+	flags := flag.NewFlagSet("failover", flag.ExitOnError)
+	to := flags.String("to", "", "The standby to promote, by default the one ready for the longest time.")
+	timeout := flags.Duration("wait", 0, "How long to wait for the new active replica, not at all if 0.")
+	args, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	agent := &prairiev1.HomeAgent{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0]}, agent); err != nil {
+		return err
+	}
+	if agent.Spec.Redundancy == nil || agent.Spec.Redundancy.Mode != prairiev1.RedundancyActiveStandby {
+		return fmt.Errorf("HomeAgent %s is not in %s mode", agent.Name, prairiev1.RedundancyActiveStandby)
+	}
+	if *to != "" && *to == agent.Status.Active {
+		return fmt.Errorf("replica %s is active already", *to)
+	}
+	previous := agent.Status.Active
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{prairiev1.FailoverAnnotation: *to},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.client.Patch(ctx, agent, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Failover of %s requested, active replica is %s.\n", agent.Name, orNone(previous))
+	if *timeout == 0 {
+		return nil
+	}
+
+	err = wait.PollImmediate(time.Second, *timeout, func() (bool, error) {
+		if err := p.client.Get(ctx, client.ObjectKeyFromObject(agent), agent); err != nil {
+			return false, err
+		}
+		if _, pending := agent.Annotations[prairiev1.FailoverAnnotation]; pending {
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failover wasn't handled: %w", err)
+	}
+	if agent.Status.Active == previous {
+		return fmt.Errorf("failover was rejected, see the events of HomeAgent %s", agent.Name)
+	}
+	fmt.Fprintf(p.out, "Replica %s is active.\n", agent.Status.Active)
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// inspect reads the live binding table of a replica through the
+// diagnostics endpoint of the operator, which reaches the replica over its
+// control channel.
+func inspect(ctx context.Context, p *plugin, args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	operator := flags.String("operator", "http://localhost:6060", "The diagnostics endpoint of the operator, e.g. port-forwarded.")
+	watch := flags.Bool("watch", false, "Stream the changes of the binding table.")
+	interval := flags.Duration("interval", 2*time.Second, "How often the replica is read while watching.")
+	token := flags.String("token", p.token, "The bearer token the operator authorizes the request with, defaults to the one of the kubeconfig.")
+	args, err := parseArgs(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	target := fmt.Sprintf("%s/bindings/%s/%s/%s", strings.TrimSuffix(*operator, "/"),
+		url.PathEscape(p.namespace), url.PathEscape(args[0]), url.PathEscape(args[1]))
+	if *watch {
+		target += "?" + url.Values{"watch": {"true"}, "interval": {interval.String()}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w, is the diagnostics endpoint of the operator port-forwarded?", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if !*watch {
+		table := daemon.BindingTable{}
+		if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "HOME ADDRESS\tCARE-OF ADDRESS\tLIFETIME\tFLAGS\tSEQUENCE")
+		for _, binding := range table.Bindings {
+			fmt.Fprintf(w, "%s\t%s\t%ds\t%s\t%d\n", binding.HomeAddress, binding.CareOfAddress, binding.Lifetime,
+				orNone(strings.Join(binding.Flags, "")), binding.Sequence)
+		}
+		return w.Flush()
+	}
+
+	// Events are printed as they come, a tabwriter would hold them back
+	fmt.Fprintf(p.out, "%-8s  %-8s  %-39s  %-39s  %s\n", "TIME", "EVENT", "HOME ADDRESS", "CARE-OF ADDRESS", "SEQUENCE")
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		event := daemon.BindingEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+		if event.Type == daemon.BindingError {
+			return fmt.Errorf("replica can't be read anymore: %s", event.Message)
+		}
+		fmt.Fprintf(p.out, "%-8s  %-8s  %-39s  %-39s  %d\n", time.Now().Format("15:04:05"), event.Type,
+			event.Binding.HomeAddress, event.Binding.CareOfAddress, event.Binding.Sequence)
+	}
+	return scanner.Err()
+}
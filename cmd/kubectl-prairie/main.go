@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command kubectl-prairie is a kubectl plugin for the day-2 operations of
+// HomeAgents: `kubectl prairie status`, `bindings`, `inspect`, `drain` and
+// `failover`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// plugin holds the clients and the namespace the commands work in.
+type plugin struct {
+	client    client.Client
+	clientset kubernetes.Interface
+	namespace string
+	// token is the bearer token of the kubeconfig, if it has one.
+	token string
+	out   io.Writer
+}
+
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, p *plugin, args []string) error
+}
+
+var commands = map[string]command{
+	"status":   {"status [HOMEAGENT]", "Show the HomeAgents of the namespace, or the replicas and conditions of one", status},
+	"bindings": {"bindings HOMEAGENT [--replica REPLICA]", "List the bindings of a HomeAgent", bindings},
+	"drain":    {"drain HOMEAGENT REPLICA", "Evict a replica, which hands its bindings over to the others before it terminates", drain},
+	"inspect":  {"inspect HOMEAGENT REPLICA [--watch] [--operator URL]", "Show or watch the live binding table of a replica, through the operator", inspect},
+	"failover": {"failover HOMEAGENT [--to REPLICA] [--wait DURATION]", "Hand the active role of a HomeAgent in ActiveStandby mode over to a standby", failover},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: kubectl prairie [--kubeconfig FILE] [--context CONTEXT] [-n NAMESPACE] COMMAND\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-54s %s\n", commands[name].usage, commands[name].help)
+	}
+}
+
+func main() {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	flags := flag.NewFlagSet("kubectl-prairie", flag.ExitOnError)
+	flags.Usage = usage
+	flags.StringVar(&rules.ExplicitPath, "kubeconfig", "", "The kubeconfig file to use.")
+	flags.StringVar(&overrides.CurrentContext, "context", "", "The kubeconfig context to use.")
+	flags.StringVar(&overrides.Context.Namespace, "namespace", "", "The namespace of the HomeAgents.")
+	flags.StringVar(&overrides.Context.Namespace, "n", "", "The namespace of the HomeAgents (shorthand).")
+	_ = flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flags.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	p, err := newPlugin(clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides))
+	if err == nil {
+		err = cmd.run(context.Background(), p, flags.Args()[1:])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newPlugin(config clientcmd.ClientConfig) (*plugin, error) {
+	rest, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := prairiev1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	c, err := client.New(rest, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(rest)
+	if err != nil {
+		return nil, err
+	}
+	token := rest.BearerToken
+	if token == "" && rest.BearerTokenFile != "" {
+		if data, err := os.ReadFile(rest.BearerTokenFile); err == nil {
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	return &plugin{client: c, clientset: clientset, namespace: namespace, token: token, out: os.Stdout}, nil
+}
+
+// parseArgs parses the flags of a command, which may follow its
+// positional arguments, and checks the number of the latter.
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		return nil, fmt.Errorf("wrong number of arguments %q, see kubectl prairie --help", strings.Join(positional, " "))
+	}
+	return positional, nil
+}
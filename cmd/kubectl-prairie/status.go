@@ -0,0 +1,154 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// status lists the HomeAgents of the namespace, or describes one.
+func status(ctx context.Context, p *plugin, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("status", flag.ExitOnError), args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return describe(ctx, p, args[0])
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	if err := p.client.List(ctx, agents, client.InNamespace(p.namespace)); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREACHABLE\tACTIVE\tVERSION\tBINDINGS\tSTATE")
+	for idx := range agents.Items {
+		agent := &agents.Items[idx]
+		fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\t%d\t%s\n", agent.Name, reachable(agent), agent.Status.CurrentReplicas,
+			orNone(agent.Status.Active), orNone(agent.Status.Version), bindingCount(agent), state(agent))
+	}
+	return w.Flush()
+}
+
+func describe(ctx context.Context, p *plugin, name string) error {
+	agent := &prairiev1.HomeAgent{}
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: name}, agent); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", agent.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", agent.Namespace)
+	fmt.Fprintf(w, "State:\t%s\n", state(agent))
+	fmt.Fprintf(w, "Version:\t%s\n", orNone(agent.Status.Version))
+	if len(agent.Status.AvailableUpgrades) > 0 {
+		fmt.Fprintf(w, "Upgrades:\t%s\n", strings.Join(agent.Status.AvailableUpgrades, ", "))
+	}
+	if agent.Status.Active != "" {
+		fmt.Fprintf(w, "Active:\t%s\n", agent.Status.Active)
+	}
+	if drain := agent.Status.Drain; drain != nil {
+		fmt.Fprintf(w, "Draining:\t%s, %d bindings remaining\n", strings.Join(drain.Replicas, ", "), drain.RemainingBindings)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.out, "\nReplicas:")
+	w = tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tROLE\tREACHABLE\tBINDINGS\tVERSION")
+	for _, replica := range agent.Status.Replicas {
+		fmt.Fprintf(w, "  %s\t%s\t%t\t%d\t%s\n", replica.Name, orNone(replica.Role), replica.Reachable,
+			replica.Bindings, orNone(replica.Version))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(agent.Status.Revisions) > 0 {
+		fmt.Fprintln(p.out, "\nRevisions:")
+		w = tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  GENERATION\tTIME\tIMAGE\tVERSION\tSIZE\tRESULT\tMESSAGE")
+		for _, revision := range agent.Status.Revisions {
+			fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%d\t%s\t%s\n", revision.Generation, revision.Time.UTC().Format(time.RFC3339),
+				orNone(revision.Image), orNone(revision.Version), revision.Size, revision.Result, revision.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(p.out, "\nConditions:")
+	w = tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tMESSAGE")
+	for _, condition := range agent.Status.Conditions {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+	return w.Flush()
+}
+
+func reachable(agent *prairiev1.HomeAgent) int {
+	count := 0
+	for _, replica := range agent.Status.Replicas {
+		if replica.Reachable {
+			count++
+		}
+	}
+	return count
+}
+
+func bindingCount(agent *prairiev1.HomeAgent) int32 {
+	count := int32(0)
+	for _, replica := range agent.Status.Replicas {
+		count += replica.Bindings
+	}
+	return count
+}
+
+// state sums up the conditions of the agent.
+func state(agent *prairiev1.HomeAgent) string {
+	for _, condition := range []string{prairiev1.ConditionDegraded, prairiev1.ConditionDraining} {
+		if found := meta.FindStatusCondition(agent.Status.Conditions, condition); found != nil && found.Status == metav1.ConditionTrue {
+			return fmt.Sprintf("%s (%s)", condition, found.Reason)
+		}
+	}
+	if len(agent.Status.Replicas) == 0 {
+		return "Pending"
+	}
+	if reachable(agent) < len(agent.Status.Replicas) {
+		return "Progressing"
+	}
+	return "Ready"
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
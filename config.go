@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+
+	configv1alpha1 "github.com/Tenacher/prairie-operator/api/config/v1alpha1"
+	"github.com/Tenacher/prairie-operator/controllers"
+)
+
+var configScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(configv1alpha1.AddToScheme(configScheme))
+	utilruntime.Must(cfg.AddToScheme(configScheme))
+}
+
+// loadConfig reads an OperatorConfig file. A plain ControllerManagerConfig
+// is read as well, it only sets the manager.
+func loadConfig(path string) (*configv1alpha1.OperatorConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := serializer.NewCodecFactory(configScheme).UniversalDeserializer().Decode(content, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch config := obj.(type) {
+	case *configv1alpha1.OperatorConfig:
+		return config, nil
+	case *cfg.ControllerManagerConfiguration:
+		return &configv1alpha1.OperatorConfig{ControllerManagerConfigurationSpec: config.ControllerManagerConfigurationSpec}, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported kind %s", path, obj.GetObjectKind().GroupVersionKind().Kind)
+}
+
+// applyConfig sets the flags which weren't given on the command line to
+// the settings of the config file.
+func applyConfig(config *configv1alpha1.OperatorConfig) error {
+	values := map[string]string{}
+	if config.Metrics.BindAddress != "" {
+		values["metrics-bind-address"] = config.Metrics.BindAddress
+	}
+	if config.Health.HealthProbeBindAddress != "" {
+		values["health-probe-bind-address"] = config.Health.HealthProbeBindAddress
+	}
+	if election := config.LeaderElection; election != nil {
+		if election.LeaderElect != nil {
+			values["leader-elect"] = strconv.FormatBool(*election.LeaderElect)
+		}
+		if election.ResourceNamespace != "" {
+			values["leader-elect-namespace"] = election.ResourceNamespace
+		}
+		if election.LeaseDuration.Duration > 0 {
+			values["leader-elect-lease-duration"] = election.LeaseDuration.Duration.String()
+		}
+		if election.RenewDeadline.Duration > 0 {
+			values["leader-elect-renew-deadline"] = election.RenewDeadline.Duration.String()
+		}
+		if election.RetryPeriod.Duration > 0 {
+			values["leader-elect-retry-period"] = election.RetryPeriod.Duration.String()
+		}
+	}
+	if config.Controller != nil && config.Controller.CacheSyncTimeout != nil {
+		values["cache-sync-timeout"] = config.Controller.CacheSyncTimeout.String()
+	}
+	if config.MaxConcurrentReconciles > 0 {
+		values["max-concurrent-reconciles"] = strconv.Itoa(config.MaxConcurrentReconciles)
+	}
+	if config.RequeueInterval != nil {
+		values["requeue-interval"] = config.RequeueInterval.Duration.String()
+	}
+	if config.MaxRequeueInterval != nil {
+		values["max-requeue-interval"] = config.MaxRequeueInterval.Duration.String()
+	}
+	if config.DefaultImage != "" {
+		values["default-image"] = config.DefaultImage
+	}
+	if config.AgentRulesClusterRole != "" {
+		values["agent-rules-cluster-role"] = config.AgentRulesClusterRole
+	}
+	if config.HardenedToolsImage != "" {
+		values["hardened-tools-image"] = config.HardenedToolsImage
+	}
+	if len(config.WatchNamespaces) > 0 {
+		values["watch-namespaces"] = strings.Join(config.WatchNamespaces, ",")
+	}
+	if config.DiagnosticsBindAddress != "" {
+		values["diagnostics-bind-address"] = config.DiagnosticsBindAddress
+	}
+	if config.TracingEndpoint != "" {
+		values["tracing-endpoint"] = config.TracingEndpoint
+	}
+	if config.Audit {
+		values["audit"] = "true"
+	}
+	if config.GrafanaDashboardNamespace != "" {
+		values["grafana-dashboard-namespace"] = config.GrafanaDashboardNamespace
+	}
+	if config.FRRNamespace != "" {
+		values["frr-namespace"] = config.FRRNamespace
+	}
+	if len(config.BGPAllowedPrefixes) > 0 {
+		values["bgp-allowed-prefixes"] = strings.Join(config.BGPAllowedPrefixes, ",")
+	}
+	if len(config.BGPAllowedPeers) > 0 {
+		values["bgp-allowed-peers"] = strings.Join(config.BGPAllowedPeers, ",")
+	}
+	if config.DryRun {
+		values["dry-run"] = "true"
+	}
+	if config.TelemetryEndpoint != "" {
+		values["telemetry-endpoint"] = config.TelemetryEndpoint
+	}
+	if config.AuditHistory > 0 {
+		values["audit-history"] = strconv.Itoa(config.AuditHistory)
+	}
+	if len(config.FeatureGates) > 0 {
+		values["feature-gates"] = controllers.FeatureGates(config.FeatureGates).String()
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range values {
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
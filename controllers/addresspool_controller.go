@@ -0,0 +1,255 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/ipam"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// AddressPoolReconciler reconciles a AddressPool object
+type AddressPoolReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=addresspools,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=addresspools/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=addresspools/finalizers,verbs=update
+
+// Reconcile validates the pool against every other pool in the cluster,
+// releases allocations whose owner is gone and updates the usage figures.
+// The allocations themselves are made by the controllers of the consumers.
+func (r *AddressPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	pool := &prairiev1.AddressPool{}
+	err := r.Get(ctx, req.NamespacedName, pool)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	allocator, err := ipam.New(pool.Spec.CIDRs, pool.Spec.Reserved)
+	if err != nil {
+		return ctrl.Result{}, r.setNotReady(ctx, pool, "InvalidSpec", err.Error())
+	}
+
+	// The older pool keeps its ranges, the newer one is refused.
+	pools := &prairiev1.AddressPoolList{}
+	err = r.List(ctx, pools)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, other := range pools.Items {
+		if other.UID == pool.UID || olderPool(pool, &other) {
+			continue
+		}
+		overlap, err := ipam.Overlaps(pool.Spec.CIDRs, other.Spec.CIDRs)
+		if err != nil || !overlap {
+			continue
+		}
+		return ctrl.Result{}, r.setNotReady(ctx, pool, "Overlap",
+			fmt.Sprintf("Ranges overlap with AddressPool %s/%s", other.Namespace, other.Name))
+	}
+
+	allocations := []prairiev1.AddressAllocation{}
+	for _, allocation := range pool.Status.Allocations {
+		exists, err := r.ownerExists(ctx, pool.Namespace, allocation.Owner)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !exists {
+			log.FromContext(ctx).Info("Releasing address of removed owner.", "address", allocation.Address, "owner", allocation.Owner)
+			continue
+		}
+		if err := allocator.Use(allocation.Address, allocation.Owner); err != nil {
+			log.FromContext(ctx).Error(err, "Dropping invalid allocation.", "owner", allocation.Owner)
+			continue
+		}
+		allocations = append(allocations, allocation)
+	}
+
+	pool.Status.Allocations = allocations
+	pool.Status.Allocated = int32(len(allocations))
+	pool.Status.Capacity = allocator.Capacity().String()
+	meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Ready",
+		Message: fmt.Sprintf("%d of %s addresses allocated", len(allocations), pool.Status.Capacity),
+	})
+	return ctrl.Result{}, r.Status().Update(ctx, pool)
+}
+
+// olderPool reports whether pool a was created before pool b. Timestamps
+// only have a resolution of a second, pools created within the same one
+// are ordered by namespace and name so exactly one of them is refused.
+func olderPool(a, b *prairiev1.AddressPool) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+func (r *AddressPoolReconciler) setNotReady(ctx context.Context, pool *prairiev1.AddressPool, reason, message string) error {
+	meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, pool)
+}
+
+// ownerExists reports whether the "<Kind>/<name>" owner of an allocation
+// still exists in the namespace of the pool.
+func (r *AddressPoolReconciler) ownerExists(ctx context.Context, namespace, owner string) (bool, error) {
+	kind, name, found := strings.Cut(owner, "/")
+	if !found {
+		return false, nil
+	}
+
+	var obj client.Object
+	switch kind {
+	case "MobileNode":
+		obj = &prairiev1.MobileNode{}
+	default:
+		// Unknown owners are left alone
+		return true, nil
+	}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, obj)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AddressPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.AddressPool{}).
+		Complete(metrics.NewReconciler("AddressPool", r))
+}
+
+// allocateAddress hands out an address of the pool to owner and records the
+// allocation in the status of the pool. A conflicting update of the pool
+// fails the call, the caller is requeued and retries on fresh state.
+func allocateAddress(ctx context.Context, c client.Client, pool *prairiev1.AddressPool, owner string) (string, error) {
+	if !meta.IsStatusConditionTrue(pool.Status.Conditions, prairiev1.ConditionReady) {
+		return "", fmt.Errorf("AddressPool %s is not ready", pool.Name)
+	}
+
+	allocator, err := ipam.New(pool.Spec.CIDRs, pool.Spec.Reserved)
+	if err != nil {
+		return "", err
+	}
+	for _, allocation := range pool.Status.Allocations {
+		if err := allocator.Use(allocation.Address, allocation.Owner); err != nil {
+			return "", err
+		}
+	}
+	if addr, ok := allocator.Lookup(owner); ok {
+		return addr, nil
+	}
+
+	addr, err := allocator.Allocate(owner, pool.Spec.Policy == prairiev1.AllocationRandom)
+	if err != nil {
+		return "", err
+	}
+	pool.Status.Allocations = append(pool.Status.Allocations, prairiev1.AddressAllocation{Address: addr, Owner: owner})
+	sort.Slice(pool.Status.Allocations, func(i, j int) bool {
+		return pool.Status.Allocations[i].Address < pool.Status.Allocations[j].Address
+	})
+	pool.Status.Allocated = int32(len(pool.Status.Allocations))
+	return addr, c.Status().Update(ctx, pool)
+}
+
+// claimAddress records the static address of owner in the pool, so it is
+// never handed out to anyone else. It fails if the pool can't hand out the
+// address or it is allocated to someone else. Another address allocated to
+// owner before is released.
+func claimAddress(ctx context.Context, c client.Client, pool *prairiev1.AddressPool, addr, owner string) (string, error) {
+	if !meta.IsStatusConditionTrue(pool.Status.Conditions, prairiev1.ConditionReady) {
+		return "", fmt.Errorf("AddressPool %s is not ready", pool.Name)
+	}
+
+	allocator, err := ipam.New(pool.Spec.CIDRs, pool.Spec.Reserved)
+	if err != nil {
+		return "", err
+	}
+	allocations := []prairiev1.AddressAllocation{}
+	claimed := false
+	for _, allocation := range pool.Status.Allocations {
+		if allocation.Owner == owner {
+			claimed = claimed || net.ParseIP(allocation.Address).Equal(net.ParseIP(addr))
+			continue
+		}
+		if err := allocator.Use(allocation.Address, allocation.Owner); err != nil {
+			return "", err
+		}
+		allocations = append(allocations, allocation)
+	}
+	if err := allocator.Use(addr, owner); err != nil {
+		return "", err
+	}
+	if claimed && len(allocations) == len(pool.Status.Allocations)-1 {
+		return addr, nil
+	}
+
+	pool.Status.Allocations = append(allocations, prairiev1.AddressAllocation{Address: addr, Owner: owner})
+	sort.Slice(pool.Status.Allocations, func(i, j int) bool {
+		return pool.Status.Allocations[i].Address < pool.Status.Allocations[j].Address
+	})
+	pool.Status.Allocated = int32(len(pool.Status.Allocations))
+	return addr, c.Status().Update(ctx, pool)
+}
+
+// releaseAddress removes the allocation of owner from the pool, if any.
+func releaseAddress(ctx context.Context, c client.Client, pool *prairiev1.AddressPool, owner string) error {
+	allocations := []prairiev1.AddressAllocation{}
+	for _, allocation := range pool.Status.Allocations {
+		if allocation.Owner != owner {
+			allocations = append(allocations, allocation)
+		}
+	}
+	if len(allocations) == len(pool.Status.Allocations) {
+		return nil
+	}
+
+	pool.Status.Allocations = allocations
+	pool.Status.Allocated = int32(len(allocations))
+	return c.Status().Update(ctx, pool)
+}
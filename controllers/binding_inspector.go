@@ -0,0 +1,206 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+const (
+	// InspectPath is where the BindingInspector is served on the
+	// diagnostics endpoint.
+	InspectPath = "/bindings/"
+
+	defaultInspectInterval = 2 * time.Second
+	minInspectInterval     = 500 * time.Millisecond
+)
+
+// BindingInspector serves the live binding table of a replica of a
+// HomeAgent under InspectPath + "<namespace>/<homeagent>/<replica>", read
+// through the control channel of the replica, so the operator's SVID is
+// used where the replica requires one. With watch=true it streams the
+// changes of the table instead, as one JSON event per line, polling the
+// replica every interval (2s by default).
+//
+// With a Reviewer, callers authenticate with a bearer token, which is
+// checked with a TokenReview, and need to be allowed to get the bindings
+// subresource of the HomeAgent, which is checked with a
+// SubjectAccessReview. Without one, the endpoint must only be reachable
+// from the operator pod, e.g. through a port-forward.
+type BindingInspector struct {
+	Reader       client.Reader
+	Reviewer     client.Client
+	Daemon       daemon.Client
+	SecureDaemon daemon.Client
+}
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// authorize checks that the bearer token of the request is valid and its
+// user may get the bindings of the HomeAgent. It returns the status to
+// reject the request with otherwise.
+func (i *BindingInspector) authorize(req *http.Request, namespace, agent string) (int, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return http.StatusUnauthorized, fmt.Errorf("a bearer token is required")
+	}
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := i.Reviewer.Create(req.Context(), review); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, fmt.Errorf("the bearer token is invalid")
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, values := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(values)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        "get",
+				Group:       prairiev1.GroupVersion.Group,
+				Resource:    "homeagents",
+				Subresource: "bindings",
+				Name:        agent,
+			},
+		},
+	}
+	if err := i.Reviewer.Create(req.Context(), access); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("%s may not get homeagents/bindings of %s/%s", user.Username, namespace, agent)
+	}
+	return http.StatusOK, nil
+}
+
+func (i *BindingInspector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, InspectPath), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		http.Error(w, fmt.Sprintf("expected %s<namespace>/<homeagent>/<replica>", InspectPath), http.StatusNotFound)
+		return
+	}
+	if i.Reviewer != nil {
+		if status, err := i.authorize(req, parts[0], parts[1]); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+	pod := &corev1.Pod{}
+	err := i.Reader.Get(req.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[2]}, pod)
+	if errors.IsNotFound(err) || (err == nil && pod.Labels["parent"] != parts[1]) {
+		http.Error(w, fmt.Sprintf("HomeAgent %s/%s has no replica %s", parts[0], parts[1], parts[2]), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pod.Status.PodIP == "" {
+		http.Error(w, fmt.Sprintf("replica %s has no address yet", pod.Name), http.StatusServiceUnavailable)
+		return
+	}
+
+	interval := defaultInspectInterval
+	if value := req.URL.Query().Get("interval"); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval < minInspectInterval {
+			http.Error(w, fmt.Sprintf("interval must be a duration of at least %s", minInspectInterval), http.StatusBadRequest)
+			return
+		}
+	}
+
+	agent := daemonFor(i.Daemon, i.SecureDaemon, pod)
+	bindings, err := agent.Bindings(req.Context(), pod.Status.PodIP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if req.URL.Query().Get("watch") != "true" {
+		_ = json.NewEncoder(w).Encode(daemon.BindingTable{Replica: pod.Name, Time: time.Now(), Bindings: bindings})
+		return
+	}
+	i.watch(req.Context(), w, agent, pod, bindings, interval)
+}
+
+// watch streams the changes of the binding table until the client goes
+// away or the replica can't be read anymore.
+func (i *BindingInspector) watch(ctx context.Context, w http.ResponseWriter, agent daemon.Client, pod *corev1.Pod, bindings []daemon.Binding, interval time.Duration) {
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(events []daemon.BindingEvent) bool {
+		for _, event := range events {
+			if err := encoder.Encode(event); err != nil {
+				return false
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	if !send(daemon.DiffBindings(nil, bindings)) {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := agent.Bindings(ctx, pod.Status.PodIP)
+		if err != nil {
+			_ = encoder.Encode(daemon.BindingEvent{Type: daemon.BindingError, Message: err.Error()})
+			return
+		}
+		if !send(daemon.DiffBindings(bindings, current)) {
+			return
+		}
+		bindings = current
+	}
+}
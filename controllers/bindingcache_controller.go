@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+const (
+	// bindingCacheRefresh is how often the bindings are read from the agents.
+	bindingCacheRefresh = 30 * time.Second
+)
+
+// BindingCacheReconciler reconciles a BindingCache object
+type BindingCacheReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Daemon daemon.Client
+	// SecureDaemon talks to SPIFFE enabled replicas, if configured.
+	SecureDaemon daemon.Client
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingcaches,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingcaches/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingcaches/finalizers,verbs=update
+
+// Reconcile reads the binding caches of every replica of the referenced
+// HomeAgent and mirrors them into the status. It requeues itself so the
+// mirror stays reasonably fresh.
+func (r *BindingCacheReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	cache := &prairiev1.BindingCache{}
+	err := r.Get(ctx, req.NamespacedName, cache)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	pods := &corev1.PodList{}
+	err = r.List(ctx, pods, client.InNamespace(cache.Namespace), client.MatchingFields{parentField: cache.Spec.HomeAgentRef.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	bindings := []prairiev1.BindingEntry{}
+	unreachable := []string{}
+	for _, pod := range pods.Items {
+		if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		replica_bindings, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Bindings(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Bindings could not be read.", "pod", pod.Name)
+			unreachable = append(unreachable, pod.Name)
+			continue
+		}
+		for _, binding := range replica_bindings {
+			bindings = append(bindings, prairiev1.BindingEntry{
+				HomeAddress:   binding.HomeAddress,
+				CareOfAddress: binding.CareOfAddress,
+				Lifetime:      binding.Lifetime,
+				Flags:         binding.Flags,
+				Replica:       pod.Name,
+			})
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].HomeAddress < bindings[j].HomeAddress
+	})
+
+	now := metav1.Now()
+	cache.Status.Bindings = bindings
+	cache.Status.Count = int32(len(bindings))
+	cache.Status.UnreachableReplicas = unreachable
+	cache.Status.LastSyncTime = &now
+	err = r.Status().Update(ctx, cache)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: bindingCacheRefresh}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The status
+// updates of the reconciler itself are filtered out, it requeues on its own.
+func (r *BindingCacheReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.BindingCache{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(metrics.NewReconciler("BindingCache", r))
+}
+
+// reconcileBindingCache makes sure every HomeAgent has a BindingCache of the
+// same name mirroring its bindings.
+func (r *HomeAgentReconciler) reconcileBindingCache(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	cache := &prairiev1.BindingCache{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, cache)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	cache = &prairiev1.BindingCache{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        agent.Name,
+			Namespace:   agent.Namespace,
+			Labels:      componentLabels(agent, componentBindingCache),
+			Annotations: commonAnnotations(agent),
+		},
+		Spec: prairiev1.BindingCacheSpec{
+			HomeAgentRef: corev1.LocalObjectReference{Name: agent.Name},
+		},
+	}
+	err = ctrl.SetControllerReference(agent, cache, r.Scheme)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Creating binding cache.")
+	return r.Create(ctx, cache)
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+const (
+	defaultHandoffTimeout = 30 * time.Second
+	handoffRetry          = 2 * time.Second
+)
+
+// BindingHandoffReconciler reconciles a BindingHandoff object
+type BindingHandoffReconciler struct {
+	client.Client
+	Scheme       *runtime.Scheme
+	RemoteClient RemoteClientFunc
+
+	remotes remoteCache
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindinghandoffs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindinghandoffs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindinghandoffs/finalizers,verbs=update
+
+// Reconcile moves the binding step by step through the control channels of
+// both HomeAgents: the binding is exported from the source replica holding
+// it, which buffers the traffic of the mobile node, imported into a target
+// replica and finally deregistered from the source. Every step is recorded
+// in the status before the next one is taken, so an interrupted handoff
+// resumes where it stopped. A handoff which can't finish in time is rolled
+// back to the source.
+func (r *BindingHandoffReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	handoff := &prairiev1.BindingHandoff{}
+	err := r.Get(ctx, req.NamespacedName, handoff)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if handoff.Status.Phase == prairiev1.HandoffCompleted || handoff.Status.Phase == prairiev1.HandoffFailed {
+		return ctrl.Result{}, nil
+	}
+	if handoff.Status.StartTime == nil {
+		now := metav1.Now()
+		handoff.Status.StartTime = &now
+		handoff.Status.Phase = prairiev1.HandoffPending
+		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, handoff)
+	}
+
+	federation := &prairiev1.Federation{}
+	err = r.Get(ctx, types.NamespacedName{Name: handoff.Spec.FederationRef.Name, Namespace: handoff.Namespace}, federation)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.setFailed(ctx, handoff, fmt.Sprintf("Federation %s does not exist", handoff.Spec.FederationRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	from, from_found := findMember(federation, handoff.Spec.From.Member)
+	to, to_found := findMember(federation, handoff.Spec.To.Member)
+	if !from_found || !to_found {
+		return ctrl.Result{}, r.setFailed(ctx, handoff, fmt.Sprintf("Members %s and %s must both belong to Federation %s",
+			handoff.Spec.From.Member, handoff.Spec.To.Member, federation.Name))
+	}
+
+	source_namespace := memberNamespace(federation, from)
+	target_namespace := memberNamespace(federation, to)
+	source, err := r.remotes.memberRemote(ctx, r.Client, r.RemoteClient, federation, from)
+	if err != nil {
+		return r.retry(ctx, handoff, nil, "", err)
+	}
+	target, err := r.remotes.memberRemote(ctx, r.Client, r.RemoteClient, federation, to)
+	if err != nil {
+		return r.retry(ctx, handoff, source, source_namespace, err)
+	}
+
+	switch handoff.Status.Phase {
+	case prairiev1.HandoffPending:
+		replica, err := bindingHolder(ctx, source, source_namespace, handoff.Spec.From.HomeAgent, handoff.Spec.HomeAddress)
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		if replica == "" {
+			return ctrl.Result{}, r.setFailed(ctx, handoff, fmt.Sprintf("HomeAgent %s holds no binding of %s",
+				handoff.Spec.From.HomeAgent, handoff.Spec.HomeAddress))
+		}
+		source_daemon, addr, err := source.replica(ctx, source_namespace, replica)
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		binding, err := source_daemon.ExportBinding(ctx, addr, handoff.Spec.HomeAddress)
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		log.FromContext(ctx).Info("Binding exported.", "handoff", handoff.Name, "replica", replica)
+		handoff.Status.SourceReplica = replica
+		handoff.Status.CareOfAddress = binding.CareOfAddress
+		handoff.Status.Lifetime = binding.Lifetime
+		handoff.Status.Sequence = binding.Sequence
+		handoff.Status.Phase = prairiev1.HandoffImporting
+		handoff.Status.Message = ""
+		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, handoff)
+
+	case prairiev1.HandoffImporting:
+		agent := &prairiev1.HomeAgent{}
+		err := target.Get(ctx, types.NamespacedName{Name: handoff.Spec.To.HomeAgent, Namespace: target_namespace}, agent)
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		replica := targetReplica(agent)
+		if replica == "" {
+			return r.retry(ctx, handoff, source, source_namespace, fmt.Errorf("HomeAgent %s has no reachable replica", agent.Name))
+		}
+		target_daemon, addr, err := target.replica(ctx, target_namespace, replica)
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		err = target_daemon.ImportBinding(ctx, addr, exportedBinding(handoff))
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		log.FromContext(ctx).Info("Binding imported.", "handoff", handoff.Name, "replica", replica)
+		handoff.Status.TargetReplica = replica
+		handoff.Status.Phase = prairiev1.HandoffDeregistering
+		handoff.Status.Message = ""
+		return ctrl.Result{Requeue: true}, r.Status().Update(ctx, handoff)
+
+	case prairiev1.HandoffDeregistering:
+		source_daemon, addr, err := source.replica(ctx, source_namespace, handoff.Status.SourceReplica)
+		if err == nil {
+			err = source_daemon.DeleteBinding(ctx, addr, handoff.Spec.HomeAddress)
+		}
+		if err != nil {
+			return r.retry(ctx, handoff, source, source_namespace, err)
+		}
+		now := metav1.Now()
+		handoff.Status.Phase = prairiev1.HandoffCompleted
+		handoff.Status.CompletionTime = &now
+		handoff.Status.Message = ""
+		log.FromContext(ctx).Info("Binding handoff completed.", "handoff", handoff.Name)
+		return ctrl.Result{}, r.Status().Update(ctx, handoff)
+	}
+	return ctrl.Result{}, nil
+}
+
+// retry records a failed step and retries it, until the handoff timed out.
+// A binding exported but not imported is handed back to the source then. A
+// binding imported whose source could not be deregistered completes anyway,
+// the frozen binding expires on the source.
+func (r *BindingHandoffReconciler) retry(ctx context.Context, handoff *prairiev1.BindingHandoff, source *Remote, source_namespace string, cause error) (ctrl.Result, error) {
+	log.FromContext(ctx).Error(cause, "Binding handoff step failed.", "handoff", handoff.Name, "phase", handoff.Status.Phase)
+
+	timeout := defaultHandoffTimeout
+	if handoff.Spec.Timeout != nil {
+		timeout = handoff.Spec.Timeout.Duration
+	}
+	if time.Since(handoff.Status.StartTime.Time) < timeout {
+		handoff.Status.Message = cause.Error()
+		return ctrl.Result{RequeueAfter: handoffRetry}, r.Status().Update(ctx, handoff)
+	}
+
+	switch handoff.Status.Phase {
+	case prairiev1.HandoffImporting:
+		if source != nil {
+			source_daemon, addr, err := source.replica(ctx, source_namespace, handoff.Status.SourceReplica)
+			if err == nil {
+				err = source_daemon.ImportBinding(ctx, addr, exportedBinding(handoff))
+			}
+			if err != nil {
+				log.FromContext(ctx).Error(err, "Binding could not be handed back to the source.", "handoff", handoff.Name)
+			}
+		}
+	case prairiev1.HandoffDeregistering:
+		now := metav1.Now()
+		handoff.Status.Phase = prairiev1.HandoffCompleted
+		handoff.Status.CompletionTime = &now
+		handoff.Status.Message = fmt.Sprintf("Source could not be deregistered: %v", cause)
+		return ctrl.Result{}, r.Status().Update(ctx, handoff)
+	}
+	return ctrl.Result{}, r.setFailed(ctx, handoff, fmt.Sprintf("Timed out: %v", cause))
+}
+
+func (r *BindingHandoffReconciler) setFailed(ctx context.Context, handoff *prairiev1.BindingHandoff, message string) error {
+	now := metav1.Now()
+	handoff.Status.Phase = prairiev1.HandoffFailed
+	handoff.Status.CompletionTime = &now
+	handoff.Status.Message = message
+	return r.Status().Update(ctx, handoff)
+}
+
+func findMember(federation *prairiev1.Federation, name string) (prairiev1.FederationMember, bool) {
+	for _, member := range federation.Spec.Members {
+		if member.Name == name {
+			return member, true
+		}
+	}
+	return prairiev1.FederationMember{}, false
+}
+
+func exportedBinding(handoff *prairiev1.BindingHandoff) daemon.Binding {
+	return daemon.Binding{
+		HomeAddress:   handoff.Spec.HomeAddress,
+		CareOfAddress: handoff.Status.CareOfAddress,
+		Lifetime:      handoff.Status.Lifetime,
+		Sequence:      handoff.Status.Sequence,
+	}
+}
+
+// bindingHolder returns the replica of the HomeAgent holding the binding of
+// the home address, empty if there is none.
+func bindingHolder(ctx context.Context, remote *Remote, namespace, agent, home_address string) (string, error) {
+	pods := &corev1.PodList{}
+	err := remote.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels{"parent": agent})
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		replica_daemon, addr, err := remote.daemonFor(&pod)
+		if err != nil {
+			return "", err
+		}
+		bindings, err := replica_daemon.Bindings(ctx, addr)
+		if err != nil {
+			return "", err
+		}
+		for _, binding := range bindings {
+			if binding.HomeAddress == home_address {
+				return pod.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// targetReplica picks the replica of the HomeAgent taking over the binding:
+// the active replica in ActiveStandby mode, the reachable replica holding
+// the fewest bindings otherwise.
+func targetReplica(agent *prairiev1.HomeAgent) string {
+	if agent.Status.Active != "" {
+		return agent.Status.Active
+	}
+	replica := ""
+	fewest := int32(-1)
+	for _, status := range agent.Status.Replicas {
+		if status.Reachable && (fewest < 0 || status.Bindings < fewest) {
+			replica = status.Name
+			fewest = status.Bindings
+		}
+	}
+	return replica
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BindingHandoffReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.BindingHandoff{}).
+		Complete(metrics.NewReconciler("BindingHandoff", r))
+}
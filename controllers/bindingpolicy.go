@@ -0,0 +1,228 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// The BindingPolicies selecting a HomeAgent are compiled into a single
+// policy, stored in a ConfigMap per HomeAgent and mounted into every replica.
+const (
+	policyMountPath = "/etc/mo-daemon/policy"
+	policyVolume    = "policy"
+	policyFile      = "policy.json"
+)
+
+func policyName(agent string) string {
+	return agent + "-policy"
+}
+
+// selectsAgent reports whether the policy applies to the agent.
+func selectsAgent(policy *prairiev1.BindingPolicy, agent *prairiev1.HomeAgent) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.HomeAgentSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(agent.Labels)), nil
+}
+
+// compileBindingPolicies merges the policies into the policy mo-daemon
+// enforces. Rules keep their order, policies are ordered by descending
+// priority and then by name. The default is the one of the highest priority
+// policy setting it, Deny if policies of the same priority disagree, and
+// the lowest binding limit wins.
+func compileBindingPolicies(policies []prairiev1.BindingPolicy) (*daemon.Policy, error) {
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Spec.Priority != policies[j].Spec.Priority {
+			return policies[i].Spec.Priority > policies[j].Spec.Priority
+		}
+		return policies[i].Name < policies[j].Name
+	})
+
+	compiled := &daemon.Policy{Default: string(prairiev1.PolicyAllow), Rules: []daemon.PolicyRule{}}
+	var deciding *prairiev1.BindingPolicy
+	for idx := range policies {
+		policy := &policies[idx]
+		if policy.Spec.DefaultAction != "" && (deciding == nil || deciding.Spec.Priority == policy.Spec.Priority) {
+			if deciding == nil || policy.Spec.DefaultAction == prairiev1.PolicyDeny {
+				compiled.Default = string(policy.Spec.DefaultAction)
+			}
+			deciding = policy
+		}
+		limit := policy.Spec.MaxBindingsPerNode
+		if limit > 0 && (compiled.MaxBindings == 0 || limit < compiled.MaxBindings) {
+			compiled.MaxBindings = limit
+		}
+
+		for idx, rule := range policy.Spec.Rules {
+			if rule.NAI != "" {
+				if _, err := path.Match(rule.NAI, ""); err != nil {
+					return nil, fmt.Errorf("rule %d of %s: invalid NAI pattern %q", idx, policy.Name, rule.NAI)
+				}
+			}
+			prefix := ""
+			if rule.HomeAddressRange != "" {
+				_, ipnet, err := net.ParseCIDR(rule.HomeAddressRange)
+				if err != nil {
+					return nil, fmt.Errorf("rule %d of %s: %w", idx, policy.Name, err)
+				}
+				prefix = ipnet.String()
+			}
+			compiled_rule := daemon.PolicyRule{
+				Action: string(rule.Action),
+				NAI:    rule.NAI,
+				Prefix: prefix,
+				Source: policy.Name,
+			}
+			if rule.RateLimit != nil {
+				if rule.Action == prairiev1.PolicyDeny {
+					return nil, fmt.Errorf("rule %d of %s: a denying rule has no traffic to limit", idx, policy.Name)
+				}
+				if err := validateRateLimit(rule.RateLimit); err != nil {
+					return nil, fmt.Errorf("rule %d of %s: %w", idx, policy.Name, err)
+				}
+				compiled_rule.RateBPS, compiled_rule.BurstBytes = bucket(rule.RateLimit)
+			}
+			compiled.Rules = append(compiled.Rules, compiled_rule)
+		}
+	}
+	return compiled, nil
+}
+
+// reconcileBindingPolicy compiles the BindingPolicies selecting the agent
+// and writes the result into the policy ConfigMap. Invalid policies are left
+// out, their status reports why.
+func (r *HomeAgentReconciler) reconcileBindingPolicy(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	policies := &prairiev1.BindingPolicyList{}
+	err := r.List(ctx, policies, client.InNamespace(agent.Namespace))
+	if err != nil {
+		return err
+	}
+
+	selected := []prairiev1.BindingPolicy{}
+	for _, policy := range policies.Items {
+		matches, err := selectsAgent(&policy, agent)
+		if err != nil || !matches {
+			continue
+		}
+		if _, err := compileBindingPolicies([]prairiev1.BindingPolicy{policy}); err != nil {
+			log.FromContext(ctx).Info("Skipping invalid BindingPolicy.", "bindingpolicy", policy.Name, "reason", err.Error())
+			continue
+		}
+		selected = append(selected, policy)
+	}
+
+	compiled, err := compileBindingPolicies(selected)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(compiled)
+	if err != nil {
+		return err
+	}
+
+	config := &corev1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: policyName(agent.Name), Namespace: agent.Namespace}, config)
+	if errors.IsNotFound(err) {
+		config = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        policyName(agent.Name),
+				Namespace:   agent.Namespace,
+				Labels:      componentLabels(agent, componentBindingPolicy),
+				Annotations: commonAnnotations(agent),
+			},
+			Data: map[string]string{policyFile: string(data)},
+		}
+		if err := ctrl.SetControllerReference(agent, config, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Binding policy created.", "policies", len(selected))
+		return r.Create(ctx, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	if config.Data[policyFile] == string(data) {
+		return nil
+	}
+	config.Data = map[string]string{policyFile: string(data)}
+	setMetadata(config, componentLabels(agent, componentBindingPolicy), commonAnnotations(agent))
+	log.FromContext(ctx).Info("Binding policy updated.", "policies", len(selected))
+	return r.Update(ctx, config)
+}
+
+// policyTemplate mounts the compiled policy into the pod template.
+func policyTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	optional := true
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: policyVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: policyName(agent.Name)},
+				Optional:             &optional,
+			},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      policyVolume,
+			MountPath: policyMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// agentsOfBindingPolicy maps a BindingPolicy to the HomeAgents in its
+// namespace. Agents which were selected before a change of the selector need
+// to be recompiled as well, so every agent of the namespace is enqueued.
+func (r *HomeAgentReconciler) agentsOfBindingPolicy(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "bindingpolicy", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// BindingPolicyReconciler reconciles a BindingPolicy object
+type BindingPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingpolicies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=bindingpolicies/finalizers,verbs=update
+
+// Reconcile validates the policy and reports the HomeAgents it applies to.
+// Compiling the policies into the daemon configuration is up to the
+// HomeAgent controller.
+func (r *BindingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	policy := &prairiev1.BindingPolicy{}
+	err := r.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Compiled",
+		Message: "Policy is pushed to the selected home agents",
+	}
+	_, err = compileBindingPolicies([]prairiev1.BindingPolicy{*policy})
+	if err == nil {
+		_, err = metav1.LabelSelectorAsSelector(&policy.Spec.HomeAgentSelector)
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	err = r.List(ctx, agents, client.InNamespace(policy.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	selected := []string{}
+	if condition.Status == metav1.ConditionTrue {
+		for _, agent := range agents.Items {
+			if matches, _ := selectsAgent(policy, &agent); matches {
+				selected = append(selected, agent.Name)
+			}
+		}
+	}
+
+	policy.Status.HomeAgents = selected
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	return ctrl.Result{}, r.Status().Update(ctx, policy)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BindingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.BindingPolicy{}).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.policiesOfNamespace)).
+		Complete(metrics.NewReconciler("BindingPolicy", r))
+}
+
+// policiesOfNamespace maps a HomeAgent to the BindingPolicies in its
+// namespace, keeping the list of selected agents up to date.
+func (r *BindingPolicyReconciler) policiesOfNamespace(obj client.Object) []reconcile.Request {
+	policies := &prairiev1.BindingPolicyList{}
+	err := r.List(context.Background(), policies, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "BindingPolicies could not be listed.", "homeagent", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(policies.Items))
+	for idx, policy := range policies.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      policy.Name,
+			Namespace: policy.Namespace,
+		}}
+	}
+	return requests
+}
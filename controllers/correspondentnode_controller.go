@@ -0,0 +1,362 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+const (
+	correspondentFinalizer = "prairie.kismi/correspondent"
+
+	// sessionRefresh is how often the sessions are read from the agents.
+	sessionRefresh = 30 * time.Second
+)
+
+// CorrespondentNodeReconciler reconciles a CorrespondentNode object
+type CorrespondentNodeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Daemon daemon.Client
+	// SecureDaemon talks to SPIFFE enabled replicas, if configured.
+	SecureDaemon daemon.Client
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=correspondentnodes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=correspondentnodes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=correspondentnodes/finalizers,verbs=update
+
+// Reconcile hands the authorization material of the correspondent node to
+// the selected HomeAgents, withdraws it from agents no longer selected and
+// mirrors the route optimization sessions into the status.
+func (r *CorrespondentNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	node := &prairiev1.CorrespondentNode{}
+	err := r.Get(ctx, req.NamespacedName, node)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !node.DeletionTimestamp.IsZero() {
+		for _, agent := range node.Status.HomeAgents {
+			err = r.Withdraw(ctx, node, agent)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(node, correspondentFinalizer)
+		return ctrl.Result{}, r.Update(ctx, node)
+	}
+
+	if controllerutil.AddFinalizer(node, correspondentFinalizer) {
+		err = r.Update(ctx, node)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	auth := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: node.Spec.AuthSecretRef.Name, Namespace: node.Namespace}, auth)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.setNotDistributed(ctx, node, "AuthSecretNotFound",
+				fmt.Sprintf("Secret %s does not exist", node.Spec.AuthSecretRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	key, ok := auth.Data[node.Spec.AuthSecretRef.Key]
+	if !ok {
+		return ctrl.Result{}, r.setNotDistributed(ctx, node, "AuthKeyNotFound",
+			fmt.Sprintf("Secret %s has no key %s", auth.Name, node.Spec.AuthSecretRef.Key))
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&node.Spec.HomeAgentSelector)
+	if err != nil {
+		return ctrl.Result{}, r.setNotDistributed(ctx, node, "InvalidSelector", err.Error())
+	}
+	agents := &prairiev1.HomeAgentList{}
+	err = r.List(ctx, agents, client.InNamespace(node.Namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	selected := map[string]bool{}
+	for idx := range agents.Items {
+		agent := &agents.Items[idx]
+		if !agent.DeletionTimestamp.IsZero() {
+			continue
+		}
+		err = r.Distribute(ctx, node, agent, key)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Authorization material could not be distributed.", "correspondentnode", node.Name, "homeagent", agent.Name)
+			return ctrl.Result{}, err
+		}
+		selected[agent.Name] = true
+	}
+	for _, agent := range node.Status.HomeAgents {
+		if selected[agent] {
+			continue
+		}
+		err = r.Withdraw(ctx, node, agent)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	home_agents := make([]string, 0, len(selected))
+	for agent := range selected {
+		home_agents = append(home_agents, agent)
+	}
+	sort.Strings(home_agents)
+
+	sessions, err := r.Sessions(ctx, node, home_agents)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	node.Status.HomeAgents = home_agents
+	node.Status.Sessions = sessions
+	node.Status.ActiveSessions = int32(len(sessions))
+	node.Status.LastSyncTime = &now
+	meta.SetStatusCondition(&node.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionDistributed,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Distributed",
+		Message: fmt.Sprintf("Authorization material distributed to %d home agents", len(home_agents)),
+	})
+	err = r.Status().Update(ctx, node)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: sessionRefresh}, nil
+}
+
+func (r *CorrespondentNodeReconciler) setNotDistributed(ctx context.Context, node *prairiev1.CorrespondentNode, reason, message string) error {
+	meta.SetStatusCondition(&node.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionDistributed,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, node)
+}
+
+// Distribute writes the entry of the correspondent node into the
+// correspondent Secret of the home agent, creating it if necessary.
+func (r *CorrespondentNodeReconciler) Distribute(ctx context.Context, node *prairiev1.CorrespondentNode, agent *prairiev1.HomeAgent, key []byte) error {
+	entry, err := json.Marshal(correspondent{
+		Address: node.Spec.Address,
+		Key:     key,
+	})
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: correspondentsName(agent.Name), Namespace: agent.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        correspondentsName(agent.Name),
+				Namespace:   agent.Namespace,
+				Labels:      componentLabels(agent, componentCorrespondents),
+				Annotations: commonAnnotations(agent),
+			},
+			Data: map[string][]byte{correspondentFile(node): entry},
+		}
+		err = ctrl.SetControllerReference(agent, secret, r.Scheme)
+		if err != nil {
+			return err
+		}
+		return r.Create(ctx, secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(secret.Data[correspondentFile(node)], entry) {
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[correspondentFile(node)] = entry
+	setMetadata(secret, componentLabels(agent, componentCorrespondents), commonAnnotations(agent))
+	log.FromContext(ctx).Info("Distributing correspondent node.", "correspondentnode", node.Name, "homeagent", agent.Name)
+	return r.Update(ctx, secret)
+}
+
+// Withdraw removes the entry of the correspondent node from the
+// correspondent Secret of the named home agent, if it is still there.
+func (r *CorrespondentNodeReconciler) Withdraw(ctx context.Context, node *prairiev1.CorrespondentNode, agent string) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: correspondentsName(agent), Namespace: node.Namespace}, secret)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if _, ok := secret.Data[correspondentFile(node)]; !ok {
+		return nil
+	}
+	delete(secret.Data, correspondentFile(node))
+	log.FromContext(ctx).Info("Withdrawing correspondent node.", "correspondentnode", node.Name, "homeagent", agent)
+	return r.Update(ctx, secret)
+}
+
+// Sessions reads the route optimization sessions with the correspondent node
+// from every running replica of the given home agents. Unreachable replicas
+// are skipped, their sessions show up again with the next refresh.
+func (r *CorrespondentNodeReconciler) Sessions(ctx context.Context, node *prairiev1.CorrespondentNode, agents []string) ([]prairiev1.RouteOptimizationSession, error) {
+	address := net.ParseIP(node.Spec.Address)
+	sessions := []prairiev1.RouteOptimizationSession{}
+
+	for _, agent := range agents {
+		pods := &corev1.PodList{}
+		err := r.List(ctx, pods, client.InNamespace(node.Namespace), client.MatchingFields{parentField: agent})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range pods.Items {
+			if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+
+			replica_sessions, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Sessions(ctx, pod.Status.PodIP)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "Sessions could not be read.", "pod", pod.Name)
+				continue
+			}
+			for _, session := range replica_sessions {
+				if !net.ParseIP(session.Correspondent).Equal(address) {
+					continue
+				}
+				sessions = append(sessions, prairiev1.RouteOptimizationSession{
+					HomeAddress:   session.HomeAddress,
+					CareOfAddress: session.CareOfAddress,
+					State:         session.State,
+					Replica:       pod.Name,
+				})
+			}
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].HomeAddress < sessions[j].HomeAddress
+	})
+	return sessions, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. The status
+// updates of the reconciler itself are filtered out, it requeues on its own.
+func (r *CorrespondentNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.CorrespondentNode{}, authSecretRefField,
+		func(obj client.Object) []string {
+			return []string{obj.(*prairiev1.CorrespondentNode).Spec.AuthSecretRef.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.CorrespondentNode{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.correspondentsOfHomeAgent)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.correspondentsOfSecret)).
+		Complete(metrics.NewReconciler("CorrespondentNode", r))
+}
+
+// correspondentsOfHomeAgent maps a HomeAgent to the CorrespondentNodes
+// selecting it, or which selected it before its labels changed.
+func (r *CorrespondentNodeReconciler) correspondentsOfHomeAgent(obj client.Object) []reconcile.Request {
+	nodes := &prairiev1.CorrespondentNodeList{}
+	err := r.List(context.Background(), nodes, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "CorrespondentNodes could not be listed.", "homeagent", obj.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, node := range nodes.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&node.Spec.HomeAgentSelector)
+		if err != nil {
+			continue
+		}
+		listed := false
+		for _, agent := range node.Status.HomeAgents {
+			listed = listed || agent == obj.GetName()
+		}
+		if !listed && !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+		}})
+	}
+	return requests
+}
+
+// correspondentsOfSecret maps a Secret to the CorrespondentNodes whose key
+// it holds, so they are distributed once it materializes and pick up a new
+// key.
+func (r *CorrespondentNodeReconciler) correspondentsOfSecret(obj client.Object) []reconcile.Request {
+	nodes := &prairiev1.CorrespondentNodeList{}
+	err := r.List(context.Background(), nodes,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{authSecretRefField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "CorrespondentNodes could not be listed.", "secret", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(nodes.Items))
+	for idx, node := range nodes.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The authorization material for route optimization is a Secret per
+// HomeAgent holding one entry per CorrespondentNode selecting it. Like the
+// subscriber database it is mounted into every replica.
+const (
+	correspondentsMountPath = "/etc/mo-daemon/correspondents"
+	correspondentsVolume    = "correspondents"
+)
+
+// correspondent is the entry mo-daemon reads for every correspondent node
+type correspondent struct {
+	Address string `json:"address"`
+	Key     []byte `json:"key"`
+}
+
+func correspondentsName(agent string) string {
+	return agent + "-correspondents"
+}
+
+func correspondentFile(node *prairiev1.CorrespondentNode) string {
+	return node.Name + ".json"
+}
+
+// correspondentsTemplate mounts the correspondent Secret into the pod
+// template. It only exists once a CorrespondentNode selects the agent.
+func correspondentsTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	optional := true
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: correspondentsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: correspondentsName(agent.Name),
+				Optional:   &optional,
+			},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      correspondentsVolume,
+			MountPath: correspondentsMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
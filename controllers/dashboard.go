@@ -0,0 +1,96 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	_ "embed"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	dashboardName = "prairie-operator-dashboard"
+	dashboardFile = "prairie-operator.json"
+)
+
+// dashboard is the Grafana dashboard of the operator metrics and the
+// mobility metrics of the HomeAgents.
+//
+//go:embed dashboards/prairie-operator.json
+var dashboard string
+
+// DashboardInstaller keeps the Grafana dashboard in a ConfigMap, which the
+// dashboard sidecar of Grafana picks up by its labels, or a GrafanaDashboard
+// of the grafana-operator references.
+type DashboardInstaller struct {
+	Client client.Client
+	// Reader reads the ConfigMap, the namespace may not be cached.
+	Reader    client.Reader
+	Namespace string
+	Labels    map[string]string
+}
+
+// Start creates the ConfigMap of the dashboard, or updates it to the
+// dashboard of this version of the operator. The operator runs on if it
+// can't.
+func (d *DashboardInstaller) Start(ctx context.Context) error {
+	if err := d.install(ctx); err != nil {
+		log.FromContext(ctx).Error(err, "Grafana dashboard could not be installed.", "namespace", d.Namespace)
+	}
+	return nil
+}
+
+func (d *DashboardInstaller) install(ctx context.Context) error {
+	config_map := &corev1.ConfigMap{}
+	err := d.Reader.Get(ctx, types.NamespacedName{Name: dashboardName, Namespace: d.Namespace}, config_map)
+	if errors.IsNotFound(err) {
+		config_map = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      dashboardName,
+				Namespace: d.Namespace,
+				Labels:    d.Labels,
+			},
+			Data: map[string]string{dashboardFile: dashboard},
+		}
+		log.FromContext(ctx).Info("Grafana dashboard created.", "namespace", d.Namespace)
+		return d.Client.Create(ctx, config_map)
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := config_map.Data[dashboardFile] != dashboard
+	if config_map.Labels == nil {
+		config_map.Labels = map[string]string{}
+	}
+	for key, value := range d.Labels {
+		changed = changed || config_map.Labels[key] != value
+		config_map.Labels[key] = value
+	}
+	if !changed {
+		return nil
+	}
+	config_map.Data = map[string]string{dashboardFile: dashboard}
+	log.FromContext(ctx).Info("Grafana dashboard updated.", "namespace", d.Namespace)
+	return d.Client.Update(ctx, config_map)
+}
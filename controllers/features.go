@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature gates switch optional behaviour of the operator on or off.
+const (
+	// PinImageDigests pins verified agent images to their digest.
+	PinImageDigests = "PinImageDigests"
+	// ActiveStandby lets HomeAgents run in ActiveStandby redundancy mode,
+	// with the operator failing over to a standby replica.
+	ActiveStandby = "ActiveStandby"
+	// Federation runs the Federation and BindingHandoff controllers, which
+	// reach out to other clusters.
+	Federation = "Federation"
+	// NodeCapabilities constrains the agent pods to the nodes Node Feature
+	// Discovery labelled with the kernel modules they need.
+	NodeCapabilities = "NodeCapabilities"
+)
+
+// The stages of a feature gate. Alpha features are off by default and may
+// change or go away, beta features are on by default and GA features can't
+// be switched off anymore.
+const (
+	Alpha = "ALPHA"
+	Beta  = "BETA"
+	GA    = "GA"
+)
+
+// The meta gates switch every gate of a stage which isn't set itself.
+const (
+	allAlpha = "AllAlpha"
+	allBeta  = "AllBeta"
+)
+
+type featureSpec struct {
+	Default bool
+	Stage   string
+}
+
+var defaultFeatureGates = map[string]featureSpec{
+	PinImageDigests:  {Default: true, Stage: Beta},
+	ActiveStandby:    {Default: false, Stage: Alpha},
+	Federation:       {Default: false, Stage: Alpha},
+	NodeCapabilities: {Default: false, Stage: Alpha},
+}
+
+// gatedKinds are the kinds only the controllers behind a gate watch.
+var gatedKinds = map[string]string{
+	"Federation":     Federation,
+	"BindingHandoff": Federation,
+}
+
+// DefaultFeatureGates returns every known gate with its default.
+func DefaultFeatureGates() map[string]bool {
+	gates := make(map[string]bool, len(defaultFeatureGates))
+	for name, spec := range defaultFeatureGates {
+		gates[name] = spec.Default
+	}
+	return gates
+}
+
+// FeatureGateUsage describes the known gates for the help of a flag.
+func FeatureGateUsage() string {
+	names := make([]string, 0, len(defaultFeatureGates))
+	for name := range defaultFeatureGates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	usage := []string{
+		allAlpha + "=true|false (ALPHA - default=false)",
+		allBeta + "=true|false (BETA - default=true)",
+	}
+	for _, name := range names {
+		spec := defaultFeatureGates[name]
+		usage = append(usage, fmt.Sprintf("%s=true|false (%s - default=%t)", name, spec.Stage, spec.Default))
+	}
+	return strings.Join(usage, "\n")
+}
+
+// FeatureGates holds the gates set for the operator, unset gates have
+// their default.
+type FeatureGates map[string]bool
+
+// Enabled reports whether the gate is on.
+func (g FeatureGates) Enabled(name string) bool {
+	if enabled, ok := g[name]; ok {
+		return enabled
+	}
+	return defaultFeatureGates[name].Default
+}
+
+// Watched reports whether a controller watches the kind, i.e. it isn't
+// only watched by the controllers of a gate that is off.
+func (g FeatureGates) Watched(kind string) bool {
+	gate, gated := gatedKinds[kind]
+	return !gated || g.Enabled(gate)
+}
+
+// String formats the gates like ParseFeatureGates reads them.
+func (g FeatureGates) String() string {
+	gates := make([]string, 0, len(g))
+	for name, enabled := range g {
+		gates = append(gates, name+"="+strconv.FormatBool(enabled))
+	}
+	sort.Strings(gates)
+	return strings.Join(gates, ",")
+}
+
+// ParseFeatureGates reads gates given as "Name=true,Other=false". AllAlpha
+// and AllBeta set the gates of their stage which aren't given. GA gates
+// can't be switched off.
+func ParseFeatureGates(value string) (FeatureGates, error) {
+	gates := FeatureGates{}
+	stages := map[string]bool{}
+	for _, gate := range strings.Split(value, ",") {
+		if strings.TrimSpace(gate) == "" {
+			continue
+		}
+		name, setting, _ := strings.Cut(gate, "=")
+		name = strings.TrimSpace(name)
+		spec, known := defaultFeatureGates[name]
+		if !known && name != allAlpha && name != allBeta {
+			return nil, fmt.Errorf("unknown feature gate %q", name)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(setting))
+		if err != nil {
+			return nil, fmt.Errorf("feature gate %s: %w", name, err)
+		}
+		switch {
+		case name == allAlpha:
+			stages[Alpha] = enabled
+		case name == allBeta:
+			stages[Beta] = enabled
+		case spec.Stage == GA && !enabled:
+			return nil, fmt.Errorf("feature gate %s is GA and can't be disabled", name)
+		default:
+			gates[name] = enabled
+		}
+	}
+	for name, spec := range defaultFeatureGates {
+		if enabled, ok := stages[spec.Stage]; ok {
+			if _, set := gates[name]; !set {
+				gates[name] = enabled
+			}
+		}
+	}
+	return gates, nil
+}
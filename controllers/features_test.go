@@ -0,0 +1,52 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feature gates", func() {
+	It("ships risky capabilities disabled", func() {
+		Expect(DefaultFeatureGates()).To(Equal(map[string]bool{
+			PinImageDigests:  true,
+			ActiveStandby:    false,
+			Federation:       false,
+			NodeCapabilities: false,
+		}))
+		for _, gate := range []string{ActiveStandby, Federation, NodeCapabilities} {
+			Expect(defaultFeatureGates[gate].Stage).To(Equal(Alpha), gate)
+			Expect(FeatureGates{}.Enabled(gate)).To(BeFalse(), gate)
+		}
+		Expect(FeatureGates{}.Watched("Federation")).To(BeFalse())
+		Expect(FeatureGates{}.Watched("HomeAgent")).To(BeTrue())
+	})
+
+	It("switches gates on when asked to", func() {
+		gates, err := ParseFeatureGates("ActiveStandby=true,Federation=true")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gates.Enabled(ActiveStandby)).To(BeTrue())
+		Expect(gates.Enabled(Federation)).To(BeTrue())
+		Expect(gates.Enabled(NodeCapabilities)).To(BeFalse())
+
+		gates, err = ParseFeatureGates("AllAlpha=true,Federation=false")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gates.Enabled(ActiveStandby)).To(BeTrue())
+		Expect(gates.Enabled(Federation)).To(BeFalse())
+	})
+})
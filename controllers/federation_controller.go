@@ -0,0 +1,418 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/ipam"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+const (
+	// federationRefresh is how often the member clusters are read.
+	federationRefresh = time.Minute
+
+	federationLabel = "prairie.kismi/federation"
+
+	// federationFinalizer removes the AddressPools of a deleted federation
+	// from its members.
+	federationFinalizer = "prairie.kismi/federation-pools"
+)
+
+// Remote is a member cluster of a federation
+type Remote struct {
+	client.Client
+
+	// Daemon reaches the agents of the cluster through the pods proxy of
+	// its API server, addressed as "<namespace>/<pod>".
+	Daemon daemon.Client
+
+	// SecureDaemon reaches SPIFFE enabled agents by their pod IP, the pods
+	// proxy can't present the SVID of the operator. Nil without an SVID.
+	SecureDaemon daemon.Client
+}
+
+// replica returns the client and address reaching a replica of the
+// cluster over its control channel.
+func (remote *Remote) replica(ctx context.Context, namespace, name string) (daemon.Client, string, error) {
+	pod := &corev1.Pod{}
+	err := remote.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, pod)
+	if err != nil {
+		return nil, "", err
+	}
+	return remote.daemonFor(pod)
+}
+
+func (remote *Remote) daemonFor(pod *corev1.Pod) (daemon.Client, string, error) {
+	if pod.Annotations[controlChannelAnnotation] != controlChannelSPIFFE {
+		return remote.Daemon, pod.Namespace + "/" + pod.Name, nil
+	}
+	if remote.SecureDaemon == nil {
+		return nil, "", fmt.Errorf("replica %s requires SPIFFE, the operator has no SVID", pod.Name)
+	}
+	return remote.SecureDaemon, pod.Status.PodIP, nil
+}
+
+// RemoteClientFunc returns the clients for the cluster described by a
+// kubeconfig
+type RemoteClientFunc func(kubeconfig []byte) (*Remote, error)
+
+// NewRemoteClient returns a RemoteClientFunc building clients with scheme.
+// SPIFFE enabled agents are reached with secure, if given.
+func NewRemoteClient(scheme *runtime.Scheme, secure daemon.Client) RemoteClientFunc {
+	return func(kubeconfig []byte) (*Remote, error) {
+		config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		c, err := client.New(config, client.Options{Scheme: scheme})
+		if err != nil {
+			return nil, err
+		}
+		http_client, err := rest.HTTPClientFor(config)
+		if err != nil {
+			return nil, err
+		}
+		// Changes made in a dry run aren't made to remote clusters either
+		remote := &Remote{Client: audit.NewClient(c), Daemon: dryRunDaemon{Client: daemon.NewProxyClient(http_client, config.Host)}}
+		if secure != nil {
+			remote.SecureDaemon = dryRunDaemon{Client: secure}
+		}
+		return remote, nil
+	}
+}
+
+// remoteCache keeps the clients of the member clusters, they are built once
+// per version of the kubeconfig Secret.
+type remoteCache struct {
+	mu      sync.Mutex
+	remotes map[string]cachedRemote
+}
+
+type cachedRemote struct {
+	resourceVersion string
+	remote          *Remote
+}
+
+// memberRemote returns the clients for a member of the federation.
+func (cache *remoteCache) memberRemote(ctx context.Context, c client.Client, remote_client RemoteClientFunc, federation *prairiev1.Federation, member prairiev1.FederationMember) (*Remote, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: member.KubeconfigSecretRef.Name, Namespace: federation.Namespace}, secret)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s/%s", secret.Namespace, secret.Name, member.KubeconfigSecretRef.Key)
+	cache.mu.Lock()
+	cached, ok := cache.remotes[key]
+	cache.mu.Unlock()
+	if ok && cached.resourceVersion == secret.ResourceVersion {
+		return cached.remote, nil
+	}
+
+	kubeconfig, ok := secret.Data[member.KubeconfigSecretRef.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no key %s", secret.Name, member.KubeconfigSecretRef.Key)
+	}
+	remote, err := remote_client(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.remotes == nil {
+		cache.remotes = map[string]cachedRemote{}
+	}
+	cache.remotes[key] = cachedRemote{resourceVersion: secret.ResourceVersion, remote: remote}
+	return remote, nil
+}
+
+// memberNamespace returns the namespace holding the HomeAgents of a member.
+func memberNamespace(federation *prairiev1.Federation, member prairiev1.FederationMember) string {
+	if member.Namespace != "" {
+		return member.Namespace
+	}
+	return federation.Namespace
+}
+
+// FederationReconciler reconciles a Federation object
+type FederationReconciler struct {
+	client.Client
+	Scheme       *runtime.Scheme
+	RemoteClient RemoteClientFunc
+
+	remotes remoteCache
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=federations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=federations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=federations/finalizers,verbs=update
+
+// Reconcile assigns every member cluster its share of the federation range,
+// publishes it to the member as an AddressPool and collects the HomeAgents
+// of the members into the status. Members which can't be reached keep their
+// share and are retried with the next refresh. Deleting the federation
+// removes the AddressPools from the members again.
+func (r *FederationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	federation := &prairiev1.Federation{}
+	err := r.Get(ctx, req.NamespacedName, federation)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !federation.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(federation, federationFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		for _, member := range federation.Spec.Members {
+			err = r.deleteMemberPool(ctx, federation, member)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "AddressPool of member could not be deleted.", "member", member.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(federation, federationFinalizer)
+		return ctrl.Result{}, r.Update(ctx, federation)
+	}
+	if controllerutil.AddFinalizer(federation, federationFinalizer) {
+		err = r.Update(ctx, federation)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	prefixes, err := assignPrefixes(federation)
+	if err != nil {
+		return ctrl.Result{}, r.setNotReady(ctx, federation, "InvalidRange", err.Error())
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&federation.Spec.HomeAgentSelector)
+	if err != nil {
+		return ctrl.Result{}, r.setNotReady(ctx, federation, "InvalidSelector", err.Error())
+	}
+
+	members := make([]prairiev1.FederationMemberStatus, 0, len(federation.Spec.Members))
+	unreachable := 0
+	bindings := int32(0)
+	for _, member := range federation.Spec.Members {
+		status := r.syncMember(ctx, federation, member, prefixes[member.Name], selector)
+		if !status.Reachable {
+			unreachable++
+			// Keep the last known view of the member
+			for _, previous := range federation.Status.Members {
+				if previous.Name == member.Name {
+					status.HomeAgents = previous.HomeAgents
+					status.Bindings = previous.Bindings
+					status.LastSyncTime = previous.LastSyncTime
+				}
+			}
+		}
+		bindings += status.Bindings
+		members = append(members, status)
+	}
+
+	federation.Status.Members = members
+	federation.Status.Bindings = bindings
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Ready",
+		Message: fmt.Sprintf("%d members in sync", len(members)),
+	}
+	if unreachable > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "MembersUnreachable"
+		condition.Message = fmt.Sprintf("%d of %d members could not be reached", unreachable, len(members))
+	}
+	meta.SetStatusCondition(&federation.Status.Conditions, condition)
+	err = r.Status().Update(ctx, federation)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: federationRefresh}, nil
+}
+
+func (r *FederationReconciler) setNotReady(ctx context.Context, federation *prairiev1.Federation, reason, message string) error {
+	meta.SetStatusCondition(&federation.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, federation)
+}
+
+// assignPrefixes returns the share of the federation range of every member.
+// Members keep the share recorded in the status as long as it is still part
+// of the range, new members get the lowest free one.
+func assignPrefixes(federation *prairiev1.Federation) (map[string]string, error) {
+	_, cidr, err := net.ParseCIDR(federation.Spec.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	length := int(federation.Spec.MemberPrefixLength)
+
+	prefixes := map[string]string{}
+	used := map[string]bool{}
+	for _, member := range federation.Status.Members {
+		_, prefix, err := net.ParseCIDR(member.Prefix)
+		if err != nil || !cidr.Contains(prefix.IP) {
+			continue
+		}
+		if ones, _ := prefix.Mask.Size(); ones != length {
+			continue
+		}
+		prefixes[member.Name] = member.Prefix
+		used[member.Prefix] = true
+	}
+
+	next := int64(0)
+	for _, member := range federation.Spec.Members {
+		if _, ok := prefixes[member.Name]; ok {
+			continue
+		}
+		for {
+			prefix, err := ipam.SubnetAt(federation.Spec.CIDR, length, next)
+			if err != nil {
+				return nil, err
+			}
+			next++
+			if !used[prefix] {
+				prefixes[member.Name] = prefix
+				used[prefix] = true
+				break
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// syncMember publishes the share of the member as an AddressPool and reads
+// its HomeAgents.
+func (r *FederationReconciler) syncMember(ctx context.Context, federation *prairiev1.Federation, member prairiev1.FederationMember, prefix string, selector labels.Selector) prairiev1.FederationMemberStatus {
+	status := prairiev1.FederationMemberStatus{Name: member.Name, Prefix: prefix}
+	unreachable := func(err error) prairiev1.FederationMemberStatus {
+		log.FromContext(ctx).Error(err, "Federation member could not be synced.", "federation", federation.Name, "member", member.Name)
+		status.Message = err.Error()
+		return status
+	}
+
+	remote, err := r.remotes.memberRemote(ctx, r.Client, r.RemoteClient, federation, member)
+	if err != nil {
+		return unreachable(err)
+	}
+
+	namespace := memberNamespace(federation, member)
+	pool := &prairiev1.AddressPool{}
+	err = remote.Get(ctx, types.NamespacedName{Name: federation.Name, Namespace: namespace}, pool)
+	if errors.IsNotFound(err) {
+		pool_labels := operatorLabels()
+		pool_labels[federationLabel] = federation.Name
+		pool = &prairiev1.AddressPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      federation.Name,
+				Namespace: namespace,
+				Labels:    pool_labels,
+			},
+			Spec: prairiev1.AddressPoolSpec{CIDRs: []string{prefix}},
+		}
+		err = remote.Create(ctx, pool)
+	} else if err == nil && (len(pool.Spec.CIDRs) != 1 || pool.Spec.CIDRs[0] != prefix) {
+		pool.Spec.CIDRs = []string{prefix}
+		err = remote.Update(ctx, pool)
+	}
+	if err != nil {
+		return unreachable(err)
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	err = remote.List(ctx, agents, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return unreachable(err)
+	}
+	for _, agent := range agents.Items {
+		federated := prairiev1.FederatedHomeAgent{
+			Name:     agent.Name,
+			Replicas: int32(len(agent.Status.Replicas)),
+		}
+		for _, replica := range agent.Status.Replicas {
+			federated.Bindings += replica.Bindings
+		}
+		status.HomeAgents = append(status.HomeAgents, federated)
+		status.Bindings += federated.Bindings
+	}
+
+	now := metav1.Now()
+	status.Reachable = true
+	status.LastSyncTime = &now
+	return status
+}
+
+// deleteMemberPool deletes the AddressPool the federation published to the
+// member. Members whose kubeconfig is gone can't be reached anymore and are
+// skipped.
+func (r *FederationReconciler) deleteMemberPool(ctx context.Context, federation *prairiev1.Federation, member prairiev1.FederationMember) error {
+	remote, err := r.remotes.memberRemote(ctx, r.Client, r.RemoteClient, federation, member)
+	if errors.IsNotFound(err) {
+		log.FromContext(ctx).Info("Kubeconfig of member is gone, leaving its AddressPool.", "member", member.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pool := &prairiev1.AddressPool{}
+	err = remote.Get(ctx, types.NamespacedName{Name: federation.Name, Namespace: memberNamespace(federation, member)}, pool)
+	if err != nil || pool.Labels[federationLabel] != federation.Name {
+		return client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).Info("Deleting AddressPool of member.", "member", member.Name)
+	return client.IgnoreNotFound(remote.Delete(ctx, pool))
+}
+
+// SetupWithManager sets up the controller with the Manager. The status
+// updates of the reconciler itself are filtered out, it requeues on its own.
+func (r *FederationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.Federation{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(metrics.NewReconciler("Federation", r))
+}
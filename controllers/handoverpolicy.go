@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const handoverPolicyField = ".spec.handoverPolicyRef.name"
+
+// GetHandoverPolicy returns the HandoverPolicy the agent refers to, or nil if
+// it does not use one.
+func (r *HomeAgentReconciler) GetHandoverPolicy(ctx context.Context, agent *prairiev1.HomeAgent) (*prairiev1.HandoverPolicy, error) {
+	if agent.Spec.HandoverPolicyRef == nil {
+		return nil, nil
+	}
+
+	policy := &prairiev1.HandoverPolicy{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Spec.HandoverPolicyRef.Name, Namespace: agent.Namespace}, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// applyHandoverPolicy fills the handover settings the rendered agent leaves
+// empty from the policy. Like the class defaults they are never written back
+// to the HomeAgent.
+func applyHandoverPolicy(rendered *prairiev1.HomeAgent, policy *prairiev1.HandoverPolicy) {
+	if policy == nil {
+		return
+	}
+	if rendered.Spec.Handover == nil {
+		rendered.Spec.Handover = &prairiev1.HandoverSpec{}
+	}
+	spec := rendered.Spec.Handover
+
+	if spec.SmoothHandoverWindow == nil && policy.Spec.SmoothHandoverWindow != nil {
+		window := *policy.Spec.SmoothHandoverWindow
+		spec.SmoothHandoverWindow = &window
+	}
+	if spec.MaxSimultaneousBindings == 0 {
+		spec.MaxSimultaneousBindings = policy.Spec.MaxSimultaneousBindings
+	}
+	if spec.Buffering == nil && policy.Spec.Buffering != nil {
+		spec.Buffering = policy.Spec.Buffering.DeepCopy()
+	}
+}
+
+// agentsOfHandoverPolicy maps a HandoverPolicy to the HomeAgents using it, so
+// changes to the policy are rolled out to them.
+func (r *HomeAgentReconciler) agentsOfHandoverPolicy(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{handoverPolicyField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "handoverpolicy", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
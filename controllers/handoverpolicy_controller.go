@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// HandoverPolicyReconciler reconciles a HandoverPolicy object
+type HandoverPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=handoverpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=handoverpolicies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=handoverpolicies/finalizers,verbs=update
+
+// Reconcile reports the HomeAgents using the policy. Translating the policy
+// into the daemon configuration is up to the HomeAgent controller.
+func (r *HandoverPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	policy := &prairiev1.HandoverPolicy{}
+	err := r.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	err = r.List(ctx, agents, client.InNamespace(policy.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	users := []string{}
+	for _, agent := range agents.Items {
+		if agent.Spec.HandoverPolicyRef != nil && agent.Spec.HandoverPolicyRef.Name == policy.Name {
+			users = append(users, agent.Name)
+		}
+	}
+
+	policy.Status.HomeAgents = users
+	return ctrl.Result{}, r.Status().Update(ctx, policy)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HandoverPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.HandoverPolicy{}).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.policiesOfNamespace)).
+		Complete(metrics.NewReconciler("HandoverPolicy", r))
+}
+
+// policiesOfNamespace maps a HomeAgent to the HandoverPolicies of its
+// namespace, so a policy notices agents dropping or changing the reference.
+func (r *HandoverPolicyReconciler) policiesOfNamespace(obj client.Object) []reconcile.Request {
+	policies := &prairiev1.HandoverPolicyList{}
+	err := r.List(context.Background(), policies, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "HandoverPolicies could not be listed.", "homeagent", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(policies.Items))
+	for idx, policy := range policies.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      policy.Name,
+			Namespace: policy.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+const (
+	cacheSyncCheckTimeout = time.Second
+	daemonCheckTimeout    = 5 * time.Second
+	// daemonCheckReplicas is how many replicas the daemon check asks
+	// before it gives up.
+	daemonCheckReplicas = 3
+)
+
+// CacheSyncCheck fails until the informers of the cache have synced.
+func CacheSyncCheck(informers cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncCheckTimeout)
+		defer cancel()
+		if !informers.WaitForCacheSync(ctx) {
+			return errors.New("informers have not synced yet")
+		}
+		return nil
+	}
+}
+
+// DaemonCheck fails if none of the ready HomeAgent replicas answers on its
+// management API, e.g. because the SVID of the operator expired or a
+// network policy cuts the operator off. Without ready replicas it passes.
+// It's a readiness check, a restart doesn't bring the replicas back.
+func DaemonCheck(reader client.Reader, plain daemon.Client, secure daemon.Client) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), daemonCheckTimeout)
+		defer cancel()
+
+		pods := &corev1.PodList{}
+		err := reader.List(ctx, pods, client.HasLabels{"parent"})
+		if err != nil {
+			return err
+		}
+
+		asked := 0
+		var last error
+		for idx := range pods.Items {
+			pod := &pods.Items[idx]
+			if asked == daemonCheckReplicas {
+				break
+			}
+			if !pod.DeletionTimestamp.IsZero() || pod.Status.PodIP == "" || !podConditionTrue(pod, corev1.ContainersReady) {
+				continue
+			}
+			agent := types.NamespacedName{Name: pod.Labels["parent"], Namespace: pod.Namespace}
+			if err := reader.Get(ctx, agent, &prairiev1.HomeAgent{}); err != nil {
+				continue
+			}
+
+			asked++
+			_, last = daemonFor(plain, secure, pod).Stats(ctx, pod.Status.PodIP)
+			if last == nil {
+				return nil
+			}
+		}
+		if asked == 0 {
+			return nil
+		}
+		return fmt.Errorf("none of %d replicas answered: %w", asked, last)
+	}
+}
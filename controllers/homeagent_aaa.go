@@ -0,0 +1,256 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// With RADIUS configured mo-daemon authenticates the registrations of the
+// mobile nodes of its realms with the servers, failing over in order. The
+// shared secret is mounted from its Secret. Diameter works alike with its
+// peers, over TLS with the certificate of its Secret.
+const (
+	radiusSecretMountPath = "/etc/mo-daemon/radius"
+	radiusSecretVolume    = "radius-secret"
+	defaultRADIUSPort     = 1812
+	defaultRADIUSTimeout  = 3 * time.Second
+	defaultRADIUSRetries  = 2
+	diameterTLSMountPath  = "/etc/mo-daemon/diameter"
+	diameterTLSVolume     = "diameter-tls"
+	defaultDiameterPort   = 3868
+	diameterFeature       = "diameter"
+	diameterCACertKey     = "ca.crt"
+)
+
+func radiusSpec(agent *prairiev1.HomeAgent) *prairiev1.RADIUSSpec {
+	if agent.Spec.AAA == nil {
+		return nil
+	}
+	return agent.Spec.AAA.RADIUS
+}
+
+// radiusServer renders a server as host:port, followed by its accounting
+// port if enabled.
+func radiusServer(server prairiev1.RADIUSServer) string {
+	port := server.AuthPort
+	if port == 0 {
+		port = defaultRADIUSPort
+	}
+	rendered := net.JoinHostPort(server.Host, strconv.Itoa(int(port)))
+	if server.AccountingPort != 0 {
+		rendered += ";acct_port=" + strconv.Itoa(int(server.AccountingPort))
+	}
+	return rendered
+}
+
+func diameterSpec(agent *prairiev1.HomeAgent) *prairiev1.DiameterSpec {
+	if agent.Spec.AAA == nil {
+		return nil
+	}
+	return agent.Spec.AAA.Diameter
+}
+
+// aaaSettings configures the AAA clients of mo-daemon.
+func aaaSettings(agent *prairiev1.HomeAgent) []setting {
+	if diameter := diameterSpec(agent); diameter != nil {
+		return diameterSettings(diameter)
+	}
+	radius := radiusSpec(agent)
+	if radius == nil {
+		return nil
+	}
+
+	servers := make([]string, len(radius.Servers))
+	for idx, server := range radius.Servers {
+		servers[idx] = radiusServer(server)
+	}
+	timeout := defaultRADIUSTimeout
+	if radius.Timeout != nil {
+		timeout = radius.Timeout.Duration
+	}
+	retries := int32(defaultRADIUSRetries)
+	if radius.Retries != nil {
+		retries = *radius.Retries
+	}
+	settings := []setting{
+		{Key: "radius_servers", Value: strings.Join(servers, ",")},
+		{Key: "radius_secret", Value: path.Join(radiusSecretMountPath, radius.SecretRef.Key)},
+		{Key: "radius_timeout_ms", Value: strconv.FormatInt(timeout.Milliseconds(), 10)},
+		{Key: "radius_retries", Value: strconv.Itoa(int(retries))},
+	}
+	if len(radius.Realms) > 0 {
+		settings = append(settings, setting{Key: "radius_realms", Value: strings.Join(radius.Realms, ",")})
+	}
+	if radius.StripRealm {
+		settings = append(settings, setting{Key: "radius_strip_realm", Value: "on"})
+	}
+	return settings
+}
+
+// diameterSettings configures the Diameter client of mo-daemon.
+func diameterSettings(diameter *prairiev1.DiameterSpec) []setting {
+	peers := make([]string, len(diameter.Peers))
+	for idx, peer := range diameter.Peers {
+		port := peer.Port
+		if port == 0 {
+			port = defaultDiameterPort
+		}
+		peers[idx] = net.JoinHostPort(peer.Host, strconv.Itoa(int(port)))
+	}
+	settings := []setting{
+		{Key: "diameter_peers", Value: strings.Join(peers, ",")},
+		{Key: "diameter_origin_realm", Value: diameter.OriginRealm},
+		{Key: "diameter_dest_realm", Value: diameter.DestinationRealm},
+	}
+	if diameter.TLSSecretRef != nil {
+		settings = append(settings,
+			setting{Key: "diameter_tls_cert", Value: path.Join(diameterTLSMountPath, corev1.TLSCertKey)},
+			setting{Key: "diameter_tls_key", Value: path.Join(diameterTLSMountPath, corev1.TLSPrivateKeyKey)},
+			setting{Key: "diameter_tls_ca", Value: path.Join(diameterTLSMountPath, diameterCACertKey)},
+		)
+	}
+	return settings
+}
+
+// aaaTemplate mounts the shared secret of the RADIUS servers, or the TLS
+// certificate for the Diameter peers.
+func aaaTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	var volume corev1.Volume
+	var mount corev1.VolumeMount
+	if radius := radiusSpec(agent); radius != nil {
+		volume = corev1.Volume{
+			Name: radiusSecretVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: radius.SecretRef.Name, Optional: radius.SecretRef.Optional},
+			},
+		}
+		mount = corev1.VolumeMount{Name: radiusSecretVolume, MountPath: radiusSecretMountPath, ReadOnly: true}
+	}
+	if diameter := diameterSpec(agent); diameter != nil && diameter.TLSSecretRef != nil {
+		volume = corev1.Volume{
+			Name: diameterTLSVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: diameter.TLSSecretRef.Name},
+			},
+		}
+		mount = corev1.VolumeMount{Name: diameterTLSVolume, MountPath: diameterTLSMountPath, ReadOnly: true}
+	}
+	if volume.Name == "" {
+		return
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, volume)
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, mount)
+	}
+}
+
+// checkAAA makes sure the agent delegates to a single AAA protocol, and
+// that its release is built with Diameter if it uses it. The release is
+// taken from spec.version or looked up by the image in the release
+// catalog, images which aren't in the catalog are trusted.
+func (r *HomeAgentReconciler) checkAAA(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if radiusSpec(rendered) != nil && diameterSpec(rendered) != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidAAA", "RADIUS and Diameter are mutually exclusive")
+	}
+	if diameterSpec(rendered) == nil {
+		return true, nil
+	}
+
+	release := r.releases().find(rendered.Spec.Version)
+	if rendered.Spec.Version == "" {
+		release = r.releases().byImage(agentImage(rendered))
+	}
+	if release != nil && !release.hasFeature(diameterFeature) {
+		message := fmt.Sprintf("Image %s of release %s is built without Diameter", agentImage(rendered), release.Version)
+		return false, r.markDegraded(ctx, agent, "DiameterUnsupported", message)
+	}
+	return true, nil
+}
+
+// aaaStatus counts the AAA servers reported by a replica by whether it
+// reaches them.
+func aaaStatus(servers []daemon.AAAStats) *prairiev1.AAAStatus {
+	if len(servers) == 0 {
+		return nil
+	}
+	status := &prairiev1.AAAStatus{}
+	for _, server := range servers {
+		if server.Reachable {
+			status.Reachable++
+		} else {
+			status.Unreachable = append(status.Unreachable, server.Server)
+		}
+	}
+	sort.Strings(status.Unreachable)
+	return status
+}
+
+// setAAACondition summarizes the AAA servers the replicas reach in the
+// AAAReachable condition. A replica reaching some of its servers still
+// authenticates, the unreachable ones are reported nonetheless.
+func setAAACondition(agent *prairiev1.HomeAgent, replicas []prairiev1.ReplicaStatus) {
+	if radiusSpec(agent) == nil && diameterSpec(agent) == nil {
+		meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionAAAReachable)
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionAAAReachable,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: "Every replica reaches its AAA servers",
+	}
+	isolated := []string{}
+	degraded := []string{}
+	for _, replica := range replicas {
+		if replica.AAA == nil || len(replica.AAA.Unreachable) == 0 {
+			continue
+		}
+		entry := fmt.Sprintf("%s: %s", replica.Name, strings.Join(replica.AAA.Unreachable, " "))
+		if replica.AAA.Reachable == 0 {
+			isolated = append(isolated, entry)
+		} else {
+			degraded = append(degraded, entry)
+		}
+	}
+	switch {
+	case len(isolated) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Unreachable"
+		condition.Message = "Replicas reaching no AAA server: " + strings.Join(isolated, ", ")
+	case len(degraded) > 0:
+		condition.Reason = "PartiallyReachable"
+		condition.Message = "Unreachable AAA servers per replica: " + strings.Join(degraded, ", ")
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+}
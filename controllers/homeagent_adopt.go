@@ -0,0 +1,139 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// adoptAnnotation set to "true" on a HomeAgent lets the operator take over
+// a workload of the same name it didn't create, e.g. one deployed by hand
+// before the HomeAgent was.
+const adoptAnnotation = "prairie.kismi/adopt"
+
+// createdByOperator tells workloads the operator created for the agent of
+// the name. They carry the hash of their template, or, if created by a
+// version of the operator before the hash, the parent label of the agent.
+// The first versions put that label on the selector and the pod template
+// only.
+func createdByOperator(workload client.Object, agent string) bool {
+	if _, ok := workload.GetAnnotations()[templateHashAnnotation]; ok {
+		return true
+	}
+	if workload.GetLabels()["parent"] == agent {
+		return true
+	}
+	if selector := workloadSelector(workload); selector != nil && selector.MatchLabels["parent"] == agent {
+		return true
+	}
+	template := workloadTemplate(workload)
+	return template != nil && template.Labels["parent"] == agent
+}
+
+// managedWorkload reports whether the workload belongs to the agent of the
+// name, rather than to someone else.
+func managedWorkload(workload client.Object, agent string) bool {
+	owner := metav1.GetControllerOf(workload)
+	if owner == nil {
+		return createdByOperator(workload, agent)
+	}
+	return owner.Kind == "HomeAgent" && owner.Name == agent
+}
+
+func workloadKind(workload client.Object) string {
+	if _, ok := workload.(*appsv1.StatefulSet); ok {
+		return "StatefulSet"
+	}
+	return "Deployment"
+}
+
+func workloadSelector(workload client.Object) *metav1.LabelSelector {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return w.Spec.Selector
+	case *appsv1.Deployment:
+		return w.Spec.Selector
+	}
+	return nil
+}
+
+// adoptWorkload makes the agent the controller of its workload. Workloads
+// created by an earlier version of the operator, i.e. with the hash of
+// their template or the parent label of the agent, are taken over right
+// away, other workloads only with the adopt annotation on the agent, and as
+// long as their selector matches the replicas of the agent. Workloads of
+// another controller are left alone. The workload is brought in line with
+// the spec by the reconcile once adopted.
+func (r *HomeAgentReconciler) adoptWorkload(ctx context.Context, agent *prairiev1.HomeAgent, workload, desired client.Object) (bool, error) {
+	if metav1.IsControlledBy(workload, agent) {
+		return true, nil
+	}
+	kind := workloadKind(workload)
+
+	if owner := metav1.GetControllerOf(workload); owner != nil {
+		message := fmt.Sprintf("%s %s is controlled by %s %s", kind, workload.GetName(), owner.Kind, owner.Name)
+		return false, r.markDegraded(ctx, agent, "WorkloadConflict", message)
+	}
+	created := createdByOperator(workload, agent.Name)
+	if !created {
+		if agent.Annotations[adoptAnnotation] != "true" {
+			message := fmt.Sprintf("%s %s exists and isn't managed by the HomeAgent, annotate the HomeAgent with %s=true to adopt it",
+				kind, workload.GetName(), adoptAnnotation)
+			return false, r.markDegraded(ctx, agent, "WorkloadConflict", message)
+		}
+		selector, err := metav1.LabelSelectorAsSelector(workloadSelector(workload))
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(workloadTemplate(desired).Labels)) {
+			message := fmt.Sprintf("%s %s can't be adopted, its selector %s doesn't match the replicas of the HomeAgent",
+				kind, workload.GetName(), metav1.FormatLabelSelector(workloadSelector(workload)))
+			return false, r.markDegraded(ctx, agent, "AdoptionFailed", message)
+		}
+	}
+
+	if err := ctrl.SetControllerReference(agent, workload, r.Scheme); err != nil {
+		return false, err
+	}
+	// The hash marks the workload as the operator's even if the owner
+	// reference goes away, it differs from the desired one until updated
+	annotations := workload.GetAnnotations()
+	if _, ok := annotations[templateHashAnnotation]; !ok {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[templateHashAnnotation] = templateHash(workloadTemplate(workload))
+		workload.SetAnnotations(annotations)
+	}
+	if err := r.Update(ctx, workload); err != nil {
+		return false, err
+	}
+	if !created {
+		log.FromContext(ctx).Info("Workload adopted.", "workload", workload.GetName())
+		r.Recorder.Event(agent, corev1.EventTypeNormal, "WorkloadAdopted",
+			fmt.Sprintf("%s %s is managed by the HomeAgent now", kind, workload.GetName()))
+	}
+	return true, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// baselineDeployment is shaped like the Deployments of the first releases,
+// which had the parent label on the selector and pod template only.
+func baselineDeployment(name string) *appsv1.Deployment {
+	labels := map[string]string{"parent": name}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "ha",
+					Image: "kismi/mo-daemon:latest",
+				}}},
+			},
+		},
+	}
+}
+
+var _ = Describe("Workload adoption", func() {
+	It("recognises workloads of the first releases", func() {
+		deployment := baselineDeployment("ha")
+		Expect(createdByOperator(deployment, "ha")).To(BeTrue())
+		Expect(managedWorkload(deployment, "ha")).To(BeTrue())
+		Expect(createdByOperator(deployment, "other")).To(BeFalse())
+
+		deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ha"}}
+		Expect(createdByOperator(deployment, "ha")).To(BeTrue())
+	})
+
+	It("recognises workloads by their labels and template hash", func() {
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name:   "ha",
+			Labels: map[string]string{"parent": "ha"},
+		}}
+		Expect(createdByOperator(deployment, "ha")).To(BeTrue())
+
+		stateful_set := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{
+			Name:        "ha",
+			Annotations: map[string]string{templateHashAnnotation: "1"},
+		}}
+		Expect(createdByOperator(stateful_set, "ha")).To(BeTrue())
+	})
+
+	It("leaves workloads deployed by hand alone", func() {
+		labels := map[string]string{"app": "mo-daemon"}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "ha"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+			},
+		}
+		Expect(createdByOperator(deployment, "ha")).To(BeFalse())
+		Expect(managedWorkload(deployment, "ha")).To(BeFalse())
+
+		deployment = baselineDeployment("ha")
+		controller := true
+		deployment.OwnerReferences = []metav1.OwnerReference{{Kind: "Rollout", Name: "ha", Controller: &controller}}
+		Expect(managedWorkload(deployment, "ha")).To(BeFalse())
+	})
+})
@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// An agent runs on nodes of a single architecture, its pods all run the
+// same image. Fleets mixing architectures run an agent per architecture,
+// or multi-arch images without setting any.
+
+// imageFor returns the image of the architecture, if there is one.
+func imageFor(images *prairiev1.ArchitectureImages, architecture prairiev1.Architecture) string {
+	if images == nil {
+		return ""
+	}
+	switch architecture {
+	case prairiev1.ArchitectureAMD64:
+		return images.AMD64
+	case prairiev1.ArchitectureARM64:
+		return images.ARM64
+	}
+	return ""
+}
+
+// architectureImage returns the image overriding spec.image for the
+// architecture of the agent, if any. Agents selecting a release take the
+// image of the release for their architecture from the catalog instead.
+func architectureImage(agent *prairiev1.HomeAgent) string {
+	if agent.Spec.Version != "" {
+		return ""
+	}
+	return imageFor(agent.Spec.ArchitectureImages, agent.Spec.Architecture)
+}
+
+// architectureTemplate keeps the agent pods on nodes of the architecture
+// their image is built for.
+func architectureTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if agent.Spec.Architecture == "" {
+		return
+	}
+	requireNodeLabels(template, []corev1.NodeSelectorRequirement{{
+		Key:      corev1.LabelArchStable,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{string(agent.Spec.Architecture)},
+	}})
+}
+
+// checkArchitecture reports whether the image overrides of the agent can be
+// told apart, and marks it degraded otherwise. Overrides taken from the
+// class are just left unused.
+func (r *HomeAgentReconciler) checkArchitecture(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if agent.Spec.ArchitectureImages == nil || agent.Spec.Architecture != "" {
+		return true, nil
+	}
+	return false, r.markDegraded(ctx, agent, "InvalidArchitecture", "architectureImages requires an architecture selecting the image")
+}
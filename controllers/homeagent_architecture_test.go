@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+var _ = Describe("Architecture images", func() {
+	images := &prairiev1.ArchitectureImages{
+		AMD64: "kismi/mo-daemon:1.4.1",
+		ARM64: "kismi/mo-daemon:1.4.1-arm64",
+	}
+	catalog := ReleaseCatalog{
+		{Version: "1.3", Image: "kismi/mo-daemon:1.3.4"},
+		{Version: "1.4", Image: "kismi/mo-daemon:1.4.2", ArchitectureImages: &prairiev1.ArchitectureImages{
+			ARM64: "kismi/mo-daemon:1.4.2-arm64",
+		}},
+	}
+
+	It("overrides the image for the architecture of the agent", func() {
+		agent := &prairiev1.HomeAgent{Spec: prairiev1.HomeAgentSpec{
+			Image:              "kismi/mo-daemon:1.4.1",
+			Architecture:       prairiev1.ArchitectureARM64,
+			ArchitectureImages: images,
+		}}
+		Expect(architectureImage(agent)).To(Equal("kismi/mo-daemon:1.4.1-arm64"))
+
+		agent.Spec.Architecture = ""
+		Expect(architectureImage(agent)).To(BeEmpty())
+	})
+
+	It("leaves agents selecting a release to the catalog", func() {
+		agent := &prairiev1.HomeAgent{Spec: prairiev1.HomeAgentSpec{
+			Version:            "1.4",
+			Architecture:       prairiev1.ArchitectureARM64,
+			ArchitectureImages: images,
+		}}
+		Expect(architectureImage(agent)).To(BeEmpty())
+	})
+
+	It("takes the image of a release for the architecture", func() {
+		release := catalog.find("1.4")
+		Expect(release.image(prairiev1.ArchitectureARM64)).To(Equal("kismi/mo-daemon:1.4.2-arm64"))
+		Expect(release.image(prairiev1.ArchitectureAMD64)).To(Equal("kismi/mo-daemon:1.4.2"))
+		Expect(release.image("")).To(Equal("kismi/mo-daemon:1.4.2"))
+		Expect(catalog.find("1.3").image(prairiev1.ArchitectureARM64)).To(Equal("kismi/mo-daemon:1.3.4"))
+	})
+
+	It("finds the release of an image built for an architecture", func() {
+		Expect(catalog.versionOf("kismi/mo-daemon:1.4.2-arm64")).To(Equal("1.4"))
+		Expect(catalog.versionOf("kismi/mo-daemon:1.4.2")).To(Equal("1.4"))
+		Expect(catalog.versionOf("kismi/mo-daemon:1.4.1-arm64")).To(BeEmpty())
+	})
+
+	It("keeps the pods on nodes of the architecture", func() {
+		agent := &prairiev1.HomeAgent{Spec: prairiev1.HomeAgentSpec{Architecture: prairiev1.ArchitectureARM64}}
+		template := &corev1.PodTemplateSpec{}
+		architectureTemplate(agent, template)
+		Expect(template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(ConsistOf(
+			corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{
+				Key:      corev1.LabelArchStable,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"arm64"},
+			}}},
+		))
+
+		template = &corev1.PodTemplateSpec{}
+		architectureTemplate(&prairiev1.HomeAgent{}, template)
+		Expect(template.Spec.Affinity).To(BeNil())
+	})
+})
@@ -0,0 +1,148 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+)
+
+// maxEventMessage is the longest message of an event kept by the API
+// server.
+const maxEventMessage = 1024
+
+// changeTrail holds the changes made for each HomeAgent until they are
+// added to its status. Changes made before a reconcile ended early are
+// added by the next status update.
+type changeTrail struct {
+	mu      sync.Mutex
+	pending map[types.NamespacedName][]audit.Entry
+}
+
+func (t *changeTrail) add(name types.NamespacedName, entry audit.Entry) {
+	t.mu.Lock()
+	if t.pending == nil {
+		t.pending = map[types.NamespacedName][]audit.Entry{}
+	}
+	t.pending[name] = append(t.pending[name], entry)
+	t.mu.Unlock()
+}
+
+// peek returns the pending changes of the agent.
+func (t *changeTrail) peek(name types.NamespacedName) []audit.Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]audit.Entry(nil), t.pending[name]...)
+}
+
+// drop removes the first count pending changes of the agent, once they
+// are in its status.
+func (t *changeTrail) drop(name types.NamespacedName, count int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if count >= len(t.pending[name]) {
+		delete(t.pending, name)
+		return
+	}
+	t.pending[name] = t.pending[name][count:]
+}
+
+// reset forgets the pending changes of the agent.
+func (t *changeTrail) reset(name types.NamespacedName) {
+	t.mu.Lock()
+	delete(t.pending, name)
+	t.mu.Unlock()
+}
+
+// auditContext returns a context the changes made for the agent are kept
+// with, if the agents keep a history of them.
+func (r *HomeAgentReconciler) auditContext(ctx context.Context, req ctrl.Request) context.Context {
+	if r.AuditHistory <= 0 {
+		return ctx
+	}
+	return audit.WithRecorder(ctx, func(entry audit.Entry) {
+		r.changes.add(req.NamespacedName, entry)
+	})
+}
+
+// recordChanges adds the pending changes to the status of the agent,
+// keeping the most recent AuditHistory. It returns the number of changes
+// added.
+func (r *HomeAgentReconciler) recordChanges(agent *prairiev1.HomeAgent) int {
+	if r.AuditHistory <= 0 {
+		agent.Status.Changes = nil
+		return 0
+	}
+	pending := r.changes.peek(types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace})
+	// Changes reported by a dry run were never made
+	changes := []prairiev1.Change{}
+	for _, change := range agent.Status.Changes {
+		if !change.DryRun {
+			changes = append(changes, change)
+		}
+	}
+	for _, entry := range pending {
+		changes = append(changes, changeOf(entry))
+	}
+	agent.Status.Changes = changes
+	if excess := len(agent.Status.Changes) - r.AuditHistory; excess > 0 {
+		agent.Status.Changes = agent.Status.Changes[excess:]
+	}
+	return len(pending)
+}
+
+// changeOf returns the status of a change.
+func changeOf(entry audit.Entry) prairiev1.Change {
+	return prairiev1.Change{
+		Time:   metav1.NewTime(entry.Time),
+		Verb:   entry.Verb,
+		Kind:   entry.Kind,
+		Object: entry.Object(),
+		Fields: entry.Fields,
+		Reason: entry.Reason,
+		DryRun: entry.DryRun,
+	}
+}
+
+// updateEvent records an event on the agent with the diff of an update of
+// one of its objects.
+func (r *HomeAgentReconciler) updateEvent(ctx context.Context, agent *prairiev1.HomeAgent, reason, object string, diff []string) {
+	message := object + " updated:"
+	if audit.DryRun(ctx) {
+		message = object + " would be updated:"
+	}
+	for i, line := range diff {
+		// Changed lines of a field follow it on one line
+		if i > 0 && !strings.HasPrefix(line, "  ") {
+			message += ";"
+		}
+		message += " " + strings.TrimSpace(line)
+	}
+	if len(message) > maxEventMessage {
+		message = message[:maxEventMessage-3] + "..."
+	}
+	r.Recorder.Event(agent, corev1.EventTypeNormal, reason, message)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const (
+	// Defaults of the requeue intervals of HomeAgents waiting for their
+	// replicas.
+	DefaultRequeueInterval    = 800 * time.Millisecond
+	DefaultMaxRequeueInterval = time.Minute
+
+	// requeueJitter is the largest fraction added to an interval, so agents
+	// created together don't come back in lockstep.
+	requeueJitter = 0.2
+)
+
+// requeueBackoff hands out growing requeue intervals to each HomeAgent
+// waiting on something no watch reports, like a rollout deadline, so
+// slow-starting daemons don't cause hot requeue loops. The interval doubles with every requeue of the agent and
+// starts over once the agent has been reconciled completely.
+type requeueBackoff struct {
+	mu       sync.Mutex
+	attempts map[types.NamespacedName]int
+}
+
+// next returns the interval for the next requeue of the agent.
+func (b *requeueBackoff) next(name types.NamespacedName, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = DefaultRequeueInterval
+	}
+	if max < base {
+		max = DefaultMaxRequeueInterval
+		if max < base {
+			max = base
+		}
+	}
+
+	b.mu.Lock()
+	if b.attempts == nil {
+		b.attempts = map[types.NamespacedName]int{}
+	}
+	attempt := b.attempts[name]
+	b.attempts[name] = attempt + 1
+	b.mu.Unlock()
+
+	interval := base
+	for i := 0; i < attempt && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+	return interval + time.Duration(rand.Float64()*requeueJitter*float64(interval))
+}
+
+// reset starts the intervals of the agent over.
+func (b *requeueBackoff) reset(name types.NamespacedName) {
+	b.mu.Lock()
+	delete(b.attempts, name)
+	b.mu.Unlock()
+}
+
+// requeueInterval returns when to look at an agent waiting for its
+// replicas again. spec.reconcile overrides the intervals of the operator.
+func (r *HomeAgentReconciler) requeueInterval(agent *prairiev1.HomeAgent) time.Duration {
+	base, max := r.RequeueInterval, r.MaxRequeueInterval
+	if reconcile := agent.Spec.Reconcile; reconcile != nil {
+		if reconcile.RequeueInterval != nil {
+			base = reconcile.RequeueInterval.Duration
+		}
+		if reconcile.MaxRequeueInterval != nil {
+			max = reconcile.MaxRequeueInterval.Duration
+		}
+	}
+	return r.backoff.next(client.ObjectKeyFromObject(agent), base, max)
+}
+
+// minResyncInterval keeps a short resync interval from flooding the API
+// server and the replicas with reconciles.
+const minResyncInterval = 30 * time.Second
+
+// resyncInterval returns after how long an agent is reconciled again even
+// if nothing changed, 0 if it's only reconciled on changes. Shorter
+// intervals than minResyncInterval are raised to it.
+func resyncInterval(agent *prairiev1.HomeAgent) time.Duration {
+	if agent.Spec.Reconcile == nil || agent.Spec.Reconcile.ResyncInterval == nil || agent.Spec.Reconcile.ResyncInterval.Duration <= 0 {
+		return 0
+	}
+	if interval := agent.Spec.Reconcile.ResyncInterval.Duration; interval > minResyncInterval {
+		return interval
+	}
+	return minResyncInterval
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// Backups are taken by mo-daemon itself: every replica writes a snapshot of
+// its binding state to the destination of the agent, a mounted volume or an
+// object store. Restoring hands the snapshots of a backup to every replica,
+// which imports the bindings. Restored replicas are marked with
+// restoredAnnotation so each is seeded once.
+const (
+	backupMountPath            = "/var/backups/mo-daemon"
+	backupVolume               = "backup"
+	backupCredentialsMountPath = "/etc/mo-daemon/backup-credentials"
+	backupCredentialsVolume    = "backup-credentials"
+
+	restoredAnnotation = "prairie.kismi/restored-from"
+	scheduledLabel     = "prairie.kismi/scheduled"
+	restoreFromField   = ".spec.restoreFrom.name"
+
+	defaultKeepLast = 7
+)
+
+// backupLocation is where a replica writes its snapshot for a backup to.
+func backupLocation(agent *prairiev1.HomeAgent, backup, replica string) string {
+	destination := agent.Spec.Backup.Destination
+	if destination.ObjectStore != nil {
+		return strings.TrimSuffix(destination.ObjectStore.URL, "/") + "/" + path.Join(backup, replica+".db")
+	}
+	return path.Join(backupMountPath, backup, replica+".db")
+}
+
+// backupSettings points mo-daemon at the credentials of the object store.
+func backupSettings(agent *prairiev1.HomeAgent) []setting {
+	if agent.Spec.Backup == nil || agent.Spec.Backup.Destination.ObjectStore == nil ||
+		agent.Spec.Backup.Destination.ObjectStore.CredentialsSecretRef == nil {
+		return nil
+	}
+	return []setting{{Key: "backup_credentials", Value: backupCredentialsMountPath}}
+}
+
+// backupTemplate mounts the backup destination into the pod template.
+func backupTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if agent.Spec.Backup == nil {
+		return
+	}
+
+	destination := agent.Spec.Backup.Destination
+	volume := corev1.Volume{}
+	mount := corev1.VolumeMount{}
+	switch {
+	case destination.VolumeClaimName != "":
+		volume = corev1.Volume{
+			Name: backupVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: destination.VolumeClaimName},
+			},
+		}
+		mount = corev1.VolumeMount{Name: backupVolume, MountPath: backupMountPath}
+	case destination.ObjectStore != nil && destination.ObjectStore.CredentialsSecretRef != nil:
+		volume = corev1.Volume{
+			Name: backupCredentialsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: destination.ObjectStore.CredentialsSecretRef.Name},
+			},
+		}
+		mount = corev1.VolumeMount{Name: backupCredentialsVolume, MountPath: backupCredentialsMountPath, ReadOnly: true}
+	default:
+		return
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, volume)
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, mount)
+	}
+}
+
+// reconcileBackups takes the scheduled backups of the agent and prunes the
+// ones beyond spec.backup.keepLast. It returns the time until the next
+// backup is due, zero without a schedule.
+func (r *HomeAgentReconciler) reconcileBackups(ctx context.Context, agent *prairiev1.HomeAgent) (time.Duration, error) {
+	if agent.Spec.Backup == nil || agent.Spec.Backup.Schedule == nil {
+		return 0, nil
+	}
+
+	backups := &prairiev1.HomeAgentBackupList{}
+	err := r.List(ctx, backups, client.InNamespace(agent.Namespace), client.MatchingLabels{"parent": agent.Name, scheduledLabel: "true"})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(backups.Items, func(i, j int) bool {
+		return backups.Items[j].CreationTimestamp.Before(&backups.Items[i].CreationTimestamp)
+	})
+
+	interval := agent.Spec.Backup.Schedule.Duration
+	next := interval
+	if len(backups.Items) == 0 || time.Since(backups.Items[0].CreationTimestamp.Time) >= interval {
+		labels := componentLabels(agent, componentBackup)
+		labels[scheduledLabel] = "true"
+		backup := &prairiev1.HomeAgentBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", agent.Name, time.Now().Unix()),
+				Namespace:   agent.Namespace,
+				Labels:      labels,
+				Annotations: commonAnnotations(agent),
+			},
+			Spec: prairiev1.HomeAgentBackupSpec{
+				HomeAgentRef: corev1.LocalObjectReference{Name: agent.Name},
+			},
+		}
+		// Backups aren't owned by the agent, they outlive it to restore it
+		if err := r.Create(ctx, backup); err != nil {
+			return 0, err
+		}
+		log.FromContext(ctx).Info("Scheduled backup created.", "backup", backup.Name)
+		backups.Items = append([]prairiev1.HomeAgentBackup{*backup}, backups.Items...)
+	} else {
+		next = interval - time.Since(backups.Items[0].CreationTimestamp.Time)
+	}
+
+	keep := int(agent.Spec.Backup.KeepLast)
+	if keep == 0 {
+		keep = defaultKeepLast
+	}
+	errs := []error{}
+	for idx := range backups.Items {
+		backup := &backups.Items[idx]
+		if metav1.IsControlledBy(backup, agent) {
+			// Taken before backups outlived their agent
+			backup.OwnerReferences = nil
+			if err := r.Update(ctx, backup); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if idx < keep || (agent.Spec.RestoreFrom != nil && agent.Spec.RestoreFrom.Name == backup.Name) {
+			continue
+		}
+		log.FromContext(ctx).Info("Pruning backup.", "backup", backup.Name)
+		if err := r.Delete(ctx, backup); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, err)
+		}
+	}
+	return next, utilerrors.NewAggregate(errs)
+}
+
+// restoreReplicas seeds every running replica which hasn't been restored
+// from spec.restoreFrom yet and records the progress in the Restored
+// condition. Replicas which can't be restored are retried with the next
+// reconcile.
+func (r *HomeAgentReconciler) restoreReplicas(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	if agent.Spec.RestoreFrom == nil {
+		if meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionRestored) != nil {
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionRestored)
+			return r.Status().Update(ctx, agent)
+		}
+		return nil
+	}
+
+	name := agent.Spec.RestoreFrom.Name
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionRestored,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Restored",
+		Message: fmt.Sprintf("Every replica has been seeded from HomeAgentBackup %s", name),
+	}
+	backup := &prairiev1.HomeAgentBackup{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: agent.Namespace}, backup)
+	if errors.IsNotFound(err) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BackupNotFound"
+		condition.Message = fmt.Sprintf("HomeAgentBackup %s does not exist", name)
+	} else if err != nil {
+		return err
+	} else if backup.Status.Phase != prairiev1.BackupCompleted {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "BackupNotCompleted"
+		condition.Message = fmt.Sprintf("HomeAgentBackup %s is not completed", name)
+	} else {
+		locations := make([]string, len(backup.Status.Snapshots))
+		for idx, snapshot := range backup.Status.Snapshots {
+			locations[idx] = snapshot.Location
+		}
+
+		pods := &corev1.PodList{}
+		err = r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+		if err != nil {
+			return err
+		}
+		pending := 0
+		for idx := range pods.Items {
+			pod := &pods.Items[idx]
+			if !pod.DeletionTimestamp.IsZero() || pod.Annotations[restoredAnnotation] == name {
+				continue
+			}
+			if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+				pending++
+				continue
+			}
+			if err := daemonFor(r.Daemon, r.SecureDaemon, pod).Restore(ctx, pod.Status.PodIP, locations); err != nil {
+				log.FromContext(ctx).Error(err, "Replica could not be restored.", "pod", pod.Name, "backup", name)
+				pending++
+				continue
+			}
+			if pod.Annotations == nil {
+				pod.Annotations = map[string]string{}
+			}
+			pod.Annotations[restoredAnnotation] = name
+			if err := r.Update(ctx, pod); err != nil {
+				return err
+			}
+			log.FromContext(ctx).Info("Replica restored.", "pod", pod.Name, "backup", name)
+		}
+		if pending > 0 {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "Restoring"
+			condition.Message = fmt.Sprintf("%d replicas are waiting to be seeded from HomeAgentBackup %s", pending, name)
+		}
+	}
+
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionRestored)
+	if current == nil || current.Status != condition.Status || current.Message != condition.Message {
+		meta.SetStatusCondition(&agent.Status.Conditions, condition)
+		return r.Status().Update(ctx, agent)
+	}
+	return nil
+}
+
+// agentsRestoringFrom maps a HomeAgentBackup to the HomeAgents restoring
+// from it, so they are seeded once it completes.
+func (r *HomeAgentReconciler) agentsRestoringFrom(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents, client.InNamespace(obj.GetNamespace()), client.MatchingFields{restoreFromField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "backup", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,399 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The home prefixes are advertised by frr-k8s, from an FRRConfiguration
+// selecting the nodes the serving replicas run on, so the returning traffic
+// reaches them. frr-k8s only reads FRRConfigurations of its own namespace,
+// which can't be owned by the agent: the frrFinalizer keeps the agent until
+// the operator deleted it, it is found by its homeAgentAnnotation.
+// FRRConfiguration is optional, the operator only touches it when asked to.
+//
+// The sessions are set up by the nodes of the cluster, so agents may only
+// advertise prefixes and peer with routers the operator allows.
+const (
+	// DefaultFRRNamespace is the namespace frr-k8s is installed in by
+	// default.
+	DefaultFRRNamespace = "frr-k8s-system"
+
+	homeAgentAnnotation = "prairie.kismi/homeagent"
+	hostnameLabel       = "kubernetes.io/hostname"
+	frrFinalizer        = "prairie.kismi/frr-configuration"
+)
+
+//+kubebuilder:rbac:groups=frrk8s.metallb.io,resources=frrconfigurations,verbs=get;list;watch;create;update;patch;delete
+
+var frrConfigurationKind = schema.GroupVersionKind{Group: "frrk8s.metallb.io", Version: "v1beta1", Kind: "FRRConfiguration"}
+
+func (r *HomeAgentReconciler) frrNamespace() string {
+	if r.FRRNamespace == "" {
+		return DefaultFRRNamespace
+	}
+	return r.FRRNamespace
+}
+
+// frrConfigurationKey returns the key of the FRRConfiguration of an agent,
+// named after its namespace and name.
+func (r *HomeAgentReconciler) frrConfigurationKey(agent types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{Name: agent.Namespace + "-" + agent.Name, Namespace: r.frrNamespace()}
+}
+
+// advertisedPrefixes returns the prefixes routed to the agent: the prefix of
+// its network, its home prefixes and its MIPv4 home network.
+func advertisedPrefixes(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) []string {
+	candidates := []string{}
+	if network != nil {
+		candidates = append(candidates, network.Spec.Prefix)
+	}
+	for _, prefix := range agent.Spec.HomePrefixes {
+		candidates = append(candidates, prefix.Prefix)
+	}
+	if agent.Spec.Protocols != nil && agent.Spec.Protocols.MIPv4 != nil {
+		candidates = append(candidates, agent.Spec.Protocols.MIPv4.HomeNetwork)
+	}
+
+	seen := map[string]bool{}
+	prefixes := []string{}
+	for _, candidate := range candidates {
+		_, prefix, err := net.ParseCIDR(candidate)
+		if err != nil || seen[prefix.String()] {
+			continue
+		}
+		seen[prefix.String()] = true
+		prefixes = append(prefixes, prefix.String())
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// allowedBy reports whether the prefix lies within one of the networks.
+func allowedBy(networks []*net.IPNet, prefix *net.IPNet) bool {
+	ones, bits := prefix.Mask.Size()
+	for _, network := range networks {
+		network_ones, network_bits := network.Mask.Size()
+		if network_bits == bits && network_ones <= ones && network.Contains(prefix.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBGP checks that the agent only advertises prefixes within
+// allowed_prefixes to routers within allowed_peers.
+func validateBGP(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork, allowed_prefixes, allowed_peers []*net.IPNet) error {
+	if agent.Spec.BGP == nil {
+		return nil
+	}
+	if len(allowed_prefixes) == 0 || len(allowed_peers) == 0 {
+		return fmt.Errorf("BGP advertisement is not allowed by the operator")
+	}
+	for _, candidate := range advertisedPrefixes(agent, network) {
+		_, prefix, _ := net.ParseCIDR(candidate)
+		if !allowedBy(allowed_prefixes, prefix) {
+			return fmt.Errorf("prefix %s is not allowed to be advertised", candidate)
+		}
+	}
+	for _, neighbor := range agent.Spec.BGP.Neighbors {
+		address := net.ParseIP(neighbor.Address)
+		if address == nil {
+			return fmt.Errorf("neighbor %s is not an IP address", neighbor.Address)
+		}
+		bits := 8 * len(address)
+		if address.To4() != nil {
+			address = address.To4()
+			bits = 32
+		}
+		if !allowedBy(allowed_peers, &net.IPNet{IP: address, Mask: net.CIDRMask(bits, bits)}) {
+			return fmt.Errorf("neighbor %s is not an allowed peer", neighbor.Address)
+		}
+	}
+	return nil
+}
+
+// checkBGP marks the agent degraded if it would advertise prefixes or peer
+// with routers the operator doesn't allow.
+func (r *HomeAgentReconciler) checkBGP(ctx context.Context, agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) (bool, error) {
+	if err := validateBGP(agent, network, r.BGPPrefixes, r.BGPPeers); err != nil {
+		return false, r.markDegraded(ctx, agent, "BGPNotAllowed", err.Error())
+	}
+	return true, nil
+}
+
+// reconcileBGPFinalizer adds the finalizer deleting the FRRConfiguration
+// while the agent advertises its prefixes. Agents which stopped
+// advertising had their FRRConfiguration deleted by reconcileBGP.
+func (r *HomeAgentReconciler) reconcileBGPFinalizer(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	changed := false
+	if agent.Spec.BGP != nil {
+		changed = controllerutil.AddFinalizer(agent, frrFinalizer)
+	} else if len(agent.Status.AdvertisedPrefixes) == 0 {
+		changed = controllerutil.RemoveFinalizer(agent, frrFinalizer)
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, agent)
+}
+
+// finalizeBGP deletes the FRRConfiguration of a deleted agent and removes
+// the finalizer, and reports whether the agent changed.
+func (r *HomeAgentReconciler) finalizeBGP(ctx context.Context, req ctrl.Request, agent *prairiev1.HomeAgent) (bool, error) {
+	if !controllerutil.ContainsFinalizer(agent, frrFinalizer) {
+		return false, nil
+	}
+	if err := r.DeleteFRRConfiguration(ctx, req); err != nil {
+		return false, err
+	}
+	return controllerutil.RemoveFinalizer(agent, frrFinalizer), nil
+}
+
+// frrTracker remembers the FRRConfiguration last applied for every agent,
+// by its hash, or "" if it has none. FRRConfigurations aren't cached, they
+// are only looked up when the desired one changes or after a restart of
+// the operator, so changes made to them by hand are kept until then.
+type frrTracker struct {
+	mu     sync.Mutex
+	hashes map[types.NamespacedName]string
+}
+
+func (t *frrTracker) unchanged(name types.NamespacedName, hash string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	applied, ok := t.hashes[name]
+	return ok && applied == hash
+}
+
+func (t *frrTracker) applied(name types.NamespacedName, hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hashes == nil {
+		t.hashes = map[types.NamespacedName]string{}
+	}
+	t.hashes[name] = hash
+}
+
+func (t *frrTracker) reset(name types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.hashes, name)
+}
+
+// frrConfigurationHash fingerprints the desired FRRConfiguration.
+func frrConfigurationHash(config *unstructured.Unstructured) string {
+	data, _ := json.Marshal(config.Object)
+	return configHash(string(data))
+}
+
+// advertisingNodes returns the nodes of the ready replicas, in
+// ActiveStandby mode only the node of the active one.
+func advertisingNodes(agent *prairiev1.HomeAgent, pods []corev1.Pod) []string {
+	seen := map[string]bool{}
+	nodes := []string{}
+	for i := range pods {
+		pod := &pods[i]
+		if !podReady(pod) || pod.Spec.NodeName == "" || seen[pod.Spec.NodeName] {
+			continue
+		}
+		if activeStandby(agent) && pod.Labels[roleLabel] != roleActive {
+			continue
+		}
+		seen[pod.Spec.NodeName] = true
+		nodes = append(nodes, pod.Spec.NodeName)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// frrConfiguration builds the FRRConfiguration advertising the prefixes
+// from the given nodes.
+func (r *HomeAgentReconciler) frrConfiguration(agent *prairiev1.HomeAgent, prefixes, nodes []string) *unstructured.Unstructured {
+	advertised := make([]interface{}, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		advertised = append(advertised, prefix)
+	}
+	hostnames := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		hostnames = append(hostnames, node)
+	}
+
+	neighbors := make([]interface{}, 0, len(agent.Spec.BGP.Neighbors))
+	for _, neighbor := range agent.Spec.BGP.Neighbors {
+		rendered := map[string]interface{}{
+			"address": neighbor.Address,
+			"asn":     neighbor.ASN,
+			"toAdvertise": map[string]interface{}{
+				"allowed": map[string]interface{}{"prefixes": advertised},
+			},
+		}
+		if neighbor.Port != 0 {
+			rendered["port"] = int64(neighbor.Port)
+		}
+		if neighbor.EBGPMultiHop {
+			rendered["ebgpMultiHop"] = true
+		}
+		neighbors = append(neighbors, rendered)
+	}
+
+	key := r.frrConfigurationKey(types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace})
+	config := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"nodeSelector": map[string]interface{}{
+				"matchExpressions": []interface{}{map[string]interface{}{
+					"key":      hostnameLabel,
+					"operator": "In",
+					"values":   hostnames,
+				}},
+			},
+			"bgp": map[string]interface{}{
+				"routers": []interface{}{map[string]interface{}{
+					"asn":       agent.Spec.BGP.ASN,
+					"prefixes":  advertised,
+					"neighbors": neighbors,
+				}},
+			},
+		},
+	}}
+	config.SetGroupVersionKind(frrConfigurationKind)
+	config.SetName(key.Name)
+	config.SetNamespace(key.Namespace)
+	config.SetLabels(componentLabels(agent, componentBGP))
+	annotations := commonAnnotations(agent)
+	annotations[homeAgentAnnotation] = agent.Namespace + "/" + agent.Name
+	config.SetAnnotations(annotations)
+	return config
+}
+
+// reconcileBGP manages the FRRConfiguration of the agent and records the
+// advertised prefixes in its status. Nothing is advertised while no
+// replica is ready. The FRRConfiguration is only looked up when the one the
+// agent needs changed. Without the FRRConfiguration resource installed a
+// warning is emitted instead.
+func (r *HomeAgentReconciler) reconcileBGP(ctx context.Context, agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork, pods []corev1.Pod) error {
+	agent.Status.AdvertisedPrefixes = nil
+	prefixes := advertisedPrefixes(agent, network)
+	nodes := advertisingNodes(agent, pods)
+	wanted := agent.Spec.BGP != nil && len(prefixes) > 0 && len(nodes) > 0
+
+	name := types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}
+	var desired *unstructured.Unstructured
+	hash := ""
+	if wanted {
+		desired = r.frrConfiguration(agent, prefixes, nodes)
+		hash = frrConfigurationHash(desired)
+	}
+	if r.frr.unchanged(name, hash) {
+		if wanted {
+			agent.Status.AdvertisedPrefixes = prefixes
+		}
+		return nil
+	}
+
+	key := r.frrConfigurationKey(name)
+	config := &unstructured.Unstructured{}
+	config.SetGroupVersionKind(frrConfigurationKind)
+	err := r.Get(ctx, key, config)
+	if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return err
+	}
+	installed := !meta.IsNoMatchError(err)
+	exists := err == nil && config.GetAnnotations()[homeAgentAnnotation] == agent.Namespace+"/"+agent.Name
+
+	if !wanted {
+		if exists {
+			log.FromContext(ctx).Info("FRRConfiguration no longer used, deleting it.")
+			if err := r.Delete(ctx, config); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		r.frr.applied(name, "")
+		return nil
+	}
+	if !installed {
+		r.Recorder.Event(agent, corev1.EventTypeWarning, "FRRConfigurationUnavailable",
+			"The FRRConfiguration resource of frr-k8s is not installed, the home prefixes are not advertised.")
+		return nil
+	}
+	if err == nil && !exists {
+		r.Recorder.Eventf(agent, corev1.EventTypeWarning, "FRRConfigurationConflict",
+			"FRRConfiguration %s/%s belongs to another HomeAgent, the home prefixes are not advertised.", key.Namespace, key.Name)
+		return nil
+	}
+
+	if !exists {
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("FRRConfiguration created.", "prefixes", prefixes, "nodes", nodes)
+	} else if !equality.Semantic.DeepEqual(desired.Object["spec"], config.Object["spec"]) ||
+		metadataChanged(config, desired.GetLabels(), desired.GetAnnotations()) {
+		config.Object["spec"] = desired.Object["spec"]
+		setMetadata(config, desired.GetLabels(), desired.GetAnnotations())
+		if err := r.Update(ctx, config); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("FRRConfiguration updated.", "prefixes", prefixes, "nodes", nodes)
+	}
+
+	r.frr.applied(name, hash)
+	agent.Status.AdvertisedPrefixes = prefixes
+	return nil
+}
+
+// DeleteFRRConfiguration deletes the FRRConfiguration of a deleted agent if
+// it exists, simply returns otherwise.
+func (r *HomeAgentReconciler) DeleteFRRConfiguration(ctx context.Context, req ctrl.Request) error {
+	r.frr.reset(req.NamespacedName)
+	config := &unstructured.Unstructured{}
+	config.SetGroupVersionKind(frrConfigurationKind)
+	err := r.Get(ctx, r.frrConfigurationKey(req.NamespacedName), config)
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		// FRRConfiguration no longer exists or frr-k8s is not installed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if config.GetAnnotations()[homeAgentAnnotation] != req.Namespace+"/"+req.Name {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("FRRConfiguration of the deleted HomeAgent deleted.", "frrconfiguration", config.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, config))
+}
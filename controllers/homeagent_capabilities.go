@@ -0,0 +1,283 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/placement"
+)
+
+// With the NodeCapabilities gate the operator keeps a NodeFeatureRule,
+// which has Node Feature Discovery label every node with the kernel modules
+// mo-daemon may need, loaded or built in, and with its network interfaces
+// which are up. The agent pods require the labels of the modules their
+// settings need, and of the home link interfaces in the host network, and
+// the CapableNodes condition reports when no node their pods fit on has
+// them all.
+const (
+	capabilityLabelPrefix = "prairie.feature.node.kubernetes.io/kmod-"
+	interfaceLabelPrefix  = "prairie.feature.node.kubernetes.io/nic-"
+	nodeFeatureRuleName   = "prairie-operator"
+)
+
+//+kubebuilder:rbac:groups=nfd.k8s-sigs.io,resources=nodefeaturerules,verbs=get;create;update
+
+var nodeFeatureRuleKind = schema.GroupVersionKind{Group: "nfd.k8s-sigs.io", Version: "v1alpha1", Kind: "NodeFeatureRule"}
+
+// kernelModules are the modules the NodeFeatureRule labels nodes with.
+var kernelModules = []string{"esp6", "ip6_gre", "ip6_tunnel", "ipip", "xfrm_user"}
+
+// requiredModules returns the kernel modules the agent needs: the IPv6
+// tunnels, GRE, IPv4 tunnels for Mobile IPv4 and the IPsec transforms.
+func requiredModules(agent *prairiev1.HomeAgent) []string {
+	modules := []string{"ip6_tunnel"}
+	if agent.Spec.Tunnel != nil && agent.Spec.Tunnel.Encapsulation == prairiev1.TunnelGRE {
+		modules = append(modules, "ip6_gre")
+	}
+	if agent.Spec.Protocols != nil && agent.Spec.Protocols.MIPv4 != nil {
+		modules = append(modules, "ipip")
+	}
+	if ipsecSpec(agent) != nil {
+		modules = append(modules, "esp6", "xfrm_user")
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// requiredInterfaces returns the interfaces of the node the agent proxies
+// on, if its pods run in the host network. Attached interfaces only exist
+// in the pods.
+func requiredInterfaces(agent *prairiev1.HomeAgent) []string {
+	proxying := agent.Spec.Proxying
+	if proxying == nil || !proxying.HostNetwork {
+		return nil
+	}
+	interfaces := []string{}
+	if proxying.NDP != nil && proxying.NDP.Interface != "" {
+		interfaces = append(interfaces, proxying.NDP.Interface)
+	}
+	if proxying.ARP != nil && (proxying.NDP == nil || proxying.ARP.Interface != proxying.NDP.Interface) {
+		interfaces = append(interfaces, proxying.ARP.Interface)
+	}
+	sort.Strings(interfaces)
+	return interfaces
+}
+
+// capabilityTemplate requires the nodes of the agent pods to carry the
+// labels of the modules and interfaces they need, on top of any other node
+// affinity.
+func capabilityTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	requirements := []corev1.NodeSelectorRequirement{}
+	for _, module := range requiredModules(agent) {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      capabilityLabelPrefix + module,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{"true"},
+		})
+	}
+	for _, iface := range requiredInterfaces(agent) {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      interfaceLabelPrefix + iface,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{"true"},
+		})
+	}
+
+	requireNodeLabels(template, requirements)
+}
+
+// requireNodeLabels adds the requirements to the required node affinity of
+// the template, on top of any there is.
+func requireNodeLabels(template *corev1.PodTemplateSpec, requirements []corev1.NodeSelectorRequirement) {
+	if template.Spec.Affinity == nil {
+		template.Spec.Affinity = &corev1.Affinity{}
+	}
+	if template.Spec.Affinity.NodeAffinity == nil {
+		template.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	affinity := template.Spec.Affinity.NodeAffinity
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	// Terms are alternatives, every one of them needs the requirements
+	selector := affinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range selector.NodeSelectorTerms {
+		selector.NodeSelectorTerms[i].MatchExpressions = append(selector.NodeSelectorTerms[i].MatchExpressions, requirements...)
+	}
+}
+
+// setCapabilityCondition counts the nodes the pods of the agent fit on in
+// the CapableNodes condition: nodes with the labels of the modules and
+// interfaces the pods require, and matching the rest of their placement,
+// i.e. zones, architecture, node selector and taints. The condition is
+// removed while the gate is off.
+func (r *HomeAgentReconciler) setCapabilityCondition(ctx context.Context, agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) error {
+	if !r.Features.Enabled(NodeCapabilities) || template == nil {
+		meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionCapableNodes)
+		return nil
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return err
+	}
+	capable := 0
+	for i := range nodes.Items {
+		if placement.Fits(&nodes.Items[i], &template.Spec) {
+			capable++
+		}
+	}
+
+	needs := requiredModules(agent)
+	for _, iface := range requiredInterfaces(agent) {
+		needs = append(needs, "interface "+iface)
+	}
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionCapableNodes,
+		Status:  metav1.ConditionTrue,
+		Reason:  "CapableNodesFound",
+		Message: fmt.Sprintf("%d nodes the replicas fit on have %s", capable, strings.Join(needs, ", ")),
+	}
+	if capable == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoCapableNodes"
+		condition.Message = fmt.Sprintf("No schedulable node the replicas fit on has %s", strings.Join(needs, ", "))
+		if !meta.IsStatusConditionFalse(agent.Status.Conditions, prairiev1.ConditionCapableNodes) {
+			r.Recorder.Event(agent, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		}
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	return nil
+}
+
+// agentsAwaitingCapableNodes maps a Node to the HomeAgents no node was
+// capable for, so they pick up nodes which got labelled.
+func (r *HomeAgentReconciler) agentsAwaitingCapableNodes(obj client.Object) []reconcile.Request {
+	if !r.Features.Enabled(NodeCapabilities) {
+		return nil
+	}
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents)
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "node", obj.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, agent := range agents.Items {
+		if !meta.IsStatusConditionFalse(agent.Status.Conditions, prairiev1.ConditionCapableNodes) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}})
+	}
+	return requests
+}
+
+// NodeFeatureRuleInstaller keeps the NodeFeatureRule labelling the nodes
+// with the kernel modules of mo-daemon.
+type NodeFeatureRuleInstaller struct {
+	Client client.Client
+	// Reader reads the NodeFeatureRule, which isn't cached.
+	Reader client.Reader
+}
+
+// Start creates the NodeFeatureRule, or updates it to the rules of this
+// version of the operator. The operator runs on if it can't, e.g. without
+// Node Feature Discovery installed.
+func (i *NodeFeatureRuleInstaller) Start(ctx context.Context) error {
+	if err := i.install(ctx); err != nil {
+		log.FromContext(ctx).Error(err, "NodeFeatureRule could not be installed.")
+	}
+	return nil
+}
+
+// nodeFeatureRules returns a rule per kernel module, labelling the nodes
+// which have it loaded or built in, and a rule labelling the nodes with
+// their network interfaces which are up.
+func nodeFeatureRules() []interface{} {
+	rules := make([]interface{}, 0, len(kernelModules)+1)
+	for _, module := range kernelModules {
+		rules = append(rules, map[string]interface{}{
+			"name":   "prairie kmod " + module,
+			"labels": map[string]interface{}{capabilityLabelPrefix + module: "true"},
+			"matchFeatures": []interface{}{map[string]interface{}{
+				"feature": "kernel.enabledmodule",
+				"matchExpressions": map[string]interface{}{
+					module: map[string]interface{}{"op": "Exists"},
+				},
+			}},
+		})
+	}
+	rules = append(rules, map[string]interface{}{
+		"name":           "prairie nic",
+		"labelsTemplate": "{{ range .network.device }}" + interfaceLabelPrefix + "{{ .name }}=true\n{{ end }}",
+		"matchFeatures": []interface{}{map[string]interface{}{
+			"feature": "network.device",
+			"matchExpressions": map[string]interface{}{
+				"operstate": map[string]interface{}{"op": "In", "value": []interface{}{"up"}},
+			},
+		}},
+	})
+	return rules
+}
+
+func (i *NodeFeatureRuleInstaller) install(ctx context.Context) error {
+	rule := &unstructured.Unstructured{}
+	rule.SetGroupVersionKind(nodeFeatureRuleKind)
+	err := i.Reader.Get(ctx, types.NamespacedName{Name: nodeFeatureRuleName}, rule)
+	if errors.IsNotFound(err) {
+		rule.SetName(nodeFeatureRuleName)
+		rule.SetLabels(operatorLabels())
+		rule.Object["spec"] = map[string]interface{}{"rules": nodeFeatureRules()}
+		log.FromContext(ctx).Info("NodeFeatureRule created.", "name", nodeFeatureRuleName)
+		return i.Client.Create(ctx, rule)
+	}
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]interface{}{"rules": nodeFeatureRules()}
+	if equality.Semantic.DeepEqual(rule.Object["spec"], desired) {
+		return nil
+	}
+	rule.Object["spec"] = desired
+	log.FromContext(ctx).Info("NodeFeatureRule updated.", "name", nodeFeatureRuleName)
+	return i.Client.Update(ctx, rule)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+)
+
+// mo-daemon reads its settings from a configuration file rendered into a
+// ConfigMap per HomeAgent. The hash of the settings which can't be reloaded
+// is stamped on the pod template, so changing one of them rolls the
+// replicas. Reloadable settings are applied live, see reloadReplicas.
+const (
+	configMountPath      = "/etc/mo-daemon/config"
+	configVolume         = "config"
+	configFile           = "mo-daemon.conf"
+	configHashAnnotation = "prairie.kismi/config-hash"
+)
+
+func configName(agent string) string {
+	return agent + "-config"
+}
+
+// renderConfig renders the configuration file of mo-daemon from the
+// rendered agent, its home network and the sync peers.
+func renderConfig(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork, peers []string) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "# Rendered from HomeAgent %s/%s, do not edit.\n", agent.Namespace, agent.Name)
+	settings := append(agentSettings(agent, network), peerSettings(peers)...)
+	for _, setting := range releaseSettings(agent.Spec.Version, settings) {
+		fmt.Fprintf(&config, "%s = %s\n", setting.Key, setting.Value)
+	}
+	return config.String()
+}
+
+// agentSettings collects every setting of mo-daemon.
+func agentSettings(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) []setting {
+	settings := append(daemonSettings(agent), networkSettings(network)...)
+	settings = append(settings, prefixSettings(agent)...)
+	settings = append(settings, backupSettings(agent)...)
+	settings = append(settings, redundancySettings(agent)...)
+	settings = append(settings, modeSettings(agent)...)
+	settings = append(settings, protocolSettings(agent)...)
+	settings = append(settings, qosSettings(agent)...)
+	settings = append(settings, shapingSettings(agent)...)
+	settings = append(settings, firewallSettings(agent)...)
+	settings = append(settings, ipsecSettings(agent)...)
+	settings = append(settings, aaaSettings(agent)...)
+	settings = append(settings, loggingSettings(agent)...)
+	settings = append(settings, spiffeSettings(agent)...)
+	return append(settings, syncSettings(agent)...)
+}
+
+func configHash(config string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(config))
+	return strconv.FormatUint(uint64(hasher.Sum32()), 16)
+}
+
+// reconcileConfig writes the rendered configuration into the ConfigMap of
+// the agent.
+func (r *HomeAgentReconciler) reconcileConfig(ctx context.Context, agent *prairiev1.HomeAgent, config string) error {
+	config_map := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: configName(agent.Name), Namespace: agent.Namespace}, config_map)
+	if errors.IsNotFound(err) {
+		config_map = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        configName(agent.Name),
+				Namespace:   agent.Namespace,
+				Labels:      componentLabels(agent, componentConfig),
+				Annotations: commonAnnotations(agent),
+			},
+			Data: map[string]string{configFile: config},
+		}
+		if err := ctrl.SetControllerReference(agent, config_map, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Daemon configuration created.", "hash", configHash(config))
+		return r.Create(ctx, config_map)
+	}
+	if err != nil {
+		return err
+	}
+
+	if config_map.Data[configFile] == config {
+		return nil
+	}
+	current := config_map.DeepCopy()
+	config_map.Data = map[string]string{configFile: config}
+	setMetadata(config_map, componentLabels(agent, componentConfig), commonAnnotations(agent))
+	diff := audit.Diff(current, config_map)
+	if err := r.Update(ctx, config_map); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Daemon configuration updated.", "hash", configHash(config), "diff", diff)
+	r.updateEvent(ctx, agent, "ConfigUpdated", "Daemon configuration", diff)
+	return nil
+}
+
+// restartHash fingerprints the settings which require a restart to change.
+func restartHash(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) string {
+	var settings strings.Builder
+	// Replicas of another release can't take the configuration of this one
+	if agent.Spec.Version != "" {
+		fmt.Fprintf(&settings, "version = %s\n", agent.Spec.Version)
+	}
+	for _, setting := range agentSettings(agent, network) {
+		if !reloadable[setting.Key] {
+			fmt.Fprintf(&settings, "%s = %s\n", setting.Key, setting.Value)
+		}
+	}
+	return configHash(settings.String())
+}
+
+// configTemplate mounts the configuration into the pod template and points
+// mo-daemon at it.
+func configTemplate(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork, template *corev1.PodTemplateSpec) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[configHashAnnotation] = restartHash(agent, network)
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: configVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configName(agent.Name)},
+			},
+		},
+	})
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "MO_CONFIG", Value: configMountPath + "/" + configFile})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      configVolume,
+			MountPath: configMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The seccomp and AppArmor profiles shipped for mo-daemon in config/security.
+// The installer places the seccomp profile below the kubelet seccomp root
+// and loads the AppArmor profile on every node.
+const (
+	defaultSeccompProfile  = "prairie/mo-daemon.json"
+	defaultAppArmorProfile = "localhost/mo-daemon"
+	appArmorAnnotation     = "container.apparmor.security.beta.kubernetes.io/"
+)
+
+// confinementTemplate applies the seccomp and AppArmor profiles of the agent
+// to the pod template.
+func confinementTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	security := agent.Spec.SecurityContext
+	if security == nil {
+		return
+	}
+
+	profile := security.AppArmorProfile
+	if profile == "" && security.DefaultProfiles {
+		profile = defaultAppArmorProfile
+	}
+	if profile != "" {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		for _, container := range template.Spec.Containers {
+			template.Annotations[appArmorAnnotation+container.Name] = profile
+		}
+	}
+
+	if !security.DefaultProfiles || (security.Pod != nil && security.Pod.SeccompProfile != nil) {
+		return
+	}
+	for i := range template.Spec.Containers {
+		context := template.Spec.Containers[i].SecurityContext
+		if context == nil {
+			context = &corev1.SecurityContext{}
+			template.Spec.Containers[i].SecurityContext = context
+		}
+		if context.SeccompProfile != nil {
+			continue
+		}
+		localhost := defaultSeccompProfile
+		context.SeccompProfile = &corev1.SeccompProfile{
+			Type:             corev1.SeccompProfileTypeLocalhost,
+			LocalhostProfile: &localhost,
+		}
+	}
+}
@@ -18,32 +18,115 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/cosign"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+	"github.com/Tenacher/prairie-operator/pkg/tracing"
+	"github.com/Tenacher/prairie-operator/pkg/vault"
+)
+
+const (
+	templateHashAnnotation = "prairie.kismi/template-hash"
+
+	// debugLevel is the verbosity of routine progress messages, shown
+	// with --zap-log-level=debug.
+	debugLevel = 1
 )
 
 // HomeAgentReconciler reconciles a HomeAgent object
 type HomeAgentReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	Daemon daemon.Client
+	// SecureDaemon talks to SPIFFE enabled replicas, if configured.
+	SecureDaemon daemon.Client
+	// SPIFFESVIDDir holds the SVID of SecureDaemon and SPIFFETrustDomain
+	// is the only trust domain it accepts agents of.
+	SPIFFESVIDDir     string
+	SPIFFETrustDomain string
+	// Vault keeps the keys of agents which opt in, if configured.
+	Vault *vault.Client
+	// Images verifies the agent image signatures, if configured.
+	Images   *cosign.Verifier
+	Recorder record.EventRecorder
+	// RequeueInterval is the first interval an agent waiting for its
+	// replicas is requeued after, it doubles up to MaxRequeueInterval.
+	RequeueInterval    time.Duration
+	MaxRequeueInterval time.Duration
+
+	// Releases are the mo-daemon releases HomeAgents select with
+	// spec.version, the releases the operator was built for if nil.
+	Releases ReleaseCatalog
+	// DefaultImage is the image of agents which set none, neither through
+	// their class.
+	DefaultImage string
+	// AgentRulesClusterRole is the ClusterRole holding the API rules agents
+	// may grant their daemon. Agents can't grant rules if empty.
+	AgentRulesClusterRole string
+	// HardenedToolsImage is the image setcap runs from for hardened agents,
+	// the agent image if empty.
+	HardenedToolsImage string
+	// FRRNamespace is the namespace of frr-k8s, which the FRRConfigurations
+	// advertising the home prefixes are kept in.
+	FRRNamespace string
+	// BGPPrefixes and BGPPeers are the networks HomeAgents may advertise
+	// prefixes of and peer with routers in. Agents can't advertise
+	// anything if either is empty.
+	BGPPrefixes []*net.IPNet
+	BGPPeers    []*net.IPNet
+	Features    FeatureGates
+	// AuditHistory is the number of changes made by the operator kept in
+	// the status of every agent, none if 0. Changes are only seen through
+	// an audit.Client.
+	AuditHistory int
+
+	backoff   requeueBackoff
+	changes   changeTrail
+	readiness readyTracker
+	frr       frrTracker
+	tools     pinnedImage
 }
 
 //+kubebuilder:rbac:groups=prairie.kismi,resources=homeagents,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=prairie.kismi,resources=homeagents/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=prairie.kismi,resources=homeagents/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=homeagentbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -55,84 +138,809 @@ type HomeAgentReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *HomeAgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-	log.Log.Info("Reconcile sequence has started.")
-	wait_duration, _ := time.ParseDuration("800ms")
+	ctx, span := tracing.Start(ctx, "HomeAgent.Reconcile",
+		tracing.String("namespace", req.Namespace), tracing.String("name", req.Name))
+	defer span.Finish()
 
+	result, err := r.reconcile(ctx, req)
+	span.SetError(err)
+	return result, err
+}
+
+func (r *HomeAgentReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log.FromContext(ctx).V(debugLevel).Info("Reconcile sequence has started.")
+
+	// Every step is traced as a child of the reconcile
+	steps := tracing.NewSteps(ctx)
+	defer steps.Finish()
+
+	ctx = r.auditContext(ctx, req)
+
+	ctx = steps.Next(ctx, "get")
 	home_agent := &prairiev1.HomeAgent{}
 	err := r.Get(ctx, req.NamespacedName, home_agent)
 	if err != nil {
 		// Resource was most likely deleted before reconcile request,
 		// thus we should clean up and return without requeueing.
 		if errors.IsNotFound(err) {
-			log.Log.Info("HomeAgent CRD not found.")
+			log.FromContext(ctx).Info("HomeAgent CRD not found.")
 
 			r.DeleteDeployment(ctx, req)
+			r.DeleteStatefulSet(ctx, req)
+			if err := r.DeleteFRRConfiguration(ctx, req); err != nil {
+				log.FromContext(ctx).Error(err, "FRRConfiguration could not be deleted.")
+			}
+			r.backoff.reset(req.NamespacedName)
+			r.changes.reset(req.NamespacedName)
+			r.readiness.reset(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading object, requeue.
 		return reconcile.Result{}, err
 	}
+	ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("generation", home_agent.Generation))
+	r.readiness.changed(home_agent)
 
-	deployment := &appsv1.Deployment{}
-	err = r.Get(ctx, req.NamespacedName, deployment)
+	// Agents in dry run are only reported on, nothing is changed for them
+	if inDryRun(ctx, home_agent) {
+		planned := []audit.Entry{}
+		ctx = audit.WithRecorder(audit.WithDryRun(ctx, true), func(entry audit.Entry) {
+			planned = append(planned, entry)
+		})
+		defer func() { r.reportDryRun(ctx, req, planned) }()
+	} else {
+		meta.RemoveStatusCondition(&home_agent.Status.Conditions, prairiev1.ConditionDryRun)
+	}
+
+	// Deleted agents run their pre-delete hook before the workload goes,
+	// and withdraw their prefixes
+	if !home_agent.DeletionTimestamp.IsZero() {
+		wait, err := r.finalizeAgent(audit.WithReason(ctx, "PreDeleteHook"), req, home_agent)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "HomeAgent could not be finalized.")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	err = r.reconcileHookFinalizer(ctx, home_agent)
 	if err != nil {
-		log.Log.Error(err, "Deployment is not ready.")
-		if errors.IsNotFound(err) {
-			err = r.Create(ctx, r.CreateDeployment(home_agent))
+		log.FromContext(ctx).Error(err, "Finalizer could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+	err = r.reconcileBGPFinalizer(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "BGP finalizer could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	ctx = steps.Next(ctx, "prepare")
+	next_window, scheduled, err := r.reconcileSchedule(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Schedule could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !scheduled {
+		log.FromContext(ctx).Info("Agent schedules are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	valid, err := r.checkCommonMetadata(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Common metadata check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !valid {
+		log.FromContext(ctx).Info("Common labels or annotations are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	resolvable, err := r.checkDNS(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "DNS check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !resolvable {
+		log.FromContext(ctx).Info("Agent DNS settings are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	authenticated, err := r.checkIPsec(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "IPsec check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !authenticated {
+		log.FromContext(ctx).Info("Agent IPsec settings are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	scoped, err := r.checkVault(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Vault check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !scoped {
+		log.FromContext(ctx).Info("Agent Vault settings are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	identified, err := r.checkSPIFFE(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "SPIFFE check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !identified {
+		// The SVID may not have been written yet
+		log.FromContext(ctx).Info("Operator can't reach SPIFFE enabled agents, waiting...")
+		return ctrl.Result{RequeueAfter: spiffeRetry}, nil
+	}
+
+	permitted, err := r.checkAPIRules(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "API rules check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !permitted {
+		log.FromContext(ctx).Info("Agent API rules are not allowed, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	next_rotation, err := r.reconcileKeys(audit.WithReason(ctx, "KeyRotation"), home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Keys could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileService(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Service could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileDNSEndpoint(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "DNSEndpoint could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	granted, err := r.reconcileServiceAccount(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Service account could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+	if !granted {
+		log.FromContext(ctx).Info("Role or RoleBinding is not managed by the HomeAgent, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	err = r.reconcilePriorityClass(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "PriorityClass could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileBindingCache(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Binding cache could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileDisruptionBudget(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Disruption budget could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileSyncKey(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Sync key could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileBindingPolicy(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Binding policy could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	next_backup, err := r.reconcileBackups(audit.WithReason(ctx, "ScheduledBackup"), home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Backups could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	ctx = steps.Next(ctx, "render")
+	class, err := r.GetClass(ctx, home_agent)
+	if errors.IsNotFound(err) {
+		// Creating the class brings us back through the class watch
+		message := fmt.Sprintf("HomeAgentClass %s does not exist", home_agent.Spec.ClassName)
+		err = r.markDegraded(ctx, home_agent, "ClassNotFound", message)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Missing class could not be recorded.")
+			return ctrl.Result{}, err
+		}
+		log.FromContext(ctx).Info("HomeAgentClass not found, waiting...", "class", home_agent.Spec.ClassName)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, "HomeAgentClass could not be read.", "class", home_agent.Spec.ClassName)
+		return ctrl.Result{}, err
+	}
+	rendered := withClassDefaults(home_agent, class)
+	resolved, err := r.resolveVersion(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Version resolution could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !resolved {
+		// A spec change or a new catalog after a restart brings us back
+		log.FromContext(ctx).Info("Agent version is unknown, waiting...", "version", home_agent.Spec.Version)
+		return ctrl.Result{}, nil
+	}
+	enabled, err := r.checkFeatures(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Feature check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !enabled {
+		log.FromContext(ctx).Info("Agent requires a disabled feature, waiting...")
+		return ctrl.Result{}, nil
+	}
+	if image := architectureImage(rendered); image != "" {
+		rendered.Spec.Image = image
+	}
+	if rendered.Spec.Image == "" && r.DefaultImage != "" {
+		rendered.Spec.Image = r.DefaultImage
+	}
+
+	handover, err := r.GetHandoverPolicy(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "HandoverPolicy could not be read.", "policy", home_agent.Spec.HandoverPolicyRef.Name)
+		return ctrl.Result{}, err
+	}
+	applyHandoverPolicy(rendered, handover)
+
+	verified, err := r.verifyImage(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Image verification could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !verified {
+		log.FromContext(ctx).Info("Agent image was rejected, waiting...", "image", agentImage(rendered))
+		return ctrl.Result{RequeueAfter: imageRetryInterval}, nil
+	}
+
+	network, attached, err := r.attachNetwork(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "PrairieNetwork could not be attached.")
+		return ctrl.Result{}, err
+	}
+	if !attached {
+		// Keep the agents running as they are until the network is usable,
+		// the network watch brings us back.
+		log.FromContext(ctx).Info("PrairieNetwork is not usable, waiting...", "network", home_agent.Spec.NetworkRef.Name)
+		return ctrl.Result{}, nil
+	}
+
+	resolved, err = r.resolveSecrets(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Referenced Secrets could not be resolved.")
+		return ctrl.Result{}, err
+	}
+	if !resolved {
+		// Keep the agents running as they are until the Secrets exist, the
+		// Secret watch brings us back.
+		log.FromContext(ctx).Info("Referenced Secrets are missing, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err := r.checkSettings(ctx, home_agent, rendered, network)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Version check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Agent version doesn't support the settings, waiting...", "version", home_agent.Spec.Version)
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkHomePrefixes(ctx, home_agent, network)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Home prefix check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Home prefixes are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkProtocols(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Protocol check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		// The nodes may still become dual-stack, look again later
+		log.FromContext(ctx).Info("Agent protocols are invalid or unavailable, waiting...")
+		return ctrl.Result{RequeueAfter: r.requeueInterval(home_agent)}, nil
+	}
+
+	supported, err = r.checkProxying(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Proxying check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Proxying interfaces are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkBGP(ctx, home_agent, network)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "BGP check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("BGP advertisement is not allowed, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkQoS(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "QoS check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("QoS rules are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
 
+	supported, err = r.checkShaping(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Shaping check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Rate limits are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkFirewall(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Firewall check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Firewall rules are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkServiceMesh(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Service mesh check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Service mesh settings are invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkLogging(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Logging check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Log output is invalid, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkArchitecture(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Architecture check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Architecture images are ambiguous, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	supported, err = r.checkAAA(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "AAA check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("AAA configuration is unsupported, waiting...")
+		return ctrl.Result{}, nil
+	}
+
+	err = r.addGatewayPeers(ctx, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "MobileAccessGateways could not be listed.")
+		return ctrl.Result{}, err
+	}
+	supported, err = r.checkMode(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Mode check could not be recorded.")
+		return ctrl.Result{}, err
+	}
+	if !supported {
+		log.FromContext(ctx).Info("Agent mode is invalid or unsupported, waiting...", "mode", home_agent.Spec.Mode)
+		return ctrl.Result{}, nil
+	}
+
+	peers, err := r.syncPeers(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Sync peers could not be listed.")
+		return ctrl.Result{}, err
+	}
+	config := renderConfig(rendered, network, peers)
+	err = r.reconcileConfig(ctx, home_agent, config)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Daemon configuration could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+	err = r.reconcileLoggingConfig(ctx, home_agent, rendered)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Log shipper configuration could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	// Switching persistence on or off replaces the workload
+	ctx = steps.Next(ctx, "apply")
+	err = r.deleteStaleWorkload(audit.WithReason(ctx, "PersistenceChanged"), home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Stale workload could not be deleted.")
+		return ctrl.Result{}, err
+	}
+
+	workload := emptyWorkload(home_agent)
+	err = r.Get(ctx, req.NamespacedName, workload)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Workload is not ready.")
+		if errors.IsNotFound(err) {
+			workload = r.CreateWorkload(rendered, network)
+			err = ctrl.SetControllerReference(home_agent, workload, r.Scheme)
+			if err == nil {
+				err = r.Create(audit.WithReason(ctx, "WorkloadMissing"), workload)
+			}
 			if err != nil {
 				return reconcile.Result{}, err
 			}
-			log.Log.Info("Deployment created, requeueing...")
+			log.FromContext(ctx).Info("Workload created, waiting for it to start...")
 
-			// We requeue to let the deployment get started
-			return reconcile.Result{RequeueAfter: wait_duration}, nil
+			// The workload and pod watches bring us back as it starts
+			return reconcile.Result{}, nil
 		} else {
 			return reconcile.Result{}, err
 		}
 	}
 
-	// Not every replica is ready, requeue
-	if deployment.Status.ReadyReplicas < home_agent.Spec.Size {
-		log.Log.Info("Not every replica is ready, requeueing...")
-		return reconcile.Result{RequeueAfter: wait_duration}, nil
+	// Bring the workload in line with the spec, e.g. after a resize or
+	// when a new key has to be distributed.
+	// The template hash catches settings that were removed from the spec,
+	// which a derivative comparison alone would ignore. Only the replicas
+	// and the pod template are compared, the volume claim templates of a
+	// StatefulSet can't be changed.
+	desired := r.CreateWorkload(rendered, network)
+
+	// Workloads the HomeAgent doesn't control are only taken over on request
+	adopted, err := r.adoptWorkload(audit.WithReason(ctx, "Adoption"), home_agent, workload, desired)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Workload could not be adopted.")
+		return ctrl.Result{}, err
+	}
+	if !adopted {
+		log.FromContext(ctx).Info("Workload is not managed by the HomeAgent, waiting...", "workload", workload.GetName())
+		return ctrl.Result{}, nil
 	}
 
+	// Replicas a scale-down removes are drained first, until then the
+	// workload keeps its size.
+	drained, err := r.drainReplicas(audit.WithReason(ctx, "ScaleDown"), home_agent, workload)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replicas could not be drained.")
+		return ctrl.Result{}, err
+	}
+	if !drained {
+		setWorkloadReplicas(desired, workloadReplicas(workload))
+	}
+	strategy_changed := setRolloutStrategy(workload, desired)
+	if strategy_changed || metadataChanged(workload, desired.GetLabels(), commonAnnotations(rendered)) ||
+		workload.GetAnnotations()[templateHashAnnotation] != desired.GetAnnotations()[templateHashAnnotation] ||
+		*workloadReplicas(workload) != *workloadReplicas(desired) ||
+		!equality.Semantic.DeepDerivative(*workloadTemplate(desired), *workloadTemplate(workload)) {
+		current := workload.DeepCopyObject().(client.Object)
+		annotations := workload.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[templateHashAnnotation] = desired.GetAnnotations()[templateHashAnnotation]
+		workload.SetAnnotations(annotations)
+		setMetadata(workload, desired.GetLabels(), commonAnnotations(rendered))
+		setWorkloadReplicas(workload, workloadReplicas(desired))
+		keepForeignMetadata(workloadTemplate(desired), workloadTemplate(workload))
+		*workloadTemplate(workload) = *workloadTemplate(desired)
+		diff := audit.Diff(current, workload)
+		err = r.Update(audit.WithReason(ctx, "SpecChanged"), workload)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		log.FromContext(ctx).Info("Workload updated, waiting for it to roll out...", "diff", diff)
+		r.updateEvent(ctx, home_agent, "WorkloadUpdated", "Workload", diff)
+		return reconcile.Result{}, nil
+	}
+
+	if !drained {
+		log.FromContext(ctx).V(debugLevel).Info("Replicas are draining, requeueing...")
+		return reconcile.Result{RequeueAfter: drainPollInterval}, nil
+	}
+
+	// Apply reloadable changes to the running replicas
+	ctx = steps.Next(ctx, "replicas")
+	err = r.reloadReplicas(ctx, home_agent, config, workloadTemplate(desired).Annotations[configHashAnnotation])
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replicas could not be reloaded.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.restoreReplicas(audit.WithReason(ctx, "Restore"), home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replicas could not be restored.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileReadinessGates(ctx, home_agent, configHash(config))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Readiness gates could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileRoles(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replica roles could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileDegraded(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replica failures could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileZones(ctx, home_agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Zones could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	rolling_out, err := r.reconcileRollout(ctx, home_agent, workload)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Rollout could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+	if rolling_out {
+		log.FromContext(ctx).V(debugLevel).Info("Rollout in progress, requeueing...")
+		return reconcile.Result{RequeueAfter: r.requeueInterval(home_agent)}, nil
+	}
+
+	ctx = steps.Next(ctx, "collect")
 	pods := &corev1.PodList{}
-	err = r.List(ctx, pods, client.MatchingLabels{"parent": home_agent.Name})
+	err = r.List(ctx, pods, client.InNamespace(home_agent.Namespace), client.MatchingFields{parentField: home_agent.Name})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	podips := make([]string, home_agent.Spec.Size)
-	for idx, pod := range pods.Items {
+	names := map[string]string{}
+	for _, pod := range pods.Items {
+		names[pod.Status.PodIP] = pod.Name
+	}
+
+	// Pods of a previous rollout may still be terminating, skip them. Pods
+	// without an address yet are left out, the pod watch brings us back
+	// once they have one.
+	podips := make([]string, 0, replicaCount(home_agent))
+	replicas := make([]prairiev1.ReplicaStatus, 0, replicaCount(home_agent))
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
 		ip := pod.Status.PodIP
 		if ip == "" {
-			log.Log.Info("Not every pod has ip, requeueing...")
-			return ctrl.Result{RequeueAfter: wait_duration}, nil
+			continue
 		}
-		podips[idx] = ip
+		podips = append(podips, ip)
+		replicas = append(replicas, r.replicaStatus(ctx, &pod, names))
+	}
+
+	err = r.reconcileBGP(ctx, home_agent, network, pods.Items)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "FRRConfiguration could not be reconciled.")
+		return ctrl.Result{}, err
 	}
 
 	home_agent.Status.NodeIps = podips
+	home_agent.Status.Replicas = replicas
+	setScaleStatus(home_agent, len(replicas))
+	setIPsecCondition(home_agent, replicas)
+	setAAACondition(home_agent, replicas)
+	err = r.setCapabilityCondition(ctx, home_agent, workloadTemplate(workload))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Capable nodes could not be listed.")
+		return ctrl.Result{}, err
+	}
+	r.setVersionStatus(home_agent, rendered)
 
+	next_hook, err := r.reconcilePostCreateHook(ctx, home_agent, workload)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Post-create hook could not be run.")
+		return ctrl.Result{}, err
+	}
+
+	ctx = steps.Next(ctx, "status")
+	setReadiness(home_agent, workload, len(podips))
+	recorded := r.recordChanges(home_agent)
 	err = r.Status().Update(ctx, home_agent)
 	if err != nil {
-		log.Log.Error(err, "HomeAgent status could not be updated.")
+		log.FromContext(ctx).Error(err, "HomeAgent status could not be updated.")
 		return ctrl.Result{}, err
 	}
+	r.changes.drop(req.NamespacedName, recorded)
+
+	// Come back for the next key rotation, backup, schedule change or
+	// resync, whichever is due first
+	requeue_after := next_rotation
+	if next_backup > 0 && (requeue_after == 0 || next_backup < requeue_after) {
+		requeue_after = next_backup
+	}
+	if next_hook > 0 && (requeue_after == 0 || next_hook < requeue_after) {
+		requeue_after = next_hook
+	}
+	if next_window > 0 && (requeue_after == 0 || next_window < requeue_after) {
+		requeue_after = next_window
+	}
+	if next_resync := resyncInterval(home_agent); next_resync > 0 && (requeue_after == 0 || next_resync < requeue_after) {
+		requeue_after = next_resync
+	}
+
+	// Replicas becoming ready are noticed through the pod watch
+	if readyReplicas(workload) < replicaCount(home_agent) || len(podips) < int(replicaCount(home_agent)) {
+		log.FromContext(ctx).V(debugLevel).Info("Not every replica is ready, waiting for the pods...")
+		return ctrl.Result{RequeueAfter: requeue_after}, nil
+	}
 
-	log.Log.Info("Reconcile sequence has successfully finished.")
-	return ctrl.Result{}, nil
+	log.FromContext(ctx).V(debugLevel).Info("Reconcile sequence has successfully finished.")
+	if took, ok := r.readiness.ready(home_agent); ok {
+		metrics.ObserveReady("HomeAgent", took)
+	}
+	r.backoff.reset(req.NamespacedName)
+	return ctrl.Result{RequeueAfter: requeue_after}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HomeAgentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.HomeAgent{}, classNameField,
+		func(obj client.Object) []string {
+			return []string{obj.(*prairiev1.HomeAgent).Spec.ClassName}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.HomeAgent{}, networkRefField,
+		func(obj client.Object) []string {
+			ref := obj.(*prairiev1.HomeAgent).Spec.NetworkRef
+			if ref == nil {
+				return nil
+			}
+			return []string{ref.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.HomeAgent{}, secretRefsField,
+		func(obj client.Object) []string {
+			return secretNames(obj.(*prairiev1.HomeAgent))
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.HomeAgent{}, handoverPolicyField,
+		func(obj client.Object) []string {
+			ref := obj.(*prairiev1.HomeAgent).Spec.HandoverPolicyRef
+			if ref == nil {
+				return nil
+			}
+			return []string{ref.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.HomeAgent{}, restoreFromField,
+		func(obj client.Object) []string {
+			ref := obj.(*prairiev1.HomeAgent).Spec.RestoreFrom
+			if ref == nil {
+				return nil
+			}
+			return []string{ref.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, parentField,
+		func(obj client.Object) []string {
+			parent, ok := obj.GetLabels()["parent"]
+			if !ok {
+				return nil
+			}
+			return []string{parent}
+		})
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&prairiev1.HomeAgent{}).
-		Owns(&appsv1.Deployment{}).
-		Complete(r)
+		For(&prairiev1.HomeAgent{}, builder.WithPredicates(agentChanged)).
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(workloadChanged)).
+		Owns(&appsv1.StatefulSet{}, builder.WithPredicates(workloadChanged)).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&policyv1.PodDisruptionBudget{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.agentOfPod)).
+		Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOnFailedNode)).
+		Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(r.agentsAwaitingCapableNodes)).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgentClass{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOfClass)).
+		Watches(&source.Kind{Type: &prairiev1.BindingPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOfBindingPolicy)).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgentBackup{}}, handler.EnqueueRequestsFromMapFunc(r.agentsRestoringFrom)).
+		Watches(&source.Kind{Type: &prairiev1.HandoverPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOfHandoverPolicy)).
+		Watches(&source.Kind{Type: &prairiev1.PrairieNetwork{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOfNetwork)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.agentsOfSecret)).
+		Watches(&source.Kind{Type: &prairiev1.MobileAccessGateway{}}, handler.EnqueueRequestsFromMapFunc(r.agentOfGateway)).
+		Complete(metrics.NewReconciler("HomeAgent", r))
+}
+
+// replicaStatus reads the state of a replica through its management API.
+// names maps pod IPs to pod names, to name the sync peers.
+func (r *HomeAgentReconciler) replicaStatus(ctx context.Context, pod *corev1.Pod, names map[string]string) prairiev1.ReplicaStatus {
+	replica := prairiev1.ReplicaStatus{Name: pod.Name}
+	if !podConditionTrue(pod, corev1.ContainersReady) {
+		return replica
+	}
+	stats, err := daemonFor(r.Daemon, r.SecureDaemon, pod).Stats(ctx, pod.Status.PodIP)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Replica stats could not be read.", "pod", pod.Name)
+		return replica
+	}
+
+	replica.Reachable = true
+	replica.Version = stats.Version
+	replica.ConfigHash = stats.ConfigHash
+	replica.Bindings = stats.Bindings
+	replica.Registrations = int64(stats.Registrations)
+	replica.Role = stats.Role
+	replica.Peers = peerStatus(stats.Peers, names)
+	replica.IPsec = saStatus(stats.SAs)
+	replica.AAA = aaaStatus(stats.AAA)
+	return replica
+}
+
+// templateHash fingerprints the rendered pod template so changes to it can be
+// detected without comparing against the defaulted object.
+func templateHash(template *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return strconv.FormatUint(uint64(hasher.Sum32()), 16)
 }
 
 // Deletes deployment if it exists, simply returns otherwise
@@ -143,46 +951,115 @@ func (r *HomeAgentReconciler) DeleteDeployment(ctx context.Context, req ctrl.Req
 		// Deployment no longer exists, we can safely return
 		return
 	}
+	if !managedWorkload(deployment, req.Name) {
+		return
+	}
 
 	r.Delete(ctx, deployment)
 }
 
-func (r *HomeAgentReconciler) CreateDeployment(agent *prairiev1.HomeAgent) *appsv1.Deployment {
+// Deletes stateful set if it exists, simply returns otherwise
+func (r *HomeAgentReconciler) DeleteStatefulSet(ctx context.Context, req ctrl.Request) {
+	stateful_set := &appsv1.StatefulSet{}
+	err := r.Get(ctx, req.NamespacedName, stateful_set)
+	if err != nil {
+		// StatefulSet no longer exists, we can safely return
+		return
+	}
+	if !managedWorkload(stateful_set, req.Name) {
+		return
+	}
+
+	r.Delete(ctx, stateful_set)
+}
+
+func (r *HomeAgentReconciler) CreateDeployment(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) *appsv1.Deployment {
 	labels := map[string]string{
 		"parent": agent.Name,
 	}
+	replicas := replicaCount(agent)
 
-	return &appsv1.Deployment{
+	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      agent.Name,
-			Namespace: agent.Namespace,
+			Name:        agent.Name,
+			Namespace:   agent.Namespace,
+			Labels:      componentLabels(agent, componentWorkload),
+			Annotations: commonAnnotations(agent),
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &agent.Spec.Size,
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      templateLabels(agent),
+					Annotations: commonAnnotations(agent),
 				},
 				Spec: corev1.PodSpec{
+					ServiceAccountName:           serviceAccountName(agent),
+					ImagePullSecrets:             agent.Spec.ImagePullSecrets,
+					PriorityClassName:            agent.Spec.PriorityClassName,
+					AutomountServiceAccountToken: automountToken(agent),
+					SecurityContext:              agentPodSecurityContext(agent),
+					Affinity:                     agentAffinity(agent),
+					Tolerations:                  agentTolerations(agent),
+					TopologySpreadConstraints:    agentTopologySpread(agent),
 					Containers: []corev1.Container{
 						{
 							Name:            "ha",
-							Image:           "kismi/mo-daemon:latest",
+							Image:           agentImage(agent),
 							ImagePullPolicy: corev1.PullAlways,
-							SecurityContext: &corev1.SecurityContext{
-								Capabilities: &corev1.Capabilities{
-									Add: []corev1.Capability{
-										"NET_ADMIN",
-									},
+							Resources:       agentResources(agent),
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "registration",
+									ContainerPort: registrationPort,
+									Protocol:      corev1.ProtocolUDP,
+								},
+								{
+									Name:          "management",
+									ContainerPort: daemon.ManagementPort,
+									Protocol:      corev1.ProtocolTCP,
 								},
 							},
+							LivenessProbe:   livenessProbe(agent),
+							ReadinessProbe:  readinessProbe(agent),
+							SecurityContext: agentSecurityContext(agent),
 						},
 					},
 				},
 			},
 		},
 	}
+	configTemplate(agent, network, &deployment.Spec.Template)
+	drainTemplate(agent, &deployment.Spec.Template)
+	readinessGateTemplate(&deployment.Spec.Template)
+	keyringTemplate(agent, &deployment.Spec.Template)
+	subscribersTemplate(agent, &deployment.Spec.Template)
+	correspondentsTemplate(agent, &deployment.Spec.Template)
+	domainKeysTemplate(agent, &deployment.Spec.Template)
+	policyTemplate(agent, &deployment.Spec.Template)
+	backupTemplate(agent, &deployment.Spec.Template)
+	syncTemplate(agent, &deployment.Spec.Template)
+	ipsecTemplate(agent, &deployment.Spec.Template)
+	aaaTemplate(agent, &deployment.Spec.Template)
+	spiffeTemplate(agent, &deployment.Spec.Template)
+	confinementTemplate(agent, &deployment.Spec.Template)
+	proxyingTemplate(agent, &deployment.Spec.Template)
+	hostPrepTemplate(agent, &deployment.Spec.Template)
+	hardenedTemplate(agent, r.hardenedToolsImage(), &deployment.Spec.Template)
+	dnsTemplate(agent, &deployment.Spec.Template)
+	writablePathsTemplate(agent, &deployment.Spec.Template)
+	meshTemplate(agent, network, &deployment.Spec.Template)
+	architectureTemplate(agent, &deployment.Spec.Template)
+	if r.Features.Enabled(NodeCapabilities) {
+		capabilityTemplate(agent, &deployment.Spec.Template)
+	}
+	loggingTemplate(agent, &deployment.Spec.Template)
+	rolloutStrategy(agent, deployment)
+
+	deployment.Annotations = commonAnnotations(agent)
+	deployment.Annotations[templateHashAnnotation] = templateHash(&deployment.Spec.Template)
+	return deployment
 }
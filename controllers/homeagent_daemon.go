@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const (
+	// registrationPort is the port mo-daemon accepts registrations on.
+	registrationPort = 434
+
+	domainKeysMountPath = "/etc/mo-daemon/domain-keys"
+	domainKeysVolume    = "domain-keys"
+)
+
+// setting is a single line of mo-daemon's configuration file
+type setting struct {
+	Key   string
+	Value string
+}
+
+// daemonSettings translates the HomeAgent spec into the settings of
+// mo-daemon.
+func daemonSettings(agent *prairiev1.HomeAgent) []setting {
+	settings := []setting{}
+
+	if discovery := agent.Spec.Discovery; discovery != nil {
+		if discovery.DHAAD {
+			settings = append(settings, setting{Key: "dhaad", Value: "on"})
+		}
+		if discovery.AnycastAddress != "" {
+			settings = append(settings, setting{Key: "ha_anycast", Value: discovery.AnycastAddress})
+		}
+	}
+
+	if tunnel := agent.Spec.Tunnel; tunnel != nil {
+		switch tunnel.Encapsulation {
+		case prairiev1.TunnelGRE:
+			settings = append(settings, setting{Key: "tunnel_encap", Value: "gre"})
+		case prairiev1.TunnelIPv6InIPv6:
+			settings = append(settings, setting{Key: "tunnel_encap", Value: "ip6ip6"})
+		}
+		if tunnel.MTU != 0 {
+			settings = append(settings, setting{Key: "tunnel_mtu", Value: strconv.Itoa(int(tunnel.MTU))})
+		}
+	}
+
+	if forwarding := agent.Spec.Forwarding; forwarding != nil {
+		if forwarding.Broadcast {
+			settings = append(settings, setting{Key: "forward_broadcast", Value: "on"})
+		}
+		if forwarding.Multicast {
+			settings = append(settings, setting{Key: "forward_multicast", Value: "on"})
+		}
+	}
+
+	if proxying := agent.Spec.Proxying; proxying != nil && proxying.NDP != nil {
+		settings = append(settings, setting{Key: "ndp_proxy", Value: "on"})
+		if proxying.NDP.Interface != "" {
+			settings = append(settings, setting{Key: "ndp_proxy_interface", Value: proxying.NDP.Interface})
+		}
+		if proxying.NDP.UnsolicitedAdvertisements != nil {
+			settings = append(settings, setting{Key: "ndp_proxy_unsolicited", Value: strconv.Itoa(int(*proxying.NDP.UnsolicitedAdvertisements))})
+		}
+	}
+	if proxying := agent.Spec.Proxying; proxying != nil && proxying.ARP != nil {
+		settings = append(settings,
+			setting{Key: "arp_proxy", Value: "on"},
+			setting{Key: "arp_proxy_interface", Value: proxying.ARP.Interface})
+		if proxying.ARP.GratuitousARPs != nil {
+			settings = append(settings, setting{Key: "arp_proxy_gratuitous", Value: strconv.Itoa(int(*proxying.ARP.GratuitousARPs))})
+		}
+	}
+
+	if domain := agent.Spec.Domain; domain != nil {
+		if domain.AuthRealm != "" {
+			settings = append(settings, setting{Key: "auth_realm", Value: domain.AuthRealm})
+		}
+		if len(domain.PrefixPools) > 0 {
+			settings = append(settings, setting{Key: "home_prefixes", Value: strings.Join(domain.PrefixPools, ",")})
+		}
+	}
+
+	if handover := agent.Spec.Handover; handover != nil {
+		if handover.SmoothHandoverWindow != nil {
+			settings = append(settings, setting{Key: "handover_window_ms", Value: milliseconds(handover.SmoothHandoverWindow)})
+		}
+		if handover.MaxSimultaneousBindings != 0 {
+			settings = append(settings, setting{Key: "simultaneous_bindings", Value: strconv.Itoa(int(handover.MaxSimultaneousBindings))})
+		}
+		if buffering := handover.Buffering; buffering != nil {
+			settings = append(settings, setting{Key: "buffer_packets", Value: strconv.Itoa(int(buffering.Packets))})
+			if buffering.Timeout != nil {
+				settings = append(settings, setting{Key: "buffer_timeout_ms", Value: milliseconds(buffering.Timeout)})
+			}
+		}
+	}
+
+	if agent.Spec.Persistence != nil {
+		settings = append(settings, setting{Key: "binding_db", Value: bindingDBFile})
+	}
+
+	return settings
+}
+
+func milliseconds(duration *metav1.Duration) string {
+	return strconv.FormatInt(duration.Milliseconds(), 10)
+}
+
+// domainKeysTemplate mounts the key material shared by the domain.
+func domainKeysTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if agent.Spec.Domain == nil || agent.Spec.Domain.KeySecretRef == nil {
+		return
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: domainKeysVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: agent.Spec.Domain.KeySecretRef.Name},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      domainKeysVolume,
+			MountPath: domainKeysMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+
+// reconcileDisruptionBudget manages the PodDisruptionBudget of the replicas,
+// so node drains and cluster upgrades never take all of them down at once.
+func (r *HomeAgentReconciler) reconcileDisruptionBudget(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	budget := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, budget)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	desired := r.CreateDisruptionBudget(agent)
+	if desired == nil {
+		if exists && metav1.IsControlledBy(budget, agent) {
+			log.FromContext(ctx).Info("Disruption budget no longer needed, deleting.")
+			return client.IgnoreNotFound(r.Delete(ctx, budget))
+		}
+		return nil
+	}
+
+	if !exists {
+		if err := ctrl.SetControllerReference(agent, desired, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Disruption budget created.")
+		return r.Create(ctx, desired)
+	}
+	if !equality.Semantic.DeepEqual(desired.Spec.MinAvailable, budget.Spec.MinAvailable) ||
+		!equality.Semantic.DeepEqual(desired.Spec.MaxUnavailable, budget.Spec.MaxUnavailable) ||
+		metadataChanged(budget, desired.Labels, desired.Annotations) {
+		budget.Spec.MinAvailable = desired.Spec.MinAvailable
+		budget.Spec.MaxUnavailable = desired.Spec.MaxUnavailable
+		setMetadata(budget, desired.Labels, desired.Annotations)
+		log.FromContext(ctx).Info("Disruption budget updated.")
+		return r.Update(ctx, budget)
+	}
+	return nil
+}
+
+// CreateDisruptionBudget renders the PodDisruptionBudget of the agent, nil
+// if the agent doesn't need one. Without spec.disruptionBudget one replica
+// may be disrupted at a time; a single replica is left unprotected, as
+// its budget would block node drains forever.
+func (r *HomeAgentReconciler) CreateDisruptionBudget(agent *prairiev1.HomeAgent) *policyv1.PodDisruptionBudget {
+	spec := policyv1.PodDisruptionBudgetSpec{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"parent": agent.Name}},
+	}
+	if budget := agent.Spec.DisruptionBudget; budget != nil && (budget.MinAvailable != nil || budget.MaxUnavailable != nil) {
+		spec.MinAvailable = budget.MinAvailable
+		spec.MaxUnavailable = budget.MaxUnavailable
+	} else if replicaCount(agent) > 1 {
+		one := intstr.FromInt(1)
+		spec.MaxUnavailable = &one
+	} else {
+		return nil
+	}
+
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        agent.Name,
+			Namespace:   agent.Namespace,
+			Labels:      componentLabels(agent, componentDisruptionBudget),
+			Annotations: commonAnnotations(agent),
+		},
+		Spec: spec,
+	}
+}
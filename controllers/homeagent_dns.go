@@ -0,0 +1,49 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// dnsTemplate sets the DNS policy and configuration and the host aliases
+// of the agent pods. Left empty, the cluster defaults apply, resolving
+// through the cluster DNS from the host network as well.
+func dnsTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	template.Spec.DNSPolicy = agent.Spec.DNSPolicy
+	if template.Spec.DNSPolicy == "" && template.Spec.HostNetwork {
+		template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+	template.Spec.DNSConfig = agent.Spec.DNSConfig.DeepCopy()
+	for _, alias := range agent.Spec.HostAliases {
+		template.Spec.HostAliases = append(template.Spec.HostAliases, *alias.DeepCopy())
+	}
+}
+
+// checkDNS reports whether the pods of the agent can resolve names with its
+// DNS settings, and marks it degraded otherwise. The API server would
+// reject the workload instead.
+func (r *HomeAgentReconciler) checkDNS(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if agent.Spec.DNSPolicy != corev1.DNSNone || (agent.Spec.DNSConfig != nil && len(agent.Spec.DNSConfig.Nameservers) > 0) {
+		return true, nil
+	}
+	return false, r.markDegraded(ctx, agent, "InvalidDNSConfig", "The None DNS policy requires a nameserver in dnsConfig")
+}
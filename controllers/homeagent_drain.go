@@ -0,0 +1,230 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+
+	// drainingAnnotation marks a replica drained ahead of a scale-down with
+	// the time the drain started.
+	drainingAnnotation = "prairie.kismi/draining"
+	// deletionCostAnnotation makes the ReplicaSet remove the drained
+	// replicas first.
+	deletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+	drainPollInterval      = 5 * time.Second
+
+	// drainGrace is added to the drain timeout for the termination grace
+	// period, so mo-daemon can shut down after the drain.
+	drainGrace = 10 * time.Second
+)
+
+func drainTimeout(agent *prairiev1.HomeAgent) time.Duration {
+	if agent.Spec.DrainTimeout != nil {
+		return agent.Spec.DrainTimeout.Duration
+	}
+	return defaultDrainTimeout
+}
+
+// drainTemplate adds a preStop hook to the pod template which drains the
+// replica before it terminates, so that scale-downs, rollouts and node
+// drains hand the bindings over instead of dropping the sessions.
+func drainTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	timeout := drainTimeout(agent)
+	grace := int64((timeout + drainGrace).Seconds())
+	if agent.Spec.TerminationGracePeriodSeconds != nil {
+		// The drain is cut short to leave mo-daemon time to shut down
+		grace = *agent.Spec.TerminationGracePeriodSeconds
+		if limit := time.Duration(grace)*time.Second - drainGrace; timeout > limit {
+			timeout = limit
+			if timeout < 0 {
+				timeout = 0
+			}
+		}
+	}
+	template.Spec.TerminationGracePeriodSeconds = &grace
+
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: daemon.DrainPath(timeout),
+					Port: intstr.FromInt(healthPort(agent)),
+				},
+			},
+		}
+	}
+}
+
+// drainReplicas drains the replicas a scale-down is about to remove. Victims
+// are marked with drainingAnnotation and the lowest pod deletion cost, so
+// the ReplicaSet removes exactly them once the deployment is scaled down.
+// A StatefulSet always removes the highest ordinals, those are the victims.
+// It reports whether the drain is finished, i.e. the victims hold no more
+// bindings or the drain timeout passed.
+func (r *HomeAgentReconciler) drainReplicas(ctx context.Context, agent *prairiev1.HomeAgent, workload client.Object) (bool, error) {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return false, err
+	}
+	replicas := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp.IsZero() {
+			replicas = append(replicas, pod)
+		}
+	}
+
+	excess := int(*workloadReplicas(workload) - replicaCount(agent))
+	if excess <= 0 {
+		// The scale-down was called off. A drained replica doesn't accept
+		// registrations anymore, it is replaced.
+		for idx := range replicas {
+			if _, draining := replicas[idx].Annotations[drainingAnnotation]; draining {
+				log.FromContext(ctx).Info("Replacing drained replica.", "pod", replicas[idx].Name)
+				if err := r.Delete(ctx, &replicas[idx]); err != nil {
+					return false, client.IgnoreNotFound(err)
+				}
+			}
+		}
+		if agent.Status.Drain != nil {
+			agent.Status.Drain = nil
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionDraining)
+			return true, r.Status().Update(ctx, agent)
+		}
+		return true, nil
+	}
+
+	// Read the bindings of every replica, victims are the replicas already
+	// draining, then those which aren't ready and those with fewest bindings.
+	bindings := map[string]int32{}
+	unreadable := map[string]bool{}
+	for _, pod := range replicas {
+		bindings[pod.Name] = -1
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		stats, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Stats(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Replica stats could not be read.", "pod", pod.Name)
+			unreadable[pod.Name] = true
+			continue
+		}
+		bindings[pod.Name] = stats.Bindings
+	}
+	_, ordered := workload.(*appsv1.StatefulSet)
+	sort.SliceStable(replicas, func(i, j int) bool {
+		if ordered {
+			return ordinal(&replicas[i]) > ordinal(&replicas[j])
+		}
+		_, i_draining := replicas[i].Annotations[drainingAnnotation]
+		_, j_draining := replicas[j].Annotations[drainingAnnotation]
+		if i_draining != j_draining {
+			return i_draining
+		}
+		return bindings[replicas[i].Name] < bindings[replicas[j].Name]
+	})
+	if excess > len(replicas) {
+		excess = len(replicas)
+	}
+
+	now := metav1.Now()
+	start := now
+	victims := []string{}
+	remaining := int32(0)
+	// Victims whose bindings can't be read may still hold some
+	unknown := []string{}
+	for idx := range replicas[:excess] {
+		pod := &replicas[idx]
+		victims = append(victims, pod.Name)
+		if bindings[pod.Name] > 0 {
+			remaining += bindings[pod.Name]
+		}
+		if unreadable[pod.Name] {
+			unknown = append(unknown, pod.Name)
+		}
+
+		if since, draining := pod.Annotations[drainingAnnotation]; draining {
+			if started, err := time.Parse(time.RFC3339, since); err == nil && started.Before(start.Time) {
+				start = metav1.NewTime(started)
+			}
+			continue
+		}
+
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[drainingAnnotation] = now.UTC().Format(time.RFC3339)
+		pod.Annotations[deletionCostAnnotation] = "-1000"
+		if err := r.Update(ctx, pod); err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("Draining replica.", "pod", pod.Name)
+		r.Recorder.Event(agent, corev1.EventTypeNormal, "DrainingReplica",
+			fmt.Sprintf("Draining %s before scaling down to %d replicas", pod.Name, replicaCount(agent)))
+		if pod.Status.PodIP != "" {
+			if err := daemonFor(r.Daemon, r.SecureDaemon, pod).Drain(ctx, pod.Status.PodIP, drainTimeout(agent)); err != nil {
+				log.FromContext(ctx).Error(err, "Replica could not be drained.", "pod", pod.Name)
+			}
+		}
+	}
+
+	timeout := drainTimeout(agent)
+	done := (remaining == 0 && len(unknown) == 0) || now.Time.After(start.Add(timeout))
+	agent.Status.Drain = &prairiev1.DrainStatus{
+		Replicas:          victims,
+		StartTime:         &start,
+		RemainingBindings: remaining,
+	}
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionDraining,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Draining",
+		Message: fmt.Sprintf("Draining %v, %d bindings remaining", victims, remaining),
+	}
+	if len(unknown) > 0 {
+		condition.Message = fmt.Sprintf("Draining %v, %d bindings remaining, bindings of %v unknown", victims, remaining, unknown)
+	}
+	if done {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Drained"
+		condition.Message = fmt.Sprintf("Drained %v, scaling down", victims)
+		if remaining > 0 || len(unknown) > 0 {
+			condition.Reason = "DrainTimeout"
+			condition.Message = fmt.Sprintf("Drain timed out with %d bindings remaining, scaling down", remaining)
+		}
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	return done, r.Status().Update(ctx, agent)
+}
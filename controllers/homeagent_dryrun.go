@@ -0,0 +1,173 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+const (
+	// dryRunAnnotation set to "true" makes the operator only report the
+	// changes it would make for the agent, like --dry-run does for every
+	// agent.
+	dryRunAnnotation = "prairie.kismi/dry-run"
+
+	// maxDryRunChanges bounds the changes reported in the status.
+	maxDryRunChanges = 20
+)
+
+var errDryRun = errors.New("not done in a dry run")
+
+// inDryRun returns whether the changes for the agent are only reported.
+func inDryRun(ctx context.Context, agent *prairiev1.HomeAgent) bool {
+	return audit.DryRun(ctx) || agent.Annotations[dryRunAnnotation] == "true"
+}
+
+// reportDryRun replaces the changes in the status of the agent with the
+// ones a reconcile in dry run didn't make.
+func (r *HomeAgentReconciler) reportDryRun(ctx context.Context, req ctrl.Request, planned []audit.Entry) {
+	agent := &prairiev1.HomeAgent{}
+	if err := r.Get(ctx, req.NamespacedName, agent); err != nil {
+		log.FromContext(ctx).Error(err, "Dry run could not be reported.")
+		return
+	}
+
+	message := "No changes are pending"
+	if len(planned) > 0 {
+		message = fmt.Sprintf("%d changes were not made", len(planned))
+		r.Recorder.Event(agent, corev1.EventTypeNormal, "DryRun", message+", see status.changes")
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+		Type:               prairiev1.ConditionDryRun,
+		Status:             metav1.ConditionTrue,
+		Reason:             "DryRun",
+		Message:            message,
+		ObservedGeneration: agent.Generation,
+	})
+	agent.Status.Changes = nil
+	if excess := len(planned) - maxDryRunChanges; excess > 0 {
+		planned = planned[excess:]
+	}
+	for _, entry := range planned {
+		agent.Status.Changes = append(agent.Status.Changes, changeOf(entry))
+	}
+
+	// The report is the one change made in a dry run
+	err := r.Status().Update(audit.WithDryRun(ctx, false), agent)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Dry run could not be reported.")
+	}
+}
+
+// dryRunDaemon skips the calls changing the state of an agent made with a
+// dry run context, reads are passed through.
+type dryRunDaemon struct {
+	daemon.Client
+}
+
+func (d dryRunDaemon) skipped(ctx context.Context, call, addr string) bool {
+	if !audit.DryRun(ctx) {
+		return false
+	}
+	log.FromContext(ctx).Info("Replica would be changed.", "call", call, "replica", addr, "dryRun", true)
+	return true
+}
+
+func (d dryRunDaemon) PushConfig(ctx context.Context, addr string, config []byte) error {
+	if d.skipped(ctx, "PushConfig", addr) {
+		return nil
+	}
+	return d.Client.PushConfig(ctx, addr, config)
+}
+
+func (d dryRunDaemon) PushKeyring(ctx context.Context, addr string, keyring map[string][]byte) error {
+	if d.skipped(ctx, "PushKeyring", addr) {
+		return nil
+	}
+	return d.Client.PushKeyring(ctx, addr, keyring)
+}
+
+func (d dryRunDaemon) Reload(ctx context.Context, addr string) error {
+	if d.skipped(ctx, "Reload", addr) {
+		return nil
+	}
+	return d.Client.Reload(ctx, addr)
+}
+
+func (d dryRunDaemon) Drain(ctx context.Context, addr string, timeout time.Duration) error {
+	if d.skipped(ctx, "Drain", addr) {
+		return nil
+	}
+	return d.Client.Drain(ctx, addr, timeout)
+}
+
+// Backup fails in a dry run, as there is no snapshot to report.
+func (d dryRunDaemon) Backup(ctx context.Context, addr, location string) (*daemon.BackupResult, error) {
+	if d.skipped(ctx, "Backup", addr) {
+		return nil, errDryRun
+	}
+	return d.Client.Backup(ctx, addr, location)
+}
+
+func (d dryRunDaemon) Restore(ctx context.Context, addr string, locations []string) error {
+	if d.skipped(ctx, "Restore", addr) {
+		return nil
+	}
+	return d.Client.Restore(ctx, addr, locations)
+}
+
+func (d dryRunDaemon) SetRole(ctx context.Context, addr, role string) error {
+	if d.skipped(ctx, "SetRole", addr) {
+		return nil
+	}
+	return d.Client.SetRole(ctx, addr, role)
+}
+
+// ExportBinding fails in a dry run, as exporting freezes the binding.
+func (d dryRunDaemon) ExportBinding(ctx context.Context, addr, homeAddress string) (*daemon.Binding, error) {
+	if d.skipped(ctx, "ExportBinding", addr) {
+		return nil, errDryRun
+	}
+	return d.Client.ExportBinding(ctx, addr, homeAddress)
+}
+
+func (d dryRunDaemon) ImportBinding(ctx context.Context, addr string, binding daemon.Binding) error {
+	if d.skipped(ctx, "ImportBinding", addr) {
+		return nil
+	}
+	return d.Client.ImportBinding(ctx, addr, binding)
+}
+
+func (d dryRunDaemon) DeleteBinding(ctx context.Context, addr, homeAddress string) error {
+	if d.skipped(ctx, "DeleteBinding", addr) {
+		return nil
+	}
+	return d.Client.DeleteBinding(ctx, addr, homeAddress)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// external-dns publishes the anycast address either from the annotations of
+// the Service, with the address as explicit target as the Service has no
+// load balancer, or from a DNSEndpoint of its crd source. DNSEndpoint is
+// optional, the operator only touches it when asked to.
+const (
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTargetAnnotation   = "external-dns.alpha.kubernetes.io/target"
+	externalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+var dnsEndpointKind = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+// externalDNS returns the DNS name settings of the agent, nil unless it has
+// an anycast address to publish.
+func externalDNS(agent *prairiev1.HomeAgent) *prairiev1.ExternalDNSSpec {
+	if agent.Spec.Discovery == nil || agent.Spec.Discovery.AnycastAddress == "" {
+		return nil
+	}
+	return agent.Spec.Discovery.ExternalDNS
+}
+
+func fqdn(hostname string) string {
+	return strings.TrimSuffix(hostname, ".")
+}
+
+// externalDNSAnnotations returns the annotations publishing the anycast
+// address from the Service.
+func externalDNSAnnotations(agent *prairiev1.HomeAgent) map[string]string {
+	spec := externalDNS(agent)
+	if spec == nil || (spec.Source != "" && spec.Source != prairiev1.ExternalDNSService) {
+		return nil
+	}
+	annotations := map[string]string{
+		externalDNSHostnameAnnotation: fqdn(spec.Hostname),
+		externalDNSTargetAnnotation:   agent.Spec.Discovery.AnycastAddress,
+	}
+	if spec.TTL != nil {
+		annotations[externalDNSTTLAnnotation] = strconv.FormatInt(*spec.TTL, 10)
+	}
+	return annotations
+}
+
+// dropExternalDNSAnnotations removes the external-dns annotations the
+// Service carries but shouldn't anymore, and reports whether there were any.
+func dropExternalDNSAnnotations(service *corev1.Service, desired map[string]string) bool {
+	dropped := false
+	for _, key := range []string{externalDNSHostnameAnnotation, externalDNSTargetAnnotation, externalDNSTTLAnnotation} {
+		if _, ok := service.Annotations[key]; !ok {
+			continue
+		}
+		if _, keep := desired[key]; !keep {
+			delete(service.Annotations, key)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// dnsEndpoint builds the DNSEndpoint publishing the anycast address.
+func dnsEndpoint(agent *prairiev1.HomeAgent, spec *prairiev1.ExternalDNSSpec) *unstructured.Unstructured {
+	record := map[string]interface{}{
+		"dnsName":    fqdn(spec.Hostname),
+		"recordType": "AAAA",
+		"targets":    []interface{}{agent.Spec.Discovery.AnycastAddress},
+	}
+	if spec.TTL != nil {
+		record["recordTTL"] = *spec.TTL
+	}
+
+	endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"endpoints": []interface{}{record}},
+	}}
+	endpoint.SetGroupVersionKind(dnsEndpointKind)
+	endpoint.SetName(agent.Name)
+	endpoint.SetNamespace(agent.Namespace)
+	endpoint.SetLabels(componentLabels(agent, componentDiscovery))
+	endpoint.SetAnnotations(commonAnnotations(agent))
+	return endpoint
+}
+
+// reconcileDNSEndpoint manages the DNSEndpoint of the agent and records the
+// published name in its status. Agents which never published through a
+// DNSEndpoint are skipped without a lookup, DNSEndpoints aren't cached.
+// Without the DNSEndpoint resource installed a warning is emitted once.
+func (r *HomeAgentReconciler) reconcileDNSEndpoint(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	spec := externalDNS(agent)
+	wanted := spec != nil && spec.Source == prairiev1.ExternalDNSEndpoint
+	previous := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionDNSPublished)
+	// Agents published by earlier releases have their name in the status
+	// only
+	unpublished := previous == nil && (spec != nil || agent.Status.FQDN == "")
+	agent.Status.FQDN = ""
+	if !wanted && unpublished {
+		if spec != nil {
+			agent.Status.FQDN = fqdn(spec.Hostname)
+		}
+		return nil
+	}
+
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(dnsEndpointKind)
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, endpoint)
+	if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return err
+	}
+	installed := !meta.IsNoMatchError(err)
+	exists := err == nil
+
+	if !wanted {
+		if exists && metav1.IsControlledBy(endpoint, agent) {
+			log.FromContext(ctx).Info("DNSEndpoint no longer used, deleting it.")
+			if err := r.Delete(ctx, endpoint); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+		meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionDNSPublished)
+		if spec != nil {
+			agent.Status.FQDN = fqdn(spec.Hostname)
+		}
+		return nil
+	}
+	if !installed {
+		message := "The DNSEndpoint resource of external-dns is not installed, the name is not published."
+		if previous == nil || previous.Reason != "DNSEndpointUnavailable" {
+			r.Recorder.Event(agent, corev1.EventTypeWarning, "DNSEndpointUnavailable", message)
+		}
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:               prairiev1.ConditionDNSPublished,
+			Status:             metav1.ConditionFalse,
+			Reason:             "DNSEndpointUnavailable",
+			Message:            message,
+			ObservedGeneration: agent.Generation,
+		})
+		return nil
+	}
+
+	desired := dnsEndpoint(agent, spec)
+	if !exists {
+		if err := ctrl.SetControllerReference(agent, desired, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("DNSEndpoint created.", "hostname", fqdn(spec.Hostname))
+	} else if !equality.Semantic.DeepEqual(desired.Object["spec"], endpoint.Object["spec"]) ||
+		metadataChanged(endpoint, desired.GetLabels(), desired.GetAnnotations()) {
+		endpoint.Object["spec"] = desired.Object["spec"]
+		setMetadata(endpoint, desired.GetLabels(), desired.GetAnnotations())
+		if err := r.Update(ctx, endpoint); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("DNSEndpoint updated.", "hostname", fqdn(spec.Hostname))
+	}
+
+	meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+		Type:               prairiev1.ConditionDNSPublished,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Published",
+		Message:            "The name is published through the DNSEndpoint " + agent.Name,
+		ObservedGeneration: agent.Generation,
+	})
+	agent.Status.FQDN = fqdn(spec.Hostname)
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The firewall of an agent is rendered into the packet filter of mo-daemon,
+// which filters the traffic it tunnels. Every rule is its name and action,
+// followed by its match fields:
+//
+//	firewall_default = deny
+//	firewall_rules = dns:allow;direction=egress;protocol=udp;ports=53,web:allow;ports=80-443
+
+// firewallSettings renders spec.firewall.
+func firewallSettings(agent *prairiev1.HomeAgent) []setting {
+	firewall := agent.Spec.Firewall
+	if firewall == nil {
+		return nil
+	}
+	action := firewall.DefaultAction
+	if action == "" {
+		action = prairiev1.PolicyAllow
+	}
+	settings := []setting{{Key: "firewall_default", Value: strings.ToLower(string(action))}}
+	if len(firewall.Rules) > 0 {
+		rules := make([]string, 0, len(firewall.Rules))
+		for _, rule := range firewall.Rules {
+			fields := []string{rule.Name + ":" + strings.ToLower(string(rule.Action))}
+			if rule.Direction != "" {
+				fields = append(fields, "direction="+strings.ToLower(string(rule.Direction)))
+			}
+			if rule.Prefix != "" {
+				_, prefix, _ := net.ParseCIDR(rule.Prefix)
+				fields = append(fields, "prefix="+prefix.String())
+			}
+			if rule.Protocol != "" {
+				fields = append(fields, "protocol="+strings.ToLower(string(rule.Protocol)))
+			}
+			if rule.Port != nil {
+				ports := fmt.Sprint(*rule.Port)
+				if rule.EndPort != nil {
+					ports += fmt.Sprintf("-%d", *rule.EndPort)
+				}
+				fields = append(fields, "ports="+ports)
+			}
+			rules = append(rules, strings.Join(fields, ";"))
+		}
+		settings = append(settings, setting{Key: "firewall_rules", Value: strings.Join(rules, ",")})
+	}
+	return settings
+}
+
+// validateFirewall checks that every rule of the agent's firewall can match
+// a packet.
+func validateFirewall(agent *prairiev1.HomeAgent) error {
+	if agent.Spec.Firewall == nil {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, rule := range agent.Spec.Firewall.Rules {
+		if names[rule.Name] {
+			return fmt.Errorf("firewall rule %s is listed twice", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Prefix != "" {
+			ip, _, err := net.ParseCIDR(rule.Prefix)
+			if err != nil {
+				return fmt.Errorf("firewall rule %s: invalid prefix %q", rule.Name, rule.Prefix)
+			}
+			if rule.Protocol == "ICMP" && ip.To4() == nil {
+				return fmt.Errorf("firewall rule %s matches ICMP from the IPv6 prefix %s", rule.Name, rule.Prefix)
+			}
+			if rule.Protocol == "ICMPv6" && ip.To4() != nil {
+				return fmt.Errorf("firewall rule %s matches ICMPv6 from the IPv4 prefix %s", rule.Name, rule.Prefix)
+			}
+		}
+		if rule.Port == nil {
+			if rule.EndPort != nil {
+				return fmt.Errorf("firewall rule %s has an end port without a port", rule.Name)
+			}
+			continue
+		}
+		if rule.Protocol != "TCP" && rule.Protocol != "UDP" && rule.Protocol != "SCTP" {
+			return fmt.Errorf("firewall rule %s matches a port without TCP, UDP or SCTP", rule.Name)
+		}
+		if rule.EndPort != nil && *rule.EndPort < *rule.Port {
+			return fmt.Errorf("firewall rule %s ends at port %d before its port %d", rule.Name, *rule.EndPort, *rule.Port)
+		}
+	}
+	return nil
+}
+
+// checkFirewall marks the agent degraded if its firewall is invalid.
+func (r *HomeAgentReconciler) checkFirewall(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateFirewall(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidFirewall", err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// In hardened mode an init container copies the daemon binary to a shared
+// volume and a second one grants the copy the capabilities mo-daemon needs.
+// The agent container runs the copy as a non-root user, the file
+// capabilities become its effective capabilities on exec.
+const (
+	hardenedUser      = 65532
+	hardenedMountPath = "/opt/prairie/bin"
+	hardenedVolume    = "bin"
+	daemonBinary      = "/usr/bin/mo-daemon"
+)
+
+// hardenedCapabilities are granted to the daemon binary. They also have to
+// remain in the bounding set of the agent container.
+var hardenedCapabilities = []corev1.Capability{"NET_ADMIN", "NET_RAW", "NET_BIND_SERVICE"}
+
+// hardenedTemplate switches the pod template to a non-root daemon. The
+// binary is copied with cp from the agent image and setcap runs from
+// tools_image, the agent image if empty. Settings of the container security
+// contexts are merged: the user, privileges and dropped capabilities set on
+// the agent are kept and the hardened capabilities are added to its own.
+// File capabilities are ignored with no_new_privs, so privilege escalation
+// stays allowed unless the agent forbids it.
+func hardenedTemplate(agent *prairiev1.HomeAgent, tools_image string, template *corev1.PodTemplateSpec) {
+	if !agent.Spec.Hardened || len(template.Spec.Containers) == 0 {
+		return
+	}
+	if tools_image == "" {
+		tools_image = agentImage(agent)
+	}
+
+	user := int64(hardenedUser)
+	if template.Spec.SecurityContext == nil {
+		template.Spec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if template.Spec.SecurityContext.RunAsUser != nil {
+		user = *template.Spec.SecurityContext.RunAsUser
+	}
+	if template.Spec.SecurityContext.FSGroup == nil {
+		group := int64(hardenedUser)
+		template.Spec.SecurityContext.FSGroup = &group
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name:         hardenedVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+
+	binary := path.Join(hardenedMountPath, path.Base(daemonBinary))
+	names := make([]string, len(hardenedCapabilities))
+	for idx, capability := range hardenedCapabilities {
+		names[idx] = "cap_" + strings.ToLower(string(capability))
+	}
+	mounts := []corev1.VolumeMount{{Name: hardenedVolume, MountPath: hardenedMountPath}}
+	pull_policy := template.Spec.Containers[0].ImagePullPolicy
+	nonRoot := true
+	root := int64(0)
+	rootAllowed := false
+	template.Spec.InitContainers = append(template.Spec.InitContainers,
+		corev1.Container{
+			Name:            "install",
+			Image:           agentImage(agent),
+			ImagePullPolicy: pull_policy,
+			Command:         []string{"cp", daemonBinary, binary},
+			SecurityContext: &corev1.SecurityContext{
+				RunAsUser:    &user,
+				RunAsNonRoot: &nonRoot,
+				Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			},
+			VolumeMounts: mounts,
+		},
+		corev1.Container{
+			Name:            "setcap",
+			Image:           tools_image,
+			ImagePullPolicy: pull_policy,
+			Command:         []string{"setcap", strings.Join(names, ",") + "+ep", binary},
+			SecurityContext: &corev1.SecurityContext{
+				RunAsUser:    &root,
+				RunAsNonRoot: &rootAllowed,
+				Capabilities: &corev1.Capabilities{
+					Drop: []corev1.Capability{"ALL"},
+					Add:  []corev1.Capability{"SETFCAP"},
+				},
+			},
+			VolumeMounts: mounts,
+		})
+
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		container.Command = []string{binary}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      hardenedVolume,
+			MountPath: hardenedMountPath,
+			ReadOnly:  true,
+		})
+		hardenedSecurityContext(container, user)
+	}
+}
+
+// hardenedSecurityContext merges the hardened settings into the security
+// context of container, keeping what is set already.
+func hardenedSecurityContext(container *corev1.Container, user int64) {
+	context := container.SecurityContext
+	if context == nil {
+		context = &corev1.SecurityContext{}
+		container.SecurityContext = context
+	}
+	if context.RunAsUser == nil {
+		context.RunAsUser = &user
+	}
+	if context.RunAsNonRoot == nil && *context.RunAsUser != 0 {
+		nonRoot := true
+		context.RunAsNonRoot = &nonRoot
+	}
+	if context.AllowPrivilegeEscalation == nil {
+		escalation := true
+		context.AllowPrivilegeEscalation = &escalation
+	}
+	if context.Capabilities == nil {
+		context.Capabilities = &corev1.Capabilities{}
+	}
+	if len(context.Capabilities.Drop) == 0 {
+		context.Capabilities.Drop = []corev1.Capability{"ALL"}
+	}
+	for _, capability := range hardenedCapabilities {
+		if !hasCapability(context.Capabilities.Add, capability) {
+			context.Capabilities.Add = append(context.Capabilities.Add, capability)
+		}
+	}
+}
+
+func hasCapability(capabilities []corev1.Capability, capability corev1.Capability) bool {
+	for _, c := range capabilities {
+		if strings.EqualFold(string(c), string(capability)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// failureReasons are the container states which mark a replica as failing,
+// in the order they are reported.
+var failureReasons = []string{
+	"CrashLoopBackOff",
+	"OOMKilled",
+	"ImagePullBackOff",
+	"ErrImagePull",
+	"CreateContainerConfigError",
+}
+
+// podFailure returns why a container of the pod is failing, if it is.
+func podFailure(pod *corev1.Pod) string {
+	statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+	failure := ""
+	for _, status := range statuses {
+		reason := ""
+		if status.State.Waiting != nil {
+			reason = status.State.Waiting.Reason
+		}
+		// A container running out of memory ends up in a crash loop, the
+		// reason of its termination is the telling one.
+		if status.State.Running == nil && (oomKilled(status.State.Terminated) || oomKilled(status.LastTerminationState.Terminated)) {
+			reason = "OOMKilled"
+		}
+		if failureRank(reason) < failureRank(failure) {
+			failure = reason
+		}
+	}
+	return failure
+}
+
+func oomKilled(terminated *corev1.ContainerStateTerminated) bool {
+	return terminated != nil && terminated.Reason == "OOMKilled"
+}
+
+// failureRank orders the failure reasons, unknown reasons rank last.
+func failureRank(reason string) int {
+	for idx, failure := range failureReasons {
+		if failure == reason {
+			return idx
+		}
+	}
+	return len(failureReasons)
+}
+
+// reconcileDegraded reflects failing replicas in the Degraded condition and
+// emits an event whenever the set of failing replicas changes.
+func (r *HomeAgentReconciler) reconcileDegraded(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return err
+	}
+
+	failing := map[string][]string{}
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if reason := podFailure(pod); failureRank(reason) < len(failureReasons) {
+			failing[reason] = append(failing[reason], pod.Name)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionDegraded,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: "No replica is failing",
+	}
+	if len(failing) > 0 {
+		reasons := make([]string, 0, len(failing))
+		for reason := range failing {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return failureRank(reasons[i]) < failureRank(reasons[j]) })
+		messages := make([]string, len(reasons))
+		for idx, reason := range reasons {
+			sort.Strings(failing[reason])
+			messages[idx] = fmt.Sprintf("%s: %s", reason, strings.Join(failing[reason], ", "))
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasons[0]
+		condition.Message = strings.Join(messages, "; ")
+	}
+
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionDegraded)
+	if current != nil && current.Status == condition.Status && current.Message == condition.Message {
+		return nil
+	}
+	if condition.Status == metav1.ConditionTrue {
+		r.Recorder.Event(agent, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	} else if current != nil {
+		r.Recorder.Event(agent, corev1.EventTypeNormal, "Recovered", "Every replica recovered")
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	return r.Status().Update(ctx, agent)
+}
+
+// agentOfPod maps a replica to its HomeAgent, so failing replicas are
+// noticed right away. Pods of other workloads carrying a parent label are
+// ignored.
+func (r *HomeAgentReconciler) agentOfPod(obj client.Object) []reconcile.Request {
+	parent, ok := obj.GetLabels()["parent"]
+	if !ok {
+		return nil
+	}
+	name := types.NamespacedName{Name: parent, Namespace: obj.GetNamespace()}
+	if err := r.Get(context.Background(), name, &prairiev1.HomeAgent{}); err != nil {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: name}}
+}
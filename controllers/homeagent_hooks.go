@@ -0,0 +1,297 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const (
+	// preDeleteFinalizer keeps a HomeAgent with a pre-delete hook until the
+	// hook ran.
+	preDeleteFinalizer = "prairie.kismi/pre-delete-hook"
+
+	defaultHookTimeout = 5 * time.Minute
+)
+
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+func preDeleteJobName(agent string) string {
+	return agent + "-pre-delete"
+}
+
+func preDeleteHook(agent *prairiev1.HomeAgent) *prairiev1.HookSpec {
+	if agent.Spec.Hooks == nil {
+		return nil
+	}
+	return agent.Spec.Hooks.PreDelete
+}
+
+// reconcileHookFinalizer adds the finalizer of the pre-delete hook while
+// the agent has one, and removes it once the hook is removed.
+func (r *HomeAgentReconciler) reconcileHookFinalizer(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	changed := false
+	if preDeleteHook(agent) != nil {
+		changed = controllerutil.AddFinalizer(agent, preDeleteFinalizer)
+	} else {
+		changed = controllerutil.RemoveFinalizer(agent, preDeleteFinalizer)
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, agent)
+}
+
+// finalizeAgent runs the pre-delete hook of a deleted agent and tears down
+// its workload once the hook is done, then deletes its FRRConfiguration,
+// before letting the agent go. It returns how long to wait for the hook to
+// finish.
+func (r *HomeAgentReconciler) finalizeAgent(ctx context.Context, req ctrl.Request, agent *prairiev1.HomeAgent) (time.Duration, error) {
+	changed := false
+	if controllerutil.ContainsFinalizer(agent, preDeleteFinalizer) {
+		hook := preDeleteHook(agent)
+		if hook != nil {
+			done, wait, err := r.runPreDeleteHook(ctx, agent, hook)
+			if err != nil || !done {
+				return wait, err
+			}
+		}
+
+		r.DeleteDeployment(ctx, req)
+		r.DeleteStatefulSet(ctx, req)
+		changed = controllerutil.RemoveFinalizer(agent, preDeleteFinalizer)
+	}
+
+	finalized, err := r.finalizeBGP(ctx, req, agent)
+	if err != nil {
+		return 0, err
+	}
+	if !changed && !finalized {
+		return 0, nil
+	}
+	return 0, r.Update(ctx, agent)
+}
+
+// runPreDeleteHook runs the Job of the hook and reports whether it is done,
+// or how long to wait for it otherwise. A failed Job or one that timed out
+// only holds the agent back with the Fail policy.
+func (r *HomeAgentReconciler) runPreDeleteHook(ctx context.Context, agent *prairiev1.HomeAgent, hook *prairiev1.HookSpec) (bool, time.Duration, error) {
+	job := preDeleteJobName(agent.Name)
+	previous := prairiev1.HookPhase("")
+	if condition := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionPreDeleteHook); condition != nil {
+		previous = prairiev1.HookPhase(condition.Reason)
+	}
+	phase, message, wait, err := r.runHook(ctx, agent, "PreDelete", job, hook, previous)
+	if err != nil {
+		return false, 0, err
+	}
+	switch phase {
+	case prairiev1.HookRunning:
+		return false, wait, r.setHookCondition(ctx, agent, metav1.ConditionFalse, "Running", fmt.Sprintf("Job %s is running", job))
+	case prairiev1.HookSucceeded:
+		return true, 0, r.setHookCondition(ctx, agent, metav1.ConditionTrue, "Succeeded", message)
+	}
+
+	if hook.FailurePolicy == prairiev1.HookFailureFail {
+		// Removing the hook from the spec, or deleting the Job to run it
+		// again, brings us back
+		log.FromContext(ctx).Info("Pre-delete hook failed, keeping the HomeAgent.", "job", job)
+		return false, 0, r.setHookCondition(ctx, agent, metav1.ConditionFalse, "Failed", message+", the HomeAgent is kept")
+	}
+	log.FromContext(ctx).Info("Pre-delete hook failed, deleting anyway.", "job", job)
+	return true, 0, nil
+}
+
+// reconcilePostCreateHook runs the post-create hook once the replicas of
+// the agent first became ready, and records it in the status so it isn't
+// run again. It returns how long to wait for the hook to time out.
+func (r *HomeAgentReconciler) reconcilePostCreateHook(ctx context.Context, agent *prairiev1.HomeAgent, workload client.Object) (time.Duration, error) {
+	hook := agent.Spec.Hooks
+	if hook == nil || hook.PostCreate == nil || hookDone(agent.Status.Hooks, hook.PostCreate) {
+		return 0, nil
+	}
+	if agent.Status.Hooks == nil || agent.Status.Hooks.PostCreate == nil {
+		// Replicas becoming ready are noticed through the pod watch
+		if readyReplicas(workload) < replicaCount(agent) {
+			return 0, nil
+		}
+	}
+
+	job := agent.Name + "-post-create"
+	previous := prairiev1.HookPhase("")
+	if agent.Status.Hooks != nil && agent.Status.Hooks.PostCreate != nil {
+		previous = agent.Status.Hooks.PostCreate.Phase
+	}
+	phase, message, wait, err := r.runHook(ctx, agent, "PostCreate", job, hook.PostCreate, previous)
+	if err != nil {
+		return 0, err
+	}
+	if agent.Status.Hooks == nil {
+		agent.Status.Hooks = &prairiev1.HooksStatus{}
+	}
+	status := &prairiev1.HookStatus{Job: job, Phase: phase}
+	if current := agent.Status.Hooks.PostCreate; current != nil && current.Phase == phase {
+		status.CompletionTime = current.CompletionTime
+	}
+	if phase != prairiev1.HookRunning && status.CompletionTime == nil {
+		now := metav1.Now()
+		status.CompletionTime = &now
+	}
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionPostCreateHook,
+		Status:  metav1.ConditionFalse,
+		Reason:  string(phase),
+		Message: message,
+	}
+	switch phase {
+	case prairiev1.HookSucceeded:
+		condition.Status = metav1.ConditionTrue
+	case prairiev1.HookFailed:
+		status.Message = message
+	}
+	agent.Status.Hooks.PostCreate = status
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	return wait, nil
+}
+
+// hookDone reports whether a hook which only runs once has run.
+func hookDone(status *prairiev1.HooksStatus, hook *prairiev1.HookSpec) bool {
+	if status == nil || status.PostCreate == nil {
+		return false
+	}
+	switch status.PostCreate.Phase {
+	case prairiev1.HookSucceeded:
+		return true
+	case prairiev1.HookFailed:
+		return hook.FailurePolicy != prairiev1.HookFailureFail
+	}
+	return false
+}
+
+// runHook starts the Job of a hook unless it exists, and returns the phase
+// of the hook with a message. Running hooks also return how long until they
+// time out. A failure is only recorded as an event when the hook wasn't
+// failed before already.
+func (r *HomeAgentReconciler) runHook(ctx context.Context, agent *prairiev1.HomeAgent, kind, name string, hook *prairiev1.HookSpec, previous prairiev1.HookPhase) (prairiev1.HookPhase, string, time.Duration, error) {
+	timeout := defaultHookTimeout
+	if hook.Timeout != nil {
+		timeout = hook.Timeout.Duration
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: agent.Namespace}, job)
+	if errors.IsNotFound(err) {
+		job = r.createHookJob(agent, name, hook)
+		if err := ctrl.SetControllerReference(agent, job, r.Scheme); err != nil {
+			return "", "", 0, err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			return "", "", 0, err
+		}
+		message := fmt.Sprintf("Job %s started", name)
+		log.FromContext(ctx).Info("Hook started.", "hook", kind, "job", name)
+		r.Recorder.Event(agent, corev1.EventTypeNormal, kind+"HookStarted", message)
+		return prairiev1.HookRunning, message, timeout, nil
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	failure := ""
+	switch {
+	case jobCondition(job, batchv1.JobComplete):
+		return prairiev1.HookSucceeded, fmt.Sprintf("Job %s succeeded", name), 0, nil
+	case jobCondition(job, batchv1.JobFailed):
+		failure = fmt.Sprintf("Job %s failed", name)
+	case time.Since(job.CreationTimestamp.Time) >= timeout:
+		failure = fmt.Sprintf("Job %s didn't finish within %s", name, timeout)
+	default:
+		return prairiev1.HookRunning, fmt.Sprintf("Job %s is running", name), time.Until(job.CreationTimestamp.Add(timeout)), nil
+	}
+	if previous != prairiev1.HookFailed {
+		r.Recorder.Event(agent, corev1.EventTypeWarning, kind+"HookFailed", failure)
+	}
+	return prairiev1.HookFailed, failure, 0, nil
+}
+
+// createHookJob renders the Job of a hook, its container learns which
+// agent it runs for through the environment.
+func (r *HomeAgentReconciler) createHookJob(agent *prairiev1.HomeAgent, name string, hook *prairiev1.HookSpec) *batchv1.Job {
+	env := append([]corev1.EnvVar{}, hook.Env...)
+	env = append(env,
+		corev1.EnvVar{Name: "HOMEAGENT_NAME", Value: agent.Name},
+		corev1.EnvVar{Name: "HOMEAGENT_NAMESPACE", Value: agent.Namespace},
+	)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: agent.Namespace},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					ServiceAccountName: hook.ServiceAccountName,
+					RestartPolicy:      corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:    "hook",
+						Image:   hook.Image,
+						Command: hook.Command,
+						Args:    hook.Args,
+						Env:     env,
+					}},
+				},
+			},
+		},
+	}
+	setMetadata(job, componentLabels(agent, componentHook), commonAnnotations(agent))
+	return job
+}
+
+func jobCondition(job *batchv1.Job, condition batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == condition {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *HomeAgentReconciler) setHookCondition(ctx context.Context, agent *prairiev1.HomeAgent, status metav1.ConditionStatus, reason, message string) error {
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionPreDeleteHook)
+	if current != nil && current.Status == status && current.Reason == reason {
+		return nil
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionPreDeleteHook,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, agent)
+}
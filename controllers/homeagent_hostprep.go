@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// Some features need kernel parameters of the network namespace of the
+// pods. They are set through the sysctls of the pod security context, the
+// container runtime applies them once the interfaces are attached. None of
+// them is in the safe set, every kubelet running agents has to allow them
+// with --allowed-unsafe-sysctls=net.ipv4.conf.*,net.ipv6.conf.*, or it
+// rejects the pods with SysctlForbidden.
+//
+// Pods in the host network can't set sysctls, there the host-prep init
+// container writes them to /proc/sys/net of the node before mo-daemon
+// starts. It gets NET_ADMIN only, hardened agents don't get it and need the
+// kernel parameters set on the nodes.
+const (
+	hostPrepContainer = "host-prep"
+	hostPrepVolume    = "host-sysctl"
+	hostPrepMountPath = "/host/proc/sys/net"
+)
+
+// hostSysctls returns the kernel parameters the agent needs, in the slash
+// notation which keeps interface names with dots intact.
+func hostSysctls(agent *prairiev1.HomeAgent) []corev1.Sysctl {
+	sysctls := []corev1.Sysctl{}
+	if proxying := agent.Spec.Proxying; proxying != nil && proxying.NDP != nil {
+		sysctls = append(sysctls, corev1.Sysctl{Name: "net/ipv6/conf/all/proxy_ndp", Value: "1"})
+		if proxying.NDP.Interface != "" {
+			sysctls = append(sysctls, corev1.Sysctl{Name: "net/ipv6/conf/" + proxying.NDP.Interface + "/proxy_ndp", Value: "1"})
+		}
+	}
+	if proxying := agent.Spec.Proxying; proxying != nil && proxying.ARP != nil {
+		sysctls = append(sysctls, corev1.Sysctl{Name: "net/ipv4/conf/" + proxying.ARP.Interface + "/proxy_arp", Value: "1"})
+	}
+	return sysctls
+}
+
+// hostPrepTemplate sets the kernel parameters the agent needs, in the pod
+// security context or, for pods in the host network, from the host-prep
+// init container.
+func hostPrepTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	sysctls := hostSysctls(agent)
+	if len(sysctls) == 0 {
+		return
+	}
+
+	if !template.Spec.HostNetwork {
+		if template.Spec.SecurityContext == nil {
+			template.Spec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		template.Spec.SecurityContext.Sysctls = append(template.Spec.SecurityContext.Sysctls, sysctls...)
+		return
+	}
+	if agent.Spec.Hardened {
+		return
+	}
+
+	writes := make([]string, len(sysctls))
+	for idx, sysctl := range sysctls {
+		writes[idx] = fmt.Sprintf("echo %s > %s", sysctl.Value, path.Join(hostPrepMountPath, strings.TrimPrefix(sysctl.Name, "net/")))
+	}
+	root := int64(0)
+	nonRoot := false
+	escalation := false
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: hostPrepVolume,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: "/proc/sys/net"},
+		},
+	})
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    hostPrepContainer,
+		Image:   agentImage(agent),
+		Command: []string{"sh", "-ec", strings.Join(writes, "\n")},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:                &root,
+			RunAsNonRoot:             &nonRoot,
+			AllowPrivilegeEscalation: &escalation,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"NET_ADMIN"},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: hostPrepVolume, MountPath: hostPrepMountPath}},
+	})
+}
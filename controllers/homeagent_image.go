@@ -0,0 +1,167 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/cosign"
+)
+
+// imageRetryInterval is how long a rejected image waits for another look,
+// signatures may be pushed after the image.
+const imageRetryInterval = time.Minute
+
+// verifyImage checks the signature of the agent image, and of the log
+// shipper and hardened tools images, and pins the rendered spec to the verified digests, so a
+// retagged image can't slip in. The registry is read with the pull secrets
+// of the agent. The outcome is recorded in the ImageVerified condition,
+// rejected images leave the workload untouched.
+func (r *HomeAgentReconciler) verifyImage(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionImageVerified)
+	if r.Images == nil {
+		if current != nil {
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionImageVerified)
+			return true, r.Status().Update(ctx, agent)
+		}
+		return true, nil
+	}
+
+	secrets, err := r.imagePullSecrets(ctx, agent)
+	if err != nil {
+		return false, err
+	}
+	keychain, err := cosign.Keychain(secrets)
+	if err != nil {
+		return false, err
+	}
+
+	image := agentImage(rendered)
+	digest, err := r.Images.Verify(ctx, image, keychain)
+	if err == nil && r.Features.Enabled(PinImageDigests) {
+		rendered.Spec.Image = cosign.Pinned(image, digest)
+	}
+	// The log shipper runs in the agent pods, it is held to the same key
+	if err == nil && rendered.Spec.Logging != nil {
+		image = logShipperImage(rendered.Spec.Logging)
+		digest, err = r.Images.Verify(ctx, image, keychain)
+		if err == nil && r.Features.Enabled(PinImageDigests) {
+			logging := rendered.Spec.Logging.DeepCopy()
+			logging.Image = cosign.Pinned(image, digest)
+			rendered.Spec.Logging = logging
+		}
+	}
+	// So does setcap for hardened agents
+	if err == nil && rendered.Spec.Hardened && r.HardenedToolsImage != "" {
+		image = r.HardenedToolsImage
+		digest, err = r.Images.Verify(ctx, image, keychain)
+		if err == nil {
+			r.tools.pin(cosign.Pinned(image, digest))
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               prairiev1.ConditionImageVerified,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Verified",
+		Message:            "The images are signed with the verification key",
+		ObservedGeneration: agent.Generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ImageVerificationFailed"
+		condition.Message = fmt.Sprintf("Image %s was rejected: %v", image, err)
+	}
+	if current != nil && current.Status == condition.Status && current.Message == condition.Message &&
+		current.ObservedGeneration == condition.ObservedGeneration {
+		return err == nil, nil
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	if err != nil {
+		r.Recorder.Event(agent, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		markStalled(agent, condition.Reason, condition.Message)
+	}
+	return err == nil, r.Status().Update(ctx, agent)
+}
+
+// imagePullSecrets returns the pull secrets of the agent pods: those of
+// the agent and of its ServiceAccount. Like the kubelet, missing ones are
+// skipped.
+func (r *HomeAgentReconciler) imagePullSecrets(ctx context.Context, agent *prairiev1.HomeAgent) ([]corev1.Secret, error) {
+	refs := append([]corev1.LocalObjectReference{}, agent.Spec.ImagePullSecrets...)
+	account := &corev1.ServiceAccount{}
+	err := r.Get(ctx, types.NamespacedName{Name: serviceAccountName(agent), Namespace: agent.Namespace}, account)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	refs = append(refs, account.ImagePullSecrets...)
+
+	secrets := []corev1.Secret{}
+	for _, ref := range refs {
+		secret := corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: agent.Namespace}, &secret)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// pinnedImage is the hardened tools image pinned to its verified digest.
+// The image is the same for every agent, so is the digest.
+type pinnedImage struct {
+	mu    sync.Mutex
+	image string
+}
+
+func (p *pinnedImage) pin(image string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.image = image
+}
+
+func (p *pinnedImage) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.image
+}
+
+// hardenedToolsImage returns the image setcap runs from, pinned to its
+// digest once verified.
+func (r *HomeAgentReconciler) hardenedToolsImage() string {
+	if r.HardenedToolsImage == "" || r.Images == nil || !r.Features.Enabled(PinImageDigests) {
+		return r.HardenedToolsImage
+	}
+	if pinned := r.tools.get(); pinned != "" {
+		return pinned
+	}
+	return r.HardenedToolsImage
+}
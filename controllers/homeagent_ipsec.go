@@ -0,0 +1,190 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// With IPsec enabled mo-daemon negotiates a child SA per tunnel over IKE.
+// The pre-shared key or certificate is mounted from its Secret.
+const (
+	ipsecPSKMountPath  = "/etc/mo-daemon/ipsec/psk"
+	ipsecCertMountPath = "/etc/mo-daemon/ipsec/cert"
+	ipsecPSKVolume     = "ipsec-psk"
+	ipsecCertVolume    = "ipsec-cert"
+	defaultIKEVersion  = 2
+	defaultSALifetime  = time.Hour
+)
+
+func ipsecSpec(agent *prairiev1.HomeAgent) *prairiev1.IPsecSpec {
+	if agent.Spec.Security == nil {
+		return nil
+	}
+	return agent.Spec.Security.IPsec
+}
+
+// checkIPsec makes sure IKE authenticates with exactly one of a pre-shared
+// key and a certificate.
+func (r *HomeAgentReconciler) checkIPsec(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	ipsec := ipsecSpec(agent)
+	if ipsec == nil {
+		return true, nil
+	}
+	psk := ipsec.PreSharedKeySecretRef != nil
+	cert := ipsec.CertificateSecretRef != nil
+	switch {
+	case psk && cert:
+		return false, r.markDegraded(ctx, agent, "InvalidIPsecConfig",
+			"IPsec takes either preSharedKeySecretRef or certificateSecretRef, not both")
+	case !psk && !cert:
+		return false, r.markDegraded(ctx, agent, "InvalidIPsecConfig",
+			"IPsec requires preSharedKeySecretRef or certificateSecretRef")
+	}
+	return true, nil
+}
+
+// ipsecSettings configures the IKE negotiation of mo-daemon.
+func ipsecSettings(agent *prairiev1.HomeAgent) []setting {
+	ipsec := ipsecSpec(agent)
+	if ipsec == nil {
+		return nil
+	}
+
+	version := int32(defaultIKEVersion)
+	if ipsec.IKEVersion != 0 {
+		version = ipsec.IKEVersion
+	}
+	lifetime := defaultSALifetime
+	if ipsec.Lifetime != nil {
+		lifetime = ipsec.Lifetime.Duration
+	}
+	settings := []setting{
+		{Key: "ipsec", Value: "on"},
+		{Key: "ipsec_ike_version", Value: strconv.Itoa(int(version))},
+		{Key: "ipsec_lifetime_s", Value: strconv.Itoa(int(lifetime.Seconds()))},
+	}
+	if len(ipsec.IKEProposals) > 0 {
+		settings = append(settings, setting{Key: "ipsec_ike_proposals", Value: strings.Join(ipsec.IKEProposals, ",")})
+	}
+	if len(ipsec.ESPProposals) > 0 {
+		settings = append(settings, setting{Key: "ipsec_esp_proposals", Value: strings.Join(ipsec.ESPProposals, ",")})
+	}
+	if ipsec.PreSharedKeySecretRef != nil {
+		settings = append(settings, setting{Key: "ipsec_psk", Value: path.Join(ipsecPSKMountPath, ipsec.PreSharedKeySecretRef.Key)})
+	}
+	if ipsec.CertificateSecretRef != nil {
+		settings = append(settings,
+			setting{Key: "ipsec_cert", Value: path.Join(ipsecCertMountPath, corev1.TLSCertKey)},
+			setting{Key: "ipsec_key", Value: path.Join(ipsecCertMountPath, corev1.TLSPrivateKeyKey)},
+		)
+	}
+	return settings
+}
+
+// ipsecTemplate mounts the IKE credentials.
+func ipsecTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	ipsec := ipsecSpec(agent)
+	if ipsec == nil {
+		return
+	}
+
+	mounts := []corev1.VolumeMount{}
+	if ipsec.PreSharedKeySecretRef != nil {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: ipsecPSKVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: ipsec.PreSharedKeySecretRef.Name},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: ipsecPSKVolume, MountPath: ipsecPSKMountPath, ReadOnly: true})
+	}
+	if ipsec.CertificateSecretRef != nil {
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name: ipsecCertVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: ipsec.CertificateSecretRef.Name},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: ipsecCertVolume, MountPath: ipsecCertMountPath, ReadOnly: true})
+	}
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, mounts...)
+	}
+}
+
+// saStatus counts the SAs reported by a replica by their state.
+func saStatus(sas []daemon.SAStats) *prairiev1.IPsecStatus {
+	if len(sas) == 0 {
+		return nil
+	}
+	status := &prairiev1.IPsecStatus{}
+	for _, sa := range sas {
+		switch sa.State {
+		case daemon.SAEstablished:
+			status.Established++
+		case daemon.SAFailed:
+			status.Failed++
+			status.FailedPeers = append(status.FailedPeers, sa.Peer)
+		default:
+			status.Connecting++
+		}
+	}
+	sort.Strings(status.FailedPeers)
+	return status
+}
+
+// setIPsecCondition summarizes the SAs of the replicas in the
+// IPsecEstablished condition.
+func setIPsecCondition(agent *prairiev1.HomeAgent, replicas []prairiev1.ReplicaStatus) {
+	if ipsecSpec(agent) == nil {
+		meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionIPsecEstablished)
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionIPsecEstablished,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: "No SA negotiation failed",
+	}
+	failed := []string{}
+	for _, replica := range replicas {
+		if replica.IPsec != nil && replica.IPsec.Failed > 0 {
+			failed = append(failed, fmt.Sprintf("%s: %d", replica.Name, replica.IPsec.Failed))
+		}
+	}
+	if len(failed) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NegotiationFailed"
+		condition.Message = "Failed SAs per replica: " + strings.Join(failed, ", ")
+	}
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+}
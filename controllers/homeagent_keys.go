@@ -0,0 +1,374 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const (
+	keyringMountPath = "/etc/mo-daemon/keys"
+	keyringVolume    = "keyring"
+	activeKeyFile    = "active"
+
+	// Secret volumes are synced by the kubelet periodically, give it time to
+	// pick up the new active key before the old one is removed.
+	keyRetireGrace = 2 * time.Minute
+	keyLength      = 32
+
+	// minRotationPeriod keeps a rotation from starting before the previous
+	// one is through.
+	minRotationPeriod = 10 * time.Minute
+)
+
+// keysManaged reports whether the operator provides the SPI/key pairs of
+// the agent, rotated or kept in Vault.
+func keysManaged(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Security != nil && (agent.Spec.Security.Rotation != nil || agent.Spec.Security.Vault != nil)
+}
+
+// rotationPeriod returns the time between two rotations, 0 without rotation.
+// Shorter periods than minRotationPeriod are raised to it.
+func rotationPeriod(agent *prairiev1.HomeAgent) time.Duration {
+	if agent.Spec.Security.Rotation == nil {
+		return 0
+	}
+	if agent.Spec.Security.Rotation.Period.Duration < minRotationPeriod {
+		return minRotationPeriod
+	}
+	return agent.Spec.Security.Rotation.Period.Duration
+}
+
+func keyringName(agent *prairiev1.HomeAgent) string {
+	return agent.Name + "-keys"
+}
+
+func keyFile(spi int64) string {
+	return fmt.Sprintf("spi-%d", spi)
+}
+
+// generateKey returns a fresh SPI/key pair. SPIs 0-255 are reserved, and the
+// new SPI must not collide with any key already present in the keyring.
+func generateKey(keyring map[string][]byte) (int64, []byte, error) {
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return 0, nil, err
+	}
+
+	buf := make([]byte, 4)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			return 0, nil, err
+		}
+		spi := int64(binary.BigEndian.Uint32(buf))
+		if spi < 256 {
+			continue
+		}
+		if _, taken := keyring[keyFile(spi)]; !taken {
+			return spi, key, nil
+		}
+	}
+}
+
+// reconcileKeys provisions the keyring Secret and drives the key rotation:
+// a new key is added next to the active one and distributed to every replica,
+// then promoted to active, and finally the superseded key is removed.
+// It returns the time after which the next rotation step is due.
+func (r *HomeAgentReconciler) reconcileKeys(ctx context.Context, agent *prairiev1.HomeAgent) (time.Duration, error) {
+	if !keysManaged(agent) {
+		return 0, nil
+	}
+	period := rotationPeriod(agent)
+	now := metav1.Now()
+
+	store, err := r.keyStore(ctx, agent)
+	if err != nil {
+		return 0, err
+	}
+	keyring, err := store.Load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if keyring == nil {
+		spi, key, err := generateKey(nil)
+		if err != nil {
+			return 0, err
+		}
+		keyring = map[string][]byte{
+			keyFile(spi):  key,
+			activeKeyFile: []byte(strconv.FormatInt(spi, 10)),
+		}
+		if err := store.Save(ctx, keyring); err != nil {
+			return 0, err
+		}
+		log.FromContext(ctx).Info("Keyring created.", "spi", spi)
+
+		agent.Status.Keys = &prairiev1.KeyStatus{ActiveSPI: spi, LastRotationTime: &now}
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionKeyRotationProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "KeysProvisioned",
+			Message: fmt.Sprintf("Initial key with SPI %d provisioned", spi),
+		})
+		return period, r.Status().Update(ctx, agent)
+	}
+
+	keys := agent.Status.Keys
+	if keys == nil {
+		// Status was lost, pick up where the keyring says we are.
+		spi, err := strconv.ParseInt(string(keyring[activeKeyFile]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("keyring of %s has no valid active key: %w", agent.Name, err)
+		}
+		keys = &prairiev1.KeyStatus{ActiveSPI: spi, LastRotationTime: &now}
+		agent.Status.Keys = keys
+	}
+	if agent.Spec.Security.Vault != nil {
+		// Replicas started since the last push have no keys at all
+		if _, err := r.keyDistributed(ctx, agent, keys.ActiveSPI, keyring); err != nil {
+			return 0, err
+		}
+	}
+
+	switch {
+	case keys.PendingSPI != 0:
+		distributed, err := r.keyDistributed(ctx, agent, keys.PendingSPI, keyring)
+		if err != nil || !distributed {
+			return keyRetireGrace, err
+		}
+
+		keyring[activeKeyFile] = []byte(strconv.FormatInt(keys.PendingSPI, 10))
+		if err := store.Save(ctx, keyring); err != nil {
+			return 0, err
+		}
+		log.FromContext(ctx).Info("New key is active, retiring the old one.", "spi", keys.PendingSPI)
+
+		keys.RetiringSPIs = append(keys.RetiringSPIs, keys.ActiveSPI)
+		keys.ActiveSPI = keys.PendingSPI
+		keys.PendingSPI = 0
+		keys.LastRotationTime = &now
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionKeyRotationProgressing,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Retiring",
+			Message: fmt.Sprintf("Key with SPI %d is active, retiring %v", keys.ActiveSPI, keys.RetiringSPIs),
+		})
+		return keyRetireGrace, r.Status().Update(ctx, agent)
+
+	case len(keys.RetiringSPIs) > 0:
+		retireAt := keys.LastRotationTime.Add(keyRetireGrace)
+		if now.Time.Before(retireAt) {
+			return retireAt.Sub(now.Time), nil
+		}
+
+		for _, spi := range keys.RetiringSPIs {
+			delete(keyring, keyFile(spi))
+		}
+		if err := store.Save(ctx, keyring); err != nil {
+			return 0, err
+		}
+		log.FromContext(ctx).Info("Old keys retired.", "spis", keys.RetiringSPIs)
+
+		keys.RetiringSPIs = nil
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionKeyRotationProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RotationComplete",
+			Message: fmt.Sprintf("Key with SPI %d is the only active key", keys.ActiveSPI),
+		})
+		return period, r.Status().Update(ctx, agent)
+
+	case period == 0:
+		// Keys are kept in Vault without rotation
+		return 0, nil
+
+	default:
+		due := keys.LastRotationTime.Add(period)
+		if now.Time.Before(due) {
+			return due.Sub(now.Time), nil
+		}
+
+		spi, key, err := generateKey(keyring)
+		if err != nil {
+			return 0, err
+		}
+		keyring[keyFile(spi)] = key
+		if err := store.Save(ctx, keyring); err != nil {
+			return 0, err
+		}
+		log.FromContext(ctx).Info("Rotating keys, distributing new key.", "spi", spi)
+
+		keys.PendingSPI = spi
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionKeyRotationProgressing,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Distributing",
+			Message: fmt.Sprintf("Distributing key with SPI %d to all replicas", spi),
+		})
+		return keyRetireGrace, r.Status().Update(ctx, agent)
+	}
+}
+
+// keyStore keeps the keyring of an agent, a file per SPI key and the
+// active SPI.
+type keyStore interface {
+	// Load returns the keyring, nil if there is none yet.
+	Load(ctx context.Context) (map[string][]byte, error)
+	// Save stores the keyring.
+	Save(ctx context.Context, keyring map[string][]byte) error
+}
+
+// secretKeyStore keeps the keyring in the Secret mounted into the replicas.
+type secretKeyStore struct {
+	r     *HomeAgentReconciler
+	agent *prairiev1.HomeAgent
+}
+
+func (s *secretKeyStore) Load(ctx context.Context) (map[string][]byte, error) {
+	secret := &corev1.Secret{}
+	err := s.r.Get(ctx, types.NamespacedName{Name: keyringName(s.agent), Namespace: s.agent.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	return secret.Data, nil
+}
+
+func (s *secretKeyStore) Save(ctx context.Context, keyring map[string][]byte) error {
+	secret := &corev1.Secret{}
+	err := s.r.Get(ctx, types.NamespacedName{Name: keyringName(s.agent), Namespace: s.agent.Namespace}, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        keyringName(s.agent),
+				Namespace:   s.agent.Namespace,
+				Labels:      componentLabels(s.agent, componentKeys),
+				Annotations: commonAnnotations(s.agent),
+			},
+			Data: keyring,
+		}
+		if err := ctrl.SetControllerReference(s.agent, secret, s.r.Scheme); err != nil {
+			return err
+		}
+		return s.r.Create(ctx, secret)
+	}
+	if err != nil {
+		return err
+	}
+	secret.Data = keyring
+	setMetadata(secret, componentLabels(s.agent, componentKeys), commonAnnotations(s.agent))
+	return s.r.Update(ctx, secret)
+}
+
+// keyDistributed reports whether every replica has loaded the key with the
+// given SPI, and at least one is running. Replicas which haven't loaded it
+// are asked to reload, though the kubelet may not have synced the keyring
+// into their pod yet. Keys kept in Vault are pushed to them first.
+func (r *HomeAgentReconciler) keyDistributed(ctx context.Context, agent *prairiev1.HomeAgent, spi int64, keyring map[string][]byte) (bool, error) {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return false, err
+	}
+
+	distributed := true
+	loaded := 0
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			distributed = false
+			continue
+		}
+
+		stats, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Stats(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Replica stats could not be read.", "pod", pod.Name)
+			distributed = false
+			continue
+		}
+		if hasSPI(stats.SPIs, spi) {
+			loaded++
+			continue
+		}
+		distributed = false
+		if agent.Spec.Security.Vault != nil {
+			if err := daemonFor(r.Daemon, r.SecureDaemon, &pod).PushKeyring(ctx, pod.Status.PodIP, keyring); err != nil {
+				log.FromContext(ctx).Error(err, "Keyring could not be pushed.", "pod", pod.Name)
+				continue
+			}
+		}
+		if err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Reload(ctx, pod.Status.PodIP); err != nil {
+			log.FromContext(ctx).Error(err, "Replica could not be reloaded.", "pod", pod.Name)
+		}
+	}
+	// Without replicas nobody has the key yet, the old one stays active
+	return distributed && loaded > 0, nil
+}
+
+func hasSPI(spis []int64, spi int64) bool {
+	for _, loaded := range spis {
+		if loaded == spi {
+			return true
+		}
+	}
+	return false
+}
+
+// keyringTemplate mounts the keyring Secret into the pod template. New keys
+// are loaded by reloading the replicas, they are not restarted.
+func keyringTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if !keysManaged(agent) || agent.Spec.Security.Vault != nil || agent.Status.Keys == nil {
+		return
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: keyringVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: keyringName(agent)},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      keyringVolume,
+			MountPath: keyringMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The Ready, Reconciling and Stalled conditions and the observed generation
+// follow the kstatus conventions, which Flux, Argo CD and kubectl wait
+// understand: a HomeAgent is current once its observed generation caught
+// up and it's neither reconciling nor stalled.
+
+// setReadiness reports the HomeAgent in the kstatus conditions at the end
+// of a reconcile, from the state of its workload.
+func setReadiness(agent *prairiev1.HomeAgent, workload client.Object, running int) {
+	desired := replicaCount(agent)
+	if degraded := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionDegraded); degraded != nil && degraded.Status == metav1.ConditionTrue {
+		markStalled(agent, degraded.Reason, degraded.Message)
+		return
+	}
+	if rollout := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionRolloutProgressing); rollout != nil &&
+		rollout.Status == metav1.ConditionFalse && rollout.Reason == "ProgressDeadlineExceeded" {
+		markStalled(agent, rollout.Reason, rollout.Message)
+		return
+	}
+
+	agent.Status.ObservedGeneration = agent.Generation
+	meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionStalled)
+	reason, message := "", ""
+	_, _, rolling := rolloutProgress(workload)
+	switch {
+	case meta.IsStatusConditionTrue(agent.Status.Conditions, prairiev1.ConditionDraining):
+		reason, message = prairiev1.ReasonDraining, "Replicas are drained for a scale-down"
+	case rolling:
+		reason, message = prairiev1.ReasonRollingOut, "Replicas are replaced with the new template"
+	case readyReplicas(workload) < desired || int32(running) < desired:
+		reason, message = prairiev1.ReasonProgressing, fmt.Sprintf("%d of %d replicas ready", readyReplicas(workload), desired)
+	}
+	if reason != "" {
+		setGenerationCondition(agent, prairiev1.ConditionReconciling, metav1.ConditionTrue, reason, message)
+		setGenerationCondition(agent, prairiev1.ConditionReady, metav1.ConditionFalse, reason, message)
+		recordRevision(agent, prairiev1.RevisionProgressing, "")
+		return
+	}
+	meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionReconciling)
+	setGenerationCondition(agent, prairiev1.ConditionReady, metav1.ConditionTrue, prairiev1.ReasonSucceeded,
+		fmt.Sprintf("%d replicas ready", desired))
+	recordRevision(agent, prairiev1.RevisionSucceeded, "")
+}
+
+// markStalled reports a HomeAgent which can't make progress without
+// intervention, e.g. one the operator leaves alone until its spec is fixed.
+func markStalled(agent *prairiev1.HomeAgent, reason, message string) {
+	agent.Status.ObservedGeneration = agent.Generation
+	meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionReconciling)
+	setGenerationCondition(agent, prairiev1.ConditionStalled, metav1.ConditionTrue, reason, message)
+	setGenerationCondition(agent, prairiev1.ConditionReady, metav1.ConditionFalse, reason, message)
+	recordRevision(agent, prairiev1.RevisionFailed, reason+": "+message)
+}
+
+func setGenerationCondition(agent *prairiev1.HomeAgent, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: agent.Generation,
+	})
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// FieldManager is the field manager of the changes the operator makes. The
+// client of the controllers sets it on every write, and it's the user
+// agent as well, which the API server derives the manager of other writes
+// from, so it doesn't change with the name of the binary.
+const FieldManager = "prairie-operator"
+
+// The well-known labels of the objects the operator creates for a HomeAgent.
+// app.kubernetes.io/instance is left out on purpose: Argo CD tracks its
+// applications with it by default and would take the objects for its own.
+const (
+	nameLabel      = "app.kubernetes.io/name"
+	componentLabel = "app.kubernetes.io/component"
+	partOfLabel    = "app.kubernetes.io/part-of"
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// The keys of the common labels and annotations last put on an object
+const (
+	commonLabelsAnnotation      = "prairie.kismi/common-labels"
+	commonAnnotationsAnnotation = "prairie.kismi/common-annotations"
+)
+
+// Components of a HomeAgent in the app.kubernetes.io/component label
+const (
+	componentWorkload         = "home-agent"
+	componentDiscovery        = "discovery"
+	componentDisruptionBudget = "disruption-budget"
+	componentRBAC             = "rbac"
+	componentConfig           = "config"
+	componentKeys             = "keys"
+	componentSync             = "sync"
+	componentBindingPolicy    = "binding-policy"
+	componentBindingCache     = "binding-cache"
+	componentBGP              = "bgp"
+	componentLogging          = "logging"
+	componentCorrespondents   = "correspondents"
+	componentSubscribers      = "subscribers"
+	componentBackup           = "backup"
+	componentHook             = "hook"
+)
+
+// operatorLabels returns the labels of the objects the operator creates
+// which belong to no HomeAgent in particular.
+func operatorLabels() map[string]string {
+	return map[string]string{
+		partOfLabel:    "prairie",
+		managedByLabel: FieldManager,
+	}
+}
+
+// componentLabels returns the labels of the component of the HomeAgent,
+// along with its common labels. The parent label keeps selecting its
+// objects, the selectors of its workload can't change.
+func componentLabels(agent *prairiev1.HomeAgent, component string) map[string]string {
+	labels := commonLabels(agent)
+	for key, value := range operatorLabels() {
+		labels[key] = value
+	}
+	labels["parent"] = agent.Name
+	labels[nameLabel] = "homeagent"
+	labels[componentLabel] = component
+	return labels
+}
+
+// templateLabels returns the labels of the pod template of the HomeAgent.
+// The well-known labels are left out, adding them to the workloads of
+// older releases would roll out every HomeAgent.
+func templateLabels(agent *prairiev1.HomeAgent) map[string]string {
+	labels := commonLabels(agent)
+	labels["parent"] = agent.Name
+	return labels
+}
+
+// commonLabels renders spec.commonLabels, without the ones which are
+// invalid, checkCommonMetadata reports those.
+func commonLabels(agent *prairiev1.HomeAgent) map[string]string {
+	labels, _ := renderCommon(agent, agent.Spec.CommonLabels, true)
+	return labels
+}
+
+// commonAnnotations renders spec.commonAnnotations. The keys of both the
+// common labels and annotations are recorded as well, so setMetadata can
+// remove the ones dropped from the spec later on.
+func commonAnnotations(agent *prairiev1.HomeAgent) map[string]string {
+	annotations, _ := renderCommon(agent, agent.Spec.CommonAnnotations, false)
+	if keys := sortedKeys(annotations); len(keys) > 0 {
+		annotations[commonAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+	if keys := sortedKeys(commonLabels(agent)); len(keys) > 0 {
+		annotations[commonLabelsAnnotation] = strings.Join(keys, ",")
+	}
+	return annotations
+}
+
+// checkCommonMetadata reports whether the common labels and annotations of
+// the HomeAgent are valid, and marks it degraded otherwise.
+func (r *HomeAgentReconciler) checkCommonMetadata(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateCommonMetadata(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidCommonMetadata", err.Error())
+	}
+	return true, nil
+}
+
+// validateCommonMetadata validates the common labels and annotations of
+// the HomeAgent.
+func validateCommonMetadata(agent *prairiev1.HomeAgent) error {
+	if _, err := renderCommon(agent, agent.Spec.CommonLabels, true); err != nil {
+		return fmt.Errorf("commonLabels: %w", err)
+	}
+	if _, err := renderCommon(agent, agent.Spec.CommonAnnotations, false); err != nil {
+		return fmt.Errorf("commonAnnotations: %w", err)
+	}
+	return nil
+}
+
+// renderCommon renders the values of common labels or annotations, leaving
+// out those which are invalid or owned by the operator. The first error is
+// returned.
+func renderCommon(agent *prairiev1.HomeAgent, values map[string]string, labels bool) (map[string]string, error) {
+	data := struct{ Name, Namespace string }{agent.Name, agent.Namespace}
+	rendered := map[string]string{}
+	var first error
+	fail := func(err error) {
+		if first == nil {
+			first = err
+		}
+	}
+	for _, key := range sortedKeys(values) {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			fail(fmt.Errorf("%s: %s", key, strings.Join(errs, ", ")))
+			continue
+		}
+		if ownedKey(key) {
+			fail(fmt.Errorf("%s is set by the operator", key))
+			continue
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(values[key])
+		if err != nil {
+			fail(err)
+			continue
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			fail(err)
+			continue
+		}
+		if labels {
+			if errs := validation.IsValidLabelValue(value.String()); len(errs) > 0 {
+				fail(fmt.Errorf("%s: %s", key, strings.Join(errs, ", ")))
+				continue
+			}
+		}
+		rendered[key] = value.String()
+	}
+	return rendered, first
+}
+
+// setMetadata puts the labels and annotations on obj and returns whether
+// that changed it. Labels and annotations others put on it are kept, so
+// the operator and e.g. a GitOps tool don't fight over them, only common
+// ones dropped from the spec are removed.
+func setMetadata(obj metav1.Object, labels, annotations map[string]string) bool {
+	current_labels := obj.GetLabels()
+	current_annotations := obj.GetAnnotations()
+	changed := false
+	for _, key := range strings.Split(current_annotations[commonLabelsAnnotation], ",") {
+		if _, ok := current_labels[key]; ok {
+			if _, keep := labels[key]; !keep {
+				delete(current_labels, key)
+				changed = true
+			}
+		}
+	}
+	dropped := strings.Split(current_annotations[commonAnnotationsAnnotation], ",")
+	dropped = append(dropped, commonLabelsAnnotation, commonAnnotationsAnnotation)
+	for _, key := range dropped {
+		if _, ok := current_annotations[key]; ok {
+			if _, keep := annotations[key]; !keep {
+				delete(current_annotations, key)
+				changed = true
+			}
+		}
+	}
+
+	if current_labels == nil && len(labels) > 0 {
+		current_labels = map[string]string{}
+	}
+	for key, value := range labels {
+		if current, ok := current_labels[key]; !ok || current != value {
+			current_labels[key] = value
+			changed = true
+		}
+	}
+	if current_annotations == nil && len(annotations) > 0 {
+		current_annotations = map[string]string{}
+	}
+	for key, value := range annotations {
+		if current, ok := current_annotations[key]; !ok || current != value {
+			current_annotations[key] = value
+			changed = true
+		}
+	}
+	obj.SetLabels(current_labels)
+	obj.SetAnnotations(current_annotations)
+	return changed
+}
+
+// metadataChanged reports whether setMetadata would change obj, e.g. as it
+// was created by an older release.
+func metadataChanged(obj metav1.Object, labels, annotations map[string]string) bool {
+	meta := &metav1.ObjectMeta{Labels: map[string]string{}, Annotations: map[string]string{}}
+	for key, value := range obj.GetLabels() {
+		meta.Labels[key] = value
+	}
+	for key, value := range obj.GetAnnotations() {
+		meta.Annotations[key] = value
+	}
+	return setMetadata(meta, labels, annotations)
+}
+
+// ownedKey reports whether the operator sets the label or annotation key on
+// pod templates.
+func ownedKey(key string) bool {
+	return key == "parent" || strings.HasPrefix(key, prairiev1.GroupVersion.Group+"/") ||
+		strings.HasPrefix(key, appArmorAnnotation) || strings.HasPrefix(key, "app.kubernetes.io/")
+}
+
+// keepForeignMetadata copies the labels and annotations others put on the
+// current pod template into the desired one, e.g. the restartedAt
+// annotation of kubectl rollout restart, which a rewrite of the template
+// would revert otherwise. Common ones dropped from the spec aren't kept.
+func keepForeignMetadata(desired, current *corev1.PodTemplateSpec) {
+	common := map[string]bool{}
+	for _, key := range strings.Split(current.Annotations[commonLabelsAnnotation], ",") {
+		common["label:"+key] = true
+	}
+	for _, key := range strings.Split(current.Annotations[commonAnnotationsAnnotation], ",") {
+		common["annotation:"+key] = true
+	}
+	for key, value := range current.Labels {
+		if _, ok := desired.Labels[key]; !ok && !ownedKey(key) && !common["label:"+key] {
+			if desired.Labels == nil {
+				desired.Labels = map[string]string{}
+			}
+			desired.Labels[key] = value
+		}
+	}
+	for key, value := range current.Annotations {
+		if _, ok := desired.Annotations[key]; !ok && !ownedKey(key) && !common["annotation:"+key] {
+			if desired.Annotations == nil {
+				desired.Annotations = map[string]string{}
+			}
+			desired.Annotations[key] = value
+		}
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// In PMIPv6-LMA mode mo-daemon serves Proxy Mobile IPv6 as Local Mobility
+// Anchor: the Mobile Access Gateways register the mobile nodes attached to
+// them, the mobile nodes themselves don't take part in the signalling.
+
+func lmaMode(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Mode == prairiev1.ModeLMA
+}
+
+// modeSettings configures the mobility protocol of mo-daemon. Nothing is
+// rendered for MIPv6-HA, the default of every release.
+func modeSettings(agent *prairiev1.HomeAgent) []setting {
+	if !lmaMode(agent) || agent.Spec.LMA == nil {
+		return nil
+	}
+	settings := []setting{
+		{Key: "mode", Value: "lma"},
+		{Key: "lma_address", Value: agent.Spec.LMA.Address},
+	}
+	if len(agent.Spec.LMA.MAGs) > 0 {
+		peers := make([]string, 0, len(agent.Spec.LMA.MAGs))
+		for _, mag := range agent.Spec.LMA.MAGs {
+			peers = append(peers, mag.Name+"="+mag.Address)
+		}
+		settings = append(settings, setting{Key: "lma_mag_peers", Value: strings.Join(peers, ",")})
+	}
+	return settings
+}
+
+// validateLMA checks the LMA address and the gateways of an agent in
+// PMIPv6-LMA mode.
+func validateLMA(lma *prairiev1.LMASpec) error {
+	if lma == nil {
+		return fmt.Errorf("the %s mode requires spec.lma", prairiev1.ModeLMA)
+	}
+	if ip := net.ParseIP(lma.Address); ip == nil || ip.To4() != nil {
+		return fmt.Errorf("LMA address %q is not an IPv6 address", lma.Address)
+	}
+	names := map[string]bool{}
+	for _, mag := range lma.MAGs {
+		if names[mag.Name] {
+			return fmt.Errorf("MAG %s is listed twice", mag.Name)
+		}
+		names[mag.Name] = true
+		if net.ParseIP(mag.Address) == nil {
+			return fmt.Errorf("address %q of MAG %s is not an IP address", mag.Address, mag.Name)
+		}
+	}
+	return nil
+}
+
+// checkMode makes sure an agent in PMIPv6-LMA mode is configured for it
+// and runs a release supporting it. The release is taken from spec.version
+// or looked up by the image in the release catalog, images which aren't in
+// the catalog are trusted.
+func (r *HomeAgentReconciler) checkMode(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if !lmaMode(rendered) {
+		return true, nil
+	}
+	if err := validateLMA(rendered.Spec.LMA); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidLMAConfig", err.Error())
+	}
+
+	version := rendered.Spec.Version
+	if version == "" {
+		version = r.releases().versionOf(agentImage(rendered))
+	}
+	if unsupported := unsupportedSettings(version, modeSettings(rendered)); len(unsupported) > 0 {
+		message := fmt.Sprintf("Image %s of release %s doesn't support the %s mode",
+			agentImage(rendered), version, prairiev1.ModeLMA)
+		return false, r.markDegraded(ctx, agent, "UnsupportedMode", message)
+	}
+	return true, nil
+}
+
+// addGatewayPeers adds the nodes of the MobileAccessGateways registering
+// with the agent to its MAG peers, named "<gateway>/<node>". Gateways
+// listed in spec.lma take precedence.
+func (r *HomeAgentReconciler) addGatewayPeers(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	if !lmaMode(agent) || agent.Spec.LMA == nil {
+		return nil
+	}
+	gateways := &prairiev1.MobileAccessGatewayList{}
+	err := r.List(ctx, gateways, client.InNamespace(agent.Namespace))
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, mag := range agent.Spec.LMA.MAGs {
+		listed[mag.Name] = true
+	}
+	for _, gateway := range gateways.Items {
+		if gateway.Spec.HomeAgentRef.Name != agent.Name || !gateway.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, node := range gateway.Status.Nodes {
+			name := gateway.Name + "/" + node.Node
+			if listed[name] {
+				continue
+			}
+			agent.Spec.LMA.MAGs = append(agent.Spec.LMA.MAGs, prairiev1.MAGPeer{Name: name, Address: node.Address})
+		}
+	}
+	return nil
+}
+
+// agentOfGateway maps a MobileAccessGateway to the HomeAgent it registers
+// with, whose MAG peers follow the nodes of the gateway.
+func (r *HomeAgentReconciler) agentOfGateway(obj client.Object) []reconcile.Request {
+	gateway := obj.(*prairiev1.MobileAccessGateway)
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      gateway.Spec.HomeAgentRef.Name,
+		Namespace: gateway.Namespace,
+	}}}
+}
@@ -0,0 +1,451 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// With spec.logging mo-daemon writes its log into a volume shared with a
+// fluent-bit or vector sidecar, which tails it and ships the records to the
+// configured endpoint. mo-daemon keeps logging to stdout as well, and
+// rotates the file; the volume is limited to the rotated files, so a stuck
+// shipper can't fill the node. The configuration of the sidecar is rendered
+// into a ConfigMap of its own; its hash is stamped on the pod template, as
+// neither shipper reloads it.
+const (
+	logShipperContainer    = "log-shipper"
+	logVolume              = "logs"
+	logMountPath           = "/var/log/mo-daemon"
+	logFile                = "mo-daemon.log"
+	logShipperStateVolume  = "log-shipper-state"
+	logShipperStatePath    = "/var/lib/log-shipper"
+	loggingConfigVolume    = "log-shipper-config"
+	loggingConfigMountPath = "/etc/log-shipper"
+	loggingHashAnnotation  = "prairie.kismi/logging-hash"
+	fluentBitConfigFile    = "fluent-bit.conf"
+	vectorConfigFile       = "vector.yaml"
+	defaultFluentBitImage  = "fluent/fluent-bit:2.1.8"
+	defaultVectorImage     = "timberio/vector:0.31.0-distroless-libc"
+	defaultLogIndex        = "mo-daemon"
+	defaultLogMaxFiles     = 2
+	// logShipperStateSize limits the volume of the tail position of the
+	// shipper, the buffers of vector included.
+	logShipperStateSize = "64Mi"
+)
+
+var defaultLogMaxSize = resource.MustParse("10Mi")
+
+func loggingName(agent string) string {
+	return agent + "-logging"
+}
+
+func logShipper(spec *prairiev1.LoggingSpec) prairiev1.LogShipper {
+	if spec.Shipper == "" {
+		return prairiev1.LogShipperFluentBit
+	}
+	return spec.Shipper
+}
+
+func logShipperImage(spec *prairiev1.LoggingSpec) string {
+	switch {
+	case spec.Image != "":
+		return spec.Image
+	case logShipper(spec) == prairiev1.LogShipperVector:
+		return defaultVectorImage
+	default:
+		return defaultFluentBitImage
+	}
+}
+
+func logMaxSize(spec *prairiev1.LoggingSpec) resource.Quantity {
+	if spec.MaxSize == nil {
+		return defaultLogMaxSize
+	}
+	return *spec.MaxSize
+}
+
+func logMaxFiles(spec *prairiev1.LoggingSpec) int64 {
+	if spec.MaxFiles == nil {
+		return defaultLogMaxFiles
+	}
+	return int64(*spec.MaxFiles)
+}
+
+// logVolumeSize is the size of the log volume: the log file, the rotated
+// ones and one more file of slack, as rotation happens after the write
+// crossing the size.
+func logVolumeSize(spec *prairiev1.LoggingSpec) *resource.Quantity {
+	size := logMaxSize(spec)
+	return resource.NewQuantity(size.Value()*(logMaxFiles(spec)+2), resource.BinarySI)
+}
+
+// loggingSettings points the log of mo-daemon at the shared volume, next
+// to stdout, and rotates it.
+func loggingSettings(agent *prairiev1.HomeAgent) []setting {
+	spec := agent.Spec.Logging
+	if spec == nil {
+		return nil
+	}
+	size := logMaxSize(spec)
+	return []setting{
+		{Key: "log_file", Value: path.Join(logMountPath, logFile)},
+		{Key: "log_file_max_size", Value: strconv.FormatInt(size.Value(), 10)},
+		{Key: "log_file_max_files", Value: strconv.FormatInt(logMaxFiles(spec), 10)},
+		{Key: "log_stdout", Value: "on"},
+	}
+}
+
+// logEndpoint is the parsed URL of a log output.
+type logEndpoint struct {
+	host string
+	port string
+	path string
+	tls  bool
+}
+
+func parseLogEndpoint(raw string) (logEndpoint, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return logEndpoint{}, fmt.Errorf("log output %q is not an http or https URL", raw)
+	}
+	endpoint := logEndpoint{host: parsed.Hostname(), port: parsed.Port(), path: parsed.Path, tls: parsed.Scheme == "https"}
+	if endpoint.port == "" {
+		endpoint.port = "80"
+		if endpoint.tls {
+			endpoint.port = "443"
+		}
+	}
+	return endpoint, nil
+}
+
+// logRecordFields returns the fields added to every record, the origin of
+// the log and the labels of the output. The pod name is expanded by the
+// shipper from its environment.
+func logRecordFields(agent *prairiev1.HomeAgent) map[string]string {
+	fields := map[string]string{
+		"namespace": agent.Namespace,
+		"homeagent": agent.Name,
+		"pod":       "${POD_NAME}",
+	}
+	for key, value := range agent.Spec.Logging.Output.Labels {
+		fields[key] = value
+	}
+	return fields
+}
+
+func logIndex(output prairiev1.LogOutput) string {
+	if output.Index == "" {
+		return defaultLogIndex
+	}
+	return output.Index
+}
+
+// renderFluentBit renders the configuration of fluent-bit.
+func renderFluentBit(agent *prairiev1.HomeAgent, endpoint logEndpoint) string {
+	output := agent.Spec.Logging.Output
+	fields := logRecordFields(agent)
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "# Rendered from HomeAgent %s/%s, do not edit.\n", agent.Namespace, agent.Name)
+	fmt.Fprintf(&config, "[SERVICE]\n    flush 5\n    log_level warn\n\n")
+	fmt.Fprintf(&config, "[INPUT]\n    name tail\n    path %s\n    tag mo-daemon\n    db %s\n    rotate_wait 10\n\n",
+		path.Join(logMountPath, logFile), path.Join(logShipperStatePath, "tail.db"))
+	fmt.Fprintf(&config, "[FILTER]\n    name record_modifier\n    match *\n")
+	for _, key := range sortedKeys(fields) {
+		fmt.Fprintf(&config, "    record %s %s\n", key, fields[key])
+	}
+
+	fmt.Fprintf(&config, "\n[OUTPUT]\n")
+	switch output.Type {
+	case prairiev1.LogOutputLoki:
+		labels := []string{"job=mo-daemon"}
+		for _, key := range sortedKeys(fields) {
+			labels = append(labels, key+"="+fields[key])
+		}
+		fmt.Fprintf(&config, "    name loki\n    labels %s\n", strings.Join(labels, ","))
+		if endpoint.path != "" {
+			fmt.Fprintf(&config, "    uri %s\n", endpoint.path)
+		}
+	case prairiev1.LogOutputElasticsearch:
+		fmt.Fprintf(&config, "    name es\n    index %s\n    suppress_type_name on\n", logIndex(output))
+		if endpoint.path != "" {
+			fmt.Fprintf(&config, "    path %s\n", endpoint.path)
+		}
+	default:
+		fmt.Fprintf(&config, "    name http\n    format json\n")
+		if endpoint.path != "" {
+			fmt.Fprintf(&config, "    uri %s\n", endpoint.path)
+		}
+	}
+	fmt.Fprintf(&config, "    match *\n    host %s\n    port %s\n", endpoint.host, endpoint.port)
+	if endpoint.tls {
+		fmt.Fprintf(&config, "    tls on\n")
+	}
+	if output.CredentialsSecretRef != nil {
+		fmt.Fprintf(&config, "    http_user ${LOG_USERNAME}\n    http_passwd ${LOG_PASSWORD}\n")
+	}
+	return config.String()
+}
+
+// renderVector renders the configuration of vector.
+func renderVector(agent *prairiev1.HomeAgent, endpoint logEndpoint) string {
+	output := agent.Spec.Logging.Output
+	fields := logRecordFields(agent)
+
+	remap := []string{}
+	for _, key := range sortedKeys(fields) {
+		remap = append(remap, fmt.Sprintf(".%s = %q", key, fields[key]))
+	}
+
+	sink := map[string]interface{}{
+		"inputs":   []string{"metadata"},
+		"encoding": map[string]interface{}{"codec": "json"},
+	}
+	switch output.Type {
+	case prairiev1.LogOutputLoki:
+		labels := map[string]string{"job": "mo-daemon"}
+		for key, value := range fields {
+			labels[key] = value
+		}
+		sink["type"] = "loki"
+		sink["endpoint"] = (&url.URL{Scheme: endpointScheme(endpoint), Host: endpoint.host + ":" + endpoint.port}).String()
+		sink["labels"] = labels
+		if endpoint.path != "" {
+			sink["path"] = endpoint.path
+		}
+	case prairiev1.LogOutputElasticsearch:
+		delete(sink, "encoding")
+		sink["type"] = "elasticsearch"
+		sink["endpoints"] = []string{output.URL}
+		sink["bulk"] = map[string]interface{}{"index": logIndex(output)}
+	default:
+		sink["type"] = "http"
+		sink["uri"] = output.URL
+	}
+	if output.CredentialsSecretRef != nil {
+		sink["auth"] = map[string]interface{}{
+			"strategy": "basic",
+			"user":     "${LOG_USERNAME}",
+			"password": "${LOG_PASSWORD}",
+		}
+	}
+
+	config := map[string]interface{}{
+		"data_dir": logShipperStatePath,
+		"sources": map[string]interface{}{
+			"mo_daemon": map[string]interface{}{
+				"type":    "file",
+				"include": []string{path.Join(logMountPath, logFile)},
+			},
+		},
+		"transforms": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"type":   "remap",
+				"inputs": []string{"mo_daemon"},
+				"source": strings.Join(remap, "\n"),
+			},
+		},
+		"sinks": map[string]interface{}{"output": sink},
+	}
+	// Maps of strings, slices and maps always marshal
+	content, _ := yaml.Marshal(config)
+	return fmt.Sprintf("# Rendered from HomeAgent %s/%s, do not edit.\n%s", agent.Namespace, agent.Name, content)
+}
+
+func endpointScheme(endpoint logEndpoint) string {
+	if endpoint.tls {
+		return "https"
+	}
+	return "http"
+}
+
+// renderLoggingConfig renders the configuration file of the shipper of the
+// agent, named after the shipper.
+func renderLoggingConfig(agent *prairiev1.HomeAgent) (file string, config string) {
+	// Invalid outputs are caught by checkLogging before anything is rendered
+	endpoint, _ := parseLogEndpoint(agent.Spec.Logging.Output.URL)
+	if logShipper(agent.Spec.Logging) == prairiev1.LogShipperVector {
+		return vectorConfigFile, renderVector(agent, endpoint)
+	}
+	return fluentBitConfigFile, renderFluentBit(agent, endpoint)
+}
+
+// reconcileLoggingConfig writes the configuration of the shipper into the
+// logging ConfigMap of the agent, and deletes it once logging is switched
+// off.
+func (r *HomeAgentReconciler) reconcileLoggingConfig(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) error {
+	config_map := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: loggingName(agent.Name), Namespace: agent.Namespace}, config_map)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if rendered.Spec.Logging == nil {
+		if exists && metav1.IsControlledBy(config_map, agent) {
+			log.FromContext(ctx).Info("Log shipper configuration no longer used, deleting it.")
+			return client.IgnoreNotFound(r.Delete(ctx, config_map))
+		}
+		return nil
+	}
+
+	file, config := renderLoggingConfig(rendered)
+	data := map[string]string{file: config}
+	if !exists {
+		config_map = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        loggingName(agent.Name),
+				Namespace:   agent.Namespace,
+				Labels:      componentLabels(agent, componentLogging),
+				Annotations: commonAnnotations(agent),
+			},
+			Data: data,
+		}
+		if err := ctrl.SetControllerReference(agent, config_map, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Log shipper configuration created.", "hash", configHash(config))
+		return r.Create(ctx, config_map)
+	}
+
+	if len(config_map.Data) == 1 && config_map.Data[file] == config &&
+		!metadataChanged(config_map, componentLabels(agent, componentLogging), commonAnnotations(agent)) {
+		return nil
+	}
+	config_map.Data = data
+	setMetadata(config_map, componentLabels(agent, componentLogging), commonAnnotations(agent))
+	if err := r.Update(ctx, config_map); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Log shipper configuration updated.", "hash", configHash(config))
+	return nil
+}
+
+// loggingTemplate shares the log volume between mo-daemon and the shipper
+// sidecar it adds. It runs last, the other templates only set up the agent
+// container.
+func loggingTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	spec := agent.Spec.Logging
+	if spec == nil {
+		return
+	}
+	file, config := renderLoggingConfig(agent)
+	state_size := resource.MustParse(logShipperStateSize)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[loggingHashAnnotation] = configHash(config)
+
+	template.Spec.Volumes = append(template.Spec.Volumes,
+		corev1.Volume{Name: logVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+			SizeLimit: logVolumeSize(spec),
+		}}},
+		corev1.Volume{Name: logShipperStateVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+			SizeLimit: &state_size,
+		}}},
+		corev1.Volume{
+			Name: loggingConfigVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: loggingName(agent.Name)},
+				},
+			},
+		})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      logVolume,
+			MountPath: logMountPath,
+		})
+	}
+
+	args := []string{"--config=" + path.Join(loggingConfigMountPath, file)}
+	if logShipper(spec) == prairiev1.LogShipperVector {
+		args = []string{"--config", path.Join(loggingConfigMountPath, file)}
+	}
+	env := []corev1.EnvVar{{
+		Name:      "POD_NAME",
+		ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+	}}
+	if ref := spec.Output.CredentialsSecretRef; ref != nil {
+		env = append(env,
+			corev1.EnvVar{Name: "LOG_USERNAME", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: *ref, Key: "username"},
+			}},
+			corev1.EnvVar{Name: "LOG_PASSWORD", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: *ref, Key: "password"},
+			}})
+	}
+
+	noEscalation := false
+	readOnly := true
+	sidecar := corev1.Container{
+		Name:  logShipperContainer,
+		Image: logShipperImage(spec),
+		Args:  args,
+		Env:   env,
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: logVolume, MountPath: logMountPath, ReadOnly: true},
+			{Name: logShipperStateVolume, MountPath: logShipperStatePath},
+			{Name: loggingConfigVolume, MountPath: loggingConfigMountPath, ReadOnly: true},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &noEscalation,
+			ReadOnlyRootFilesystem:   &readOnly,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	}
+	if spec.Resources != nil {
+		sidecar.Resources = *spec.Resources.DeepCopy()
+	}
+	template.Spec.Containers = append(template.Spec.Containers, sidecar)
+}
+
+// validateLogging checks the output of the shipper.
+func validateLogging(agent *prairiev1.HomeAgent) error {
+	if agent.Spec.Logging == nil {
+		return nil
+	}
+	_, err := parseLogEndpoint(agent.Spec.Logging.Output.URL)
+	return err
+}
+
+// checkLogging marks the agent degraded if its log output is invalid, the
+// shipper would fail to start with it.
+func (r *HomeAgentReconciler) checkLogging(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateLogging(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidLogging", err.Error())
+	}
+	return true, nil
+}
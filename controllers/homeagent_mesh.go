@@ -0,0 +1,162 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The sidecars of Istio and Linkerd redirect the traffic of the pod through
+// their proxy, which breaks the raw tunnels and the UDP registrations of
+// mo-daemon. In Exclude mode the agent pods opt out of the injection. In
+// Bypass mode the sidecar is injected, but the ports of the agent and the
+// prefixes of the mobile nodes are excluded from its redirection. The
+// annotations of both meshes are set, the other one ignores them.
+const (
+	istioInjectLabel             = "sidecar.istio.io/inject"
+	istioExcludeInboundPorts     = "traffic.sidecar.istio.io/excludeInboundPorts"
+	istioExcludeOutboundPorts    = "traffic.sidecar.istio.io/excludeOutboundPorts"
+	istioExcludeOutboundIPRanges = "traffic.sidecar.istio.io/excludeOutboundIPRanges"
+	linkerdInjectAnnotation      = "linkerd.io/inject"
+	linkerdSkipInboundPorts      = "config.linkerd.io/skip-inbound-ports"
+	linkerdSkipOutboundPorts     = "config.linkerd.io/skip-outbound-ports"
+	linkerdSkipSubnets           = "config.linkerd.io/skip-subnets"
+)
+
+func serviceMeshMode(agent *prairiev1.HomeAgent) prairiev1.ServiceMeshMode {
+	if agent.Spec.ServiceMesh.Mode == "" {
+		return prairiev1.ServiceMeshExclude
+	}
+	return agent.Spec.ServiceMesh.Mode
+}
+
+// joinPorts renders the ports as a sorted comma separated list.
+func joinPorts(ports map[int32]bool) string {
+	sorted := make([]int, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, int(port))
+	}
+	sort.Ints(sorted)
+	rendered := make([]string, len(sorted))
+	for idx, port := range sorted {
+		rendered[idx] = strconv.Itoa(port)
+	}
+	return strings.Join(rendered, ",")
+}
+
+// meshOutboundPorts returns the ports the agent connects to: the
+// replication port of its peers and the ports of its AAA backends.
+func meshOutboundPorts(agent *prairiev1.HomeAgent) map[int32]bool {
+	ports := map[int32]bool{}
+	if syncEnabled(agent) {
+		ports[syncPort(agent)] = true
+	}
+	if radius := radiusSpec(agent); radius != nil {
+		for _, server := range radius.Servers {
+			port := server.AuthPort
+			if port == 0 {
+				port = defaultRADIUSPort
+			}
+			ports[port] = true
+			if server.AccountingPort != 0 {
+				ports[server.AccountingPort] = true
+			}
+		}
+	}
+	if diameter := diameterSpec(agent); diameter != nil {
+		for _, peer := range diameter.Peers {
+			port := peer.Port
+			if port == 0 {
+				port = defaultDiameterPort
+			}
+			ports[port] = true
+		}
+	}
+	return ports
+}
+
+// meshTemplate sets the injection and redirection annotations of the
+// service meshes on the pod template. It runs after the templates adding
+// container ports, every declared port bypasses the sidecar.
+func meshTemplate(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork, template *corev1.PodTemplateSpec) {
+	if agent.Spec.ServiceMesh == nil {
+		return
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+
+	if serviceMeshMode(agent) == prairiev1.ServiceMeshExclude {
+		if template.Labels == nil {
+			template.Labels = map[string]string{}
+		}
+		template.Labels[istioInjectLabel] = "false"
+		template.Annotations[linkerdInjectAnnotation] = "disabled"
+		return
+	}
+
+	inbound := map[int32]bool{}
+	for _, container := range template.Spec.Containers {
+		for _, port := range container.Ports {
+			inbound[port.ContainerPort] = true
+		}
+	}
+	if len(inbound) > 0 {
+		template.Annotations[istioExcludeInboundPorts] = joinPorts(inbound)
+		template.Annotations[linkerdSkipInboundPorts] = joinPorts(inbound)
+	}
+	if outbound := meshOutboundPorts(agent); len(outbound) > 0 {
+		template.Annotations[istioExcludeOutboundPorts] = joinPorts(outbound)
+		template.Annotations[linkerdSkipOutboundPorts] = joinPorts(outbound)
+	}
+	cidrs := append(advertisedPrefixes(agent, network), agent.Spec.ServiceMesh.ExcludeCIDRs...)
+	if len(cidrs) > 0 {
+		template.Annotations[istioExcludeOutboundIPRanges] = strings.Join(cidrs, ",")
+		template.Annotations[linkerdSkipSubnets] = strings.Join(cidrs, ",")
+	}
+}
+
+// validateServiceMesh checks the excluded destinations of the agent.
+func validateServiceMesh(agent *prairiev1.HomeAgent) error {
+	if agent.Spec.ServiceMesh == nil {
+		return nil
+	}
+	for _, cidr := range agent.Spec.ServiceMesh.ExcludeCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("excluded destination %q is not a CIDR", cidr)
+		}
+	}
+	return nil
+}
+
+// checkServiceMesh marks the agent degraded if its service mesh settings
+// are invalid, the sidecar would fail to start with them.
+func (r *HomeAgentReconciler) checkServiceMesh(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateServiceMesh(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidServiceMesh", err.Error())
+	}
+	return true, nil
+}
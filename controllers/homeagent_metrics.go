@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// homeAgentCollectTimeout bounds the listing of the HomeAgents on
+// collection.
+const homeAgentCollectTimeout = 5 * time.Second
+
+var (
+	agentLabels = []string{"namespace", "homeagent"}
+
+	homeAgentReplicasDesc = prometheus.NewDesc("prairie_homeagent_replicas",
+		"Replicas of the HomeAgent in its spec.", agentLabels, nil)
+	homeAgentReachableDesc = prometheus.NewDesc("prairie_homeagent_reachable_replicas",
+		"Replicas of the HomeAgent answering on their management API.", agentLabels, nil)
+	homeAgentBindingsDesc = prometheus.NewDesc("prairie_homeagent_bindings",
+		"Bindings held by a replica of the HomeAgent.", append(agentLabels, "replica"), nil)
+	homeAgentRegistrationsDesc = prometheus.NewDesc("prairie_homeagent_registrations_total",
+		"Registrations a replica of the HomeAgent accepted since it started.", append(agentLabels, "replica"), nil)
+	homeAgentSAsDesc = prometheus.NewDesc("prairie_homeagent_ipsec_sas",
+		"IPsec SAs of a replica of the HomeAgent, broken down by state.", append(agentLabels, "replica", "state"), nil)
+	homeAgentSyncLagDesc = prometheus.NewDesc("prairie_homeagent_sync_lag_seconds",
+		"How far a replica of the HomeAgent is behind the bindings of a peer.", append(agentLabels, "replica", "peer"), nil)
+	homeAgentConditionDesc = prometheus.NewDesc("prairie_homeagent_condition",
+		"Conditions of the HomeAgent, 1 if true.", append(agentLabels, "type"), nil)
+)
+
+// HomeAgentCollector reports the mobility metrics of every HomeAgent, as
+// last read from its replicas into the status.
+type HomeAgentCollector struct {
+	reader  client.Reader
+	elected <-chan struct{}
+}
+
+// NewHomeAgentCollector returns a collector of the HomeAgents read through
+// reader. They are only collected after elected is closed, the status of
+// the HomeAgents is only kept up to date by the leader.
+func NewHomeAgentCollector(reader client.Reader, elected <-chan struct{}) *HomeAgentCollector {
+	return &HomeAgentCollector{reader: reader, elected: elected}
+}
+
+func (c *HomeAgentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- homeAgentReplicasDesc
+	ch <- homeAgentReachableDesc
+	ch <- homeAgentBindingsDesc
+	ch <- homeAgentRegistrationsDesc
+	ch <- homeAgentSAsDesc
+	ch <- homeAgentSyncLagDesc
+	ch <- homeAgentConditionDesc
+}
+
+func (c *HomeAgentCollector) Collect(ch chan<- prometheus.Metric) {
+	select {
+	case <-c.elected:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), homeAgentCollectTimeout)
+	defer cancel()
+	agents := &prairiev1.HomeAgentList{}
+	if err := c.reader.List(ctx, agents); err != nil {
+		return
+	}
+
+	for _, agent := range agents.Items {
+		labels := []string{agent.Namespace, agent.Name}
+		gauge := func(desc *prometheus.Desc, value float64, extra ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append(labels, extra...)...)
+		}
+
+		gauge(homeAgentReplicasDesc, float64(replicaCount(&agent)))
+		reachable := 0
+		for _, replica := range agent.Status.Replicas {
+			if !replica.Reachable {
+				continue
+			}
+			reachable++
+			gauge(homeAgentBindingsDesc, float64(replica.Bindings), replica.Name)
+			ch <- prometheus.MustNewConstMetric(homeAgentRegistrationsDesc, prometheus.CounterValue,
+				float64(replica.Registrations), append(labels, replica.Name)...)
+			if replica.IPsec != nil {
+				gauge(homeAgentSAsDesc, float64(replica.IPsec.Established), replica.Name, "established")
+				gauge(homeAgentSAsDesc, float64(replica.IPsec.Connecting), replica.Name, "connecting")
+				gauge(homeAgentSAsDesc, float64(replica.IPsec.Failed), replica.Name, "failed")
+			}
+			for _, peer := range replica.Peers {
+				if peer.Lag != nil {
+					gauge(homeAgentSyncLagDesc, peer.Lag.Duration.Seconds(), replica.Name, peer.Name)
+				}
+			}
+		}
+		gauge(homeAgentReachableDesc, float64(reachable))
+
+		for _, condition := range agent.Status.Conditions {
+			value := 0.0
+			if condition.Status == metav1.ConditionTrue {
+				value = 1
+			}
+			gauge(homeAgentConditionDesc, value, condition.Type)
+		}
+	}
+}
+
+// readyTracker tracks since when each HomeAgent has been waiting to become
+// ready after a change of its spec.
+type readyTracker struct {
+	mu     sync.Mutex
+	agents map[types.NamespacedName]*specChange
+}
+
+type specChange struct {
+	generation int64
+	since      time.Time
+	observed   bool
+}
+
+// changed notes the generation of the agent. Agents which are ready when
+// first seen, e.g. after a restart of the operator, aren't observed.
+func (t *readyTracker) changed(agent *prairiev1.HomeAgent) {
+	name := types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.agents == nil {
+		t.agents = map[types.NamespacedName]*specChange{}
+	}
+	change, seen := t.agents[name]
+	if seen && change.generation == agent.Generation {
+		return
+	}
+	t.agents[name] = &specChange{
+		generation: agent.Generation,
+		since:      specChangeTime(agent),
+		observed:   !seen && agentPhase(agent) == phaseReady,
+	}
+}
+
+// ready returns how long the agent took to become ready since its spec
+// changed, once for every generation.
+func (t *readyTracker) ready(agent *prairiev1.HomeAgent) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := t.agents[types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}]
+	if change == nil || change.observed || change.generation != agent.Generation {
+		return 0, false
+	}
+	change.observed = true
+	return time.Since(change.since), true
+}
+
+func (t *readyTracker) reset(name types.NamespacedName) {
+	t.mu.Lock()
+	delete(t.agents, name)
+	t.mu.Unlock()
+}
+
+// specChangeTime returns when the spec of the agent was last written, as
+// recorded in its managed fields, or now if they don't tell.
+func specChangeTime(agent *prairiev1.HomeAgent) time.Time {
+	since := time.Time{}
+	for _, entry := range agent.ManagedFields {
+		if entry.Subresource != "" || entry.Time == nil || entry.FieldsV1 == nil {
+			continue
+		}
+		if bytes.Contains(entry.FieldsV1.Raw, []byte(`"f:spec"`)) && entry.Time.After(since) {
+			since = entry.Time.Time
+		}
+	}
+	if since.IsZero() {
+		return time.Now()
+	}
+	return since
+}
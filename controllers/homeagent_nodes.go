@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const defaultNodeFailureTolerationSeconds = 30
+
+// agentTolerations shortens the time replicas stay on unreachable or not
+// ready nodes before they are evicted and rescheduled.
+func agentTolerations(agent *prairiev1.HomeAgent) []corev1.Toleration {
+	if agent.Spec.NodeFailure == nil {
+		return nil
+	}
+	seconds := int64(defaultNodeFailureTolerationSeconds)
+	if agent.Spec.NodeFailure.TolerationSeconds != nil {
+		seconds = *agent.Spec.NodeFailure.TolerationSeconds
+	}
+
+	tolerations := []corev1.Toleration{}
+	for _, taint := range []string{corev1.TaintNodeUnreachable, corev1.TaintNodeNotReady} {
+		tolerations = append(tolerations, corev1.Toleration{
+			Key:               taint,
+			Operator:          corev1.TolerationOpExists,
+			Effect:            corev1.TaintEffectNoExecute,
+			TolerationSeconds: &seconds,
+		})
+	}
+	return tolerations
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// onFailedNode reports whether the pod runs on a node which isn't ready,
+// with node failure handling enabled. Pods of nodes which can't be read are
+// considered healthy, the pod conditions catch up with them.
+func (r *HomeAgentReconciler) onFailedNode(ctx context.Context, agent *prairiev1.HomeAgent, pod *corev1.Pod) bool {
+	if agent.Spec.NodeFailure == nil || pod.Spec.NodeName == "" {
+		return false
+	}
+	node := &corev1.Node{}
+	err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node)
+	if err != nil {
+		return false
+	}
+	return !nodeReady(node)
+}
+
+// agentsOnFailedNode maps a node which isn't ready to the ActiveStandby
+// agents whose active replica runs on it, so they fail over right away.
+func (r *HomeAgentReconciler) agentsOnFailedNode(obj client.Object) []reconcile.Request {
+	node, ok := obj.(*corev1.Node)
+	if !ok || nodeReady(node) {
+		return nil
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents)
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "node", node.Name)
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, agent := range agents.Items {
+		if agent.Spec.NodeFailure == nil || !activeStandby(&agent) || agent.Status.Active == "" {
+			continue
+		}
+		pod := &corev1.Pod{}
+		err := r.Get(context.Background(), types.NamespacedName{Name: agent.Status.Active, Namespace: agent.Namespace}, pod)
+		if err != nil || pod.Spec.NodeName != node.Name {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}})
+	}
+	return requests
+}
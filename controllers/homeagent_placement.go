@@ -0,0 +1,244 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// replicaCount returns the number of replicas the agent runs, which with
+// spec.zones is given per zone. An open window of spec.schedules overrides
+// the size.
+func replicaCount(agent *prairiev1.HomeAgent) int32 {
+	if agent.Spec.Zones == nil {
+		if size, ok := scheduledSize(agent); ok {
+			return size
+		}
+		return agent.Spec.Size
+	}
+	return int32(len(agent.Spec.Zones.Names)) * replicasPerZone(agent)
+}
+
+func replicasPerZone(agent *prairiev1.HomeAgent) int32 {
+	if size, ok := scheduledSize(agent); ok {
+		return size
+	}
+	return unscheduledSize(agent)
+}
+
+// unscheduledSize returns the size outside of the windows of
+// spec.schedules, per zone with spec.zones.
+func unscheduledSize(agent *prairiev1.HomeAgent) int32 {
+	if agent.Spec.Zones == nil {
+		return agent.Spec.Size
+	}
+	if agent.Spec.Zones.ReplicasPerZone != 0 {
+		return agent.Spec.Zones.ReplicasPerZone
+	}
+	return 1
+}
+
+func zoneTopologyKey(agent *prairiev1.HomeAgent) string {
+	if agent.Spec.Zones.TopologyKey != "" {
+		return agent.Spec.Zones.TopologyKey
+	}
+	return corev1.LabelTopologyZone
+}
+
+// agentAffinity pins the replicas to the zones of spec.zones.
+func agentAffinity(agent *prairiev1.HomeAgent) *corev1.Affinity {
+	if agent.Spec.Zones == nil {
+		return nil
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      zoneTopologyKey(agent),
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   agent.Spec.Zones.Names,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// agentTopologySpread returns the topology spread constraints of the
+// replicas. The defaults prefer spreading across nodes and zones but never
+// keep a replica from being scheduled. With spec.zones the replicas are
+// strictly balanced across the zones, so every zone gets its share.
+func agentTopologySpread(agent *prairiev1.HomeAgent) []corev1.TopologySpreadConstraint {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"parent": agent.Name},
+	}
+
+	if agent.Spec.Zones != nil {
+		constraints := []corev1.TopologySpreadConstraint{
+			{
+				MaxSkew:           1,
+				TopologyKey:       zoneTopologyKey(agent),
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector:     selector,
+			},
+		}
+		if len(agent.Spec.TopologySpread) == 0 {
+			return append(constraints, corev1.TopologySpreadConstraint{
+				MaxSkew:           1,
+				TopologyKey:       corev1.LabelHostname,
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+				LabelSelector:     selector,
+			})
+		}
+		return append(constraints, customTopologySpread(agent, selector)...)
+	}
+
+	if len(agent.Spec.TopologySpread) == 0 {
+		return []corev1.TopologySpreadConstraint{
+			{
+				MaxSkew:           1,
+				TopologyKey:       corev1.LabelHostname,
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+				LabelSelector:     selector,
+			},
+			{
+				MaxSkew:           1,
+				TopologyKey:       corev1.LabelTopologyZone,
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+				LabelSelector:     selector,
+			},
+		}
+	}
+
+	return customTopologySpread(agent, selector)
+}
+
+func customTopologySpread(agent *prairiev1.HomeAgent, selector *metav1.LabelSelector) []corev1.TopologySpreadConstraint {
+	constraints := make([]corev1.TopologySpreadConstraint, len(agent.Spec.TopologySpread))
+	for idx, constraint := range agent.Spec.TopologySpread {
+		constraint.DeepCopyInto(&constraints[idx])
+		if constraints[idx].LabelSelector == nil {
+			constraints[idx].LabelSelector = selector.DeepCopy()
+		}
+	}
+	return constraints
+}
+
+// reconcileZones reports the coverage of every zone of spec.zones in the
+// status and the ZonesCovered condition. Replicas are assigned to zones
+// through the labels of their nodes.
+func (r *HomeAgentReconciler) reconcileZones(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	if agent.Spec.Zones == nil {
+		if agent.Status.Zones != nil || meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionZonesCovered) != nil {
+			agent.Status.Zones = nil
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionZonesCovered)
+			return r.Status().Update(ctx, agent)
+		}
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return err
+	}
+
+	key := zoneTopologyKey(agent)
+	zones := map[string]*prairiev1.ZoneStatus{}
+	statuses := make([]prairiev1.ZoneStatus, len(agent.Spec.Zones.Names))
+	for idx, name := range agent.Spec.Zones.Names {
+		statuses[idx].Name = name
+		zones[name] = &statuses[idx]
+	}
+	nodes := map[string]string{}
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if !pod.DeletionTimestamp.IsZero() || pod.Spec.NodeName == "" {
+			continue
+		}
+		zone, known := nodes[pod.Spec.NodeName]
+		if !known {
+			node := &corev1.Node{}
+			err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node)
+			if errors.IsNotFound(err) {
+				// The pod of a removed node is on its way out, it counts for no zone
+				nodes[pod.Spec.NodeName] = ""
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			zone = node.Labels[key]
+			nodes[pod.Spec.NodeName] = zone
+		}
+		status, ok := zones[zone]
+		if !ok {
+			continue
+		}
+		status.Replicas++
+		if podReady(pod) {
+			status.ReadyReplicas++
+		}
+	}
+
+	degraded := []string{}
+	for idx := range statuses {
+		statuses[idx].Degraded = statuses[idx].ReadyReplicas < replicasPerZone(agent)
+		if statuses[idx].Degraded {
+			degraded = append(degraded, statuses[idx].Name)
+		}
+	}
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionZonesCovered,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ZonesCovered",
+		Message: fmt.Sprintf("%d replicas ready in every zone", replicasPerZone(agent)),
+	}
+	if len(degraded) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ZonesDegraded"
+		condition.Message = fmt.Sprintf("Zones %s are degraded", strings.Join(degraded, ", "))
+	}
+
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionZonesCovered)
+	if equality.Semantic.DeepEqual(agent.Status.Zones, statuses) && current != nil &&
+		current.Status == condition.Status && current.Message == condition.Message {
+		return nil
+	}
+	agent.Status.Zones = statuses
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	return r.Status().Update(ctx, agent)
+}
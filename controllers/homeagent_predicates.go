@@ -0,0 +1,46 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// agentChanged lets through changes of the spec, labels and annotations of
+// a HomeAgent, but not the status updates the reconciler makes itself.
+var agentChanged = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+)
+
+// workloadChanged lets through the changes of a workload the reconcile acts
+// on: its spec, labels and annotations, and the progress of its replicas.
+// Other status churn, e.g. of its conditions, is dropped.
+var workloadChanged = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+	predicate.Funcs{UpdateFunc: replicasChanged},
+)
+
+func replicasChanged(e event.UpdateEvent) bool {
+	old_updated, old_ready, old_progressing := rolloutProgress(e.ObjectOld)
+	updated, ready, progressing := rolloutProgress(e.ObjectNew)
+	return old_updated != updated || old_ready != ready || old_progressing != progressing
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The home prefixes of an agent are advertised next to the prefix of its
+// PrairieNetwork. mo-daemon takes them as a list of prefixes, each followed
+// by its router advertisement parameters:
+//
+//	ra_prefixes = 2001:db8:1::/64;interval_ms=600000;valid_lifetime_ms=86400000,2001:db8:2::/64
+
+// prefixSettings renders spec.homePrefixes.
+func prefixSettings(agent *prairiev1.HomeAgent) []setting {
+	if len(agent.Spec.HomePrefixes) == 0 {
+		return nil
+	}
+	prefixes := make([]string, 0, len(agent.Spec.HomePrefixes))
+	for _, prefix := range agent.Spec.HomePrefixes {
+		fields := []string{prefix.Prefix}
+		if prefix.Interval != nil {
+			fields = append(fields, "interval_ms="+milliseconds(prefix.Interval))
+		}
+		if prefix.ValidLifetime != nil {
+			fields = append(fields, "valid_lifetime_ms="+milliseconds(prefix.ValidLifetime))
+		}
+		if prefix.PreferredLifetime != nil {
+			fields = append(fields, "preferred_lifetime_ms="+milliseconds(prefix.PreferredLifetime))
+		}
+		prefixes = append(prefixes, strings.Join(fields, ";"))
+	}
+	return []setting{{Key: "ra_prefixes", Value: strings.Join(prefixes, ",")}}
+}
+
+// validateHomePrefixes checks the home prefixes of the agent. The prefix of
+// its network is advertised with the parameters of the network already.
+func validateHomePrefixes(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) error {
+	seen := map[string]bool{}
+	if network != nil {
+		if _, prefix, err := net.ParseCIDR(network.Spec.Prefix); err == nil {
+			seen[prefix.String()] = true
+		}
+	}
+	for _, home_prefix := range agent.Spec.HomePrefixes {
+		ip, prefix, err := net.ParseCIDR(home_prefix.Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("home prefix %q is not an IPv6 prefix", home_prefix.Prefix)
+		}
+		if seen[prefix.String()] {
+			return fmt.Errorf("home prefix %s is listed twice or is the prefix of the network", prefix)
+		}
+		seen[prefix.String()] = true
+		if home_prefix.ValidLifetime != nil && home_prefix.PreferredLifetime != nil &&
+			home_prefix.PreferredLifetime.Duration > home_prefix.ValidLifetime.Duration {
+			return fmt.Errorf("preferred lifetime of home prefix %s exceeds its valid lifetime", prefix)
+		}
+	}
+	return nil
+}
+
+// checkHomePrefixes marks the agent degraded if its home prefixes are
+// invalid, mo-daemon would refuse to start with them.
+func (r *HomeAgentReconciler) checkHomePrefixes(ctx context.Context, agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) (bool, error) {
+	if err := validateHomePrefixes(agent, network); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidHomePrefixes", err.Error())
+	}
+	return true, nil
+}
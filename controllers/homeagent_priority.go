@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// criticalPriorityClass ranks home agents above regular workloads, so they
+// are not the first to be evicted under node pressure, but below the system
+// critical classes.
+const (
+	criticalPriorityClass = "prairie-critical"
+	criticalPriority      = 1000000
+)
+
+//+kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch;create
+
+// reconcilePriorityClass creates the prairie-critical PriorityClass once an
+// agent references it. It is cluster-scoped and shared by all agents, so it
+// is kept when the agents are gone.
+func (r *HomeAgentReconciler) reconcilePriorityClass(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	if agent.Spec.PriorityClassName != criticalPriorityClass {
+		return nil
+	}
+
+	class := &schedulingv1.PriorityClass{}
+	err := r.Get(ctx, types.NamespacedName{Name: criticalPriorityClass}, class)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	class = &schedulingv1.PriorityClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   criticalPriorityClass,
+			Labels: operatorLabels(),
+		},
+		Value:         criticalPriority,
+		GlobalDefault: false,
+		Description:   "Home agents of prairie-operator, evicted after regular workloads.",
+	}
+	err = r.Create(ctx, class)
+	if err != nil {
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	log.FromContext(ctx).Info("PriorityClass created.", "class", criticalPriorityClass)
+	return nil
+}
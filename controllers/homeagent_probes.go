@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// mo-daemon reports on its management port, or its health port if the
+// management API requires mutual TLS, whether it is alive and whether it
+// accepts registrations. A draining replica is alive but not ready.
+const (
+	livenessPath  = "/v1/health"
+	readinessPath = "/v1/ready"
+)
+
+func healthCheck(agent *prairiev1.HomeAgent, path string) corev1.ProbeHandler {
+	return corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path: path,
+			Port: intstr.FromInt(healthPort(agent)),
+		},
+	}
+}
+
+// healthPort is the port the kubelet reaches health, readiness and the
+// drain on. It can't present a client certificate, so SPIFFE enabled
+// agents serve them on a plain HTTP port of their own.
+func healthPort(agent *prairiev1.HomeAgent) int {
+	if spiffeSpec(agent) != nil {
+		return daemon.HealthPort
+	}
+	return daemon.ManagementPort
+}
+
+func livenessProbe(agent *prairiev1.HomeAgent) *corev1.Probe {
+	if agent.Spec.Probes != nil && agent.Spec.Probes.Liveness != nil {
+		return agent.Spec.Probes.Liveness
+	}
+	return &corev1.Probe{
+		ProbeHandler:        healthCheck(agent, livenessPath),
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+		FailureThreshold:    3,
+	}
+}
+
+func readinessProbe(agent *prairiev1.HomeAgent) *corev1.Probe {
+	if agent.Spec.Probes != nil && agent.Spec.Probes.Readiness != nil {
+		return agent.Spec.Probes.Readiness
+	}
+	return &corev1.Probe{
+		ProbeHandler:     healthCheck(agent, readinessPath),
+		PeriodSeconds:    5,
+		FailureThreshold: 2,
+	}
+}
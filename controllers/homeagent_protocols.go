@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// mo-daemon serves Mobile IPv6 unless told otherwise, Mobile IPv4 has to be
+// enabled. Agents serving both need an address of either family, so every
+// node has to be dual-stack.
+
+func mipv4Enabled(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Protocols != nil && agent.Spec.Protocols.MIPv4 != nil
+}
+
+func mipv6Enabled(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Protocols == nil || agent.Spec.Protocols.MIPv6 == nil || !agent.Spec.Protocols.MIPv6.Disabled
+}
+
+// protocolSettings configures the versions of Mobile IP. Nothing is
+// rendered for the default of MIPv6 only.
+func protocolSettings(agent *prairiev1.HomeAgent) []setting {
+	if agent.Spec.Protocols == nil {
+		return nil
+	}
+	settings := []setting{}
+	if mipv4 := agent.Spec.Protocols.MIPv4; mipv4 != nil {
+		settings = append(settings,
+			setting{Key: "mip4", Value: "on"},
+			setting{Key: "mip4_home_network", Value: mipv4.HomeNetwork})
+		if mipv4.ReverseTunneling {
+			settings = append(settings, setting{Key: "mip4_reverse_tunnel", Value: "on"})
+		}
+		if mipv4.MaxRegistrationLifetime != nil {
+			settings = append(settings, setting{Key: "mip4_max_lifetime_s", Value: strconv.Itoa(int(mipv4.MaxRegistrationLifetime.Seconds()))})
+		}
+	}
+	if mipv6 := agent.Spec.Protocols.MIPv6; mipv6 != nil {
+		if mipv6.Disabled {
+			settings = append(settings, setting{Key: "mip6", Value: "off"})
+		}
+		if mipv6.MaxBindingLifetime != nil {
+			settings = append(settings, setting{Key: "mip6_max_lifetime_s", Value: strconv.Itoa(int(mipv6.MaxBindingLifetime.Seconds()))})
+		}
+	}
+	return settings
+}
+
+// validateProtocols checks that the agent serves at least one version of
+// Mobile IP and that the features it uses are available without MIPv6.
+func validateProtocols(agent *prairiev1.HomeAgent) error {
+	if mipv4Enabled(agent) {
+		ip, _, err := net.ParseCIDR(agent.Spec.Protocols.MIPv4.HomeNetwork)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("MIPv4 home network %q is not an IPv4 network", agent.Spec.Protocols.MIPv4.HomeNetwork)
+		}
+	}
+	if mipv6Enabled(agent) {
+		return nil
+	}
+	switch {
+	case !mipv4Enabled(agent):
+		return fmt.Errorf("spec.protocols disables MIPv6 without enabling MIPv4")
+	case lmaMode(agent):
+		return fmt.Errorf("the %s mode requires MIPv6", prairiev1.ModeLMA)
+	case agent.Spec.Discovery != nil && (agent.Spec.Discovery.DHAAD || agent.Spec.Discovery.AnycastAddress != ""):
+		return fmt.Errorf("home agent address discovery requires MIPv6")
+	case agent.Spec.Proxying != nil && agent.Spec.Proxying.NDP != nil:
+		return fmt.Errorf("NDP proxying requires MIPv6")
+	}
+	return nil
+}
+
+// protocolFamilies returns the IP families the pods of the agent need. An
+// agent serving MIPv6 only is left alone, it may well be attached to its
+// home link by a secondary network.
+func protocolFamilies(agent *prairiev1.HomeAgent) []corev1.IPFamily {
+	if !mipv4Enabled(agent) {
+		return nil
+	}
+	families := []corev1.IPFamily{corev1.IPv4Protocol}
+	if mipv6Enabled(agent) {
+		families = append(families, corev1.IPv6Protocol)
+	}
+	return families
+}
+
+// missingFamily returns the first node whose pod networks lack one of the
+// families, along with the family. Nodes without pod networks are skipped,
+// their addresses are managed outside of Kubernetes.
+func missingFamily(nodes []corev1.Node, families []corev1.IPFamily) (string, corev1.IPFamily) {
+	for _, node := range nodes {
+		if len(node.Spec.PodCIDRs) == 0 {
+			continue
+		}
+		found := map[corev1.IPFamily]bool{}
+		for _, cidr := range node.Spec.PodCIDRs {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			if ip.To4() != nil {
+				found[corev1.IPv4Protocol] = true
+			} else {
+				found[corev1.IPv6Protocol] = true
+			}
+		}
+		for _, family := range families {
+			if !found[family] {
+				return node.Name, family
+			}
+		}
+	}
+	return "", ""
+}
+
+// checkProtocols makes sure the agent serves a valid set of protocols and
+// that the cluster provides the IP families they need before rolling it
+// out, and marks it degraded otherwise.
+func (r *HomeAgentReconciler) checkProtocols(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if err := validateProtocols(rendered); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidProtocols", err.Error())
+	}
+	families := protocolFamilies(rendered)
+	if len(families) == 0 {
+		return true, nil
+	}
+
+	nodes := &corev1.NodeList{}
+	err := r.List(ctx, nodes)
+	if err != nil {
+		return false, err
+	}
+	node, family := missingFamily(nodes.Items, families)
+	if node == "" {
+		return true, nil
+	}
+	message := fmt.Sprintf("Node %s has no %s pod network", node, family)
+	if len(families) > 1 {
+		message += ", serving MIPv4 and MIPv6 requires a dual-stack cluster"
+	}
+	return false, r.markDegraded(ctx, agent, "IPFamilyUnavailable", message)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The home link is attached to the agent pods by Multus through
+// spec.proxying.attachments, or the pods run in the host network of nodes
+// attached to it. Proxying on the cluster network interface would answer
+// for addresses of the cluster, so the proxying interfaces of pods which
+// aren't in the host network have to be Multus attachments. Attachments
+// set through the Multus annotation in spec.commonAnnotations are still
+// honored, that stamps it on every object of the agent though.
+const (
+	multusNetworksAnnotation = "k8s.v1.cni.cncf.io/networks"
+	clusterInterface         = "eth0"
+)
+
+// multusNetwork is an entry of the JSON form of the Multus annotation.
+type multusNetwork struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// multusInterfaces returns the interfaces the Multus annotation attaches to
+// a pod. Attachments without an explicit name are named net1, net2, ... in
+// the order they are listed.
+func multusInterfaces(annotation string) ([]string, error) {
+	annotation = strings.TrimSpace(annotation)
+	if annotation == "" {
+		return nil, nil
+	}
+	networks := []multusNetwork{}
+	if strings.HasPrefix(annotation, "[") {
+		if err := json.Unmarshal([]byte(annotation), &networks); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", multusNetworksAnnotation, err)
+		}
+	} else {
+		for _, entry := range strings.Split(annotation, ",") {
+			name, iface, _ := strings.Cut(strings.TrimSpace(entry), "@")
+			networks = append(networks, multusNetwork{Name: name, Interface: iface})
+		}
+	}
+
+	interfaces := make([]string, len(networks))
+	for idx, network := range networks {
+		interfaces[idx] = network.Interface
+		if interfaces[idx] == "" {
+			interfaces[idx] = fmt.Sprintf("net%d", idx+1)
+		}
+	}
+	return interfaces, nil
+}
+
+// attachmentsAnnotation renders the attachments of the agent in the JSON
+// form of the Multus annotation, empty without attachments.
+func attachmentsAnnotation(agent *prairiev1.HomeAgent) string {
+	if agent.Spec.Proxying == nil || len(agent.Spec.Proxying.Attachments) == 0 {
+		return ""
+	}
+	networks := make([]multusNetwork, len(agent.Spec.Proxying.Attachments))
+	for idx, attachment := range agent.Spec.Proxying.Attachments {
+		networks[idx].Name = attachment.Network
+		if namespace, name, ok := strings.Cut(attachment.Network, "/"); ok {
+			networks[idx].Namespace = namespace
+			networks[idx].Name = name
+		}
+		networks[idx].Interface = attachment.Interface
+	}
+	annotation, _ := json.Marshal(networks)
+	return string(annotation)
+}
+
+// proxyingTemplate attaches the home link to the pods of the agent, or
+// moves them to the host network. Replicas in the host network are spread
+// over the nodes by the host ports of their containers.
+func proxyingTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if agent.Spec.Proxying == nil {
+		return
+	}
+	if agent.Spec.Proxying.HostNetwork {
+		template.Spec.HostNetwork = true
+		return
+	}
+	if annotation := attachmentsAnnotation(agent); annotation != "" {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[multusNetworksAnnotation] = annotation
+	}
+}
+
+// validateProxying checks that the agent proxies on interfaces Multus
+// attaches to its pods, or on named interfaces of the node in the host
+// network, and ARP only for MIPv4.
+func validateProxying(agent *prairiev1.HomeAgent) error {
+	proxying := agent.Spec.Proxying
+	if proxying == nil {
+		return nil
+	}
+	if proxying.ARP != nil && !mipv4Enabled(agent) {
+		return fmt.Errorf("ARP proxying requires MIPv4")
+	}
+	common := commonAnnotations(agent)[multusNetworksAnnotation]
+	if proxying.HostNetwork {
+		if len(proxying.Attachments) > 0 || common != "" {
+			return fmt.Errorf("pods in the host network can't have Multus attachments")
+		}
+		if proxying.NDP != nil && proxying.NDP.Interface == "" {
+			return fmt.Errorf("NDP proxying in the host network requires an interface, it would proxy on every interface of the node")
+		}
+		return nil
+	}
+	if len(proxying.Attachments) > 0 && common != "" {
+		return fmt.Errorf("attachments are set both in spec.proxying.attachments and by %s in spec.commonAnnotations",
+			multusNetworksAnnotation)
+	}
+
+	source := "spec.proxying.attachments"
+	annotation := attachmentsAnnotation(agent)
+	if annotation == "" {
+		source = multusNetworksAnnotation + " in spec.commonAnnotations"
+		annotation = common
+	}
+	interfaces, err := multusInterfaces(annotation)
+	if err != nil {
+		return err
+	}
+	attached := map[string]bool{}
+	for _, iface := range interfaces {
+		attached[iface] = true
+	}
+	check := func(protocol, iface string) error {
+		if iface == clusterInterface {
+			return fmt.Errorf("%s proxying on %s would answer on the cluster network", protocol, iface)
+		}
+		if !attached[iface] {
+			return fmt.Errorf("%s proxying interface %s is not attached by %s", protocol, iface, source)
+		}
+		return nil
+	}
+	if proxying.NDP != nil && proxying.NDP.Interface != "" {
+		if err := check("NDP", proxying.NDP.Interface); err != nil {
+			return err
+		}
+	}
+	if proxying.ARP != nil {
+		return check("ARP", proxying.ARP.Interface)
+	}
+	return nil
+}
+
+// checkProxying marks the agent degraded if it would proxy on an interface
+// its pods don't have.
+func (r *HomeAgentReconciler) checkProxying(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if err := validateProxying(rendered); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidProxying", err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// mo-daemon marks the outer header of the packets it tunnels. The traffic
+// classes of spec.qos are passed as a list of rules, each with the inner
+// DSCP values it matches and the marking it sets:
+//
+//	qos_dscp_rules = voice:46>46,bulk:8/10>0
+
+// qosSettings renders spec.qos.
+func qosSettings(agent *prairiev1.HomeAgent) []setting {
+	qos := agent.Spec.QoS
+	if qos == nil {
+		return nil
+	}
+	mode := qos.Mode
+	if mode == "" {
+		mode = prairiev1.DSCPModePreserve
+	}
+	settings := []setting{{Key: "qos_dscp_mode", Value: strings.ToLower(string(mode))}}
+	if mode == prairiev1.DSCPModeFixed && qos.DSCP != nil {
+		settings = append(settings, setting{Key: "qos_dscp", Value: strconv.Itoa(int(*qos.DSCP))})
+	}
+	if len(qos.Rules) > 0 {
+		rules := make([]string, 0, len(qos.Rules))
+		for _, rule := range qos.Rules {
+			match := make([]string, 0, len(rule.Match))
+			for _, value := range rule.Match {
+				match = append(match, strconv.Itoa(int(value)))
+			}
+			rules = append(rules, fmt.Sprintf("%s:%s>%d", rule.Name, strings.Join(match, "/"), rule.DSCP))
+		}
+		settings = append(settings, setting{Key: "qos_dscp_rules", Value: strings.Join(rules, ",")})
+	}
+	return settings
+}
+
+// validateQoS checks that the marking of every packet is unambiguous.
+func validateQoS(agent *prairiev1.HomeAgent) error {
+	qos := agent.Spec.QoS
+	if qos == nil {
+		return nil
+	}
+	if qos.Mode == prairiev1.DSCPModeFixed && qos.DSCP == nil {
+		return fmt.Errorf("the Fixed QoS mode requires a DSCP")
+	}
+	if qos.Mode != prairiev1.DSCPModeFixed && qos.DSCP != nil {
+		return fmt.Errorf("the QoS DSCP is only used in Fixed mode")
+	}
+	names := map[string]bool{}
+	classes := map[prairiev1.DSCPValue]string{}
+	for _, rule := range qos.Rules {
+		if names[rule.Name] {
+			return fmt.Errorf("traffic class %s is listed twice", rule.Name)
+		}
+		names[rule.Name] = true
+		for _, value := range rule.Match {
+			if class, ok := classes[value]; ok {
+				return fmt.Errorf("DSCP %d is matched by traffic classes %s and %s", value, class, rule.Name)
+			}
+			classes[value] = rule.Name
+		}
+	}
+	return nil
+}
+
+// checkQoS marks the agent degraded if its QoS rules are ambiguous.
+func (r *HomeAgentReconciler) checkQoS(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateQoS(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidQoS", err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// mobilityReadyCondition is the readiness gate of every replica. The kubelet
+// only reports a replica ready, and Services only send registrations to it,
+// once the operator has seen its tunnels and peerings come up.
+const mobilityReadyCondition corev1.PodConditionType = "prairie.kismi/mobility-ready"
+
+//+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
+
+// readinessGateTemplate adds the mobility readiness gate to the pod template.
+func readinessGateTemplate(template *corev1.PodTemplateSpec) {
+	template.Spec.ReadinessGates = append(template.Spec.ReadinessGates, corev1.PodReadinessGate{
+		ConditionType: mobilityReadyCondition,
+	})
+}
+
+// reconcileReadinessGates sets the mobility readiness condition of every
+// running replica from the state its daemon reports. Unreachable replicas
+// are not ready.
+func (r *HomeAgentReconciler) reconcileReadinessGates(ctx context.Context, agent *prairiev1.HomeAgent, config_hash string) error {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return err
+	}
+
+	running := 0
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp.IsZero() && pod.Status.Phase == corev1.PodRunning {
+			running++
+		}
+	}
+
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		condition := corev1.PodCondition{
+			Type:   mobilityReadyCondition,
+			Status: corev1.ConditionFalse,
+			Reason: "DaemonUnreachable",
+		}
+		stats, err := daemonFor(r.Daemon, r.SecureDaemon, pod).Stats(ctx, pod.Status.PodIP)
+		switch {
+		case err != nil:
+			condition.Message = err.Error()
+		case !stats.TunnelsUp:
+			condition.Reason = "TunnelsDown"
+		case !stats.PeeringsUp:
+			condition.Reason = "PeeringsDown"
+		case sessionContinuity(agent) && !podConditionTrue(pod, mobilityReadyCondition) && stats.ConfigHash != config_hash:
+			// A replica joining during a rollout has to run the current
+			// configuration before bindings are handed over to it
+			condition.Reason = "LoadingConfig"
+		case sessionContinuity(agent) && syncEnabled(agent) && !podConditionTrue(pod, mobilityReadyCondition) &&
+			!resynced(agent, stats, running-1):
+			// and catch up with its peers first
+			condition.Reason = "Resyncing"
+		default:
+			condition.Status = corev1.ConditionTrue
+			condition.Reason = "MobilityPlaneUp"
+		}
+
+		if setPodCondition(pod, condition) {
+			log.FromContext(ctx).Info("Replica mobility readiness changed.", "pod", pod.Name, "ready", condition.Status)
+			if err := r.Status().Update(ctx, pod); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		}
+	}
+	return nil
+}
+
+func podConditionTrue(pod *corev1.Pod, condition corev1.PodConditionType) bool {
+	for _, existing := range pod.Status.Conditions {
+		if existing.Type == condition {
+			return existing.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// setPodCondition sets the condition on the pod, reporting whether its
+// status or reason changed.
+func setPodCondition(pod *corev1.Pod, condition corev1.PodCondition) bool {
+	condition.LastTransitionTime = metav1.Now()
+	for idx, existing := range pod.Status.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason {
+			return false
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		pod.Status.Conditions[idx] = condition
+		return true
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	return true
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// reloadable lists the settings mo-daemon applies on reload. Changing any
+// other setting restarts the replicas. Files mounted from Secrets and
+// ConfigMaps other than the configuration, i.e. keys, subscribers, binding
+// policies and correspondents, are picked up by mo-daemon on reload as well.
+var reloadable = map[string]bool{
+	"arp_proxy":                false,
+	"arp_proxy_gratuitous":     true,
+	"arp_proxy_interface":      false,
+	"auth_realm":               false,
+	"backup_credentials":       false,
+	"binding_db":               false,
+	"buffer_packets":           false,
+	"buffer_timeout_ms":        true,
+	"dhaad":                    false,
+	"diameter_dest_realm":      true,
+	"diameter_origin_realm":    false,
+	"diameter_peers":           true,
+	"diameter_tls_ca":          false,
+	"diameter_tls_cert":        false,
+	"diameter_tls_key":         false,
+	"firewall_default":         true,
+	"firewall_rules":           true,
+	"forward_broadcast":        false,
+	"forward_multicast":        false,
+	"ha_anycast":               false,
+	"handover_window_ms":       true,
+	"home_gateway":             false,
+	"home_prefix":              false,
+	"home_prefixes":            false,
+	"home_vlan":                false,
+	"ipsec":                    false,
+	"ipsec_cert":               false,
+	"ipsec_esp_proposals":      true,
+	"ipsec_ike_proposals":      true,
+	"ipsec_ike_version":        false,
+	"ipsec_key":                false,
+	"ipsec_lifetime_s":         true,
+	"ipsec_psk":                false,
+	"lma_address":              false,
+	"lma_mag_peers":            true,
+	"log_file":                 false,
+	"log_file_max_files":       false,
+	"log_file_max_size":        false,
+	"log_stdout":               false,
+	"qos_dscp":                 true,
+	"qos_dscp_mode":            true,
+	"qos_dscp_rules":           true,
+	"ra":                       false,
+	"ra_interval_ms":           true,
+	"ra_preferred_lifetime_ms": true,
+	"ra_prefixes":              true,
+	"ra_valid_lifetime_ms":     true,
+	"radius_realms":            true,
+	"radius_retries":           true,
+	"radius_secret":            false,
+	"radius_servers":           true,
+	"radius_strip_realm":       true,
+	"radius_timeout_ms":        true,
+	"redundancy":               false,
+	"mgmt_allowed_id":          false,
+	"mgmt_auth":                false,
+	"mip4":                     false,
+	"mip4_home_network":        false,
+	"mip4_max_lifetime_s":      true,
+	"mip4_reverse_tunnel":      false,
+	"mip6":                     false,
+	"mip6_max_lifetime_s":      true,
+	"mode":                     false,
+	"ndp_proxy":                false,
+	"ndp_proxy_interface":      false,
+	"ndp_proxy_unsolicited":    true,
+	"shaping_class_rates":      true,
+	"shaping_node_burst_bytes": true,
+	"shaping_node_rate_bps":    true,
+	"simultaneous_bindings":    true,
+	"spiffe_socket":            false,
+	"spiffe_trust_domain":      false,
+	"sync_auth":                false,
+	"sync_key":                 false,
+	"sync_peers":               true,
+	"sync_port":                false,
+	"tunnel_encap":             false,
+	"tunnel_mtu":               false,
+}
+
+// reloadReplicas pushes the configuration to every running replica which
+// reports a different one and makes it reload. Replicas started from an
+// outdated template are left alone, the rollout replaces them anyway.
+// Replicas which can't be reached are retried with the next reconcile.
+func (r *HomeAgentReconciler) reloadReplicas(ctx context.Context, agent *prairiev1.HomeAgent, config, restart_hash string) error {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return err
+	}
+
+	hash := configHash(config)
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if pod.Annotations[configHashAnnotation] != restart_hash {
+			continue
+		}
+
+		stats, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Stats(ctx, pod.Status.PodIP)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Replica stats could not be read.", "pod", pod.Name)
+			continue
+		}
+		if stats.ConfigHash == hash {
+			continue
+		}
+
+		err = daemonFor(r.Daemon, r.SecureDaemon, &pod).PushConfig(ctx, pod.Status.PodIP, []byte(config))
+		if err == nil {
+			err = daemonFor(r.Daemon, r.SecureDaemon, &pod).Reload(ctx, pod.Status.PodIP)
+		}
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Replica could not be reloaded.", "pod", pod.Name)
+			continue
+		}
+		log.FromContext(ctx).Info("Replica reloaded.", "pod", pod.Name, "hash", hash)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// maxRevisions bounds the revisions kept in the status.
+const maxRevisions = 10
+
+// recordRevision records the outcome of the current generation of the spec
+// in the revision history. A new generation supersedes the previous one if
+// it is still in progress. A revision which succeeded keeps its result, so
+// the history tells how the rollout went rather than the current state.
+func recordRevision(agent *prairiev1.HomeAgent, result prairiev1.RevisionResult, message string) {
+	now := metav1.Now()
+	revisions := agent.Status.Revisions
+	if len(revisions) == 0 || revisions[len(revisions)-1].Generation != agent.Generation {
+		if len(revisions) > 0 {
+			last := &revisions[len(revisions)-1]
+			if last.Result == prairiev1.RevisionProgressing {
+				last.Result = prairiev1.RevisionSuperseded
+				last.CompletionTime = &now
+			}
+		}
+		revisions = append(revisions, prairiev1.Revision{
+			Generation: agent.Generation,
+			Image:      agent.Spec.Image,
+			Version:    agent.Spec.Version,
+			Size:       agent.Spec.Size,
+			Result:     prairiev1.RevisionProgressing,
+			Time:       now,
+		})
+		if excess := len(revisions) - maxRevisions; excess > 0 {
+			revisions = revisions[excess:]
+		}
+	}
+
+	last := &revisions[len(revisions)-1]
+	if last.Result != prairiev1.RevisionSucceeded && (last.Result != result || last.Message != message) {
+		last.Result = result
+		last.Message = message
+		last.CompletionTime = nil
+		if result != prairiev1.RevisionProgressing {
+			last.CompletionTime = &now
+		}
+	}
+	agent.Status.Revisions = revisions
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// In ActiveStandby mode a single replica, labelled with roleActive, serves
+// mobile nodes and holds the advertised address; the Service only selects
+// it. The other replicas keep their state in sync as standbys. Once the
+// active replica fails, i.e. it is gone or not ready anymore, the operator
+// promotes the standby which has been ready for the longest time.
+const (
+	roleLabel   = "prairie.kismi/role"
+	roleActive  = "active"
+	roleStandby = "standby"
+
+	// maxRoleTransitions bounds the transitions kept in the status.
+	maxRoleTransitions = 10
+)
+
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+func activeStandby(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Redundancy != nil && agent.Spec.Redundancy.Mode == prairiev1.RedundancyActiveStandby
+}
+
+// checkFeatures makes sure ActiveStandby mode is enabled if the agent asks
+// for it. Running replicas keep their role until the gate is enabled.
+func (r *HomeAgentReconciler) checkFeatures(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if !activeStandby(rendered) || r.Features.Enabled(ActiveStandby) {
+		return true, nil
+	}
+	message := fmt.Sprintf("Redundancy mode %s requires the %s feature gate", prairiev1.RedundancyActiveStandby, ActiveStandby)
+	return false, r.markDegraded(ctx, agent, "FeatureDisabled", message)
+}
+
+// redundancySettings tells mo-daemon to start as a standby and wait for the
+// operator to pick the active replica.
+func redundancySettings(agent *prairiev1.HomeAgent) []setting {
+	if !activeStandby(agent) {
+		return nil
+	}
+	return []setting{{Key: "redundancy", Value: "active-standby"}}
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if !pod.DeletionTimestamp.IsZero() || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// readySince returns when the pod last became ready.
+func readySince(pod *corev1.Pod) metav1.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.LastTransitionTime
+		}
+	}
+	return pod.CreationTimestamp
+}
+
+// reconcileRoles makes sure exactly one ready replica is active in
+// ActiveStandby mode, promoting a standby if the active replica failed or a
+// failover was requested.
+func (r *HomeAgentReconciler) reconcileRoles(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	target, failover := agent.Annotations[prairiev1.FailoverAnnotation]
+	if err := r.assignRoles(ctx, agent, target, failover); err != nil {
+		return err
+	}
+	if failover {
+		return r.clearFailover(ctx, agent)
+	}
+	return nil
+}
+
+// assignRoles picks the active replica, handing the role over to target
+// if a failover was requested.
+func (r *HomeAgentReconciler) assignRoles(ctx context.Context, agent *prairiev1.HomeAgent, target string, failover bool) error {
+	if !activeStandby(agent) {
+		if failover {
+			r.Recorder.Event(agent, corev1.EventTypeWarning, "FailoverRejected",
+				fmt.Sprintf("Failover requires redundancy mode %s", prairiev1.RedundancyActiveStandby))
+		}
+		if agent.Status.Active != "" {
+			agent.Status.Active = ""
+			return r.Status().Update(ctx, agent)
+		}
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return err
+	}
+
+	// Replicas on a failed node may still be reported ready for a while,
+	// they are not trusted with node failure handling enabled.
+	var active *corev1.Pod
+	candidates := []*corev1.Pod{}
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if !podReady(pod) || r.onFailedNode(ctx, agent, pod) {
+			continue
+		}
+		if pod.Name == agent.Status.Active {
+			active = pod
+		} else {
+			candidates = append(candidates, pod)
+		}
+	}
+
+	reason := "Failover"
+	if agent.Status.Active == "" {
+		reason = "Elected"
+	}
+	if failover && active != nil {
+		standby := requestedStandby(candidates, target)
+		if standby == nil {
+			message := "Failover requested, but no standby is ready"
+			if target != "" {
+				message = fmt.Sprintf("Failover to %s requested, but it isn't a ready standby", target)
+			}
+			r.Recorder.Event(agent, corev1.EventTypeWarning, "FailoverRejected", message)
+		} else {
+			// The active replica steps down first, so both are never active
+			if err := daemonFor(r.Daemon, r.SecureDaemon, active).SetRole(ctx, active.Status.PodIP, roleStandby); err != nil {
+				log.FromContext(ctx).Error(err, "Replica could not be demoted.", "pod", active.Name)
+				return err
+			}
+			if err := r.setRole(ctx, active, roleStandby); err != nil {
+				return err
+			}
+			candidates = []*corev1.Pod{standby}
+			active = nil
+			reason = "Manual"
+		}
+	}
+	if active == nil {
+		if len(candidates) == 0 {
+			if agent.Status.Active != "" {
+				log.FromContext(ctx).Info("Active replica failed and no standby is ready.", "pod", agent.Status.Active)
+			}
+			return nil
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			i_since, j_since := readySince(candidates[i]), readySince(candidates[j])
+			return i_since.Before(&j_since)
+		})
+		active = candidates[0]
+		candidates = candidates[1:]
+
+		if err := daemonFor(r.Daemon, r.SecureDaemon, active).SetRole(ctx, active.Status.PodIP, roleActive); err != nil {
+			log.FromContext(ctx).Error(err, "Replica could not be promoted.", "pod", active.Name)
+			return err
+		}
+		if err := r.setRole(ctx, active, roleActive); err != nil {
+			return err
+		}
+
+		message := fmt.Sprintf("Replica %s promoted to active", active.Name)
+		event := corev1.EventTypeNormal
+		switch reason {
+		case "Failover":
+			message = fmt.Sprintf("Active replica %s failed, replica %s promoted to active", agent.Status.Active, active.Name)
+			event = corev1.EventTypeWarning
+		case "Manual":
+			message = fmt.Sprintf("Active role handed over from %s to %s on request", agent.Status.Active, active.Name)
+		}
+		log.FromContext(ctx).Info(message)
+		r.Recorder.Event(agent, event, reason, message)
+
+		agent.Status.RoleTransitions = append(agent.Status.RoleTransitions, prairiev1.RoleTransition{
+			Time:   metav1.Now(),
+			From:   agent.Status.Active,
+			To:     active.Name,
+			Reason: reason,
+		})
+		if excess := len(agent.Status.RoleTransitions) - maxRoleTransitions; excess > 0 {
+			agent.Status.RoleTransitions = agent.Status.RoleTransitions[excess:]
+		}
+		agent.Status.Active = active.Name
+		if err := r.Status().Update(ctx, agent); err != nil {
+			return err
+		}
+	}
+
+	// Every other replica, including a failed active one still around,
+	// is a standby.
+	for idx := range pods.Items {
+		pod := &pods.Items[idx]
+		if pod.Name == active.Name || !pod.DeletionTimestamp.IsZero() || pod.Labels[roleLabel] == roleStandby {
+			continue
+		}
+		if pod.Status.PodIP != "" {
+			if err := daemonFor(r.Daemon, r.SecureDaemon, pod).SetRole(ctx, pod.Status.PodIP, roleStandby); err != nil {
+				log.FromContext(ctx).Error(err, "Replica could not be demoted.", "pod", pod.Name)
+			}
+		}
+		if err := r.setRole(ctx, pod, roleStandby); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *HomeAgentReconciler) setRole(ctx context.Context, pod *corev1.Pod, role string) error {
+	if pod.Labels[roleLabel] == role {
+		return nil
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[roleLabel] = role
+	return client.IgnoreNotFound(r.Update(ctx, pod))
+}
+
+// requestedStandby returns the standby a failover was asked for, or the one
+// ready for the longest time if none was named.
+func requestedStandby(candidates []*corev1.Pod, name string) *corev1.Pod {
+	var standby *corev1.Pod
+	for _, pod := range candidates {
+		if name != "" {
+			if pod.Name == name {
+				return pod
+			}
+			continue
+		}
+		if standby == nil {
+			standby = pod
+			continue
+		}
+		if since, standby_since := readySince(pod), readySince(standby); since.Before(&standby_since) {
+			standby = pod
+		}
+	}
+	return standby
+}
+
+// clearFailover removes the failover request from the agent once it was
+// handled, whatever the outcome.
+func (r *HomeAgentReconciler) clearFailover(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	patch := client.MergeFrom(agent.DeepCopy())
+	delete(agent.Annotations, prairiev1.FailoverAnnotation)
+	return r.Patch(ctx, agent, patch)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// In SessionContinuity mode a rollout replaces one replica at a time. The
+// readiness gate of a new replica stays closed until it has re-synced the
+// bindings from its peers, which holds back the next replacement.
+const (
+	defaultProgressDeadline = 10 * time.Minute
+	defaultMaxSyncLag       = time.Second
+)
+
+func sessionContinuity(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Rollout != nil && agent.Spec.Rollout.Mode == prairiev1.RolloutSessionContinuity
+}
+
+func progressDeadline(agent *prairiev1.HomeAgent) time.Duration {
+	if agent.Spec.Rollout.ProgressDeadline != nil {
+		return agent.Spec.Rollout.ProgressDeadline.Duration
+	}
+	return defaultProgressDeadline
+}
+
+func maxSyncLag(agent *prairiev1.HomeAgent) time.Duration {
+	if agent.Spec.Rollout.MaxSyncLag != nil {
+		return agent.Spec.Rollout.MaxSyncLag.Duration
+	}
+	return defaultMaxSyncLag
+}
+
+// rolloutStrategy surges a single replica and keeps every old one until
+// its replacement is ready. Otherwise the Deployment defaults apply, they
+// are rendered explicitly so switching the mode back restores them.
+func rolloutStrategy(agent *prairiev1.HomeAgent, deployment *appsv1.Deployment) {
+	surge := intstr.FromString("25%")
+	unavailable := intstr.FromString("25%")
+	deadline := int32(600)
+	if sessionContinuity(agent) {
+		surge = intstr.FromInt(1)
+		unavailable = intstr.FromInt(0)
+		deadline = int32(progressDeadline(agent).Seconds())
+	}
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &surge,
+			MaxUnavailable: &unavailable,
+		},
+	}
+	deployment.Spec.ProgressDeadlineSeconds = &deadline
+}
+
+// setRolloutStrategy copies the rollout settings of the desired workload,
+// reporting whether they changed. A StatefulSet always replaces its
+// replicas one at a time.
+func setRolloutStrategy(workload, desired client.Object) bool {
+	w, ok := workload.(*appsv1.Deployment)
+	if !ok {
+		return false
+	}
+	d := desired.(*appsv1.Deployment)
+	if equality.Semantic.DeepEqual(w.Spec.Strategy, d.Spec.Strategy) &&
+		equality.Semantic.DeepEqual(w.Spec.ProgressDeadlineSeconds, d.Spec.ProgressDeadlineSeconds) {
+		return false
+	}
+	w.Spec.Strategy = d.Spec.Strategy
+	w.Spec.ProgressDeadlineSeconds = d.Spec.ProgressDeadlineSeconds
+	return true
+}
+
+// resynced reports whether a replica caught up with every other replica.
+func resynced(agent *prairiev1.HomeAgent, stats *daemon.Stats, others int) bool {
+	if len(stats.Peers) < others {
+		return false
+	}
+	for _, peer := range stats.Peers {
+		if !peer.Connected || time.Duration(peer.LagMillis)*time.Millisecond > maxSyncLag(agent) {
+			return false
+		}
+	}
+	return true
+}
+
+// rolloutProgress returns the updated and ready replicas of a workload and
+// whether a rollout is under way.
+func rolloutProgress(workload client.Object) (int32, int32, bool) {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		progressing := w.Generation > w.Status.ObservedGeneration ||
+			w.Status.UpdateRevision != w.Status.CurrentRevision ||
+			w.Status.UpdatedReplicas < *w.Spec.Replicas
+		return w.Status.UpdatedReplicas, w.Status.ReadyReplicas, progressing
+	case *appsv1.Deployment:
+		progressing := w.Generation > w.Status.ObservedGeneration ||
+			w.Status.UpdatedReplicas < *w.Spec.Replicas ||
+			w.Status.Replicas > w.Status.UpdatedReplicas
+		return w.Status.UpdatedReplicas, w.Status.ReadyReplicas, progressing
+	}
+	return 0, 0, false
+}
+
+// reconcileRollout tracks a rollout in SessionContinuity mode, reporting
+// whether it is still under way. A rollout which didn't replace a replica
+// within the progress deadline is reported as stalled.
+func (r *HomeAgentReconciler) reconcileRollout(ctx context.Context, agent *prairiev1.HomeAgent, workload client.Object) (bool, error) {
+	if !sessionContinuity(agent) {
+		if agent.Status.Rollout == nil && meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionRolloutProgressing) == nil {
+			return false, nil
+		}
+		agent.Status.Rollout = nil
+		meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionRolloutProgressing)
+		return false, r.Status().Update(ctx, agent)
+	}
+
+	updated, ready, progressing := rolloutProgress(workload)
+	if !progressing {
+		if agent.Status.Rollout == nil {
+			return false, nil
+		}
+		agent.Status.Rollout = nil
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionRolloutProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RolloutComplete",
+			Message: "Every replica runs the current template",
+		})
+		r.Recorder.Event(agent, corev1.EventTypeNormal, "RolloutComplete", "Every replica runs the current template")
+		return false, r.Status().Update(ctx, agent)
+	}
+
+	message := fmt.Sprintf("%d of %d replicas updated", updated, replicaCount(agent))
+	rollout := agent.Status.Rollout
+	if rollout == nil || rollout.UpdatedReplicas != updated || rollout.ReadyReplicas != ready {
+		agent.Status.Rollout = &prairiev1.RolloutStatus{
+			UpdatedReplicas:  updated,
+			ReadyReplicas:    ready,
+			LastProgressTime: metav1.Now(),
+		}
+		meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionRolloutProgressing,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ReplicaReplaced",
+			Message: message,
+		})
+		return true, r.Status().Update(ctx, agent)
+	}
+
+	if time.Since(rollout.LastProgressTime.Time) < progressDeadline(agent) ||
+		meta.IsStatusConditionFalse(agent.Status.Conditions, prairiev1.ConditionRolloutProgressing) {
+		return true, nil
+	}
+	message = fmt.Sprintf("No replica replaced within %s, %s", progressDeadline(agent), message)
+	meta.SetStatusCondition(&agent.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionRolloutProgressing,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ProgressDeadlineExceeded",
+		Message: message,
+	})
+	r.Recorder.Event(agent, corev1.EventTypeWarning, "ProgressDeadlineExceeded", message)
+	return true, r.Status().Update(ctx, agent)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// writablePath is a directory mo-daemon writes to at runtime.
+type writablePath struct {
+	Volume    string
+	MountPath string
+}
+
+// writablePaths are backed by emptyDir volumes when the root filesystem of
+// the agent container is read-only. With persistence enabled the binding
+// database lives on its volume claim instead.
+var writablePaths = []writablePath{
+	{Volume: stateVolume, MountPath: stateMountPath},
+	{Volume: "run", MountPath: "/run/mo-daemon"},
+	{Volume: "tmp", MountPath: "/tmp"},
+}
+
+func readOnlyRootFilesystem(agent *prairiev1.HomeAgent) bool {
+	context := agentSecurityContext(agent)
+	return context.ReadOnlyRootFilesystem != nil && *context.ReadOnlyRootFilesystem
+}
+
+// writablePathsTemplate mounts the writable paths of the daemon, unless
+// they are mounted already.
+func writablePathsTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if !readOnlyRootFilesystem(agent) {
+		return
+	}
+
+	for _, writable := range writablePaths {
+		if writable.MountPath == stateMountPath && agent.Spec.Persistence != nil {
+			continue
+		}
+		mounted := false
+		for _, container := range template.Spec.Containers {
+			for _, mount := range container.VolumeMounts {
+				mounted = mounted || mount.MountPath == writable.MountPath
+			}
+		}
+		if mounted {
+			continue
+		}
+
+		template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+			Name:         writable.Volume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		for i := range template.Spec.Containers {
+			template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      writable.Volume,
+				MountPath: writable.MountPath,
+			})
+		}
+	}
+}
@@ -0,0 +1,30 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// setScaleStatus reports the running replicas and their selector, which
+// the scale subresource serves to autoscalers like the HPA or KEDA.
+func setScaleStatus(agent *prairiev1.HomeAgent, running int) {
+	agent.Status.CurrentReplicas = int32(running)
+	agent.Status.Selector = labels.SelectorFromSet(labels.Set{"parent": agent.Name}).String()
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/schedule"
+)
+
+// scheduledSize returns the size of the open window of spec.schedules, as
+// last reconciled into the status.
+func scheduledSize(agent *prairiev1.HomeAgent) (int32, bool) {
+	if len(agent.Spec.Schedules) == 0 || agent.Status.Schedule == nil || agent.Status.Schedule.Active == "" {
+		return 0, false
+	}
+	return agent.Status.Schedule.Size, true
+}
+
+// scheduleWindows reads the windows of spec.schedules.
+func scheduleWindows(agent *prairiev1.HomeAgent) ([]schedule.Window, error) {
+	windows := make([]schedule.Window, 0, len(agent.Spec.Schedules))
+	for _, spec := range agent.Spec.Schedules {
+		start, err := schedule.Parse(spec.Start)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", spec.Name, err)
+		}
+		location, err := time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", spec.Name, err)
+		}
+		if spec.Duration.Duration <= 0 {
+			return nil, fmt.Errorf("schedule %s: duration must be positive", spec.Name)
+		}
+		windows = append(windows, schedule.Window{Start: start, Duration: spec.Duration.Duration, Location: location})
+	}
+	return windows, nil
+}
+
+// reconcileSchedule reports the size in effect from the open window of
+// spec.schedules, or from spec.size if it changed since the window opened,
+// and returns when the next window opens or closes.
+func (r *HomeAgentReconciler) reconcileSchedule(ctx context.Context, agent *prairiev1.HomeAgent) (time.Duration, bool, error) {
+	if len(agent.Spec.Schedules) == 0 {
+		agent.Status.Schedule = nil
+		return 0, true, nil
+	}
+	windows, err := scheduleWindows(agent)
+	if err != nil {
+		return 0, false, r.markDegraded(ctx, agent, "InvalidSchedule", err.Error())
+	}
+
+	now := time.Now()
+	status := &prairiev1.ScheduleStatus{Size: unscheduledSize(agent)}
+	var opened, next time.Time
+	for idx, window := range windows {
+		if start, open := window.Opened(now); open && !start.Before(opened) {
+			opened = start
+			status.Active = agent.Spec.Schedules[idx].Name
+			status.Size = agent.Spec.Schedules[idx].Size
+		}
+		if change := window.NextChange(now); !change.IsZero() && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	if !next.IsZero() {
+		status.NextChange = &metav1.Time{Time: next}
+	}
+
+	// A size written while the window is open, e.g. by an autoscaler
+	// through the scale subresource, wins until the window closes
+	previous := agent.Status.Schedule
+	if status.Active != "" && agent.Spec.Zones == nil {
+		size := agent.Spec.Size
+		status.SpecSize = &size
+		if previous != nil && previous.Active == status.Active && previous.SpecSize != nil {
+			status.SpecSize = previous.SpecSize
+		}
+		if *status.SpecSize != agent.Spec.Size {
+			status.Size = agent.Spec.Size
+		}
+	}
+	if previous == nil || previous.Active != status.Active || previous.Size != status.Size {
+		if status.Active != "" {
+			r.Recorder.Event(agent, corev1.EventTypeNormal, "ScheduleActive",
+				fmt.Sprintf("Schedule %s is active, size is %d", status.Active, status.Size))
+		} else if previous != nil && previous.Active != "" {
+			r.Recorder.Event(agent, corev1.EventTypeNormal, "ScheduleInactive",
+				fmt.Sprintf("No schedule is active, size is %d", status.Size))
+		}
+	}
+	agent.Status.Schedule = status
+
+	if next.IsZero() {
+		return 0, true, nil
+	}
+	return next.Sub(now), true, nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// Secrets managed through GitOps, e.g. SealedSecrets or SOPS, only appear
+// once their controller decrypted them, possibly after the HomeAgent. Until
+// every Secret the agent mounts exists with its keys, the workload is left
+// alone and the SecretsResolved condition names what is missing; pods
+// would hang in ContainerCreating otherwise. The Secret watch brings the
+// agent back once they materialize.
+const secretRefsField = ".spec.secretRefs"
+
+// secretReference is a Secret the agent mounts, with the keys it reads.
+type secretReference struct {
+	name string
+	keys []string
+}
+
+// secretReferences returns the Secrets the agent mounts which are not
+// created by the operator.
+func secretReferences(agent *prairiev1.HomeAgent) []secretReference {
+	refs := []secretReference{}
+	if ipsec := ipsecSpec(agent); ipsec != nil {
+		if ref := ipsec.PreSharedKeySecretRef; ref != nil {
+			refs = append(refs, secretReference{name: ref.Name, keys: []string{ref.Key}})
+		}
+		if ref := ipsec.CertificateSecretRef; ref != nil {
+			refs = append(refs, secretReference{name: ref.Name, keys: []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}})
+		}
+	}
+	if radius := radiusSpec(agent); radius != nil {
+		refs = append(refs, secretReference{name: radius.SecretRef.Name, keys: []string{radius.SecretRef.Key}})
+	}
+	if diameter := diameterSpec(agent); diameter != nil && diameter.TLSSecretRef != nil {
+		refs = append(refs, secretReference{name: diameter.TLSSecretRef.Name, keys: []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}})
+	}
+	if syncEnabled(agent) && agent.Spec.Redundancy.Sync.KeySecretRef != nil {
+		ref := agent.Spec.Redundancy.Sync.KeySecretRef
+		refs = append(refs, secretReference{name: ref.Name, keys: []string{ref.Key}})
+	}
+	if backup := agent.Spec.Backup; backup != nil && backup.Destination.ObjectStore != nil &&
+		backup.Destination.ObjectStore.CredentialsSecretRef != nil {
+		refs = append(refs, secretReference{name: backup.Destination.ObjectStore.CredentialsSecretRef.Name})
+	}
+	if logging := agent.Spec.Logging; logging != nil && logging.Output.CredentialsSecretRef != nil {
+		refs = append(refs, secretReference{name: logging.Output.CredentialsSecretRef.Name, keys: []string{"username", "password"}})
+	}
+	return refs
+}
+
+// secretNames indexes the agents by the Secrets they mount.
+func secretNames(agent *prairiev1.HomeAgent) []string {
+	names := []string{}
+	for _, ref := range secretReferences(agent) {
+		names = append(names, ref.name)
+	}
+	return names
+}
+
+// resolveSecrets checks the Secrets the agent mounts and records the
+// outcome in the SecretsResolved condition. resolved is false while one of
+// them or one of their keys is missing.
+func (r *HomeAgentReconciler) resolveSecrets(ctx context.Context, agent *prairiev1.HomeAgent) (resolved bool, err error) {
+	refs := secretReferences(agent)
+	if len(refs) == 0 {
+		if meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionSecretsResolved) != nil {
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionSecretsResolved)
+			return true, r.Status().Update(ctx, agent)
+		}
+		return true, nil
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionSecretsResolved,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Resolved",
+		Message: "Every referenced Secret exists",
+	}
+	missing := []string{}
+	missing_keys := []string{}
+	for _, ref := range refs {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Name: ref.name, Namespace: agent.Namespace}, secret)
+		if errors.IsNotFound(err) {
+			missing = append(missing, ref.name)
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, key := range ref.keys {
+			if _, ok := secret.Data[key]; !ok {
+				missing_keys = append(missing_keys, ref.name+"/"+key)
+			}
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SecretNotFound"
+		condition.Message = fmt.Sprintf("Waiting for Secrets %s", strings.Join(missing, ", "))
+	case len(missing_keys) > 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SecretKeyNotFound"
+		condition.Message = fmt.Sprintf("Waiting for Secret keys %s", strings.Join(missing_keys, ", "))
+	}
+
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionSecretsResolved)
+	if current == nil || current.Status != condition.Status || current.Reason != condition.Reason || current.Message != condition.Message {
+		meta.SetStatusCondition(&agent.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, agent); err != nil {
+			return false, err
+		}
+	}
+	return condition.Status == metav1.ConditionTrue, nil
+}
+
+// agentsOfSecret maps a Secret to the HomeAgents mounting it, so agents
+// waiting for it proceed once it materializes.
+func (r *HomeAgentReconciler) agentsOfSecret(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{secretRefsField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "secret", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// reconcileService manages the Service fronting the replicas. It only exists
+// while an anycast address is configured, which it carries as external IP so
+// that traffic sent to the anycast address reaches whichever replica is
+// closest.
+func (r *HomeAgentReconciler) reconcileService(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	service := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, service)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if agent.Spec.Discovery == nil || agent.Spec.Discovery.AnycastAddress == "" {
+		if exists && metav1.IsControlledBy(service, agent) {
+			log.FromContext(ctx).Info("Anycast address removed, deleting service.")
+			if err := r.Delete(ctx, service); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		}
+		agent.Status.ServiceName = ""
+		return nil
+	}
+
+	desired := r.CreateService(agent)
+	stale := exists && dropExternalDNSAnnotations(service, desired.Annotations)
+	if !exists {
+		if err := ctrl.SetControllerReference(agent, desired, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Service created.", "anycast", agent.Spec.Discovery.AnycastAddress)
+	} else if stale || !equality.Semantic.DeepDerivative(desired.Spec, service.Spec) || metadataChanged(service, desired.Labels, desired.Annotations) {
+		service.Spec.ExternalIPs = desired.Spec.ExternalIPs
+		service.Spec.Ports = desired.Spec.Ports
+		service.Spec.Selector = desired.Spec.Selector
+		setMetadata(service, desired.Labels, desired.Annotations)
+		if err := r.Update(ctx, service); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Service updated.", "anycast", agent.Spec.Discovery.AnycastAddress)
+	}
+
+	agent.Status.ServiceName = desired.Name
+	return nil
+}
+
+func (r *HomeAgentReconciler) CreateService(agent *prairiev1.HomeAgent) *corev1.Service {
+	labels := componentLabels(agent, componentDiscovery)
+	single_stack := corev1.IPFamilyPolicySingleStack
+
+	// Only the active replica holds the address in ActiveStandby mode
+	selector := map[string]string{
+		"parent": agent.Name,
+	}
+	if activeStandby(agent) {
+		selector[roleLabel] = roleActive
+	}
+
+	annotations := commonAnnotations(agent)
+	for key, value := range externalDNSAnnotations(agent) {
+		annotations[key] = value
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        agent.Name,
+			Namespace:   agent.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector:       selector,
+			IPFamilies:     []corev1.IPFamily{corev1.IPv6Protocol},
+			IPFamilyPolicy: &single_stack,
+			ExternalIPs:    []string{agent.Spec.Discovery.AnycastAddress},
+			Ports: []corev1.ServicePort{
+				{
+					Name:     "registration",
+					Protocol: corev1.ProtocolUDP,
+					Port:     registrationPort,
+				},
+			},
+		},
+	}
+}
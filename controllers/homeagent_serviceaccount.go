@@ -0,0 +1,245 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/rbac"
+)
+
+// The operator only grants rules it holds itself, those of the allowlist
+// ClusterRole, so it needs neither escalate nor bind.
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=get;list;watch
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// serviceAccountName returns the ServiceAccount the agent pods run as.
+func serviceAccountName(agent *prairiev1.HomeAgent) string {
+	if agent.Spec.ServiceAccount != nil && agent.Spec.ServiceAccount.Name != "" {
+		return agent.Spec.ServiceAccount.Name
+	}
+	return agent.Name
+}
+
+func apiRules(agent *prairiev1.HomeAgent) []rbacv1.PolicyRule {
+	if agent.Spec.ServiceAccount == nil {
+		return nil
+	}
+	return agent.Spec.ServiceAccount.Rules
+}
+
+// checkAPIRules makes sure the agent only grants rules of the allowlist
+// ClusterRole. Without one no rules can be granted.
+func (r *HomeAgentReconciler) checkAPIRules(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	rules := apiRules(agent)
+	if len(rules) == 0 {
+		return true, nil
+	}
+	if r.AgentRulesClusterRole == "" {
+		return false, r.markDegraded(ctx, agent, "RulesNotAllowed",
+			"API rules can't be granted, the operator has no allowlist of rules")
+	}
+
+	allowlist := &rbacv1.ClusterRole{}
+	err := r.Get(ctx, types.NamespacedName{Name: r.AgentRulesClusterRole}, allowlist)
+	if errors.IsNotFound(err) {
+		return false, r.markDegraded(ctx, agent, "RulesNotAllowed",
+			fmt.Sprintf("API rules can't be granted, the allowlist ClusterRole %s doesn't exist", r.AgentRulesClusterRole))
+	}
+	if err != nil {
+		return false, err
+	}
+	if uncovered := rbac.Uncovered(allowlist.Rules, rules); len(uncovered) > 0 {
+		return false, r.markDegraded(ctx, agent, "RulesNotAllowed",
+			fmt.Sprintf("The allowlist ClusterRole %s doesn't grant %s", r.AgentRulesClusterRole, strings.Join(uncovered, ", ")))
+	}
+	return true, nil
+}
+
+// automountToken only mounts an API token into pods which use it.
+func automountToken(agent *prairiev1.HomeAgent) *bool {
+	automount := len(apiRules(agent)) > 0 ||
+		(agent.Spec.ServiceAccount != nil && agent.Spec.ServiceAccount.Name != "")
+	return &automount
+}
+
+// reconcileServiceAccount manages the ServiceAccount of the agent pods, and
+// the Role and RoleBinding granting them API access if rules are given. A
+// Role or RoleBinding of the name of the agent it doesn't control is left
+// alone and marks the agent degraded, the result is false then.
+func (r *HomeAgentReconciler) reconcileServiceAccount(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	labels := componentLabels(agent, componentRBAC)
+
+	account := &corev1.ServiceAccount{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, account)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	owned := err == nil && metav1.IsControlledBy(account, agent)
+
+	if serviceAccountName(agent) != agent.Name {
+		if owned {
+			log.FromContext(ctx).Info("External service account used, deleting service account.")
+			if err := r.Delete(ctx, account); err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+	} else if errors.IsNotFound(err) {
+		account = &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        agent.Name,
+				Namespace:   agent.Namespace,
+				Labels:      labels,
+				Annotations: commonAnnotations(agent),
+			},
+		}
+		if err := ctrl.SetControllerReference(agent, account, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, account); err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("Service account created.")
+	}
+	agent.Status.ServiceAccountName = serviceAccountName(agent)
+
+	role := &rbacv1.Role{}
+	err = r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, role)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if len(apiRules(agent)) == 0 {
+		if exists && metav1.IsControlledBy(role, agent) {
+			log.FromContext(ctx).Info("API access revoked, deleting role.")
+			binding := &rbacv1.RoleBinding{}
+			err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, binding)
+			if err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+			if err == nil && metav1.IsControlledBy(binding, agent) {
+				if err := r.Delete(ctx, binding); err != nil && !errors.IsNotFound(err) {
+					return false, err
+				}
+			}
+			if err := r.Delete(ctx, role); err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		agent.Status.RoleName = ""
+		return true, nil
+	}
+	if exists && !metav1.IsControlledBy(role, agent) {
+		message := fmt.Sprintf("Role %s exists and isn't managed by the HomeAgent", role.Name)
+		return false, r.markDegraded(ctx, agent, "RBACConflict", message)
+	}
+
+	if !exists {
+		role = &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        agent.Name,
+				Namespace:   agent.Namespace,
+				Labels:      labels,
+				Annotations: commonAnnotations(agent),
+			},
+			Rules: apiRules(agent),
+		}
+		if err := ctrl.SetControllerReference(agent, role, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, role); err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("Role created.")
+	} else if !equality.Semantic.DeepEqual(role.Rules, apiRules(agent)) || metadataChanged(role, labels, commonAnnotations(agent)) {
+		role.Rules = apiRules(agent)
+		setMetadata(role, labels, commonAnnotations(agent))
+		if err := r.Update(ctx, role); err != nil {
+			return false, err
+		}
+		log.FromContext(ctx).Info("Role updated.")
+	}
+
+	bound, err := r.reconcileRoleBinding(ctx, agent, labels)
+	if err != nil || !bound {
+		return false, err
+	}
+	agent.Status.RoleName = agent.Name
+	return true, nil
+}
+
+// reconcileRoleBinding binds the Role of the agent to its ServiceAccount.
+func (r *HomeAgentReconciler) reconcileRoleBinding(ctx context.Context, agent *prairiev1.HomeAgent, labels map[string]string) (bool, error) {
+	subjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      serviceAccountName(agent),
+		Namespace: agent.Namespace,
+	}}
+
+	binding := &rbacv1.RoleBinding{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, binding)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		if !metav1.IsControlledBy(binding, agent) {
+			message := fmt.Sprintf("RoleBinding %s exists and isn't managed by the HomeAgent", binding.Name)
+			return false, r.markDegraded(ctx, agent, "RBACConflict", message)
+		}
+		if equality.Semantic.DeepEqual(binding.Subjects, subjects) && !metadataChanged(binding, labels, commonAnnotations(agent)) {
+			return true, nil
+		}
+		binding.Subjects = subjects
+		setMetadata(binding, labels, commonAnnotations(agent))
+		return true, r.Update(ctx, binding)
+	}
+
+	binding = &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        agent.Name,
+			Namespace:   agent.Namespace,
+			Labels:      labels,
+			Annotations: commonAnnotations(agent),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     agent.Name,
+		},
+		Subjects: subjects,
+	}
+	if err := ctrl.SetControllerReference(agent, binding, r.Scheme); err != nil {
+		return false, err
+	}
+	log.FromContext(ctx).Info("Role binding created.")
+	return true, r.Create(ctx, binding)
+}
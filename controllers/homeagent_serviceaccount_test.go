@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+var _ = Describe("HomeAgent RBAC", func() {
+	var (
+		reconciler *HomeAgentReconciler
+		agent      *prairiev1.HomeAgent
+	)
+	rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(prairiev1.AddToScheme(scheme)).To(Succeed())
+		agent = &prairiev1.HomeAgent{
+			ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "default", UID: "ha-uid"},
+			Spec:       prairiev1.HomeAgentSpec{ServiceAccount: &prairiev1.ServiceAccountSpec{Rules: rules}},
+		}
+		reconciler = &HomeAgentReconciler{
+			Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(agent.DeepCopy()).Build(),
+			Scheme:   scheme,
+			Recorder: record.NewFakeRecorder(10),
+		}
+		Expect(reconciler.Get(context.Background(), client.ObjectKeyFromObject(agent), agent)).To(Succeed())
+	})
+
+	expectConflict := func() {
+		condition := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionDegraded)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("RBACConflict"))
+	}
+
+	It("creates the Role and RoleBinding of the agent", func() {
+		ctx := context.Background()
+		Expect(reconciler.reconcileServiceAccount(ctx, agent)).To(BeTrue())
+
+		binding := &rbacv1.RoleBinding{}
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(agent), binding)).To(Succeed())
+		Expect(metav1.IsControlledBy(binding, agent)).To(BeTrue())
+		Expect(agent.Status.RoleName).To(Equal("ha"))
+	})
+
+	It("leaves a Role it doesn't control alone", func() {
+		ctx := context.Background()
+		role := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "default"},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}},
+		}
+		Expect(reconciler.Create(ctx, role)).To(Succeed())
+
+		Expect(reconciler.reconcileServiceAccount(ctx, agent)).To(BeFalse())
+		expectConflict()
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(role), role)).To(Succeed())
+		Expect(role.Rules[0].Resources).To(Equal([]string{"secrets"}))
+	})
+
+	It("leaves a RoleBinding it doesn't control alone", func() {
+		ctx := context.Background()
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "default"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "ha"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "mo-daemon", Namespace: "default"}},
+		}
+		Expect(reconciler.Create(ctx, binding)).To(Succeed())
+
+		Expect(reconciler.reconcileServiceAccount(ctx, agent)).To(BeFalse())
+		expectConflict()
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(binding), binding)).To(Succeed())
+		Expect(binding.Subjects[0].Name).To(Equal("mo-daemon"))
+
+		agent.Spec.ServiceAccount.Rules = nil
+		Expect(reconciler.reconcileServiceAccount(ctx, agent)).To(BeTrue())
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(binding), binding)).To(Succeed())
+	})
+})
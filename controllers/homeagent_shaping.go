@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// mo-daemon shapes the tunneled traffic of every mobile node with token
+// buckets, given by their rate in bits per second and their burst in bytes:
+//
+//	shaping_node_rate_bps = 10000000
+//	shaping_node_burst_bytes = 125000
+//	shaping_class_rates = bulk:rate_bps=2000000;burst_bytes=25000
+
+// minBurst is the smallest bucket passing a full-sized packet.
+const minBurst = 1500
+
+// bucket returns the rate and burst of a rate limit. The burst defaults to
+// 100ms of traffic at the rate.
+func bucket(limit *prairiev1.RateLimit) (int64, int64) {
+	rate := limit.Rate.Value()
+	if limit.Burst != nil {
+		return rate, limit.Burst.Value()
+	}
+	burst := rate / 80
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return rate, burst
+}
+
+// validateRateLimit checks that a rate limit lets traffic through.
+func validateRateLimit(limit *prairiev1.RateLimit) error {
+	rate, burst := bucket(limit)
+	if rate <= 0 {
+		return fmt.Errorf("rate %s is not positive", limit.Rate.String())
+	}
+	if burst < minBurst {
+		return fmt.Errorf("burst %s is smaller than a full-sized packet", limit.Burst.String())
+	}
+	return nil
+}
+
+// shapingSettings renders spec.shaping.
+func shapingSettings(agent *prairiev1.HomeAgent) []setting {
+	shaping := agent.Spec.Shaping
+	if shaping == nil {
+		return nil
+	}
+	settings := []setting{}
+	if shaping.PerNode != nil {
+		rate, burst := bucket(shaping.PerNode)
+		settings = append(settings,
+			setting{Key: "shaping_node_rate_bps", Value: strconv.FormatInt(rate, 10)},
+			setting{Key: "shaping_node_burst_bytes", Value: strconv.FormatInt(burst, 10)})
+	}
+	if len(shaping.Classes) > 0 {
+		classes := make([]string, 0, len(shaping.Classes))
+		for _, class := range shaping.Classes {
+			rate, burst := bucket(&class.RateLimit)
+			classes = append(classes, fmt.Sprintf("%s:rate_bps=%d;burst_bytes=%d", class.Class, rate, burst))
+		}
+		settings = append(settings, setting{Key: "shaping_class_rates", Value: strings.Join(classes, ",")})
+	}
+	return settings
+}
+
+// validateShaping checks the rate limits of the agent, and that the classes
+// they apply to are traffic classes of spec.qos.
+func validateShaping(agent *prairiev1.HomeAgent) error {
+	shaping := agent.Spec.Shaping
+	if shaping == nil {
+		return nil
+	}
+	if shaping.PerNode != nil {
+		if err := validateRateLimit(shaping.PerNode); err != nil {
+			return fmt.Errorf("per-node rate limit: %w", err)
+		}
+	}
+
+	classes := map[string]bool{}
+	if agent.Spec.QoS != nil {
+		for _, rule := range agent.Spec.QoS.Rules {
+			classes[rule.Name] = true
+		}
+	}
+	limited := map[string]bool{}
+	for _, class := range shaping.Classes {
+		if !classes[class.Class] {
+			return fmt.Errorf("traffic class %s is not a rule of spec.qos", class.Class)
+		}
+		if limited[class.Class] {
+			return fmt.Errorf("traffic class %s is limited twice", class.Class)
+		}
+		limited[class.Class] = true
+		if err := validateRateLimit(&class.RateLimit); err != nil {
+			return fmt.Errorf("rate limit of traffic class %s: %w", class.Class, err)
+		}
+	}
+	return nil
+}
+
+// checkShaping marks the agent degraded if its rate limits are invalid.
+func (r *HomeAgentReconciler) checkShaping(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if err := validateShaping(agent); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidShaping", err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// With SPIFFE enabled mo-daemon fetches its SVID from the SPIRE agent
+// socket mounted into the pod, serves the management API over mutual TLS
+// and authenticates the binding synchronization with it. Health, readiness
+// and the drain are served on a plain HTTP port for the kubelet. The pods
+// are annotated, so the operator picks the SPIFFE client to talk to them.
+const (
+	defaultSPIFFESocket      = "/run/spire/sockets/agent.sock"
+	spiffeMountPath          = "/run/spire/sockets"
+	spiffeVolume             = "spire-agent-socket"
+	controlChannelAnnotation = "prairie.kismi/control-channel"
+	controlChannelSPIFFE     = "spiffe"
+
+	// spiffeRetry is how soon an agent the operator has no usable SVID
+	// for is looked at again.
+	spiffeRetry = 30 * time.Second
+)
+
+func spiffeSpec(agent *prairiev1.HomeAgent) *prairiev1.SPIFFESpec {
+	if agent.Spec.Security == nil {
+		return nil
+	}
+	return agent.Spec.Security.SPIFFE
+}
+
+func spiffeSocket(spiffe *prairiev1.SPIFFESpec) string {
+	if spiffe.SocketPath != "" {
+		return spiffe.SocketPath
+	}
+	return defaultSPIFFESocket
+}
+
+// spiffeSettings points mo-daemon at the SPIRE agent and switches the
+// control channel and the synchronization to SVIDs.
+func spiffeSettings(agent *prairiev1.HomeAgent) []setting {
+	spiffe := spiffeSpec(agent)
+	if spiffe == nil {
+		return nil
+	}
+
+	settings := []setting{
+		{Key: "spiffe_socket", Value: path.Join(spiffeMountPath, path.Base(spiffeSocket(spiffe)))},
+		{Key: "spiffe_trust_domain", Value: spiffe.TrustDomain},
+		{Key: "mgmt_auth", Value: "spiffe"},
+		{Key: "health_port", Value: strconv.Itoa(daemon.HealthPort)},
+	}
+	if spiffe.OperatorID != "" {
+		settings = append(settings, setting{Key: "mgmt_allowed_id", Value: spiffe.OperatorID})
+	}
+	if syncEnabled(agent) {
+		settings = append(settings, setting{Key: "sync_auth", Value: "spiffe"})
+	}
+	return settings
+}
+
+// spiffeTemplate mounts the SPIRE agent socket and marks the pods.
+func spiffeTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	spiffe := spiffeSpec(agent)
+	if spiffe == nil {
+		return
+	}
+
+	volume := corev1.Volume{Name: spiffeVolume}
+	if spiffe.CSIDriver != "" {
+		read_only := true
+		volume.VolumeSource = corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{Driver: spiffe.CSIDriver, ReadOnly: &read_only},
+		}
+	} else {
+		directory := corev1.HostPathDirectory
+		volume.VolumeSource = corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: path.Dir(spiffeSocket(spiffe)), Type: &directory},
+		}
+	}
+	template.Spec.Volumes = append(template.Spec.Volumes, volume)
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      spiffeVolume,
+			MountPath: spiffeMountPath,
+			ReadOnly:  true,
+		})
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          "health",
+			ContainerPort: daemon.HealthPort,
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[controlChannelAnnotation] = controlChannelSPIFFE
+}
+
+// checkSPIFFE makes sure the operator can reach SPIFFE enabled agents: it
+// needs an SVID of the trust domain of the agent.
+func (r *HomeAgentReconciler) checkSPIFFE(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	spiffe := spiffeSpec(agent)
+	if spiffe == nil {
+		return true, nil
+	}
+	if r.SecureDaemon == nil || r.SPIFFESVIDDir == "" {
+		return false, r.markDegraded(ctx, agent, "SVIDUnavailable",
+			"The operator has no SVID to reach SPIFFE enabled agents, it is started without --spiffe-svid-dir")
+	}
+	if err := daemon.CheckSVID(r.SPIFFESVIDDir); err != nil {
+		return false, r.markDegraded(ctx, agent, "SVIDUnavailable",
+			fmt.Sprintf("The SVID of the operator can't be read from %s: %v", r.SPIFFESVIDDir, err))
+	}
+	if spiffe.TrustDomain != r.SPIFFETrustDomain {
+		return false, r.markDegraded(ctx, agent, "TrustDomainMismatch",
+			fmt.Sprintf("The agents are in trust domain %s, the operator only accepts trust domain %s",
+				spiffe.TrustDomain, r.SPIFFETrustDomain))
+	}
+	return true, nil
+}
+
+// daemonFor returns the client of the control channel of a replica. Without
+// a SPIFFE client the operator can't authenticate to SPIFFE enabled
+// replicas, they show up as unreachable. Calls changing the replica are
+// skipped in a dry run.
+func daemonFor(plain, secure daemon.Client, pod *corev1.Pod) daemon.Client {
+	if secure != nil && pod.Annotations[controlChannelAnnotation] == controlChannelSPIFFE {
+		return dryRunDaemon{Client: secure}
+	}
+	return dryRunDaemon{Client: plain}
+}
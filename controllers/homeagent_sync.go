@@ -0,0 +1,220 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+)
+
+// With sync enabled every replica replicates its bindings to all others on
+// the sync port, authenticated with a shared key. The peer list is part of
+// the rendered configuration and reloaded whenever replicas come and go.
+const (
+	defaultSyncPort = 8701
+	syncMountPath   = "/etc/mo-daemon/sync"
+	syncVolume      = "sync"
+	syncKey         = "key"
+)
+
+func syncEnabled(agent *prairiev1.HomeAgent) bool {
+	return agent.Spec.Redundancy != nil && agent.Spec.Redundancy.Sync != nil
+}
+
+// syncKeyRequired reports whether the replication is authenticated with a
+// shared key. With SPIFFE enabled the replicas use their SVIDs instead.
+func syncKeyRequired(agent *prairiev1.HomeAgent) bool {
+	return syncEnabled(agent) && spiffeSpec(agent) == nil
+}
+
+func syncName(agent *prairiev1.HomeAgent) string {
+	return agent.Name + "-sync"
+}
+
+func syncPort(agent *prairiev1.HomeAgent) int32 {
+	if agent.Spec.Redundancy.Sync.Port != 0 {
+		return agent.Spec.Redundancy.Sync.Port
+	}
+	return defaultSyncPort
+}
+
+// syncKeySelector returns the Secret key the replication is authenticated
+// with, either the configured or the generated one.
+func syncKeySelector(agent *prairiev1.HomeAgent) corev1.SecretKeySelector {
+	if ref := agent.Spec.Redundancy.Sync.KeySecretRef; ref != nil {
+		return *ref
+	}
+	return corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: syncName(agent)},
+		Key:                  syncKey,
+	}
+}
+
+// reconcileSyncKey generates the sync key Secret unless the agent brings
+// its own key, and removes it once it isn't used anymore.
+func (r *HomeAgentReconciler) reconcileSyncKey(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: syncName(agent), Namespace: agent.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if !syncKeyRequired(agent) || agent.Spec.Redundancy.Sync.KeySecretRef != nil {
+		if exists && metav1.IsControlledBy(secret, agent) {
+			log.FromContext(ctx).Info("Sync key no longer used, deleting secret.")
+			return client.IgnoreNotFound(r.Delete(ctx, secret))
+		}
+		return nil
+	}
+	if exists {
+		return nil
+	}
+
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        syncName(agent),
+			Namespace:   agent.Namespace,
+			Labels:      componentLabels(agent, componentSync),
+			Annotations: commonAnnotations(agent),
+		},
+		Data: map[string][]byte{syncKey: key},
+	}
+	if err := ctrl.SetControllerReference(agent, secret, r.Scheme); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Sync key created.")
+	return r.Create(ctx, secret)
+}
+
+// syncPeers lists the sync endpoints of the running replicas.
+func (r *HomeAgentReconciler) syncPeers(ctx context.Context, agent *prairiev1.HomeAgent) ([]string, error) {
+	if !syncEnabled(agent) {
+		return nil, nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return nil, err
+	}
+	peers := []string{}
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.PodIP == "" {
+			continue
+		}
+		peers = append(peers, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(syncPort(agent)))))
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+// syncSettings configures the replication. mo-daemon skips itself in the
+// peer list.
+func syncSettings(agent *prairiev1.HomeAgent) []setting {
+	if !syncEnabled(agent) {
+		return nil
+	}
+	settings := []setting{{Key: "sync_port", Value: strconv.Itoa(int(syncPort(agent)))}}
+	if syncKeyRequired(agent) {
+		settings = append(settings, setting{Key: "sync_key", Value: path.Join(syncMountPath, syncKeySelector(agent).Key)})
+	}
+	return settings
+}
+
+func peerSettings(peers []string) []setting {
+	if len(peers) == 0 {
+		return nil
+	}
+	return []setting{{Key: "sync_peers", Value: strings.Join(peers, ",")}}
+}
+
+// syncTemplate exposes the sync port and mounts the sync key.
+func syncTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	if !syncEnabled(agent) {
+		return
+	}
+
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].Ports = append(template.Spec.Containers[i].Ports, corev1.ContainerPort{
+			Name:          "sync",
+			ContainerPort: syncPort(agent),
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+	if !syncKeyRequired(agent) {
+		return
+	}
+
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: syncVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: syncKeySelector(agent).Name},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      syncVolume,
+			MountPath: syncMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// peerStatus translates the replication state reported by a replica,
+// naming the peers after their pods.
+func peerStatus(peers []daemon.PeerStats, names map[string]string) []prairiev1.PeerSyncStatus {
+	statuses := make([]prairiev1.PeerSyncStatus, 0, len(peers))
+	for _, peer := range peers {
+		host := peer.Address
+		if h, _, err := net.SplitHostPort(peer.Address); err == nil {
+			host = h
+		}
+		name, ok := names[host]
+		if !ok {
+			name = peer.Address
+		}
+		lag := metav1.Duration{Duration: time.Duration(peer.LagMillis) * time.Millisecond}
+		statuses = append(statuses, prairiev1.PeerSyncStatus{
+			Name:      name,
+			Connected: peer.Connected,
+			Lag:       &lag,
+		})
+	}
+	return statuses
+}
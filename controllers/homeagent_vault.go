@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/vault"
+)
+
+// vaultKeyStore keeps the keyring in a KV secret of Vault. Vault only holds
+// strings, the keys are stored base64 encoded.
+type vaultKeyStore struct {
+	client *vault.Client
+	spec   *prairiev1.VaultSpec
+}
+
+func (s *vaultKeyStore) Load(ctx context.Context) (map[string][]byte, error) {
+	data, err := s.client.Read(ctx, s.spec.Role, s.spec.Path)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	keyring := map[string][]byte{}
+	for name, value := range data {
+		if name == activeKeyFile {
+			keyring[name] = []byte(value)
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s in %s: %w", name, s.spec.Path, err)
+		}
+		keyring[name] = key
+	}
+	return keyring, nil
+}
+
+func (s *vaultKeyStore) Save(ctx context.Context, keyring map[string][]byte) error {
+	if audit.DryRun(ctx) {
+		log.FromContext(ctx).Info("Keyring would be written to Vault.", "path", s.spec.Path, "dryRun", true)
+		return nil
+	}
+	data := map[string]string{}
+	for name, value := range keyring {
+		if name == activeKeyFile {
+			data[name] = string(value)
+			continue
+		}
+		data[name] = base64.StdEncoding.EncodeToString(value)
+	}
+	return s.client.Write(ctx, s.spec.Role, s.spec.Path, data)
+}
+
+// checkVault makes sure the agent only keeps its keys under the Vault role
+// and path of its namespace.
+func (r *HomeAgentReconciler) checkVault(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if agent.Spec.Security == nil || agent.Spec.Security.Vault == nil {
+		return true, nil
+	}
+	spec := agent.Spec.Security.Vault
+	if err := vault.CheckScope(agent.Namespace, spec.Role, spec.Path); err != nil {
+		return false, r.markDegraded(ctx, agent, "InvalidVaultConfig", err.Error())
+	}
+	return true, nil
+}
+
+// keyStore returns where the keyring of the agent is kept. Switching an
+// agent to Vault moves its keyring from the Secret into Vault, so rotation
+// carries on with the same keys.
+func (r *HomeAgentReconciler) keyStore(ctx context.Context, agent *prairiev1.HomeAgent) (keyStore, error) {
+	secrets := &secretKeyStore{r: r, agent: agent}
+	spec := agent.Spec.Security.Vault
+	if spec == nil {
+		return secrets, nil
+	}
+	if r.Vault == nil {
+		return nil, fmt.Errorf("keys of %s are kept in Vault, but no Vault address is configured", agent.Name)
+	}
+
+	if err := vault.CheckScope(agent.Namespace, spec.Role, spec.Path); err != nil {
+		return nil, err
+	}
+
+	store := &vaultKeyStore{client: r.Vault, spec: spec}
+	keyring, err := store.Load(ctx)
+	if err != nil || keyring != nil {
+		return store, err
+	}
+	keyring, err = secrets.Load(ctx)
+	if err != nil || keyring == nil {
+		return store, err
+	}
+	if err := store.Save(ctx, keyring); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("Keyring moved to Vault.", "path", spec.Path)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: keyringName(agent), Namespace: agent.Namespace}}
+	if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	return store, nil
+}
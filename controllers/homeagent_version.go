@@ -0,0 +1,359 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// Release is a release of mo-daemon the operator can run.
+type Release struct {
+	// Version is the release, e.g. "1.4".
+	Version string `json:"version"`
+	// Image is the image of the release.
+	Image string `json:"image"`
+	// ArchitectureImages are the images of the release built for a single
+	// architecture, taken over Image for agents of that architecture.
+	ArchitectureImages *prairiev1.ArchitectureImages `json:"architectureImages,omitempty"`
+	// Features are the optional modules the image is built with, e.g.
+	// "diameter".
+	Features []string `json:"features,omitempty"`
+}
+
+// image returns the image of the release for agents of the architecture.
+func (r *Release) image(architecture prairiev1.Architecture) string {
+	if image := imageFor(r.ArchitectureImages, architecture); image != "" {
+		return image
+	}
+	return r.Image
+}
+
+func (r *Release) hasFeature(feature string) bool {
+	for _, known := range r.Features {
+		if known == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseCatalog lists the releases HomeAgents can select with
+// spec.version.
+type ReleaseCatalog []Release
+
+// defaultReleases are the releases of mo-daemon the operator was built for.
+var defaultReleases = ReleaseCatalog{
+	{Version: "1.2", Image: "kismi/mo-daemon:1.2.6"},
+	{Version: "1.3", Image: "kismi/mo-daemon:1.3.4"},
+	{Version: "1.4", Image: "kismi/mo-daemon:1.4.1", Features: []string{diameterFeature}},
+}
+
+// LoadReleaseCatalog reads a catalog from a YAML file, a list of releases
+// with their version and image.
+func LoadReleaseCatalog(path string) (ReleaseCatalog, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var catalog ReleaseCatalog
+	if err := yaml.UnmarshalStrict(content, &catalog); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, release := range catalog {
+		if _, err := parseVersion(release.Version); err != nil || release.Image == "" {
+			return nil, fmt.Errorf("%s: invalid release %q", path, release.Version)
+		}
+	}
+	return catalog, nil
+}
+
+func (c ReleaseCatalog) find(version string) *Release {
+	for idx := range c {
+		if c[idx].Version == version {
+			return &c[idx]
+		}
+	}
+	return nil
+}
+
+// byImage returns the release of an image, if it is in the catalog.
+func (c ReleaseCatalog) byImage(image string) *Release {
+	for idx := range c {
+		images := c[idx].ArchitectureImages
+		if c[idx].Image == image || (images != nil && (images.AMD64 == image || images.ARM64 == image)) {
+			return &c[idx]
+		}
+	}
+	return nil
+}
+
+// versionOf returns the version of the release of an image, if it is in
+// the catalog.
+func (c ReleaseCatalog) versionOf(image string) string {
+	if release := c.byImage(image); release != nil {
+		return release.Version
+	}
+	return ""
+}
+
+// upgrades returns the releases newer than version, oldest first.
+func (c ReleaseCatalog) upgrades(version string) []string {
+	var newer []string
+	for _, release := range c {
+		if compareVersions(release.Version, version) > 0 {
+			newer = append(newer, release.Version)
+		}
+	}
+	sort.Slice(newer, func(i, j int) bool { return compareVersions(newer[i], newer[j]) < 0 })
+	return newer
+}
+
+func (c ReleaseCatalog) versions() []string {
+	versions := make([]string, len(c))
+	for idx, release := range c {
+		versions[idx] = release.Version
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+	return versions
+}
+
+// parseVersion reads a version like "1.4" or "1.4.1".
+func parseVersion(version string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	numbers := make([]int, len(parts))
+	for idx, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		numbers[idx] = number
+	}
+	return numbers, nil
+}
+
+// compareVersions orders two versions, invalid ones first.
+func compareVersions(a, b string) int {
+	left, err := parseVersion(a)
+	if err != nil {
+		left = []int{-1}
+	}
+	right, err := parseVersion(b)
+	if err != nil {
+		right = []int{-1}
+	}
+	for idx := 0; idx < len(left) || idx < len(right); idx++ {
+		var l, r int
+		if idx < len(left) {
+			l = left[idx]
+		}
+		if idx < len(right) {
+			r = right[idx]
+		}
+		if l != r {
+			if l < r {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// settingChange is a difference of the configuration schema between
+// releases of mo-daemon. Settings are rendered for the newest release and
+// translated for older ones.
+type settingChange struct {
+	Key string
+	// Since is the first release knowing the setting by Key.
+	Since string
+	// Before is the name of the setting in older releases, older releases
+	// don't support the setting if empty.
+	Before string
+}
+
+var settingChanges = []settingChange{
+	{Key: "redundancy", Since: "1.3"},
+	{Key: "tunnel_encap", Since: "1.3", Before: "tunnel_mode"},
+	{Key: "ipsec_ike_proposals", Since: "1.3"},
+	{Key: "ipsec_esp_proposals", Since: "1.3"},
+	{Key: "ipsec_lifetime_s", Since: "1.4", Before: "ipsec_lifetime"},
+	{Key: "mgmt_auth", Since: "1.4"},
+	{Key: "mgmt_allowed_id", Since: "1.4"},
+	{Key: "spiffe_socket", Since: "1.4"},
+	{Key: "spiffe_trust_domain", Since: "1.4"},
+	{Key: "sync_auth", Since: "1.4"},
+	{Key: "mode", Since: "1.4"},
+	{Key: "lma_address", Since: "1.4"},
+	{Key: "lma_mag_peers", Since: "1.4"},
+	{Key: "mip4", Since: "1.4"},
+	{Key: "mip4_home_network", Since: "1.4"},
+	{Key: "mip4_reverse_tunnel", Since: "1.4"},
+	{Key: "mip4_max_lifetime_s", Since: "1.4"},
+	{Key: "mip6", Since: "1.4"},
+	{Key: "mip6_max_lifetime_s", Since: "1.4"},
+	{Key: "ra_prefixes", Since: "1.4"},
+	{Key: "ndp_proxy", Since: "1.4"},
+	{Key: "ndp_proxy_interface", Since: "1.4"},
+	{Key: "ndp_proxy_unsolicited", Since: "1.4"},
+	{Key: "arp_proxy", Since: "1.4"},
+	{Key: "arp_proxy_interface", Since: "1.4"},
+	{Key: "arp_proxy_gratuitous", Since: "1.4"},
+	{Key: "qos_dscp_mode", Since: "1.4"},
+	{Key: "qos_dscp", Since: "1.4"},
+	{Key: "qos_dscp_rules", Since: "1.4"},
+	{Key: "shaping_node_rate_bps", Since: "1.4"},
+	{Key: "shaping_node_burst_bytes", Since: "1.4"},
+	{Key: "shaping_class_rates", Since: "1.4"},
+	{Key: "firewall_default", Since: "1.4"},
+	{Key: "firewall_rules", Since: "1.4"},
+	{Key: "radius_servers", Since: "1.4"},
+	{Key: "radius_secret", Since: "1.4"},
+	{Key: "radius_timeout_ms", Since: "1.4"},
+	{Key: "radius_retries", Since: "1.4"},
+	{Key: "radius_realms", Since: "1.4"},
+	{Key: "radius_strip_realm", Since: "1.4"},
+	{Key: "diameter_peers", Since: "1.4"},
+	{Key: "diameter_origin_realm", Since: "1.4"},
+	{Key: "diameter_dest_realm", Since: "1.4"},
+	{Key: "diameter_tls_cert", Since: "1.4"},
+	{Key: "diameter_tls_key", Since: "1.4"},
+	{Key: "diameter_tls_ca", Since: "1.4"},
+	{Key: "log_file", Since: "1.4"},
+	{Key: "log_file_max_size", Since: "1.4"},
+	{Key: "log_file_max_files", Since: "1.4"},
+	{Key: "log_stdout", Since: "1.4"},
+}
+
+// releaseSettings translates the settings for a release of mo-daemon. An
+// empty version is the newest release.
+func releaseSettings(version string, settings []setting) []setting {
+	if version == "" {
+		return settings
+	}
+	translated := make([]setting, 0, len(settings))
+	for _, setting := range settings {
+		for _, change := range settingChanges {
+			if change.Key == setting.Key && compareVersions(version, change.Since) < 0 && change.Before != "" {
+				setting.Key = change.Before
+			}
+		}
+		translated = append(translated, setting)
+	}
+	return translated
+}
+
+// unsupportedSettings returns the settings a release of mo-daemon doesn't
+// know.
+func unsupportedSettings(version string, settings []setting) []string {
+	var unsupported []string
+	if version == "" {
+		return unsupported
+	}
+	for _, setting := range settings {
+		for _, change := range settingChanges {
+			if change.Key == setting.Key && compareVersions(version, change.Since) < 0 && change.Before == "" {
+				unsupported = append(unsupported, fmt.Sprintf("%s (since %s)", setting.Key, change.Since))
+			}
+		}
+	}
+	return unsupported
+}
+
+func (r *HomeAgentReconciler) releases() ReleaseCatalog {
+	if r.Releases != nil {
+		return r.Releases
+	}
+	return defaultReleases
+}
+
+// resolveVersion selects the image of the release in spec.version, unless
+// the agent sets its image. Unknown releases leave the workload untouched
+// and mark the agent Degraded.
+func (r *HomeAgentReconciler) resolveVersion(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) (bool, error) {
+	if rendered.Spec.Version == "" {
+		return true, nil
+	}
+	release := r.releases().find(rendered.Spec.Version)
+	if release == nil {
+		message := fmt.Sprintf("Version %s is not in the release catalog, known releases are %s",
+			rendered.Spec.Version, strings.Join(r.releases().versions(), ", "))
+		return false, r.markDegraded(ctx, agent, "UnknownVersion", message)
+	}
+	if agent.Spec.Image == "" {
+		rendered.Spec.Image = release.image(rendered.Spec.Architecture)
+	}
+	return true, nil
+}
+
+// checkSettings makes sure the release in spec.version supports every
+// setting of the agent.
+func (r *HomeAgentReconciler) checkSettings(ctx context.Context, agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) (bool, error) {
+	unsupported := unsupportedSettings(rendered.Spec.Version, agentSettings(rendered, network))
+	if len(unsupported) == 0 {
+		return true, nil
+	}
+	message := fmt.Sprintf("Version %s doesn't support the settings %s",
+		rendered.Spec.Version, strings.Join(unsupported, ", "))
+	return false, r.markDegraded(ctx, agent, "UnsupportedVersion", message)
+}
+
+// markDegraded marks the agent Degraded for a reason which keeps the
+// operator from touching the workload.
+func (r *HomeAgentReconciler) markDegraded(ctx context.Context, agent *prairiev1.HomeAgent, reason, message string) error {
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionDegraded)
+	if current != nil && current.Status == condition.Status && current.Message == condition.Message &&
+		agent.Status.ObservedGeneration == agent.Generation {
+		return nil
+	}
+	r.Recorder.Event(agent, corev1.EventTypeWarning, condition.Reason, condition.Message)
+	meta.SetStatusCondition(&agent.Status.Conditions, condition)
+	markStalled(agent, reason, message)
+	return r.Status().Update(ctx, agent)
+}
+
+// setVersionStatus reports the image and release the replicas run and the
+// newer releases of the catalog.
+func (r *HomeAgentReconciler) setVersionStatus(agent *prairiev1.HomeAgent, rendered *prairiev1.HomeAgent) {
+	agent.Status.Image = agentImage(rendered)
+	version := rendered.Spec.Version
+	if version == "" {
+		version = r.releases().versionOf(agentImage(rendered))
+	}
+	agent.Status.Version = version
+	agent.Status.AvailableUpgrades = nil
+	if version != "" {
+		agent.Status.AvailableUpgrades = r.releases().upgrades(version)
+	}
+}
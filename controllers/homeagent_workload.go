@@ -0,0 +1,177 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The replicas of a HomeAgent run as a Deployment. With persistence enabled
+// they run as a StatefulSet instead, so every replica keeps the binding
+// database in its own PersistentVolumeClaim across restarts.
+const (
+	stateMountPath = "/var/lib/mo-daemon"
+	stateVolume    = "state"
+	bindingDBFile  = stateMountPath + "/bindings.db"
+)
+
+// parentField indexes pods by their parent label, so listing the replicas
+// of an agent only touches its own pods. The index is registered by the
+// HomeAgent controller and used by every controller talking to replicas.
+const parentField = ".metadata.labels.parent"
+
+// emptyWorkload returns an empty object of the workload kind the agent runs as.
+func emptyWorkload(agent *prairiev1.HomeAgent) client.Object {
+	if agent.Spec.Persistence != nil {
+		return &appsv1.StatefulSet{}
+	}
+	return &appsv1.Deployment{}
+}
+
+// CreateWorkload renders the workload the agent runs as.
+func (r *HomeAgentReconciler) CreateWorkload(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) client.Object {
+	if agent.Spec.Persistence != nil {
+		return r.CreateStatefulSet(agent, network)
+	}
+	return r.CreateDeployment(agent, network)
+}
+
+func (r *HomeAgentReconciler) CreateStatefulSet(agent *prairiev1.HomeAgent, network *prairiev1.PrairieNetwork) *appsv1.StatefulSet {
+	deployment := r.CreateDeployment(agent, network)
+	template := deployment.Spec.Template
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == logShipperContainer {
+			continue
+		}
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      stateVolume,
+			MountPath: stateMountPath,
+		})
+	}
+
+	stateful_set := &appsv1.StatefulSet{
+		ObjectMeta: deployment.ObjectMeta,
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    deployment.Spec.Replicas,
+			Selector:    deployment.Spec.Selector,
+			ServiceName: agent.Name,
+			// Replicas are independent of each other, there's no need to
+			// start them one by one.
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			Template:            template,
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: stateVolume,
+					},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						StorageClassName: agent.Spec.Persistence.StorageClassName,
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceStorage: agent.Spec.Persistence.Size,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	stateful_set.Annotations = commonAnnotations(agent)
+	stateful_set.Annotations[templateHashAnnotation] = templateHash(&stateful_set.Spec.Template)
+	return stateful_set
+}
+
+// workloadReplicas returns the desired number of replicas of a workload.
+func workloadReplicas(workload client.Object) *int32 {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return w.Spec.Replicas
+	case *appsv1.Deployment:
+		return w.Spec.Replicas
+	}
+	return nil
+}
+
+func setWorkloadReplicas(workload client.Object, replicas *int32) {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		w.Spec.Replicas = replicas
+	case *appsv1.Deployment:
+		w.Spec.Replicas = replicas
+	}
+}
+
+// workloadTemplate returns the pod template of a workload.
+func workloadTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	}
+	return nil
+}
+
+func readyReplicas(workload client.Object) int32 {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return w.Status.ReadyReplicas
+	case *appsv1.Deployment:
+		return w.Status.ReadyReplicas
+	}
+	return 0
+}
+
+// ordinal returns the ordinal of a StatefulSet replica, -1 for other pods.
+func ordinal(pod *corev1.Pod) int {
+	idx := strings.LastIndex(pod.Name, "-")
+	if idx < 0 {
+		return -1
+	}
+	n, err := strconv.Atoi(pod.Name[idx+1:])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// deleteStaleWorkload removes the workload of the kind the agent doesn't run
+// as anymore after persistence was switched on or off. The volume claims
+// of a removed StatefulSet are kept, they are reused if persistence is
+// switched on again.
+func (r *HomeAgentReconciler) deleteStaleWorkload(ctx context.Context, agent *prairiev1.HomeAgent) error {
+	var stale client.Object = &appsv1.StatefulSet{}
+	if agent.Spec.Persistence != nil {
+		stale = &appsv1.Deployment{}
+	}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Name, Namespace: agent.Namespace}, stale)
+	if err != nil || !managedWorkload(stale, agent.Name) {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, stale))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// backupRetry is how long a backup waits for a running replica.
+const backupRetry = 10 * time.Second
+
+// HomeAgentBackupReconciler reconciles a HomeAgentBackup object
+type HomeAgentBackupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Daemon daemon.Client
+	// SecureDaemon talks to SPIFFE enabled replicas, if configured.
+	SecureDaemon daemon.Client
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=homeagentbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=homeagentbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=homeagentbackups/finalizers,verbs=update
+
+// Reconcile takes the backup once: every running replica of the HomeAgent
+// writes a snapshot to the backup destination of the agent. A replica
+// failing to do so fails the whole backup, as it would restore incomplete.
+func (r *HomeAgentBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	backup := &prairiev1.HomeAgentBackup{}
+	err := r.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if backup.Status.Phase == prairiev1.BackupCompleted || backup.Status.Phase == prairiev1.BackupFailed {
+		return ctrl.Result{}, nil
+	}
+
+	agent := &prairiev1.HomeAgent{}
+	err = r.Get(ctx, types.NamespacedName{Name: backup.Spec.HomeAgentRef.Name, Namespace: backup.Namespace}, agent)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.setFailed(ctx, backup, fmt.Sprintf("HomeAgent %s does not exist", backup.Spec.HomeAgentRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	if agent.Spec.Backup == nil {
+		return ctrl.Result{}, r.setFailed(ctx, backup, fmt.Sprintf("HomeAgent %s has no backup destination", agent.Name))
+	}
+
+	pods := &corev1.PodList{}
+	err = r.List(ctx, pods, client.InNamespace(agent.Namespace), client.MatchingFields{parentField: agent.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	snapshots := []prairiev1.Snapshot{}
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		result, err := daemonFor(r.Daemon, r.SecureDaemon, &pod).Backup(ctx, pod.Status.PodIP, backupLocation(agent, backup.Name, pod.Name))
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Replica could not be backed up.", "pod", pod.Name, "backup", backup.Name)
+			return ctrl.Result{}, r.setFailed(ctx, backup, fmt.Sprintf("Replica %s could not be backed up: %v", pod.Name, err))
+		}
+		snapshots = append(snapshots, prairiev1.Snapshot{
+			Replica:  pod.Name,
+			Location: result.Location,
+			Bindings: result.Bindings,
+		})
+	}
+	if len(snapshots) == 0 {
+		log.FromContext(ctx).Info("No replica is running, requeueing...", "backup", backup.Name)
+		backup.Status.Phase = prairiev1.BackupPending
+		return ctrl.Result{RequeueAfter: backupRetry}, r.Status().Update(ctx, backup)
+	}
+
+	now := metav1.Now()
+	backup.Status.Phase = prairiev1.BackupCompleted
+	backup.Status.Snapshots = snapshots
+	backup.Status.CompletionTime = &now
+	backup.Status.Message = ""
+	log.FromContext(ctx).Info("Backup completed.", "backup", backup.Name, "replicas", len(snapshots))
+	return ctrl.Result{}, r.Status().Update(ctx, backup)
+}
+
+func (r *HomeAgentBackupReconciler) setFailed(ctx context.Context, backup *prairiev1.HomeAgentBackup, message string) error {
+	backup.Status.Phase = prairiev1.BackupFailed
+	backup.Status.Message = message
+	return r.Status().Update(ctx, backup)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HomeAgentBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.HomeAgentBackup{}).
+		Complete(metrics.NewReconciler("HomeAgentBackup", r))
+}
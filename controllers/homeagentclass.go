@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const (
+	defaultImage   = "kismi/mo-daemon:latest"
+	classNameField = ".spec.className"
+)
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=homeagentclasses,verbs=get;list;watch
+
+// GetClass returns the HomeAgentClass the agent refers to, or nil if it does
+// not use one.
+func (r *HomeAgentReconciler) GetClass(ctx context.Context, agent *prairiev1.HomeAgent) (*prairiev1.HomeAgentClass, error) {
+	if agent.Spec.ClassName == "" {
+		return nil, nil
+	}
+
+	class := &prairiev1.HomeAgentClass{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Spec.ClassName}, class)
+	if err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+// withClassDefaults returns a copy of the agent in which every setting the
+// agent leaves empty is taken from the class. The copy is only used for
+// rendering, the defaults are never written back to the HomeAgent.
+func withClassDefaults(agent *prairiev1.HomeAgent, class *prairiev1.HomeAgentClass) *prairiev1.HomeAgent {
+	rendered := agent.DeepCopy()
+	if class == nil {
+		return rendered
+	}
+	spec := &rendered.Spec
+
+	if spec.Image == "" {
+		spec.Image = class.Spec.Image
+	}
+	if agent.Spec.Image == "" && spec.ArchitectureImages == nil && class.Spec.ArchitectureImages != nil {
+		spec.ArchitectureImages = class.Spec.ArchitectureImages.DeepCopy()
+	}
+	if spec.Resources == nil && class.Spec.Resources != nil {
+		spec.Resources = class.Spec.Resources.DeepCopy()
+	}
+	if spec.SecurityProfile == "" {
+		spec.SecurityProfile = class.Spec.SecurityProfile
+	}
+	if class.Spec.Tunnel != nil {
+		if spec.Tunnel == nil {
+			spec.Tunnel = &prairiev1.TunnelSpec{}
+		}
+		if spec.Tunnel.Encapsulation == "" {
+			spec.Tunnel.Encapsulation = class.Spec.Tunnel.Encapsulation
+		}
+		if spec.Tunnel.MTU == 0 {
+			spec.Tunnel.MTU = class.Spec.Tunnel.MTU
+		}
+	}
+	return rendered
+}
+
+func agentImage(agent *prairiev1.HomeAgent) string {
+	if agent.Spec.Image != "" {
+		return agent.Spec.Image
+	}
+	return defaultImage
+}
+
+func agentResources(agent *prairiev1.HomeAgent) corev1.ResourceRequirements {
+	if agent.Spec.Resources != nil {
+		return *agent.Spec.Resources
+	}
+	return corev1.ResourceRequirements{}
+}
+
+// agentSecurityContext translates the security profile and the container
+// security context of the agent into the container security context.
+// mo-daemon needs at least NET_ADMIN to manage its tunnel interfaces.
+func agentSecurityContext(agent *prairiev1.HomeAgent) *corev1.SecurityContext {
+	context := &corev1.SecurityContext{}
+	if agent.Spec.SecurityContext != nil && agent.Spec.SecurityContext.Container != nil {
+		context = agent.Spec.SecurityContext.Container.DeepCopy()
+	}
+	if context.Privileged == nil && agent.Spec.SecurityProfile == prairiev1.SecurityProfilePrivileged {
+		privileged := true
+		context.Privileged = &privileged
+	}
+	if context.Privileged != nil && *context.Privileged {
+		return context
+	}
+
+	if context.Capabilities == nil {
+		context.Capabilities = &corev1.Capabilities{}
+	}
+	for _, capability := range context.Capabilities.Add {
+		if capability == "NET_ADMIN" {
+			return context
+		}
+	}
+	context.Capabilities.Add = append([]corev1.Capability{"NET_ADMIN"}, context.Capabilities.Add...)
+	return context
+}
+
+// agentPodSecurityContext returns the pod security context of the agent.
+func agentPodSecurityContext(agent *prairiev1.HomeAgent) *corev1.PodSecurityContext {
+	if agent.Spec.SecurityContext == nil || agent.Spec.SecurityContext.Pod == nil {
+		return nil
+	}
+	return agent.Spec.SecurityContext.Pod.DeepCopy()
+}
+
+// agentsOfClass maps a HomeAgentClass to the HomeAgents using it, so changes
+// to the class are rolled out to them.
+func (r *HomeAgentReconciler) agentsOfClass(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents, client.MatchingFields{classNameField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "class", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
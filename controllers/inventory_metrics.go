@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// Phases of the objects in the inventory metrics. Kinds with a phase in
+// their status report it as is.
+const (
+	phasePending     = "Pending"
+	phaseReady       = "Ready"
+	phaseNotReady    = "NotReady"
+	phaseProgressing = "Progressing"
+	phaseDegraded    = "Degraded"
+)
+
+var (
+	resourcesDesc = prometheus.NewDesc("prairie_resources",
+		"Custom resources of the operator, broken down by kind, namespace and phase.", []string{"kind", "namespace", "phase"}, nil)
+	fleetReplicasDesc = prometheus.NewDesc("prairie_fleet_replicas",
+		"Replicas of the HomeAgents of a namespace, desired in their spec or ready.", []string{"namespace", "state"}, nil)
+)
+
+// InventoryCollector counts the custom resources of every kind of the
+// operator in the cache, so fleet dashboards don't need kube-state-metrics.
+type InventoryCollector struct {
+	reader  client.Reader
+	scheme  *runtime.Scheme
+	elected <-chan struct{}
+}
+
+// NewInventoryCollector returns a collector of the custom resources read
+// through reader. Like the controllers, which start the informers, it only
+// collects once elected is closed.
+func NewInventoryCollector(reader client.Reader, scheme *runtime.Scheme, elected <-chan struct{}) *InventoryCollector {
+	return &InventoryCollector{reader: reader, scheme: scheme, elected: elected}
+}
+
+func (c *InventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- resourcesDesc
+	ch <- fleetReplicasDesc
+}
+
+func (c *InventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	select {
+	case <-c.elected:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), homeAgentCollectTimeout)
+	defer cancel()
+	for gvk := range c.scheme.AllKnownTypes() {
+		if gvk.Group != prairiev1.GroupVersion.Group || !strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		obj, err := c.scheme.New(gvk)
+		if err != nil {
+			continue
+		}
+		list, ok := obj.(client.ObjectList)
+		if !ok || c.reader.List(ctx, list) != nil {
+			continue
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			continue
+		}
+
+		type key struct{ namespace, phase string }
+		counts := map[key]int{}
+		for _, item := range items {
+			if obj, ok := item.(client.Object); ok {
+				counts[key{obj.GetNamespace(), phaseOf(obj)}]++
+			}
+		}
+		kind := strings.TrimSuffix(gvk.Kind, "List")
+		for key, count := range counts {
+			ch <- prometheus.MustNewConstMetric(resourcesDesc, prometheus.GaugeValue, float64(count), kind, key.namespace, key.phase)
+		}
+
+		if agents, ok := list.(*prairiev1.HomeAgentList); ok {
+			collectFleetReplicas(ch, agents)
+		}
+	}
+}
+
+// collectFleetReplicas reports the desired and ready replicas of the
+// HomeAgents of every namespace.
+func collectFleetReplicas(ch chan<- prometheus.Metric, agents *prairiev1.HomeAgentList) {
+	desired := map[string]int32{}
+	ready := map[string]int32{}
+	for i := range agents.Items {
+		agent := &agents.Items[i]
+		desired[agent.Namespace] += replicaCount(agent)
+		ready[agent.Namespace] += reachableReplicas(agent)
+	}
+	for namespace := range desired {
+		ch <- prometheus.MustNewConstMetric(fleetReplicasDesc, prometheus.GaugeValue, float64(desired[namespace]), namespace, "desired")
+		ch <- prometheus.MustNewConstMetric(fleetReplicasDesc, prometheus.GaugeValue, float64(ready[namespace]), namespace, "ready")
+	}
+}
+
+// reachableReplicas returns the number of replicas answering on their
+// management API, which the operator only asks once they are ready.
+func reachableReplicas(agent *prairiev1.HomeAgent) int32 {
+	reachable := int32(0)
+	for _, replica := range agent.Status.Replicas {
+		if replica.Reachable {
+			reachable++
+		}
+	}
+	return reachable
+}
+
+// phaseOf returns the phase of a custom resource, empty for kinds without
+// one. The phase of most kinds follows their main condition.
+func phaseOf(obj client.Object) string {
+	switch obj := obj.(type) {
+	case *prairiev1.HomeAgent:
+		return agentPhase(obj)
+	case *prairiev1.BindingHandoff:
+		return statusPhase(string(obj.Status.Phase))
+	case *prairiev1.HomeAgentBackup:
+		return statusPhase(string(obj.Status.Phase))
+	case *prairiev1.AddressPool:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionReady)
+	case *prairiev1.BindingPolicy:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionReady)
+	case *prairiev1.Federation:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionReady)
+	case *prairiev1.PrairieNetwork:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionReady)
+	case *prairiev1.MobileNode:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionProvisioned)
+	case *prairiev1.MobilityDomain:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionPropagated)
+	case *prairiev1.CorrespondentNode:
+		return conditionPhase(obj.Status.Conditions, prairiev1.ConditionDistributed)
+	}
+	return ""
+}
+
+// agentPhase sums up the state of a HomeAgent: Pending until a replica
+// answers, Progressing until every replica does, then Ready. Degraded
+// while replicas are failing.
+func agentPhase(agent *prairiev1.HomeAgent) string {
+	reachable := reachableReplicas(agent)
+	switch {
+	case meta.IsStatusConditionTrue(agent.Status.Conditions, prairiev1.ConditionDegraded):
+		return phaseDegraded
+	case reachable >= replicaCount(agent):
+		return phaseReady
+	case reachable == 0:
+		return phasePending
+	}
+	return phaseProgressing
+}
+
+func statusPhase(phase string) string {
+	if phase == "" {
+		return phasePending
+	}
+	return phase
+}
+
+func conditionPhase(conditions []metav1.Condition, condition string) string {
+	found := meta.FindStatusCondition(conditions, condition)
+	switch {
+	case found == nil:
+		return phasePending
+	case found.Status == metav1.ConditionTrue:
+		return phaseReady
+	}
+	return phaseNotReady
+}
@@ -0,0 +1,443 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// A MobileAccessGateway runs mo-daemon in MAG mode on every access node it
+// selects, with host networking so the mobile nodes attached to the access
+// interface can be served. The address of the node is the proxy care-of
+// address of the gateway, the gateways register with the HomeAgent as
+// "<gateway>/<node>".
+const (
+	gatewayContainer = "mag"
+	// gatewayLabel selects the pods of a MobileAccessGateway.
+	gatewayLabel     = "prairie.kismi/gateway"
+	componentGateway = "access-gateway"
+)
+
+func gatewayName(gateway string) string {
+	return gateway + "-mag"
+}
+
+// MobileAccessGatewayReconciler reconciles a MobileAccessGateway object
+type MobileAccessGatewayReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobileaccessgateways,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobileaccessgateways/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobileaccessgateways/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile runs the gateways on the access nodes, pointed at the LMA
+// address of the HomeAgent, and reports the nodes running one. The
+// HomeAgent picks the nodes up from the status as its MAG peers.
+func (r *MobileAccessGatewayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	gateway := &prairiev1.MobileAccessGateway{}
+	err := r.Get(ctx, req.NamespacedName, gateway)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	agent := &prairiev1.HomeAgent{}
+	err = r.Get(ctx, types.NamespacedName{Name: gateway.Spec.HomeAgentRef.Name, Namespace: gateway.Namespace}, agent)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.setNotReady(ctx, gateway, "HomeAgentNotFound",
+				fmt.Sprintf("HomeAgent %s does not exist", gateway.Spec.HomeAgentRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	if !lmaMode(agent) || agent.Spec.LMA == nil {
+		// The running gateways are kept, the HomeAgent watch brings us back
+		return ctrl.Result{}, r.setNotReady(ctx, gateway, "NotLMAMode",
+			fmt.Sprintf("HomeAgent %s doesn't run in %s mode", agent.Name, prairiev1.ModeLMA))
+	}
+
+	if gatewayImage(gateway, agent) == "" {
+		// The HomeAgent watch brings us back once the image is rendered
+		return ctrl.Result{}, r.setNotReady(ctx, gateway, "ImageNotRendered",
+			fmt.Sprintf("HomeAgent %s hasn't rendered its image yet", agent.Name))
+	}
+
+	config := renderGatewayConfig(gateway, agent)
+	err = r.reconcileGatewayConfig(ctx, gateway, config)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Gateway configuration could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+	daemon_set, err := r.reconcileDaemonSet(ctx, gateway, agent, config)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Gateway DaemonSet could not be reconciled.")
+		return ctrl.Result{}, err
+	}
+
+	pods := &corev1.PodList{}
+	err = r.List(ctx, pods, client.InNamespace(gateway.Namespace), client.MatchingLabels{gatewayLabel: gateway.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	nodes := gatewayNodes(pods.Items)
+	ready := int32(0)
+	for _, node := range nodes {
+		if node.Ready {
+			ready++
+		}
+	}
+	gateway.Status.Nodes = nodes
+	gateway.Status.ReadyNodes = ready
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Succeeded",
+		Message: fmt.Sprintf("Gateways are ready on %d nodes", ready),
+	}
+	switch desired := daemon_set.Status.DesiredNumberScheduled; {
+	case desired == 0:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NoAccessNodes"
+		condition.Message = "No node matches the node selector"
+	case ready < desired:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Progressing"
+		condition.Message = fmt.Sprintf("Gateways are ready on %d of %d nodes", ready, desired)
+	}
+	condition.ObservedGeneration = gateway.Generation
+	meta.SetStatusCondition(&gateway.Status.Conditions, condition)
+	return ctrl.Result{}, r.Status().Update(ctx, gateway)
+}
+
+// setNotReady reports why the gateways can't be run.
+func (r *MobileAccessGatewayReconciler) setNotReady(ctx context.Context, gateway *prairiev1.MobileAccessGateway, reason, message string) error {
+	meta.SetStatusCondition(&gateway.Status.Conditions, metav1.Condition{
+		Type:               prairiev1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: gateway.Generation,
+	})
+	return r.Status().Update(ctx, gateway)
+}
+
+// gatewayNodes lists the nodes the gateway pods run on, sorted by name.
+// Pods which aren't bound to a node yet are left out.
+func gatewayNodes(pods []corev1.Pod) []prairiev1.GatewayNode {
+	nodes := []prairiev1.GatewayNode{}
+	for idx := range pods {
+		pod := &pods[idx]
+		if !pod.DeletionTimestamp.IsZero() || pod.Spec.NodeName == "" || pod.Status.HostIP == "" {
+			continue
+		}
+		nodes = append(nodes, prairiev1.GatewayNode{
+			Node:    pod.Spec.NodeName,
+			Address: pod.Status.HostIP,
+			Ready:   podReady(pod),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+// gatewayLabels returns the labels of the objects of the gateway.
+func gatewayLabels(gateway *prairiev1.MobileAccessGateway) map[string]string {
+	labels := operatorLabels()
+	labels[gatewayLabel] = gateway.Name
+	labels[nameLabel] = "mobileaccessgateway"
+	labels[componentLabel] = componentGateway
+	return labels
+}
+
+// renderGatewayConfig renders the configuration file of the gateways. The
+// name of the node and the proxy care-of address differ per node, mo-daemon
+// takes them from the environment.
+func renderGatewayConfig(gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent) string {
+	return fmt.Sprintf("# Rendered from MobileAccessGateway %s/%s, do not edit.\n", gateway.Namespace, gateway.Name) +
+		"mode = mag\n" +
+		fmt.Sprintf("mag_name = %s\n", gateway.Name) +
+		fmt.Sprintf("mag_access_interface = %s\n", gatewayInterface(gateway)) +
+		fmt.Sprintf("lma_address = %s\n", agent.Spec.LMA.Address)
+}
+
+func gatewayInterface(gateway *prairiev1.MobileAccessGateway) string {
+	if gateway.Spec.AccessInterface != "" {
+		return gateway.Spec.AccessInterface
+	}
+	return "eth0"
+}
+
+// gatewayImage is the image of the gateways. It defaults to the image the
+// replicas of the HomeAgent run, with class defaults, release, architecture
+// and the verified digest applied, rather than the one of its spec.
+func gatewayImage(gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent) string {
+	if gateway.Spec.Image != "" {
+		return gateway.Spec.Image
+	}
+	return agent.Status.Image
+}
+
+// gatewayPullSecrets pulls the image of the HomeAgent with its pull
+// secrets.
+func gatewayPullSecrets(gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent) []corev1.LocalObjectReference {
+	if gateway.Spec.Image != "" {
+		return nil
+	}
+	return agent.Spec.ImagePullSecrets
+}
+
+// gatewayNodeSelector keeps gateways running the image of the HomeAgent on
+// nodes of its architecture, the image may not run on others.
+func gatewayNodeSelector(gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent) map[string]string {
+	if gateway.Spec.Image != "" || agent.Spec.Architecture == "" {
+		return gateway.Spec.NodeSelector
+	}
+	selector := map[string]string{corev1.LabelArchStable: string(agent.Spec.Architecture)}
+	for key, value := range gateway.Spec.NodeSelector {
+		selector[key] = value
+	}
+	return selector
+}
+
+// gatewayReadinessProbe holds back a gateway until mo-daemon accepts
+// registrations. Gateways don't take the SPIFFE settings of the HomeAgent,
+// mo-daemon serves readiness on its management port on the host.
+func gatewayReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: readinessPath,
+				Port: intstr.FromInt(daemon.ManagementPort),
+			},
+		},
+		PeriodSeconds:    5,
+		FailureThreshold: 2,
+	}
+}
+
+// reconcileGatewayConfig creates or updates the ConfigMap of the gateways.
+func (r *MobileAccessGatewayReconciler) reconcileGatewayConfig(ctx context.Context, gateway *prairiev1.MobileAccessGateway, config string) error {
+	config_map := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Name: configName(gatewayName(gateway.Name)), Namespace: gateway.Namespace}, config_map)
+	if errors.IsNotFound(err) {
+		config_map = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      configName(gatewayName(gateway.Name)),
+				Namespace: gateway.Namespace,
+				Labels:    gatewayLabels(gateway),
+			},
+			Data: map[string]string{configFile: config},
+		}
+		if err := ctrl.SetControllerReference(gateway, config_map, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Gateway configuration created.", "hash", configHash(config))
+		return r.Create(ctx, config_map)
+	}
+	if err != nil {
+		return err
+	}
+
+	if config_map.Data[configFile] == config {
+		return nil
+	}
+	config_map.Data = map[string]string{configFile: config}
+	log.FromContext(ctx).Info("Gateway configuration updated.", "hash", configHash(config))
+	return r.Update(ctx, config_map)
+}
+
+// gatewayDaemonSet builds the DaemonSet running the gateways. The hash of
+// the configuration is stamped on the pod template, the gateways restart to
+// pick up a new one.
+func gatewayDaemonSet(gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent, config string) *appsv1.DaemonSet {
+	selector := map[string]string{gatewayLabel: gateway.Name}
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      selector,
+			Annotations: map[string]string{configHashAnnotation: configHash(config)},
+		},
+		Spec: corev1.PodSpec{
+			// The gateways serve the access interface of the node
+			HostNetwork:      true,
+			DNSPolicy:        corev1.DNSClusterFirstWithHostNet,
+			NodeSelector:     gatewayNodeSelector(gateway, agent),
+			Tolerations:      gateway.Spec.Tolerations,
+			ImagePullSecrets: gatewayPullSecrets(gateway, agent),
+			Containers: []corev1.Container{{
+				Name:  gatewayContainer,
+				Image: gatewayImage(gateway, agent),
+				// The gateway counts as ready, and a rollout proceeds, once
+				// mo-daemon accepts registrations
+				ReadinessProbe: gatewayReadinessProbe(),
+				Env: []corev1.EnvVar{
+					{
+						Name: "MO_MAG_NODE",
+						ValueFrom: &corev1.EnvVarSource{
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"},
+						},
+					},
+					{
+						Name: "MO_MAG_ADDRESS",
+						ValueFrom: &corev1.EnvVarSource{
+							FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"},
+						},
+					},
+					{Name: "MO_CONFIG", Value: configMountPath + "/" + configFile},
+				},
+				VolumeMounts: []corev1.VolumeMount{{
+					Name:      configVolume,
+					MountPath: configMountPath,
+					ReadOnly:  true,
+				}},
+				SecurityContext: &corev1.SecurityContext{
+					Capabilities: &corev1.Capabilities{
+						Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+					},
+				},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: configVolume,
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: configName(gatewayName(gateway.Name))},
+					},
+				},
+			}},
+		},
+	}
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        gatewayName(gateway.Name),
+			Namespace:   gateway.Namespace,
+			Labels:      gatewayLabels(gateway),
+			Annotations: map[string]string{templateHashAnnotation: templateHash(&template)},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: template,
+		},
+	}
+}
+
+// reconcileDaemonSet creates the DaemonSet of the gateways or updates its
+// pod template when it changed.
+func (r *MobileAccessGatewayReconciler) reconcileDaemonSet(ctx context.Context, gateway *prairiev1.MobileAccessGateway, agent *prairiev1.HomeAgent, config string) (*appsv1.DaemonSet, error) {
+	desired := gatewayDaemonSet(gateway, agent, config)
+	daemon_set := &appsv1.DaemonSet{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), daemon_set)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(gateway, desired, r.Scheme); err != nil {
+			return nil, err
+		}
+		log.FromContext(ctx).Info("Gateway DaemonSet created.", "daemonset", desired.Name)
+		return desired, r.Create(ctx, desired)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if daemon_set.Annotations[templateHashAnnotation] == desired.Annotations[templateHashAnnotation] {
+		return daemon_set, nil
+	}
+	if daemon_set.Annotations == nil {
+		daemon_set.Annotations = map[string]string{}
+	}
+	daemon_set.Annotations[templateHashAnnotation] = desired.Annotations[templateHashAnnotation]
+	daemon_set.Spec.Template = desired.Spec.Template
+	log.FromContext(ctx).Info("Gateway DaemonSet updated.", "daemonset", daemon_set.Name)
+	return daemon_set, r.Update(ctx, daemon_set)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MobileAccessGatewayReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.MobileAccessGateway{}, homeAgentRefField,
+		func(obj client.Object) []string {
+			return []string{obj.(*prairiev1.MobileAccessGateway).Spec.HomeAgentRef.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.MobileAccessGateway{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(gatewayOfPod)).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.gatewaysOfHomeAgent)).
+		Complete(metrics.NewReconciler("MobileAccessGateway", r))
+}
+
+// gatewayOfPod maps a gateway pod to its MobileAccessGateway, its node and
+// address only show in the pod.
+func gatewayOfPod(obj client.Object) []reconcile.Request {
+	gateway, ok := obj.GetLabels()[gatewayLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      gateway,
+		Namespace: obj.GetNamespace(),
+	}}}
+}
+
+// gatewaysOfHomeAgent maps a HomeAgent to the MobileAccessGateways
+// registering with it.
+func (r *MobileAccessGatewayReconciler) gatewaysOfHomeAgent(obj client.Object) []reconcile.Request {
+	gateways := &prairiev1.MobileAccessGatewayList{}
+	err := r.List(context.Background(), gateways,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{homeAgentRefField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "MobileAccessGateways could not be listed.", "homeagent", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(gateways.Items))
+	for idx, gateway := range gateways.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      gateway.Name,
+			Namespace: gateway.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,426 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+const (
+	subscriberFinalizer = "prairie.kismi/subscriber"
+	homeAgentRefField   = ".spec.homeAgentRef.name"
+	addressPoolRefField = ".spec.addressPoolRef.name"
+	authSecretRefField  = ".spec.authSecretRef.name"
+)
+
+// MobileNodeReconciler reconciles a MobileNode object
+type MobileNodeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilenodes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilenodes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilenodes/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile provisions the MobileNode as a subscriber into the subscriber
+// database of the referenced HomeAgent and removes it again on deletion.
+func (r *MobileNodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	node := &prairiev1.MobileNode{}
+	err := r.Get(ctx, req.NamespacedName, node)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !node.DeletionTimestamp.IsZero() {
+		if node.Status.HomeAgent != "" {
+			err = r.Deprovision(ctx, node, node.Status.HomeAgent)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		err = r.ReleaseHomeAddress(ctx, node)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(node, subscriberFinalizer)
+		return ctrl.Result{}, r.Update(ctx, node)
+	}
+
+	if controllerutil.AddFinalizer(node, subscriberFinalizer) {
+		err = r.Update(ctx, node)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The node was moved to another home agent, take it off the old one.
+	if node.Status.HomeAgent != "" && node.Status.HomeAgent != node.Spec.HomeAgentRef.Name {
+		err = r.Deprovision(ctx, node, node.Status.HomeAgent)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		node.Status.HomeAgent = ""
+	}
+
+	home_address, err := r.HomeAddress(ctx, node)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Home address could not be allocated.", "mobilenode", node.Name)
+		err = r.setPending(ctx, node, "AddressAllocationFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	node.Status.HomeAddress = home_address
+
+	home_agent := &prairiev1.HomeAgent{}
+	err = r.Get(ctx, types.NamespacedName{Name: node.Spec.HomeAgentRef.Name, Namespace: node.Namespace}, home_agent)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// We are notified once the home agent shows up.
+			return ctrl.Result{}, r.setPending(ctx, node, "HomeAgentNotFound",
+				fmt.Sprintf("HomeAgent %s does not exist", node.Spec.HomeAgentRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+
+	auth := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: node.Spec.AuthSecretRef.Name, Namespace: node.Namespace}, auth)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, r.setPending(ctx, node, "AuthSecretNotFound",
+				fmt.Sprintf("Secret %s does not exist", node.Spec.AuthSecretRef.Name))
+		}
+		return ctrl.Result{}, err
+	}
+	key, ok := auth.Data[node.Spec.AuthSecretRef.Key]
+	if !ok {
+		return ctrl.Result{}, r.setPending(ctx, node, "AuthKeyNotFound",
+			fmt.Sprintf("Secret %s has no key %s", auth.Name, node.Spec.AuthSecretRef.Key))
+	}
+
+	gre_key, err := r.Provision(ctx, node, home_agent, home_address, key)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Subscriber could not be provisioned.", "mobilenode", node.Name)
+		return ctrl.Result{}, err
+	}
+	node.Status.GREKey = gre_key
+
+	registered, err := r.Registered(ctx, node)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	node.Status.HomeAgent = home_agent.Name
+	node.Status.State = prairiev1.MobileNodeProvisioned
+	if registered {
+		node.Status.State = prairiev1.MobileNodeRegistered
+	}
+	meta.SetStatusCondition(&node.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionProvisioned,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Provisioned",
+		Message: fmt.Sprintf("Subscriber provisioned into HomeAgent %s", home_agent.Name),
+	})
+	return ctrl.Result{}, r.Status().Update(ctx, node)
+}
+
+func (r *MobileNodeReconciler) setPending(ctx context.Context, node *prairiev1.MobileNode, reason, message string) error {
+	node.Status.State = prairiev1.MobileNodePending
+	meta.SetStatusCondition(&node.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionProvisioned,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+	return r.Status().Update(ctx, node)
+}
+
+// Provision writes the subscriber entry of the node into the subscriber
+// database of the home agent, creating the database if necessary. It
+// returns the GRE key of the node if the home agent uses GRE encapsulation.
+func (r *MobileNodeReconciler) Provision(ctx context.Context, node *prairiev1.MobileNode, agent *prairiev1.HomeAgent, home_address string, key []byte) (int64, error) {
+	gre, err := r.greEncapsulation(ctx, agent)
+	if err != nil {
+		return 0, err
+	}
+
+	db := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: subscribersName(agent.Name), Namespace: agent.Namespace}, db)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	exists := err == nil
+
+	entry := subscriber{
+		HomeAddress: home_address,
+		Key:         key,
+		Services:    node.Spec.AllowedServices,
+	}
+	if gre {
+		entry.GREKey = allocateGREKey(db, node)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		db = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        subscribersName(agent.Name),
+				Namespace:   agent.Namespace,
+				Labels:      componentLabels(agent, componentSubscribers),
+				Annotations: commonAnnotations(agent),
+			},
+			Data: map[string][]byte{subscriberFile(node): data},
+		}
+		err = ctrl.SetControllerReference(agent, db, r.Scheme)
+		if err != nil {
+			return 0, err
+		}
+		return entry.GREKey, r.Create(ctx, db)
+	}
+
+	if bytes.Equal(db.Data[subscriberFile(node)], data) {
+		return entry.GREKey, nil
+	}
+	if db.Data == nil {
+		db.Data = map[string][]byte{}
+	}
+	db.Data[subscriberFile(node)] = data
+	setMetadata(db, componentLabels(agent, componentSubscribers), commonAnnotations(agent))
+	log.FromContext(ctx).Info("Provisioning subscriber.", "mobilenode", node.Name, "homeagent", agent.Name)
+	return entry.GREKey, r.Update(ctx, db)
+}
+
+// greEncapsulation reports whether the home agent tunnels with GRE, set by
+// itself or by its class.
+func (r *MobileNodeReconciler) greEncapsulation(ctx context.Context, agent *prairiev1.HomeAgent) (bool, error) {
+	if agent.Spec.Tunnel != nil && agent.Spec.Tunnel.Encapsulation != "" {
+		return agent.Spec.Tunnel.Encapsulation == prairiev1.TunnelGRE, nil
+	}
+	if agent.Spec.ClassName == "" {
+		return false, nil
+	}
+	class := &prairiev1.HomeAgentClass{}
+	err := r.Get(ctx, types.NamespacedName{Name: agent.Spec.ClassName}, class)
+	if err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return class.Spec.Tunnel != nil && class.Spec.Tunnel.Encapsulation == prairiev1.TunnelGRE, nil
+}
+
+// Deprovision removes the subscriber entry of the node from the subscriber
+// database of the named home agent, if it is still there.
+func (r *MobileNodeReconciler) Deprovision(ctx context.Context, node *prairiev1.MobileNode, agent string) error {
+	db := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: subscribersName(agent), Namespace: node.Namespace}, db)
+	if err != nil {
+		// The database went away together with its home agent
+		return client.IgnoreNotFound(err)
+	}
+
+	if _, ok := db.Data[subscriberFile(node)]; !ok {
+		return nil
+	}
+	delete(db.Data, subscriberFile(node))
+	log.FromContext(ctx).Info("Deprovisioning subscriber.", "mobilenode", node.Name, "homeagent", agent)
+	return r.Update(ctx, db)
+}
+
+// HomeAddress returns the home address of the node, allocating one from the
+// referenced AddressPool if the spec leaves it empty. A configured address
+// is recorded in the pool. An address held in a pool the node no longer
+// refers to is released.
+func (r *MobileNodeReconciler) HomeAddress(ctx context.Context, node *prairiev1.MobileNode) (string, error) {
+	if node.Status.AddressPool != "" && (node.Spec.AddressPoolRef == nil || node.Spec.AddressPoolRef.Name != node.Status.AddressPool) {
+		err := r.releaseAddressIn(ctx, node, node.Status.AddressPool)
+		if err != nil {
+			return "", err
+		}
+		node.Status.AddressPool = ""
+	}
+	if node.Spec.AddressPoolRef == nil {
+		if node.Spec.HomeAddress == "" {
+			return "", fmt.Errorf("neither homeAddress nor addressPoolRef is set")
+		}
+		return node.Spec.HomeAddress, nil
+	}
+
+	pool := &prairiev1.AddressPool{}
+	err := r.Get(ctx, types.NamespacedName{Name: node.Spec.AddressPoolRef.Name, Namespace: node.Namespace}, pool)
+	if err != nil {
+		return "", err
+	}
+	home_address := ""
+	if node.Spec.HomeAddress != "" {
+		home_address, err = claimAddress(ctx, r.Client, pool, node.Spec.HomeAddress, addressOwner(node))
+	} else {
+		home_address, err = allocateAddress(ctx, r.Client, pool, addressOwner(node))
+	}
+	if err != nil {
+		return "", err
+	}
+	node.Status.AddressPool = pool.Name
+	return home_address, nil
+}
+
+// ReleaseHomeAddress returns an allocated home address to its pool.
+func (r *MobileNodeReconciler) ReleaseHomeAddress(ctx context.Context, node *prairiev1.MobileNode) error {
+	if node.Status.AddressPool != "" {
+		return r.releaseAddressIn(ctx, node, node.Status.AddressPool)
+	}
+	// Nodes allocated from before the pool was recorded in the status
+	if node.Spec.AddressPoolRef == nil {
+		return nil
+	}
+	return r.releaseAddressIn(ctx, node, node.Spec.AddressPoolRef.Name)
+}
+
+func (r *MobileNodeReconciler) releaseAddressIn(ctx context.Context, node *prairiev1.MobileNode, name string) error {
+	pool := &prairiev1.AddressPool{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: node.Namespace}, pool)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return releaseAddress(ctx, r.Client, pool, addressOwner(node))
+}
+
+func addressOwner(node *prairiev1.MobileNode) string {
+	return "MobileNode/" + node.Name
+}
+
+// Registered reports whether the home agent of the node holds a binding for
+// its home address, according to the agent's BindingCache.
+func (r *MobileNodeReconciler) Registered(ctx context.Context, node *prairiev1.MobileNode) (bool, error) {
+	cache := &prairiev1.BindingCache{}
+	err := r.Get(ctx, types.NamespacedName{Name: node.Spec.HomeAgentRef.Name, Namespace: node.Namespace}, cache)
+	if err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	home_address := net.ParseIP(node.Status.HomeAddress)
+	for _, binding := range cache.Status.Bindings {
+		if home_address.Equal(net.ParseIP(binding.HomeAddress)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MobileNodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.MobileNode{}, homeAgentRefField,
+		func(obj client.Object) []string {
+			return []string{obj.(*prairiev1.MobileNode).Spec.HomeAgentRef.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.MobileNode{}, addressPoolRefField,
+		func(obj client.Object) []string {
+			ref := obj.(*prairiev1.MobileNode).Spec.AddressPoolRef
+			if ref == nil {
+				return nil
+			}
+			return []string{ref.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &prairiev1.MobileNode{}, authSecretRefField,
+		func(obj client.Object) []string {
+			return []string{obj.(*prairiev1.MobileNode).Spec.AuthSecretRef.Name}
+		})
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.MobileNode{}).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.nodesOfHomeAgent)).
+		Watches(&source.Kind{Type: &prairiev1.BindingCache{}}, handler.EnqueueRequestsFromMapFunc(r.nodesOfHomeAgent)).
+		Watches(&source.Kind{Type: &prairiev1.AddressPool{}}, handler.EnqueueRequestsFromMapFunc(r.nodesOfAddressPool)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.nodesOfSecret)).
+		Complete(metrics.NewReconciler("MobileNode", r))
+}
+
+// nodesOfHomeAgent maps a HomeAgent, or its BindingCache which shares its
+// name, to the MobileNodes referencing it. This way nodes waiting for their
+// home agent are provisioned once it appears, and registrations show up in
+// the status of the nodes.
+func (r *MobileNodeReconciler) nodesOfHomeAgent(obj client.Object) []reconcile.Request {
+	return r.nodesReferencing(obj, homeAgentRefField)
+}
+
+// nodesOfAddressPool maps an AddressPool to the MobileNodes allocating from
+// it, so nodes waiting for a pool to become ready get their address.
+func (r *MobileNodeReconciler) nodesOfAddressPool(obj client.Object) []reconcile.Request {
+	return r.nodesReferencing(obj, addressPoolRefField)
+}
+
+// nodesOfSecret maps a Secret to the MobileNodes authenticating with it,
+// so nodes waiting for their key, e.g. a SealedSecret yet to be decrypted,
+// are provisioned once it materializes and pick up a new key.
+func (r *MobileNodeReconciler) nodesOfSecret(obj client.Object) []reconcile.Request {
+	return r.nodesReferencing(obj, authSecretRefField)
+}
+
+func (r *MobileNodeReconciler) nodesReferencing(obj client.Object, field string) []reconcile.Request {
+	nodes := &prairiev1.MobileNodeList{}
+	err := r.List(context.Background(), nodes,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{field: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "MobileNodes could not be listed.", "object", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(nodes.Items))
+	for idx, node := range nodes.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// MobilityDomainReconciler reconciles a MobilityDomain object
+type MobilityDomainReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilitydomains,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilitydomains/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=mobilitydomains/finalizers,verbs=update
+
+// Reconcile propagates the defaults of the domain to the HomeAgents it
+// selects and records the members in the status.
+func (r *MobilityDomainReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	domain := &prairiev1.MobilityDomain{}
+	err := r.Get(ctx, req.NamespacedName, domain)
+	if errors.IsNotFound(err) {
+		// The members of a deleted domain keep their own settings only
+		_, err = r.reconcileMembers(ctx, req.Name, req.Namespace, labels.Nothing(), nil)
+		return ctrl.Result{}, err
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&domain.Spec.Selector)
+	if err != nil {
+		meta.SetStatusCondition(&domain.Status.Conditions, metav1.Condition{
+			Type:    prairiev1.ConditionPropagated,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidSelector",
+			Message: err.Error(),
+		})
+		return ctrl.Result{}, r.Status().Update(ctx, domain)
+	}
+
+	members, err := r.reconcileMembers(ctx, domain.Name, domain.Namespace, selector, domain)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	domain.Status.Members = members
+	meta.SetStatusCondition(&domain.Status.Conditions, metav1.Condition{
+		Type:    prairiev1.ConditionPropagated,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Propagated",
+		Message: fmt.Sprintf("Defaults propagated to %d members", len(members)),
+	})
+	return ctrl.Result{}, r.Status().Update(ctx, domain)
+}
+
+// reconcileMembers propagates the settings of the domain to the HomeAgents
+// the selector matches and releases the ones it claimed before but no
+// longer matches. It returns the names of the members.
+func (r *MobilityDomainReconciler) reconcileMembers(ctx context.Context, name, namespace string, selector labels.Selector, domain *prairiev1.MobilityDomain) ([]string, error) {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(ctx, agents, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	members := []string{}
+	for idx := range agents.Items {
+		agent := &agents.Items[idx]
+		owner, claimed := agent.Annotations[prairiev1.MobilityDomainAnnotation]
+		if claimed && owner != name {
+			if selector.Matches(labels.Set(agent.Labels)) {
+				log.FromContext(ctx).Info("HomeAgent already belongs to another domain.", "homeagent", agent.Name, "domain", owner)
+			}
+			continue
+		}
+
+		if !selector.Matches(labels.Set(agent.Labels)) {
+			if !claimed {
+				continue
+			}
+			releaseDomain(agent)
+			log.FromContext(ctx).Info("Releasing HomeAgent from domain.", "homeagent", agent.Name, "domain", name)
+		} else {
+			members = append(members, agent.Name)
+			if !propagateDomain(domain, agent) {
+				continue
+			}
+			log.FromContext(ctx).Info("Propagating domain defaults.", "homeagent", agent.Name, "domain", name)
+		}
+		err = r.Update(ctx, agent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+// domainFields returns the settings of the agent the domain set.
+func domainFields(agent *prairiev1.HomeAgent) map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range strings.Split(agent.Annotations[prairiev1.MobilityDomainFieldsAnnotation], ",") {
+		if field != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// setDomainFields records the settings of the agent the domain set.
+func setDomainFields(agent *prairiev1.HomeAgent, fields map[string]bool) {
+	names := []string{}
+	for field, set := range fields {
+		if set {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		delete(agent.Annotations, prairiev1.MobilityDomainFieldsAnnotation)
+		return
+	}
+	agent.Annotations[prairiev1.MobilityDomainFieldsAnnotation] = strings.Join(names, ",")
+}
+
+// propagateDomain sets the domain settings the agent leaves empty, or which
+// the domain set before, to the defaults of the domain. Settings of the
+// agent itself are kept. It reports whether the agent was changed.
+func propagateDomain(domain *prairiev1.MobilityDomain, agent *prairiev1.HomeAgent) bool {
+	before := agent.DeepCopy()
+	if agent.Annotations == nil {
+		agent.Annotations = map[string]string{}
+	}
+	agent.Annotations[prairiev1.MobilityDomainAnnotation] = domain.Name
+
+	owned := domainFields(agent)
+	if agent.Spec.Domain == nil {
+		agent.Spec.Domain = &prairiev1.DomainSettings{}
+	}
+	settings := agent.Spec.Domain
+	if settings.AuthRealm == "" || owned["authRealm"] {
+		settings.AuthRealm = domain.Spec.AuthRealm
+		owned["authRealm"] = domain.Spec.AuthRealm != ""
+	}
+	if settings.KeySecretRef == nil || owned["keySecretRef"] {
+		settings.KeySecretRef = domain.Spec.KeySecretRef.DeepCopy()
+		owned["keySecretRef"] = domain.Spec.KeySecretRef != nil
+	}
+	if len(settings.PrefixPools) == 0 || owned["prefixPools"] {
+		settings.PrefixPools = nil
+		if len(domain.Spec.PrefixPools) > 0 {
+			settings.PrefixPools = append([]string{}, domain.Spec.PrefixPools...)
+		}
+		owned["prefixPools"] = len(domain.Spec.PrefixPools) > 0
+	}
+	if agent.Spec.HandoverPolicyRef == nil || owned["handoverPolicyRef"] {
+		agent.Spec.HandoverPolicyRef = domain.Spec.HandoverPolicyRef.DeepCopy()
+		owned["handoverPolicyRef"] = domain.Spec.HandoverPolicyRef != nil
+	}
+	setDomainFields(agent, owned)
+	return !equality.Semantic.DeepEqual(before, agent)
+}
+
+// releaseDomain clears the settings the domain set on the agent and its
+// claim on it.
+func releaseDomain(agent *prairiev1.HomeAgent) {
+	owned := domainFields(agent)
+	if settings := agent.Spec.Domain; settings != nil {
+		if owned["authRealm"] {
+			settings.AuthRealm = ""
+		}
+		if owned["keySecretRef"] {
+			settings.KeySecretRef = nil
+		}
+		if owned["prefixPools"] {
+			settings.PrefixPools = nil
+		}
+		if equality.Semantic.DeepEqual(*settings, prairiev1.DomainSettings{}) {
+			agent.Spec.Domain = nil
+		}
+	}
+	if owned["handoverPolicyRef"] {
+		agent.Spec.HandoverPolicyRef = nil
+	}
+	delete(agent.Annotations, prairiev1.MobilityDomainAnnotation)
+	delete(agent.Annotations, prairiev1.MobilityDomainFieldsAnnotation)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MobilityDomainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.MobilityDomain{}).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.domainsOfHomeAgent)).
+		Complete(metrics.NewReconciler("MobilityDomain", r))
+}
+
+// domainsOfHomeAgent maps a HomeAgent to the domains selecting it, so new
+// members receive the defaults right away, and to the domain claiming it,
+// which releases it once it isn't selected anymore.
+func (r *MobilityDomainReconciler) domainsOfHomeAgent(obj client.Object) []reconcile.Request {
+	domains := &prairiev1.MobilityDomainList{}
+	err := r.List(context.Background(), domains, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "MobilityDomains could not be listed.")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	owner := obj.GetAnnotations()[prairiev1.MobilityDomainAnnotation]
+	if owner != "" {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      owner,
+			Namespace: obj.GetNamespace(),
+		}})
+	}
+	for _, domain := range domains.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&domain.Spec.Selector)
+		if err != nil || domain.Name == owner || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      domain.Name,
+			Namespace: domain.Namespace,
+		}})
+	}
+	return requests
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+const networkRefField = ".spec.networkRef.name"
+
+// validateNetwork checks the settings of a home network which the schema
+// cannot express.
+func validateNetwork(network *prairiev1.PrairieNetwork) error {
+	_, prefix, err := net.ParseCIDR(network.Spec.Prefix)
+	if err != nil {
+		return err
+	}
+	if network.Spec.Gateway != "" {
+		gateway := net.ParseIP(network.Spec.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("invalid gateway %q", network.Spec.Gateway)
+		}
+		if !prefix.Contains(gateway) {
+			return fmt.Errorf("gateway %s is not part of %s", gateway, prefix)
+		}
+	}
+	return nil
+}
+
+// attachNetwork resolves the PrairieNetwork of the agent and records the
+// outcome in the NetworkAttached condition. It returns nil if the agent does
+// not reference a network, attached is false if the reference can't be used.
+func (r *HomeAgentReconciler) attachNetwork(ctx context.Context, agent *prairiev1.HomeAgent) (network *prairiev1.PrairieNetwork, attached bool, err error) {
+	if agent.Spec.NetworkRef == nil {
+		if meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionNetworkAttached) != nil {
+			meta.RemoveStatusCondition(&agent.Status.Conditions, prairiev1.ConditionNetworkAttached)
+			return nil, true, r.Status().Update(ctx, agent)
+		}
+		return nil, true, nil
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionNetworkAttached,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Attached",
+		Message: fmt.Sprintf("Attached to PrairieNetwork %s", agent.Spec.NetworkRef.Name),
+	}
+	network = &prairiev1.PrairieNetwork{}
+	err = r.Get(ctx, types.NamespacedName{Name: agent.Spec.NetworkRef.Name, Namespace: agent.Namespace}, network)
+	if errors.IsNotFound(err) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NetworkNotFound"
+		condition.Message = fmt.Sprintf("PrairieNetwork %s does not exist", agent.Spec.NetworkRef.Name)
+	} else if err != nil {
+		return nil, false, err
+	} else if err := validateNetwork(network); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidNetwork"
+		condition.Message = err.Error()
+	}
+
+	current := meta.FindStatusCondition(agent.Status.Conditions, prairiev1.ConditionNetworkAttached)
+	if current == nil || current.Status != condition.Status || current.Reason != condition.Reason {
+		meta.SetStatusCondition(&agent.Status.Conditions, condition)
+		if err := r.Status().Update(ctx, agent); err != nil {
+			return nil, false, err
+		}
+	}
+	if condition.Status != metav1.ConditionTrue {
+		return nil, false, nil
+	}
+	return network, true, nil
+}
+
+// networkSettings translates the home network into settings of mo-daemon,
+// which sets up the home link and routes the prefix towards the tunnels.
+func networkSettings(network *prairiev1.PrairieNetwork) []setting {
+	if network == nil {
+		return nil
+	}
+
+	settings := []setting{{Key: "home_prefix", Value: network.Spec.Prefix}}
+	if network.Spec.VLAN != 0 {
+		settings = append(settings, setting{Key: "home_vlan", Value: strconv.Itoa(int(network.Spec.VLAN))})
+	}
+	if network.Spec.Gateway != "" {
+		settings = append(settings, setting{Key: "home_gateway", Value: network.Spec.Gateway})
+	}
+	if advertisement := network.Spec.Advertisement; advertisement != nil {
+		settings = append(settings, setting{Key: "ra", Value: "on"})
+		if advertisement.Interval != nil {
+			settings = append(settings, setting{Key: "ra_interval_ms", Value: milliseconds(advertisement.Interval)})
+		}
+		if advertisement.ValidLifetime != nil {
+			settings = append(settings, setting{Key: "ra_valid_lifetime_ms", Value: milliseconds(advertisement.ValidLifetime)})
+		}
+		if advertisement.PreferredLifetime != nil {
+			settings = append(settings, setting{Key: "ra_preferred_lifetime_ms", Value: milliseconds(advertisement.PreferredLifetime)})
+		}
+	}
+	return settings
+}
+
+// agentsOfNetwork maps a PrairieNetwork to the HomeAgents referencing it, so
+// they attach once it appears and pick up changes to it.
+func (r *HomeAgentReconciler) agentsOfNetwork(obj client.Object) []reconcile.Request {
+	agents := &prairiev1.HomeAgentList{}
+	err := r.List(context.Background(), agents,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{networkRefField: obj.GetName()})
+	if err != nil {
+		log.Log.Error(err, "HomeAgents could not be listed.", "network", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(agents.Items))
+	for idx, agent := range agents.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      agent.Name,
+			Namespace: agent.Namespace,
+		}}
+	}
+	return requests
+}
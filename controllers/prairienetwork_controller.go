@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// PrairieNetworkReconciler reconciles a PrairieNetwork object
+type PrairieNetworkReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=prairie.kismi,resources=prairienetworks,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=prairie.kismi,resources=prairienetworks/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prairie.kismi,resources=prairienetworks/finalizers,verbs=update
+
+// Reconcile validates the network and reports the HomeAgents attached to it.
+func (r *PrairieNetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	network := &prairiev1.PrairieNetwork{}
+	err := r.Get(ctx, req.NamespacedName, network)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:    prairiev1.ConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Valid",
+		Message: "Network can be attached",
+	}
+	if err := validateNetwork(network); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	err = r.List(ctx, agents, client.InNamespace(network.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	attached := []string{}
+	for _, agent := range agents.Items {
+		if agent.Spec.NetworkRef == nil || agent.Spec.NetworkRef.Name != network.Name {
+			continue
+		}
+		if meta.IsStatusConditionTrue(agent.Status.Conditions, prairiev1.ConditionNetworkAttached) {
+			attached = append(attached, agent.Name)
+		}
+	}
+
+	network.Status.HomeAgents = attached
+	meta.SetStatusCondition(&network.Status.Conditions, condition)
+	return ctrl.Result{}, r.Status().Update(ctx, network)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PrairieNetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&prairiev1.PrairieNetwork{}).
+		Watches(&source.Kind{Type: &prairiev1.HomeAgent{}}, handler.EnqueueRequestsFromMapFunc(r.networksOfNamespace)).
+		Complete(metrics.NewReconciler("PrairieNetwork", r))
+}
+
+// networksOfNamespace maps a HomeAgent to the PrairieNetworks of its
+// namespace, keeping the attachment state up to date when agents attach,
+// detach or change networks.
+func (r *PrairieNetworkReconciler) networksOfNamespace(obj client.Object) []reconcile.Request {
+	networks := &prairiev1.PrairieNetworkList{}
+	err := r.List(context.Background(), networks, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.Log.Error(err, "PrairieNetworks could not be listed.", "homeagent", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(networks.Items))
+	for idx, network := range networks.Items {
+		requests[idx] = reconcile.Request{NamespacedName: types.NamespacedName{
+			Name:      network.Name,
+			Namespace: network.Namespace,
+		}}
+	}
+	return requests
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/rightsizing"
+)
+
+//+kubebuilder:rbac:groups=metrics.k8s.io,resources=pods,verbs=list
+
+const (
+	// DefaultAnalysisWindow is how long the usage of the agents is
+	// considered for recommendations by default.
+	DefaultAnalysisWindow = 24 * time.Hour
+
+	// agentContainer is the name of the container running mo-daemon.
+	agentContainer = "ha"
+)
+
+// podMetricsKind is the usage of pods served by the metrics API.
+var podMetricsKind = schema.GroupVersionKind{Group: "metrics.k8s.io", Version: "v1beta1", Kind: "PodMetricsList"}
+
+// ResourceAnalyzer samples the usage of the agent containers from the
+// metrics API every interval and reports right-sizing recommendations for
+// them in the status of the HomeAgents, compared with spec.resources. The
+// samples are kept in memory, after a restart the recommendations start
+// over. It runs on the leader only.
+type ResourceAnalyzer struct {
+	Client   client.Client
+	Interval time.Duration
+	Window   time.Duration
+
+	recommender *rightsizing.Recommender
+}
+
+// Start analyzes every interval until the context is done. It makes the
+// ResourceAnalyzer a Runnable of the controller manager.
+func (a *ResourceAnalyzer) Start(ctx context.Context) error {
+	ctx = log.IntoContext(ctx, log.FromContext(ctx).WithName("resource-analyzer"))
+	// A recommendation needs an hour of samples at least
+	a.recommender = &rightsizing.Recommender{Window: a.Window, MinSamples: int(time.Hour / a.Interval)}
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := a.analyze(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Resource usage could not be analyzed.")
+		}
+	}
+}
+
+func (a *ResourceAnalyzer) analyze(ctx context.Context) error {
+	if err := a.sample(ctx); err != nil {
+		return err
+	}
+
+	agents := &prairiev1.HomeAgentList{}
+	if err := a.Client.List(ctx, agents); err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for idx := range agents.Items {
+		agent := &agents.Items[idx]
+		key := client.ObjectKeyFromObject(agent).String()
+		known[key] = true
+		if err := a.recommend(ctx, agent, key); err != nil {
+			log.FromContext(ctx).Error(err, "Recommendation could not be reported.", "homeagent", key)
+		}
+	}
+	for _, key := range a.recommender.Keys() {
+		if !known[key] {
+			a.recommender.Forget(key)
+		}
+	}
+	return nil
+}
+
+// sample records the usage of the agent container of every replica.
+func (a *ResourceAnalyzer) sample(ctx context.Context) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(podMetricsKind)
+	err := a.Client.List(ctx, list, client.HasLabels{"parent"})
+	if meta.IsNoMatchError(err) {
+		log.FromContext(ctx).Info("The metrics API is not available, no usage was sampled.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, item := range list.Items {
+		timestamp, _, _ := unstructured.NestedString(item.Object, "timestamp")
+		at, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			at = time.Now()
+		}
+		containers, _, _ := unstructured.NestedSlice(item.Object, "containers")
+		for _, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if !ok || container["name"] != agentContainer {
+				continue
+			}
+			cpu, _, _ := unstructured.NestedString(container, "usage", "cpu")
+			memory, _, _ := unstructured.NestedString(container, "usage", "memory")
+			sample := rightsizing.Sample{Time: at}
+			if sample.CPU, err = resource.ParseQuantity(cpu); err != nil {
+				continue
+			}
+			if sample.Memory, err = resource.ParseQuantity(memory); err != nil {
+				continue
+			}
+			agent := types.NamespacedName{Namespace: item.GetNamespace(), Name: item.GetLabels()["parent"]}
+			a.recommender.Add(agent.String(), sample)
+		}
+	}
+	return nil
+}
+
+// recommend reports the recommendation for the agent once it changed.
+func (a *ResourceAnalyzer) recommend(ctx context.Context, agent *prairiev1.HomeAgent, key string) error {
+	requests, samples, ok := a.recommender.Recommend(key)
+	if !ok {
+		return nil
+	}
+	current, err := a.agentRequests(ctx, agent)
+	if err != nil {
+		return err
+	}
+	recommendation := &prairiev1.ResourceRecommendation{
+		Requests: requests,
+		Samples:  int32(samples),
+		Time:     metav1.Now(),
+		Findings: rightsizing.Compare(current, requests),
+	}
+	if last := agent.Status.ResourceRecommendation; last != nil &&
+		equality.Semantic.DeepEqual(last.Requests, recommendation.Requests) &&
+		equality.Semantic.DeepEqual(last.Findings, recommendation.Findings) {
+		return nil
+	}
+
+	patch := client.MergeFrom(agent.DeepCopy())
+	agent.Status.ResourceRecommendation = recommendation
+	if err := a.Client.Status().Patch(ctx, agent, patch); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).Info("Resource recommendation updated.", "homeagent", key, "findings", recommendation.Findings)
+	return nil
+}
+
+// agentRequests returns the requests of the agent container, with those
+// of the class of the agent for defaults.
+func (a *ResourceAnalyzer) agentRequests(ctx context.Context, agent *prairiev1.HomeAgent) (corev1.ResourceList, error) {
+	var class *prairiev1.HomeAgentClass
+	if agent.Spec.ClassName != "" {
+		class = &prairiev1.HomeAgentClass{}
+		if err := a.Client.Get(ctx, types.NamespacedName{Name: agent.Spec.ClassName}, class); err != nil {
+			return nil, err
+		}
+	}
+	rendered := withClassDefaults(agent, class)
+	if rendered.Spec.Resources == nil {
+		return nil, nil
+	}
+	return rendered.Spec.Resources.Requests, nil
+}
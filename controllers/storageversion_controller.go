@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+)
+
+// migrationPageSize is the number of objects listed at once while
+// migrating.
+const migrationPageSize = 500
+
+// StorageVersionReconciler migrates the stored custom resources of the
+// operator to the storage version of their CRD after an upgrade, so older
+// versions can be dropped from the CRD.
+type StorageVersionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=get;update;patch
+
+// Reconcile rewrites every object of a CRD which still has objects stored
+// in another version than its storage version. Writing an object stores it
+// in the storage version, an empty patch is enough. Once every object is
+// rewritten, the other versions are removed from the stored versions.
+func (r *StorageVersionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	err := r.Get(ctx, req.NamespacedName, crd)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	storage := storageVersion(crd)
+	if storage == "" || migrated(crd, storage) {
+		return ctrl.Result{}, nil
+	}
+	log.FromContext(ctx).Info("Migrating stored objects.", "storedVersions", crd.Status.StoredVersions, "storageVersion", storage)
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   crd.Spec.Group,
+		Version: storage,
+		Kind:    crd.Spec.Names.ListKind,
+	})
+	migrated := 0
+	ctx = audit.WithReason(ctx, "StorageMigration")
+	for {
+		err = r.List(ctx, list, client.Limit(migrationPageSize), client.Continue(list.GetContinue()))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		for idx := range list.Items {
+			err = r.Patch(ctx, &list.Items[idx], client.RawPatch(types.MergePatchType, []byte("{}")))
+			if client.IgnoreNotFound(err) != nil {
+				log.FromContext(ctx).Error(err, "Object could not be migrated.", "object", client.ObjectKeyFromObject(&list.Items[idx]))
+				return ctrl.Result{}, err
+			}
+			migrated++
+		}
+		if list.GetContinue() == "" {
+			break
+		}
+	}
+
+	crd.Status.StoredVersions = []string{storage}
+	err = r.Status().Update(ctx, crd)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Stored versions could not be updated.")
+		return ctrl.Result{}, err
+	}
+	log.FromContext(ctx).Info("Stored objects migrated.", "objects", migrated, "storageVersion", storage)
+	return ctrl.Result{}, nil
+}
+
+func storageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name
+		}
+	}
+	return ""
+}
+
+// migrated reports whether every object is stored in the storage version.
+func migrated(crd *apiextensionsv1.CustomResourceDefinition, storage string) bool {
+	stored := crd.Status.StoredVersions
+	return len(stored) == 0 || (len(stored) == 1 && stored[0] == storage)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *StorageVersionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	operatorCRD := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return strings.HasSuffix(obj.GetName(), "."+prairiev1.GroupVersion.Group)
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiextensionsv1.CustomResourceDefinition{}, builder.WithPredicates(operatorCRD)).
+		Complete(metrics.NewReconciler("CustomResourceDefinition", r))
+}
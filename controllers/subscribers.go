@@ -0,0 +1,99 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+// The subscriber database is a Secret per HomeAgent holding one entry per
+// provisioned MobileNode. It is mounted into every replica, mo-daemon picks
+// up changes to it without a restart.
+const (
+	subscribersMountPath = "/etc/mo-daemon/subscribers"
+	subscribersVolume    = "subscribers"
+)
+
+// subscriber is the entry mo-daemon reads for every mobile node
+type subscriber struct {
+	HomeAddress string   `json:"homeAddress"`
+	Key         []byte   `json:"key"`
+	Services    []string `json:"services,omitempty"`
+	GREKey      int64    `json:"greKey,omitempty"`
+}
+
+func subscribersName(agent string) string {
+	return agent + "-subscribers"
+}
+
+func subscriberFile(node *prairiev1.MobileNode) string {
+	return node.Name + ".json"
+}
+
+// allocateGREKey returns the GRE key of the node in the subscriber database:
+// the key its entry holds already, else the one in its status if no other
+// subscriber holds it, else the lowest free key. Allocations are made with
+// the update of the database, a conflicting update fails the reconcile and
+// the allocation is retried on fresh state.
+func allocateGREKey(db *corev1.Secret, node *prairiev1.MobileNode) int64 {
+	used := map[int64]bool{}
+	for file, data := range db.Data {
+		entry := subscriber{}
+		if err := json.Unmarshal(data, &entry); err != nil || entry.GREKey == 0 {
+			continue
+		}
+		if file == subscriberFile(node) {
+			return entry.GREKey
+		}
+		used[entry.GREKey] = true
+	}
+	if node.Status.GREKey != 0 && !used[node.Status.GREKey] {
+		return node.Status.GREKey
+	}
+	key := int64(1)
+	for used[key] {
+		key++
+	}
+	return key
+}
+
+// subscribersTemplate mounts the subscriber database into the pod template.
+// The Secret is only created once the first MobileNode is provisioned, so
+// the volume is optional.
+func subscribersTemplate(agent *prairiev1.HomeAgent, template *corev1.PodTemplateSpec) {
+	optional := true
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: subscribersVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: subscribersName(agent.Name),
+				Optional:   &optional,
+			},
+		},
+	})
+	for i := range template.Spec.Containers {
+		template.Spec.Containers[i].VolumeMounts = append(template.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      subscribersVolume,
+			MountPath: subscribersMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+)
+
+// DefaultSweepInterval is how often orphaned objects are looked for by
+// default.
+const DefaultSweepInterval = time.Hour
+
+// OrphanSweeper deletes the Deployments, StatefulSets, Services and
+// ConfigMaps of HomeAgents which don't exist anymore. They are normally
+// removed when the HomeAgent is, by the operator or the garbage collector,
+// but objects of an agent deleted while the operator was down, or created
+// by versions of the operator which didn't set an owner, may be left
+// behind. It runs on the leader only.
+type OrphanSweeper struct {
+	Client   client.Client
+	Interval time.Duration
+}
+
+// Start sweeps right away, as orphans are most likely left behind while
+// the operator was down, and then every interval until the context is
+// done. It makes the OrphanSweeper a Runnable of the controller manager.
+func (s *OrphanSweeper) Start(ctx context.Context) error {
+	ctx = audit.WithReason(log.IntoContext(ctx, log.FromContext(ctx).WithName("sweeper")), "OrphanCleanup")
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.sweep(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Orphaned objects could not be swept.")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *OrphanSweeper) sweep(ctx context.Context) error {
+	deleted := 0
+	for _, list := range []client.ObjectList{
+		&appsv1.DeploymentList{}, &appsv1.StatefulSetList{}, &corev1.ServiceList{}, &corev1.ConfigMapList{},
+	} {
+		if err := s.Client.List(ctx, list); err != nil {
+			return err
+		}
+		objects, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range objects {
+			obj := item.(client.Object)
+			orphaned, err := s.orphaned(ctx, obj)
+			if err != nil {
+				return err
+			}
+			if !orphaned {
+				continue
+			}
+			uid := obj.GetUID()
+			if err := s.Client.Delete(ctx, obj, client.Preconditions{UID: &uid}); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			log.FromContext(ctx).Info("Orphaned object deleted.", "kind", kindOf(list), "object", client.ObjectKeyFromObject(obj))
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		log.FromContext(ctx).Info("Orphaned objects deleted.", "objects", deleted)
+	}
+	return nil
+}
+
+// orphaned reports whether the object was made for a HomeAgent which is
+// gone. Objects of other controllers are never orphaned.
+func (s *OrphanSweeper) orphaned(ctx context.Context, obj client.Object) (bool, error) {
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return false, nil
+	}
+	owner := metav1.GetControllerOf(obj)
+	name := ""
+	switch {
+	case owner != nil && owner.Kind == "HomeAgent" && owner.APIVersion == prairiev1.GroupVersion.String():
+		name = owner.Name
+	// Workloads of the first releases have no owner, and the parent label
+	// on their selector and pod template only
+	case owner == nil && len(obj.GetOwnerReferences()) == 0 && createdByOperator(obj, obj.GetName()):
+		name = obj.GetName()
+	default:
+		return false, nil
+	}
+
+	agent := &prairiev1.HomeAgent{}
+	err := s.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}, agent)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// A HomeAgent of the same name replaced the owner
+	return owner != nil && owner.UID != agent.UID, nil
+}
+
+func kindOf(list client.ObjectList) string {
+	switch list.(type) {
+	case *appsv1.DeploymentList:
+		return "Deployment"
+	case *appsv1.StatefulSetList:
+		return "StatefulSet"
+	case *corev1.ServiceList:
+		return "Service"
+	}
+	return "ConfigMap"
+}
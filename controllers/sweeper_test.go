@@ -0,0 +1,74 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+var _ = Describe("Orphan sweeper", func() {
+	var sweeper *OrphanSweeper
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(prairiev1.AddToScheme(scheme)).To(Succeed())
+		sweeper = &OrphanSweeper{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	})
+
+	It("deletes the workloads of the first releases of deleted agents", func() {
+		ctx := context.Background()
+		orphan := baselineDeployment("ha")
+		Expect(sweeper.Client.Create(ctx, orphan)).To(Succeed())
+		Expect(sweeper.orphaned(ctx, orphan)).To(BeTrue())
+
+		Expect(sweeper.sweep(ctx)).To(Succeed())
+		err := sweeper.Client.Get(ctx, client.ObjectKeyFromObject(orphan), &appsv1.Deployment{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("keeps the workloads of existing agents", func() {
+		ctx := context.Background()
+		agent := &prairiev1.HomeAgent{ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "default"}}
+		Expect(sweeper.Client.Create(ctx, agent)).To(Succeed())
+		workload := baselineDeployment("ha")
+		Expect(sweeper.Client.Create(ctx, workload)).To(Succeed())
+		Expect(sweeper.orphaned(ctx, workload)).To(BeFalse())
+	})
+
+	It("leaves workloads deployed by hand alone", func() {
+		ctx := context.Background()
+		workload := baselineDeployment("ha")
+		workload.Spec.Selector.MatchLabels = map[string]string{"app": "mo-daemon"}
+		workload.Spec.Template.Labels = map[string]string{"app": "mo-daemon"}
+		Expect(sweeper.Client.Create(ctx, workload)).To(Succeed())
+		Expect(sweeper.orphaned(ctx, workload)).To(BeFalse())
+	})
+})
@@ -17,32 +17,62 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	// Time zones of scaling schedules are read without zoneinfo in the image
+	_ "time/tzdata"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 
+	configv1alpha1 "github.com/Tenacher/prairie-operator/api/config/v1alpha1"
 	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
 	"github.com/Tenacher/prairie-operator/controllers"
+	"github.com/Tenacher/prairie-operator/pkg/audit"
+	"github.com/Tenacher/prairie-operator/pkg/cosign"
+	"github.com/Tenacher/prairie-operator/pkg/daemon"
+	"github.com/Tenacher/prairie-operator/pkg/diagnostics"
+	"github.com/Tenacher/prairie-operator/pkg/metrics"
+	"github.com/Tenacher/prairie-operator/pkg/telemetry"
+	"github.com/Tenacher/prairie-operator/pkg/tracing"
+	"github.com/Tenacher/prairie-operator/pkg/vault"
 	//+kubebuilder:scaffold:imports
 )
 
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+
+	// version is set when building, e.g. with -ldflags "-X main.version=0.0.1"
+	version = "dev"
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(prairiev1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
@@ -51,12 +81,140 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var leaderElectionNamespace string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
 	var probeAddr string
+	var spiffeSVIDDir string
+	var spiffeTrustDomain string
+	var vaultAddr string
+	var vaultAuthMount string
+	var vaultCACert string
+	var imageKeyPath string
+	var configFile string
+	var maxConcurrentReconciles int
+	var cacheSyncTimeout time.Duration
+	var requeueInterval time.Duration
+	var maxRequeueInterval time.Duration
+	var defaultImage string
+	var hardenedToolsImage string
+	var agentRulesClusterRole string
+	var releaseCatalog string
+	var featureGates string
+	var watchNamespaces string
+	var diagnosticsAddr string
+	var diagnosticsRemote bool
+	var checkDaemons bool
+	var tracingEndpoint string
+	var auditChanges bool
+	var auditHistory int
+	var dryRun bool
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var dashboardNamespace string
+	var dashboardLabels string
+	var telemetryEndpoint string
+	var frrNamespace string
+	var bgpAllowedPrefixes string
+	var bgpAllowedPeers string
+	var sweepInterval time.Duration
+	var analysisInterval time.Duration
+	var analysisWindow time.Duration
+	var telemetryInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+			"Enabling this will ensure there is only one active controller manager. "+
+			"Overrides leaderElection.leaderElect of the config file, e.g. --leader-elect=false for local runs.")
+	flag.StringVar(&leaderElectionNamespace, "leader-elect-namespace", "",
+		"The namespace of the leader election lease. Defaults to the namespace the operator runs in.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"How long a standby operator waits before taking over the lease of a leader that stopped renewing it.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"How long the leader retries renewing its lease before it steps down.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"How often the operators try to acquire or renew the lease.")
+	flag.StringVar(&spiffeSVIDDir, "spiffe-svid-dir", "",
+		"Directory the X.509 SVID of the operator is written to, e.g. by spiffe-helper. "+
+			"Enables the control channel to SPIFFE enabled HomeAgents.")
+	flag.StringVar(&spiffeTrustDomain, "spiffe-trust-domain", "", "The SPIFFE trust domain of the HomeAgents.")
+	flag.StringVar(&vaultAddr, "vault-address", "",
+		"The https address of the Vault server HomeAgents may keep their keys in.")
+	flag.StringVar(&vaultCACert, "vault-ca-cert", "",
+		"PEM file with the CA certificates the Vault server certificate is verified with. Defaults to the system roots.")
+	flag.StringVar(&vaultAuthMount, "vault-auth-mount", vault.DefaultAuthMount,
+		"The mount path of the Kubernetes auth method in Vault.")
+	flag.StringVar(&imageKeyPath, "image-verification-key", "",
+		"PEM file with the cosign public key agent images must be signed with. "+
+			"Unsigned images are rejected if set.")
+	flag.StringVar(&configFile, "config", "",
+		"An OperatorConfig file with the settings of the operator. Flags given on the command line take precedence.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of objects of a kind reconciled concurrently, unless set for the kind in the config file.")
+	flag.DurationVar(&cacheSyncTimeout, "cache-sync-timeout", 0,
+		"How long the controllers wait for their caches to sync on start. Defaults to 2 minutes.")
+	flag.DurationVar(&requeueInterval, "requeue-interval", controllers.DefaultRequeueInterval,
+		"How soon a HomeAgent waiting for its replicas is looked at again. Doubles with every requeue.")
+	flag.DurationVar(&maxRequeueInterval, "max-requeue-interval", controllers.DefaultMaxRequeueInterval,
+		"The longest interval a HomeAgent waiting for its replicas is requeued after.")
+	flag.StringVar(&defaultImage, "default-image", "",
+		"The mo-daemon image of HomeAgents which set none. Defaults to kismi/mo-daemon:latest.")
+	flag.StringVar(&agentRulesClusterRole, "agent-rules-cluster-role", "",
+		"The ClusterRole holding the API rules HomeAgents may grant with spec.serviceAccount.rules. "+
+			"The operator has to be bound to it. No rules can be granted if empty.")
+	flag.StringVar(&hardenedToolsImage, "hardened-tools-image", "",
+		"The image the file capabilities of hardened agents are set from. It has to ship setcap. Defaults to the agent image.")
+	flag.StringVar(&releaseCatalog, "release-catalog", "",
+		"YAML file with the mo-daemon releases HomeAgents select with spec.version, replacing the releases the operator was built for.")
+	flag.StringVar(&featureGates, "feature-gates", "",
+		"Comma separated feature gates to switch on or off, e.g. Federation=true. Known gates are:\n"+
+			controllers.FeatureGateUsage())
+	flag.StringVar(&diagnosticsAddr, "diagnostics-bind-address", "",
+		"The address pprof and the runtime statistics are served on, e.g. 127.0.0.1:6060. Disabled if empty.")
+	flag.BoolVar(&diagnosticsRemote, "diagnostics-allow-remote", false,
+		"Allow serving the diagnostics on a non-loopback address. The endpoint is not authenticated.")
+	flag.BoolVar(&checkDaemons, "check-daemon-connectivity", false,
+		"Fail the readiness probe while none of the ready HomeAgent replicas answers the operator.")
+	flag.StringVar(&tracingEndpoint, "tracing-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"The OTLP/HTTP endpoint of the collector reconcile spans are exported to, e.g. http://otel-collector:4318. "+
+			"Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT, tracing is disabled if empty.")
+	flag.BoolVar(&auditChanges, "audit", false,
+		"Log every change the operator makes to the cluster, with the changed fields and the reason.")
+	flag.IntVar(&auditHistory, "audit-history", 10,
+		"The number of changes kept in the status of every HomeAgent with --audit, none if 0.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only report the changes the operator would make, in the logs and the status of the HomeAgents, without making them.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20,
+		"The requests per second the operator makes to the API server at most, beyond a burst.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30,
+		"The requests the operator makes to the API server at once at most.")
+	flag.StringVar(&dashboardNamespace, "grafana-dashboard-namespace", "",
+		"The namespace the ConfigMap of the Grafana dashboard is kept in. No dashboard is created if empty.")
+	flag.StringVar(&dashboardLabels, "grafana-dashboard-labels", "grafana_dashboard=1",
+		"Comma separated labels of the ConfigMap of the Grafana dashboard, which the Grafana dashboard sidecar looks for.")
+	flag.DurationVar(&sweepInterval, "orphan-sweep-interval", controllers.DefaultSweepInterval,
+		"How often objects of deleted HomeAgents, which were left behind e.g. while the operator was down, "+
+			"are looked for and deleted. Disabled if 0.")
+	flag.DurationVar(&analysisInterval, "resource-analysis-interval", 0,
+		"How often the usage of the agent containers is read from the metrics API, for the right-sizing "+
+			"recommendations in the status of the HomeAgents. Disabled if 0.")
+	flag.DurationVar(&analysisWindow, "resource-analysis-window", controllers.DefaultAnalysisWindow,
+		"How long the usage of the agent containers is considered for the recommendations.")
+	flag.StringVar(&frrNamespace, "frr-namespace", controllers.DefaultFRRNamespace,
+		"The namespace of frr-k8s, which the FRRConfigurations advertising the home prefixes of HomeAgents are kept in.")
+	flag.StringVar(&bgpAllowedPrefixes, "bgp-allowed-prefixes", "",
+		"Comma separated networks HomeAgents may advertise prefixes of over BGP. No prefix may be advertised if empty.")
+	flag.StringVar(&bgpAllowedPeers, "bgp-allowed-peers", "",
+		"Comma separated networks of the routers HomeAgents may peer with over BGP. No router may be peered with if empty.")
+	flag.StringVar(&telemetryEndpoint, "telemetry-endpoint", "",
+		"The endpoint anonymous usage of the operator is reported to: its version, the number of custom resources "+
+			"and the features in use. Nothing is reported if empty.")
+	flag.DurationVar(&telemetryInterval, "telemetry-interval", telemetry.DefaultInterval,
+		"How often usage is reported to --telemetry-endpoint.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACE"),
+		"Comma separated namespaces the operator is restricted to. Defaults to $WATCH_NAMESPACE, or every namespace.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,47 +223,438 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "68fdcb5e.kismi",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
-	})
+	// The config file replaces the defaults of the flags
+	var config *configv1alpha1.OperatorConfig
+	if configFile != "" {
+		var err error
+		config, err = loadConfig(configFile)
+		if err == nil {
+			err = applyConfig(config)
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+	}
+	features, err := controllers.ParseFeatureGates(featureGates)
+	if err != nil {
+		setupLog.Error(err, "invalid feature gates")
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "68fdcb5e.kismi",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		// The lease is released on shutdown, so a standby takes over right
+		// away during rolling updates of the operator. Nothing runs after
+		// the manager stopped.
+		LeaderElectionReleaseOnCancel: true,
+	}
+	if cacheSyncTimeout > 0 {
+		options.Controller.CacheSyncTimeout = &cacheSyncTimeout
+	}
+	if config != nil {
+		options, err = options.AndFrom(config)
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+		// AndFrom switches leader election on whenever the file does. The
+		// flag already holds the setting of the file unless it was given.
+		options.LeaderElection = enableLeaderElection
+	}
+	// Only the objects of the watched namespaces are cached and reconciled
+	if namespaces := splitNamespaces(watchNamespaces); len(namespaces) == 1 {
+		options.Namespace = namespaces[0]
+	} else if len(namespaces) > 1 {
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	// Every reconcile runs in dry run
+	if dryRun {
+		options.BaseContext = func() context.Context {
+			return audit.WithDryRun(context.Background(), true)
+		}
+	}
+	options.Controller.GroupKindConcurrency = concurrency(options.Controller.GroupKindConcurrency, maxConcurrentReconciles)
+
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = float32(kubeAPIQPS)
+	restConfig.Burst = kubeAPIBurst
+	// The API server names the field manager of the changes after the user
+	// agent, which defaults to the name of the binary
+	restConfig.UserAgent = controllers.FieldManager
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// The cached objects are counted for capacity planning, next to the
+	// workqueue and client metrics
+	err = crmetrics.Registry.Register(metrics.NewCacheCollector(mgr.GetCache(), mgr.GetScheme(), mgr.Elected(), cachedObjects(features)...))
+	if err == nil {
+		err = crmetrics.Registry.Register(controllers.NewHomeAgentCollector(mgr.GetClient(), mgr.Elected()))
+	}
+	if err == nil {
+		err = crmetrics.Registry.Register(controllers.NewInventoryCollector(mgr.GetClient(), mgr.GetScheme(), mgr.Elected()))
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to register metrics")
+		os.Exit(1)
+	}
+
+	// Node Feature Discovery labels the nodes the agents can run on
+	if features.Enabled(controllers.NodeCapabilities) {
+		err := mgr.Add(&controllers.NodeFeatureRuleInstaller{
+			Client: mgr.GetClient(),
+			Reader: mgr.GetAPIReader(),
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to install the NodeFeatureRule")
+			os.Exit(1)
+		}
+	}
+
+	// New installs get a dashboard of the metrics out of the box
+	if dashboardNamespace != "" {
+		labels, err := k8slabels.ConvertSelectorToLabelsMap(dashboardLabels)
+		if err == nil {
+			err = mgr.Add(&controllers.DashboardInstaller{
+				Client:    mgr.GetClient(),
+				Reader:    mgr.GetAPIReader(),
+				Namespace: dashboardNamespace,
+				Labels:    labels,
+			})
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to install the Grafana dashboard")
+			os.Exit(1)
+		}
+	}
+
+	// Profiles are only served on request, by default to local clients
+	var diagnosticsServer *diagnostics.Server
+	if diagnosticsAddr != "" {
+		diagnosticsServer, err = diagnostics.NewServer(diagnosticsAddr, diagnosticsRemote)
+		if err == nil {
+			err = mgr.Add(diagnosticsServer)
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to serve diagnostics")
+			os.Exit(1)
+		}
+	}
+
+	// Reconciles are traced once the exporter runs
+	if tracingEndpoint != "" {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = "prairie-operator"
+		}
+		if err := mgr.Add(tracing.NewExporter(tracingEndpoint, service)); err != nil {
+			setupLog.Error(err, "unable to add the span exporter")
+			os.Exit(1)
+		}
+	}
+
+	// Usage is only ever reported on request
+	if telemetryEndpoint != "" {
+		usage := map[string]bool{
+			"Audit":             auditChanges,
+			"DryRun":            dryRun,
+			"SPIFFE":            spiffeSVIDDir != "",
+			"Vault":             vaultAddr != "",
+			"ImageVerification": imageKeyPath != "",
+			"Tracing":           tracingEndpoint != "",
+			"GrafanaDashboard":  dashboardNamespace != "",
+			"DaemonCheck":       checkDaemons,
+			"LeaderElection":    enableLeaderElection,
+			"WatchNamespaces":   watchNamespaces != "",
+		}
+		for gate := range controllers.DefaultFeatureGates() {
+			usage[gate] = features.Enabled(gate)
+		}
+		err := mgr.Add(&telemetry.Reporter{
+			Endpoint: telemetryEndpoint,
+			Interval: telemetryInterval,
+			Version:  version,
+			Features: usage,
+			Reader:   mgr.GetAPIReader(),
+			Scheme:   mgr.GetScheme(),
+			Group:    prairiev1.GroupVersion.Group,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add the usage reporter")
+			os.Exit(1)
+		}
+	}
+
+	// SPIFFE enabled agents only accept the operator with its SVID
+	var secureDaemon daemon.Client
+	if spiffeSVIDDir != "" {
+		if spiffeTrustDomain == "" {
+			setupLog.Error(nil, "--spiffe-trust-domain is required with --spiffe-svid-dir")
+			os.Exit(1)
+		}
+		secureDaemon = daemon.NewSPIFFEClient(spiffeSVIDDir, spiffeTrustDomain)
+	}
+
+	// Support reads the live bindings of replicas through the operator,
+	// callers from outside the pod are authorized by the API server
+	if diagnosticsServer != nil {
+		inspector := &controllers.BindingInspector{
+			Reader:       mgr.GetAPIReader(),
+			Daemon:       daemon.NewClient(),
+			SecureDaemon: secureDaemon,
+		}
+		if diagnosticsRemote {
+			inspector.Reviewer = mgr.GetClient()
+		}
+		diagnosticsServer.Handle(controllers.InspectPath, inspector)
+	}
+
+	// The tokens of the Vault roles are renewed in the background
+	var vaultClient *vault.Client
+	if vaultAddr != "" {
+		vaultClient, err = vault.NewClient(vaultAddr, vaultCACert)
+		if err != nil {
+			setupLog.Error(err, "unable to create the Vault client")
+			os.Exit(1)
+		}
+		vaultClient.AuthMount = vaultAuthMount
+		if err := mgr.Add(vaultClient); err != nil {
+			setupLog.Error(err, "unable to add Vault lease renewal")
+			os.Exit(1)
+		}
+	}
+
+	// Agent images must carry a signature of the key
+	var imageVerifier *cosign.Verifier
+	if imageKeyPath != "" {
+		key, err := os.ReadFile(imageKeyPath)
+		if err == nil {
+			imageVerifier, err = cosign.NewVerifier(key)
+		}
+		if err != nil {
+			setupLog.Error(err, "unable to load image verification key")
+			os.Exit(1)
+		}
+	}
+
+	// Releases beyond the built in ones can be offered without upgrading
+	// the operator
+	var releases controllers.ReleaseCatalog
+	if releaseCatalog != "" {
+		releases, err = controllers.LoadReleaseCatalog(releaseCatalog)
+		if err != nil {
+			setupLog.Error(err, "unable to load the release catalog")
+			os.Exit(1)
+		}
+	}
+
+	// HomeAgents advertise through the routers of the nodes, only within
+	// what the operator allows
+	bgpPrefixes, err := splitNetworks(bgpAllowedPrefixes)
+	if err != nil {
+		setupLog.Error(err, "unable to parse --bgp-allowed-prefixes")
+		os.Exit(1)
+	}
+	bgpPeers, err := splitNetworks(bgpAllowedPeers)
+	if err != nil {
+		setupLog.Error(err, "unable to parse --bgp-allowed-peers")
+		os.Exit(1)
+	}
+
+	// Changes are logged for forensics, e.g. in regulated environments,
+	// and only validated in a dry run
+	operatorClient := audit.NewClient(mgr.GetClient())
+	operatorClient.Log = auditChanges
+	operatorClient.FieldOwner = controllers.FieldManager
+	if !auditChanges {
+		auditHistory = 0
+	}
+
 	if err = (&controllers.HomeAgentReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                metrics.NewClient(operatorClient, "homeagent"),
+		Scheme:                mgr.GetScheme(),
+		Daemon:                daemon.NewClient(),
+		SecureDaemon:          secureDaemon,
+		SPIFFESVIDDir:         spiffeSVIDDir,
+		SPIFFETrustDomain:     spiffeTrustDomain,
+		Vault:                 vaultClient,
+		Images:                imageVerifier,
+		Recorder:              mgr.GetEventRecorderFor("homeagent-controller"),
+		RequeueInterval:       requeueInterval,
+		MaxRequeueInterval:    maxRequeueInterval,
+		Releases:              releases,
+		DefaultImage:          defaultImage,
+		HardenedToolsImage:    hardenedToolsImage,
+		AgentRulesClusterRole: agentRulesClusterRole,
+		FRRNamespace:          frrNamespace,
+		BGPPrefixes:           bgpPrefixes,
+		BGPPeers:              bgpPeers,
+		Features:              features,
+		AuditHistory:          auditHistory,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HomeAgent")
 		os.Exit(1)
 	}
+	if err = (&controllers.MobileNodeReconciler{
+		Client: metrics.NewClient(operatorClient, "mobilenode"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MobileNode")
+		os.Exit(1)
+	}
+	if err = (&controllers.BindingCacheReconciler{
+		Client:       metrics.NewClient(operatorClient, "bindingcache"),
+		Scheme:       mgr.GetScheme(),
+		Daemon:       daemon.NewClient(),
+		SecureDaemon: secureDaemon,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BindingCache")
+		os.Exit(1)
+	}
+	if err = (&controllers.MobilityDomainReconciler{
+		Client: metrics.NewClient(operatorClient, "mobilitydomain"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MobilityDomain")
+		os.Exit(1)
+	}
+	if err = (&controllers.AddressPoolReconciler{
+		Client: metrics.NewClient(operatorClient, "addresspool"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AddressPool")
+		os.Exit(1)
+	}
+	if err = (&controllers.BindingPolicyReconciler{
+		Client: metrics.NewClient(operatorClient, "bindingpolicy"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BindingPolicy")
+		os.Exit(1)
+	}
+	if err = (&controllers.HandoverPolicyReconciler{
+		Client: metrics.NewClient(operatorClient, "handoverpolicy"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HandoverPolicy")
+		os.Exit(1)
+	}
+	if err = (&controllers.PrairieNetworkReconciler{
+		Client: metrics.NewClient(operatorClient, "prairienetwork"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PrairieNetwork")
+		os.Exit(1)
+	}
+	if err = (&controllers.CorrespondentNodeReconciler{
+		Client:       metrics.NewClient(operatorClient, "correspondentnode"),
+		Scheme:       mgr.GetScheme(),
+		Daemon:       daemon.NewClient(),
+		SecureDaemon: secureDaemon,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CorrespondentNode")
+		os.Exit(1)
+	}
+	if err = (&controllers.HomeAgentBackupReconciler{
+		Client:       metrics.NewClient(operatorClient, "homeagentbackup"),
+		Scheme:       mgr.GetScheme(),
+		Daemon:       daemon.NewClient(),
+		SecureDaemon: secureDaemon,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HomeAgentBackup")
+		os.Exit(1)
+	}
+	// Reaching out to other clusters can be switched off
+	if features.Enabled(controllers.Federation) {
+		if err = (&controllers.FederationReconciler{
+			Client:       metrics.NewClient(operatorClient, "federation"),
+			Scheme:       mgr.GetScheme(),
+			RemoteClient: controllers.NewRemoteClient(mgr.GetScheme(), secureDaemon),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Federation")
+			os.Exit(1)
+		}
+		if err = (&controllers.BindingHandoffReconciler{
+			Client:       metrics.NewClient(operatorClient, "bindinghandoff"),
+			Scheme:       mgr.GetScheme(),
+			RemoteClient: controllers.NewRemoteClient(mgr.GetScheme(), secureDaemon),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "BindingHandoff")
+			os.Exit(1)
+		}
+	}
+	if err = (&controllers.MobileAccessGatewayReconciler{
+		Client: metrics.NewClient(operatorClient, "mobileaccessgateway"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MobileAccessGateway")
+		os.Exit(1)
+	}
+	if err = (&controllers.StorageVersionReconciler{
+		Client: metrics.NewClient(operatorClient, "storageversion"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "StorageVersion")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	// Cleanup by NotFound misses agents deleted while the operator was down
+	if sweepInterval > 0 {
+		err := mgr.Add(&controllers.OrphanSweeper{
+			Client:   metrics.NewClient(operatorClient, "sweeper"),
+			Interval: sweepInterval,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add the orphan sweeper")
+			os.Exit(1)
+		}
+	}
+
+	if analysisInterval > 0 {
+		err := mgr.Add(&controllers.ResourceAnalyzer{
+			Client:   metrics.NewClient(operatorClient, "resourceanalyzer"),
+			Interval: analysisInterval,
+			Window:   analysisWindow,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to add the resource analyzer")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
+	if checkDaemons {
+		// Unreachable daemons aren't fixed by restarting the operator
+		err := mgr.AddReadyzCheck("daemons", controllers.DaemonCheck(mgr.GetClient(), daemon.NewClient(), secureDaemon))
+		if err != nil {
+			setupLog.Error(err, "unable to set up daemon check")
+			os.Exit(1)
+		}
+	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("informers", controllers.CacheSyncCheck(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -113,3 +662,65 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// concurrency returns the number of workers of each kind of the operator,
+// the concurrency set per kind in the config file takes precedence.
+func concurrency(configured map[string]int, workers int) map[string]int {
+	kinds := map[string]int{}
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.Group != prairiev1.GroupVersion.Group || strings.HasSuffix(gvk.Kind, "List") {
+			continue
+		}
+		kinds[gvk.GroupKind().String()] = workers
+	}
+	for kind, workers := range configured {
+		kinds[kind] = workers
+	}
+	return kinds
+}
+
+// cachedObjects returns an object of every kind the controllers watch.
+func cachedObjects(features controllers.FeatureGates) []client.Object {
+	objects := []client.Object{
+		&appsv1.Deployment{}, &appsv1.StatefulSet{}, &corev1.Pod{}, &corev1.ConfigMap{}, &corev1.Secret{},
+		&corev1.Service{}, &corev1.ServiceAccount{}, &corev1.Node{}, &rbacv1.Role{}, &rbacv1.RoleBinding{},
+		&policyv1.PodDisruptionBudget{}, &batchv1.Job{}, &apiextensionsv1.CustomResourceDefinition{},
+	}
+	for gvk := range scheme.AllKnownTypes() {
+		// Counting a kind nothing watches would start its informer
+		if gvk.Group != prairiev1.GroupVersion.Group || strings.HasSuffix(gvk.Kind, "List") || !features.Watched(gvk.Kind) {
+			continue
+		}
+		if obj, err := scheme.New(gvk); err == nil {
+			objects = append(objects, obj.(client.Object))
+		}
+	}
+	return objects
+}
+
+// splitNetworks parses a comma separated list of networks in CIDR notation.
+func splitNetworks(value string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// splitNamespaces returns the namespaces of a comma separated list.
+func splitNamespaces(value string) []string {
+	namespaces := []string{}
+	for _, namespace := range strings.Split(value, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
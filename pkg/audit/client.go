@@ -0,0 +1,417 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records the changes the operator makes to the cluster.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultReason is the reason of changes made without one in the
+	// context.
+	DefaultReason = "Reconcile"
+
+	// maxDepth bounds the depth of the changed fields, deeper changes are
+	// reported as a change of their parent.
+	maxDepth = 3
+)
+
+// Fields which change with every write and say nothing about it.
+var ignoredFields = map[string]bool{
+	"apiVersion":                 true,
+	"kind":                       true,
+	"metadata.resourceVersion":   true,
+	"metadata.generation":        true,
+	"metadata.managedFields":     true,
+	"metadata.creationTimestamp": true,
+	"metadata.uid":               true,
+}
+
+// Entry is a change made by the operator.
+type Entry struct {
+	Time        time.Time
+	Verb        string
+	Kind        string
+	Namespace   string
+	Name        string
+	Subresource string
+	// Fields are the changed fields, e.g. spec.replicas. Only the paths are
+	// recorded, never the values, as they may be secret.
+	Fields []string
+	Reason string
+	// DryRun is set if the change was not made.
+	DryRun bool
+}
+
+type reasonKey struct{}
+
+type recorderKey struct{}
+
+type dryRunKey struct{}
+
+// WithReason returns a context the changes made with are recorded with
+// the reason, e.g. KeyRotation.
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// WithRecorder returns a context the changes made with are passed to
+// record as well. Changes of status subresources aren't passed.
+func WithRecorder(ctx context.Context, record func(Entry)) context.Context {
+	return context.WithValue(ctx, recorderKey{}, record)
+}
+
+// WithDryRun returns a context the changes made with are only validated
+// by the API server, not made, if dryRun is set.
+func WithDryRun(ctx context.Context, dryRun bool) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, dryRun)
+}
+
+// DryRun returns whether changes made with the context are not made.
+func DryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// Client records the changes made through it, along with the changed
+// fields and the reason of the change. Changes made with a dry run context
+// are sent as dry runs and always logged.
+type Client struct {
+	client.Client
+	// Log logs every change made.
+	Log bool
+	// FieldOwner, if set, is the field manager the changes are made as,
+	// rather than the one the API server derives from the user agent.
+	FieldOwner string
+}
+
+// NewClient returns a client recording the changes made through c.
+func NewClient(c client.Client) *Client {
+	return &Client{Client: c}
+}
+
+// Create creates obj and records the change.
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if c.FieldOwner != "" {
+		opts = append(opts, client.FieldOwner(c.FieldOwner))
+	}
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := c.Client.Create(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.CreateOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		c.record(ctx, "create", obj, "", nil)
+	}
+	return nil
+}
+
+// Update updates obj and records the fields it changed.
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	fields := c.updatedFields(ctx, obj, false)
+	if c.FieldOwner != "" {
+		opts = append(opts, client.FieldOwner(c.FieldOwner))
+	}
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := c.Client.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.UpdateOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		c.record(ctx, "update", obj, "", fields)
+	}
+	return nil
+}
+
+// Patch patches obj and records the fields of the patch.
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	fields := c.patchedFields(ctx, obj, patch)
+	if c.FieldOwner != "" {
+		opts = append(opts, client.FieldOwner(c.FieldOwner))
+	}
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := c.Client.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.PatchOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		c.record(ctx, "patch", obj, "", fields)
+	}
+	return nil
+}
+
+// Delete deletes obj and records the change.
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.DeleteOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		c.record(ctx, "delete", obj, "", nil)
+	}
+	return nil
+}
+
+// DeleteAllOf deletes the matching objects and records the change.
+func (c *Client) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := c.Client.DeleteAllOf(ctx, obj, opts...); err != nil {
+		return err
+	}
+	options := (&client.DeleteAllOfOptions{}).ApplyOptions(opts)
+	if DryRun(ctx) || len(options.DryRun) == 0 {
+		obj.SetNamespace(options.Namespace)
+		c.record(ctx, "deleteAllOf", obj, "", nil)
+	}
+	return nil
+}
+
+// Status returns a writer recording the changes of status subresources.
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	c *Client
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	fields := w.c.updatedFields(ctx, obj, true)
+	if w.c.FieldOwner != "" {
+		opts = append(opts, client.FieldOwner(w.c.FieldOwner))
+	}
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := w.StatusWriter.Update(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.UpdateOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		w.c.record(ctx, "update", obj, "status", fields)
+	}
+	return nil
+}
+
+func (w *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	fields := w.c.patchedFields(ctx, obj, patch)
+	if w.c.FieldOwner != "" {
+		opts = append(opts, client.FieldOwner(w.c.FieldOwner))
+	}
+	if DryRun(ctx) {
+		opts = append(opts, client.DryRunAll)
+	}
+	if err := w.StatusWriter.Patch(ctx, obj, patch, opts...); err != nil {
+		return err
+	}
+	if DryRun(ctx) || len((&client.PatchOptions{}).ApplyOptions(opts).DryRun) == 0 {
+		w.c.record(ctx, "patch", obj, "status", fields)
+	}
+	return nil
+}
+
+// record logs the change and passes it to the recorder of the context.
+func (c *Client) record(ctx context.Context, verb string, obj client.Object, subresource string, fields []string) {
+	entry := Entry{
+		Time:        time.Now(),
+		Verb:        verb,
+		Namespace:   obj.GetNamespace(),
+		Name:        obj.GetName(),
+		Subresource: subresource,
+		Fields:      fields,
+		Reason:      DefaultReason,
+		DryRun:      DryRun(ctx),
+	}
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		entry.Kind = gvk.Kind
+	}
+	if reason, ok := ctx.Value(reasonKey{}).(string); ok {
+		entry.Reason = reason
+	}
+
+	if c.Log || entry.DryRun {
+		values := []interface{}{"verb", verb, "kind", entry.Kind, "object", entry.Object(), "reason", entry.Reason}
+		if subresource != "" {
+			values = append(values, "subresource", subresource)
+		}
+		if len(fields) > 0 {
+			values = append(values, "fields", fields)
+		}
+		if entry.DryRun {
+			log.FromContext(ctx).WithName("audit").Info("Object would be changed.", append(values, "dryRun", true)...)
+		} else {
+			log.FromContext(ctx).WithName("audit").Info("Object changed.", values...)
+		}
+	}
+
+	if record, ok := ctx.Value(recorderKey{}).(func(Entry)); ok && subresource == "" {
+		record(entry)
+	}
+}
+
+// Object returns the namespaced name of the changed object, or its name
+// if it isn't namespaced.
+func (e Entry) Object() string {
+	if e.Namespace == "" {
+		return e.Name
+	}
+	return types.NamespacedName{Namespace: e.Namespace, Name: e.Name}.String()
+}
+
+// recorded returns whether the changes made with the context are logged
+// or recorded, and thus their fields needed.
+func (c *Client) recorded(ctx context.Context) bool {
+	_, record := ctx.Value(recorderKey{}).(func(Entry))
+	return c.Log || record || DryRun(ctx)
+}
+
+// updatedFields returns the fields an update of obj changes compared to
+// the object read through the client. Updates of the status only change
+// the status, other updates never do.
+func (c *Client) updatedFields(ctx context.Context, obj client.Object, status bool) []string {
+	if !c.recorded(ctx) {
+		return nil
+	}
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok || c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current) != nil {
+		return nil
+	}
+	before, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return nil
+	}
+	after, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil
+	}
+
+	fields := []string{}
+	diff(before, after, "", 1, &fields)
+	changed := []string{}
+	for _, field := range fields {
+		if status == (field == "status" || strings.HasPrefix(field, "status.")) {
+			changed = append(changed, field)
+		}
+	}
+	return changed
+}
+
+// patchedFields returns the fields set by the patch of obj.
+func (c *Client) patchedFields(ctx context.Context, obj client.Object, patch client.Patch) []string {
+	if !c.recorded(ctx) {
+		return nil
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return nil
+	}
+	var content interface{}
+	if json.Unmarshal(data, &content) != nil {
+		return nil
+	}
+
+	fields := []string{}
+	switch content := content.(type) {
+	case []interface{}:
+		// A JSON patch lists the paths of its operations
+		seen := map[string]bool{}
+		for _, operation := range content {
+			operation, _ := operation.(map[string]interface{})
+			path, _ := operation["path"].(string)
+			segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+			if len(segments) > maxDepth {
+				segments = segments[:maxDepth]
+			}
+			field := strings.Join(segments, ".")
+			if field != "" && !seen[field] && !ignoredFields[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	case map[string]interface{}:
+		leaves(content, "", 1, &fields)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// leaves appends the paths of the fields set by a merge patch to fields,
+// including the fields it removes.
+func leaves(patch map[string]interface{}, prefix string, depth int, fields *[]string) {
+	for key, value := range patch {
+		field := prefix + key
+		if ignoredFields[field] {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 && depth < maxDepth {
+			leaves(nested, field+".", depth+1, fields)
+			continue
+		}
+		*fields = append(*fields, field)
+	}
+}
+
+// diff appends the paths of the fields which differ between before and
+// after to fields, in order.
+func diff(before, after map[string]interface{}, prefix string, depth int, fields *[]string) {
+	keys := []string{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field := prefix + key
+		if ignoredFields[field] || reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+		old, oldIsMap := before[key].(map[string]interface{})
+		changed, changedIsMap := after[key].(map[string]interface{})
+		if depth < maxDepth && (oldIsMap || before[key] == nil) && (changedIsMap || after[key] == nil) {
+			count := len(*fields)
+			diff(old, changed, field+".", depth+1, fields)
+			if len(*fields) > count {
+				continue
+			}
+		}
+		*fields = append(*fields, field)
+	}
+}
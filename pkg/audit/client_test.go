@@ -0,0 +1,164 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClient(t *testing.T) {
+	entries := []Entry{}
+	ctx := WithRecorder(context.Background(), func(entry Entry) { entries = append(entries, entry) })
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build())
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "default"},
+		Data:       map[string][]byte{"current": []byte("secret")},
+	}
+	if err := c.Create(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	secret.Data["next"] = []byte("secret")
+	secret.Labels = map[string]string{"parent": "agent"}
+	if err := c.Update(WithReason(ctx, "KeyRotation"), secret); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	delete(secret.Data, "current")
+	if err := c.Patch(ctx, secret, patch); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-0", Namespace: "default"}}
+	if err := c.Create(ctx, pod, client.DryRunAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Entry{
+		{Verb: "create", Kind: "Secret", Reason: DefaultReason},
+		{Verb: "update", Kind: "Secret", Reason: "KeyRotation", Fields: []string{"data.next", "metadata.labels.parent"}},
+		{Verb: "patch", Kind: "Secret", Reason: DefaultReason, Fields: []string{"data.current"}},
+		{Verb: "delete", Kind: "Secret", Reason: DefaultReason},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("recorded %+v", entries)
+	}
+	for i, entry := range entries {
+		if entry.Object() != "default/keys" || entry.Time.IsZero() {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+		entry.Time, entry.Namespace, entry.Name = expected[i].Time, "", ""
+		if !reflect.DeepEqual(entry, expected[i]) {
+			t.Errorf("got %+v, expected %+v", entry, expected[i])
+		}
+	}
+}
+
+func TestStatusIsNotRecorded(t *testing.T) {
+	entries := []Entry{}
+	ctx := WithRecorder(context.Background(), func(entry Entry) { entries = append(entries, entry) })
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-0", Namespace: "default"}}
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(pod).Build())
+
+	pod.Status.PodIP = "10.0.0.1"
+	if err := c.Status().Update(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("recorded %+v", entries)
+	}
+	if fields := c.updatedFields(ctx, pod, true); len(fields) != 0 {
+		t.Errorf("got fields %v after the update", fields)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	entries := []Entry{}
+	ctx := WithRecorder(context.Background(), func(entry Entry) { entries = append(entries, entry) })
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build())
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "default"}}
+	if err := c.Create(WithDryRun(ctx, true), secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{}); err == nil {
+		t.Error("created the secret in a dry run")
+	}
+	if len(entries) != 1 || !entries[0].DryRun || entries[0].Verb != "create" {
+		t.Errorf("recorded %+v", entries)
+	}
+	if DryRun(WithDryRun(WithDryRun(ctx, true), false)) {
+		t.Error("dry run wasn't switched off")
+	}
+}
+
+func TestPatchedFields(t *testing.T) {
+	pod := &corev1.Pod{}
+	patch := client.RawPatch("application/json-patch+json",
+		[]byte(`[{"op":"replace","path":"/spec/containers/0/image"},{"op":"add","path":"/metadata/labels/role"}]`))
+	fields := (&Client{Log: true}).patchedFields(context.Background(), pod, patch)
+	if !reflect.DeepEqual(fields, []string{"metadata.labels.role", "spec.containers.0"}) {
+		t.Errorf("got %v", fields)
+	}
+}
+
+// ownerClient records the field managers of the changes made through it.
+type ownerClient struct {
+	client.Client
+	owners []string
+}
+
+func (c *ownerClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.owners = append(c.owners, (&client.CreateOptions{}).ApplyOptions(opts).FieldManager)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *ownerClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.owners = append(c.owners, (&client.UpdateOptions{}).ApplyOptions(opts).FieldManager)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestClientFieldOwner(t *testing.T) {
+	recorded := &ownerClient{Client: fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build()}
+	c := NewClient(recorded)
+	c.FieldOwner = "prairie-operator"
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "default"}}
+	if err := c.Create(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	secret.Labels = map[string]string{"parent": "agent"}
+	if err := c.Update(context.Background(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorded.owners, []string{"prairie-operator", "prairie-operator"}) {
+		t.Errorf("changes made as %v", recorded.owners)
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxValueLength bounds the length of the values shown in a diff.
+const maxValueLength = 60
+
+// Diff describes the changes from before to after, one line per changed
+// field, e.g. `spec.replicas: 3 -> 5`. Changed lines of multi-line
+// strings, like configuration files, are shown with - and +. The status
+// and the metadata managed by the API server are left out. Never diff
+// Secrets, the values are shown.
+func Diff(before, after client.Object) []string {
+	old, err := runtime.DefaultUnstructuredConverter.ToUnstructured(before)
+	if err != nil {
+		return nil
+	}
+	changed, err := runtime.DefaultUnstructuredConverter.ToUnstructured(after)
+	if err != nil {
+		return nil
+	}
+	delete(old, "status")
+	delete(changed, "status")
+
+	lines := []string{}
+	diffValue(old, changed, "", "", &lines)
+	return lines
+}
+
+// diffValue appends the differences of a field to lines. field is the
+// dotted path ignoredFields are matched with, label the path shown.
+func diffValue(before, after interface{}, field, label string, lines *[]string) {
+	if ignoredFields[field] || reflect.DeepEqual(before, after) {
+		return
+	}
+
+	switch old := before.(type) {
+	case map[string]interface{}:
+		if changed, ok := after.(map[string]interface{}); ok {
+			keys := []string{}
+			for key := range old {
+				keys = append(keys, key)
+			}
+			for key := range changed {
+				if _, ok := old[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				diffValue(old[key], changed[key], join(field, key), label+keyLabel(label, key), lines)
+			}
+			return
+		}
+	case []interface{}:
+		if changed, ok := after.([]interface{}); ok {
+			diffList(old, changed, field, label, lines)
+			return
+		}
+	case string:
+		if changed, ok := after.(string); ok && (strings.Contains(old, "\n") || strings.Contains(changed, "\n")) {
+			*lines = append(*lines, label+":")
+			for _, line := range diffLines(old, changed) {
+				*lines = append(*lines, "  "+line)
+			}
+			return
+		}
+	}
+
+	switch {
+	case before == nil:
+		*lines = append(*lines, fmt.Sprintf("%s: %s (added)", label, format(after)))
+	case after == nil:
+		*lines = append(*lines, fmt.Sprintf("%s: removed, was %s", label, format(before)))
+	default:
+		*lines = append(*lines, fmt.Sprintf("%s: %s -> %s", label, format(before), format(after)))
+	}
+}
+
+// diffList appends the differences of a list to lines. Items with a name,
+// like containers, are matched by it, other lists by their index.
+func diffList(before, after []interface{}, field, label string, lines *[]string) {
+	oldNames, named := itemNames(before)
+	changedNames, alsoNamed := itemNames(after)
+	if !named || !alsoNamed {
+		if len(before) != len(after) {
+			*lines = append(*lines, fmt.Sprintf("%s: %d -> %d items", label, len(before), len(after)))
+			return
+		}
+		for i := range before {
+			diffValue(before[i], after[i], field, fmt.Sprintf("%s[%d]", label, i), lines)
+		}
+		return
+	}
+
+	names := []string{}
+	for name := range oldNames {
+		names = append(names, name)
+	}
+	for name := range changedNames {
+		if _, ok := oldNames[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		item := fmt.Sprintf("%s[%s]", label, name)
+		old, changed := oldNames[name], changedNames[name]
+		switch {
+		case old == nil:
+			*lines = append(*lines, item+": added")
+		case changed == nil:
+			*lines = append(*lines, item+": removed")
+		default:
+			diffValue(old, changed, field, item, lines)
+		}
+	}
+}
+
+// itemNames returns the items of a list by their name, if every item has
+// a distinct one.
+func itemNames(items []interface{}) (map[string]interface{}, bool) {
+	names := map[string]interface{}{}
+	for _, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := fields["name"].(string)
+		if _, taken := names[name]; !ok || taken {
+			return nil, false
+		}
+		names[name] = item
+	}
+	return names, true
+}
+
+// diffLines returns the lines removed from before, prefixed with -, and
+// the lines added in after, prefixed with +.
+func diffLines(before, after string) []string {
+	old := strings.Split(before, "\n")
+	changed := strings.Split(after, "\n")
+
+	// Lines of the longest common subsequence are unchanged
+	lengths := make([][]int, len(old)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(changed)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(changed) - 1; j >= 0; j-- {
+			if old[i] == changed[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(old) || j < len(changed) {
+		switch {
+		case i < len(old) && j < len(changed) && old[i] == changed[j]:
+			i++
+			j++
+		case j == len(changed) || (i < len(old) && lengths[i+1][j] >= lengths[i][j+1]):
+			lines = append(lines, "-"+old[i])
+			i++
+		default:
+			lines = append(lines, "+"+changed[j])
+			j++
+		}
+	}
+	return lines
+}
+
+func join(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
+
+// keyLabel returns how a key is appended to the shown path, keys like
+// annotations which contain dots are quoted.
+func keyLabel(label, key string) string {
+	if strings.ContainsAny(key, "./ ") {
+		return fmt.Sprintf("[%q]", key)
+	}
+	if label == "" {
+		return key
+	}
+	return "." + key
+}
+
+// format returns a value as shown in a diff.
+func format(value interface{}) string {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if len(content) > maxValueLength {
+		return string(content[:maxValueLength-3]) + "..."
+	}
+	return string(content)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiff(t *testing.T) {
+	replicas := int32(3)
+	before := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "agent",
+			ResourceVersion: "1",
+			Annotations:     map[string]string{"prairie.kismi/template-hash": "a1"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "agent", Image: "mo-daemon:1.3"},
+				{Name: "exporter", Image: "exporter:1.0"},
+			}}},
+		},
+	}
+	after := before.DeepCopy()
+	resized := int32(5)
+	after.ResourceVersion = "2"
+	after.Annotations["prairie.kismi/template-hash"] = "b2"
+	after.Spec.Replicas = &resized
+	after.Spec.Template.Spec.Containers[0].Image = "mo-daemon:1.4"
+	after.Spec.Template.Spec.Containers = after.Spec.Template.Spec.Containers[:1]
+	after.Status.ReadyReplicas = 5
+
+	expected := []string{
+		`metadata.annotations["prairie.kismi/template-hash"]: "a1" -> "b2"`,
+		`spec.replicas: 3 -> 5`,
+		`spec.template.spec.containers[agent].image: "mo-daemon:1.3" -> "mo-daemon:1.4"`,
+		`spec.template.spec.containers[exporter]: removed`,
+	}
+	if lines := Diff(before, after); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	before := &corev1.ConfigMap{Data: map[string]string{"mo.conf": "a = 1\nb = 2\nc = 3\n"}}
+	after := &corev1.ConfigMap{Data: map[string]string{"mo.conf": "a = 1\nb = 4\nc = 3\nd = 5\n"}}
+
+	expected := []string{`data["mo.conf"]:`, "  -b = 2", "  +b = 4", "  +d = 5"}
+	if lines := Diff(before, after); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got %q", lines)
+	}
+	if lines := Diff(before, before.DeepCopy()); len(lines) != 0 {
+		t.Errorf("got %q for equal objects", lines)
+	}
+}
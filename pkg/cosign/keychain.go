@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cosign
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// dockerHub are the names the Docker Hub registry goes by in pull secrets
+var dockerHub = map[string]bool{"docker.io": true, "index.docker.io": true, "registry-1.docker.io": true}
+
+type credential struct {
+	Host string
+	Path string
+	authn.AuthConfig
+}
+
+// pullSecrets resolves registries to the first credential matching them
+type pullSecrets []credential
+
+// Keychain returns the credentials of image pull secrets, of the types
+// kubernetes.io/dockerconfigjson and kubernetes.io/dockercfg, for Verify.
+// Like the kubelet, the first secret with an entry for the registry, and
+// the path of the repository if the entry has one, is used.
+func Keychain(secrets []corev1.Secret) (authn.Keychain, error) {
+	keychain := pullSecrets{}
+	for _, secret := range secrets {
+		auths := map[string]authn.AuthConfig{}
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			config := struct {
+				Auths map[string]authn.AuthConfig `json:"auths"`
+			}{}
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+				return nil, fmt.Errorf("pull secret %s: %w", secret.Name, err)
+			}
+			auths = config.Auths
+		case corev1.SecretTypeDockercfg:
+			if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &auths); err != nil {
+				return nil, fmt.Errorf("pull secret %s: %w", secret.Name, err)
+			}
+		default:
+			continue
+		}
+
+		registries := []string{}
+		for registry := range auths {
+			registries = append(registries, registry)
+		}
+		// Longer paths are more specific
+		sort.Slice(registries, func(i, j int) bool {
+			if len(registries[i]) != len(registries[j]) {
+				return len(registries[i]) > len(registries[j])
+			}
+			return registries[i] < registries[j]
+		})
+		for _, registry := range registries {
+			host, path := splitRegistry(registry)
+			keychain = append(keychain, credential{Host: host, Path: path, AuthConfig: auths[registry]})
+		}
+	}
+	return keychain, nil
+}
+
+// splitRegistry splits a registry entry of a pull secret, e.g.
+// https://index.docker.io/v1/ or registry.example.net/kismi, into its host
+// and repository path.
+func splitRegistry(registry string) (string, string) {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	host, path, _ := strings.Cut(registry, "/")
+	if dockerHub[host] {
+		host = name.DefaultRegistry
+	}
+	path = strings.Trim(path, "/")
+	if path == "v1" || path == "v2" {
+		path = ""
+	}
+	return host, path
+}
+
+func (k pullSecrets) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	host := target.RegistryStr()
+	if dockerHub[host] {
+		host = name.DefaultRegistry
+	}
+	repository := ""
+	if ref, ok := target.(name.Repository); ok {
+		repository = ref.RepositoryStr()
+	}
+	for _, credential := range k {
+		if credential.Host != host {
+			continue
+		}
+		if credential.Path != "" && repository != credential.Path && !strings.HasPrefix(repository, credential.Path+"/") {
+			continue
+		}
+		return authn.FromConfig(credential.AuthConfig), nil
+	}
+	return authn.Anonymous, nil
+}
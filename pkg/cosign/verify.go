@@ -0,0 +1,233 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cosign verifies the cosign signatures of container images against
+// a public key, with the sigstore libraries. It reads the signature cosign
+// attaches to an image in its registry, tagged sha256-<digest>.sig, so no
+// client side tooling is needed.
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+)
+
+const (
+	signatureAnnotation = "dev.cosignproject.cosign/signature"
+	cacheTTL            = 5 * time.Minute
+	maxBlobSize         = 1 << 20
+)
+
+// ErrUnsigned is returned for images without a cosign signature.
+var ErrUnsigned = errors.New("image is not signed")
+
+type result struct {
+	Digest  string
+	Err     error
+	Expires time.Time
+}
+
+// Verifier checks image signatures against a single public key. Results
+// are cached for a while, so images aren't looked up on every reconcile.
+type Verifier struct {
+	Key signature.Verifier
+	// Transport carries the registry requests
+	Transport http.RoundTripper
+
+	mu    sync.Mutex
+	cache map[string]result
+}
+
+// NewVerifier returns a Verifier for the PEM encoded public key, as
+// written by cosign generate-key-pair.
+func NewVerifier(key []byte) (*Verifier, error) {
+	public, err := cryptoutils.UnmarshalPEMToPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := signature.LoadVerifier(public, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		Key:       verifier,
+		Transport: remote.DefaultTransport,
+		cache:     map[string]result{},
+	}, nil
+}
+
+// Pinned returns the image reference pinned to the digest.
+func Pinned(image, digest string) string {
+	name := image
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	} else if colon := strings.LastIndex(name, ":"); colon > strings.LastIndex(name, "/") {
+		name = name[:colon]
+	}
+	return name + "@" + digest
+}
+
+// Verify checks that the image carries a signature of the key for its
+// digest and returns the digest. The registry is accessed with the
+// credentials the keychain has for it, anonymously without a keychain.
+func (v *Verifier) Verify(ctx context.Context, image string, keychain authn.Keychain) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	auth := authn.Anonymous
+	if keychain != nil {
+		auth, err = keychain.Resolve(ref.Context())
+		if err != nil {
+			return "", err
+		}
+	}
+	// Results are cached by credentials as well, other credentials may
+	// have access the ones before lacked
+	credentials, err := auth.Authorization()
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(credentials)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(encoded)
+	key := image + "@" + hex.EncodeToString(hash[:])
+
+	v.mu.Lock()
+	cached, ok := v.cache[key]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.Expires) {
+		return cached.Digest, cached.Err
+	}
+
+	digest, err := v.verify(ctx, ref, auth)
+	if ctx.Err() == nil {
+		v.store(key, result{Digest: digest, Err: err, Expires: time.Now().Add(cacheTTL)})
+	}
+	return digest, err
+}
+
+// store caches a result, dropping the expired ones. Keys include the
+// credentials, which change as pull secrets are rotated.
+func (v *Verifier) store(key string, entry result) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for cached, previous := range v.cache {
+		if !now.Before(previous.Expires) {
+			delete(v.cache, cached)
+		}
+	}
+	v.cache[key] = entry
+}
+
+func (v *Verifier) verify(ctx context.Context, ref name.Reference, auth authn.Authenticator) (string, error) {
+	registry := []remote.Option{remote.WithContext(ctx), remote.WithAuth(auth), remote.WithTransport(v.Transport)}
+	descriptor, err := remote.Head(ref, registry...)
+	if err != nil {
+		return "", err
+	}
+	digest := descriptor.Digest.String()
+
+	signatures, err := remote.Image(ref.Context().Tag(strings.Replace(digest, ":", "-", 1)+".sig"), registry...)
+	var status *transport.Error
+	if errors.As(err, &status) && status.StatusCode == http.StatusNotFound {
+		return "", ErrUnsigned
+	}
+	if err != nil {
+		return "", err
+	}
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	failures := []string{}
+	for _, layer := range manifest.Layers {
+		signature, ok := layer.Annotations[signatureAnnotation]
+		if !ok {
+			continue
+		}
+		err := v.verifyLayer(ctx, signatures, layer.Digest, signature, digest)
+		if err == nil {
+			return digest, nil
+		}
+		failures = append(failures, err.Error())
+	}
+	if len(failures) == 0 {
+		return "", ErrUnsigned
+	}
+	return "", fmt.Errorf("no valid signature for %s: %s", digest, strings.Join(failures, "; "))
+}
+
+// verifyLayer checks a signature and that its payload names the digest.
+func (v *Verifier) verifyLayer(ctx context.Context, signatures v1.Image, layer v1.Hash, signature, digest string) error {
+	blob, err := signatures.LayerByDigest(layer)
+	if err != nil {
+		return err
+	}
+	// The registry client checks the payload against its digest
+	reader, err := blob.Compressed()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	signed, err := io.ReadAll(io.LimitReader(reader, maxBlobSize))
+	if err != nil {
+		return err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	if err := v.Key.VerifySignature(bytes.NewReader(raw), bytes.NewReader(signed), options.WithContext(ctx)); err != nil {
+		return errors.New("signature does not match the key")
+	}
+
+	claim := payload.SimpleContainerImage{}
+	if err := json.Unmarshal(signed, &claim); err != nil {
+		return err
+	}
+	if claim.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s", claim.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
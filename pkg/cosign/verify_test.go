@@ -0,0 +1,233 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var credentials = authn.FromConfig(authn.AuthConfig{Username: "pull", Password: "secret"})
+
+// push starts a registry only serving pulls with credentials, holding the
+// image example/mo-daemon:v1.2, and returns the repository and a pull
+// secret for it. Unless signed is nil, the image carries a signature made
+// with the key over a payload naming the digest signed.
+func push(t *testing.T, key *ecdsa.PrivateKey, signed func(digest name.Digest) name.Digest) (string, authn.Keychain) {
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "pull" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	repository, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/example/mo-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(repository.Tag("v1.2"), image, remote.WithAuth(credentials)); err != nil {
+		t.Fatal(err)
+	}
+	keychain, err := Keychain([]corev1.Secret{{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {"` + repository.RegistryStr() + `": {"username": "pull", "password": "secret"}}}`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed == nil {
+		return repository.String(), keychain
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := payload.Cosign{Image: signed(repository.Digest(digest.String()))}.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signature.LoadSigner(key, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := signer.SignMessage(bytes.NewReader(claim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       static.NewLayer(claim, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{signatureAnnotation: base64.StdEncoding.EncodeToString(raw)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := repository.Tag(strings.Replace(digest.String(), ":", "-", 1) + ".sig")
+	if err := remote.Write(tag, signatures, remote.WithAuth(credentials)); err != nil {
+		t.Fatal(err)
+	}
+	return repository.String(), keychain
+}
+
+func verifier(t *testing.T, key *ecdsa.PrivateKey) *Verifier {
+	encoded, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestVerify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	same := func(digest name.Digest) name.Digest { return digest }
+
+	repository, keychain := push(t, key, same)
+	digest, err := verifier(t, key).Verify(context.Background(), repository+":v1.2", keychain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		t.Errorf("got digest %s", digest)
+	}
+	if _, err := verifier(t, key).Verify(context.Background(), repository+"@"+digest, keychain); err != nil {
+		t.Errorf("pinned image: %v", err)
+	}
+	if _, err := verifier(t, key).Verify(context.Background(), repository+":v1.2", nil); err == nil {
+		t.Error("verified an image without credentials")
+	}
+	if _, err := verifier(t, other).Verify(context.Background(), repository+":v1.2", keychain); err == nil {
+		t.Error("verified a signature of another key")
+	}
+
+	repository, keychain = push(t, key, func(digest name.Digest) name.Digest {
+		return digest.Context().Digest("sha256:4f6b0c2ea1c1d6b4a3b0e0e5f1d1c9a8f7e6d5c4b3a2918273645546372819aa")
+	})
+	if _, err := verifier(t, key).Verify(context.Background(), repository+":v1.2", keychain); err == nil {
+		t.Error("verified a signature of another digest")
+	}
+
+	repository, keychain = push(t, key, nil)
+	if _, err := verifier(t, key).Verify(context.Background(), repository+":v1.2", keychain); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+}
+
+func TestKeychain(t *testing.T) {
+	secrets := []corev1.Secret{
+		{
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {
+				"https://index.docker.io/v1/": {"auth": "aHViOmh1Yg=="},
+				"registry.example.net/kismi": {"username": "kismi", "password": "kismi"}
+			}}`)},
+		},
+		{
+			Type: corev1.SecretTypeDockercfg,
+			Data: map[string][]byte{corev1.DockerConfigKey: []byte(`{
+				"registry.example.net": {"username": "example", "password": "example"}
+			}`)},
+		},
+		{Type: corev1.SecretTypeOpaque},
+	}
+	keychain, err := Keychain(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"kismi/mo-daemon":                      "hub",
+		"registry.example.net/kismi/mo-daemon": "kismi",
+		"registry.example.net/other/mo-daemon": "example",
+		"ghcr.io/kismi/mo-daemon":              "",
+	}
+	for image, want := range cases {
+		repository, err := name.NewRepository(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := keychain.Resolve(repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := auth.Authorization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Username != want {
+			t.Errorf("%s: got user %q, want %q", image, config.Username, want)
+		}
+	}
+
+	if _, err := Keychain([]corev1.Secret{{Type: corev1.SecretTypeDockerConfigJson}}); err == nil {
+		t.Error("accepted a pull secret without config")
+	}
+}
+
+func TestPinned(t *testing.T) {
+	if got := Pinned("localhost:5000/mo-daemon:v1", "sha256:1"); got != "localhost:5000/mo-daemon@sha256:1" {
+		t.Errorf("got pinned %s", got)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := verifier(t, key)
+	verifier.store("expired", result{Expires: time.Now().Add(-time.Second)})
+	verifier.store("current", result{Expires: time.Now().Add(time.Minute)})
+	verifier.store("new", result{Expires: time.Now().Add(time.Minute)})
+	if _, ok := verifier.cache["expired"]; ok || len(verifier.cache) != 2 {
+		t.Errorf("got cache %v", verifier.cache)
+	}
+}
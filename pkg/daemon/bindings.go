@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Types of binding events
+const (
+	BindingAdded    = "ADDED"
+	BindingModified = "MODIFIED"
+	BindingDeleted  = "DELETED"
+	// BindingError ends a stream of events, its message tells why.
+	BindingError = "ERROR"
+)
+
+// BindingTable is the binding cache of an agent at a time
+type BindingTable struct {
+	Replica  string    `json:"replica"`
+	Time     time.Time `json:"time"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// BindingEvent is a change of the binding cache of an agent, in the manner
+// of the events of a Kubernetes watch.
+type BindingEvent struct {
+	Type    string  `json:"type"`
+	Binding Binding `json:"binding"`
+	Message string  `json:"message,omitempty"`
+}
+
+// DiffBindings returns the events turning the bindings old into current,
+// ordered by home address. A binding is modified if it moved to another
+// care-of address or was registered again; the lifetime counting down
+// isn't a change.
+func DiffBindings(old, current []Binding) []BindingEvent {
+	previous := make(map[string]Binding, len(old))
+	for _, binding := range old {
+		previous[binding.HomeAddress] = binding
+	}
+	events := []BindingEvent{}
+	for _, binding := range current {
+		before, found := previous[binding.HomeAddress]
+		delete(previous, binding.HomeAddress)
+		switch {
+		case !found:
+			events = append(events, BindingEvent{Type: BindingAdded, Binding: binding})
+		case before.CareOfAddress != binding.CareOfAddress || before.Sequence != binding.Sequence ||
+			!reflect.DeepEqual(before.Flags, binding.Flags):
+			events = append(events, BindingEvent{Type: BindingModified, Binding: binding})
+		}
+	}
+	for _, binding := range previous {
+		events = append(events, BindingEvent{Type: BindingDeleted, Binding: binding})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Binding.HomeAddress < events[j].Binding.HomeAddress
+	})
+	return events
+}
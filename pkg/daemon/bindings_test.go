@@ -0,0 +1,51 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffBindings(t *testing.T) {
+	old := []Binding{
+		{HomeAddress: "2001:db8::1", CareOfAddress: "2001:db8:1::1", Lifetime: 300, Sequence: 1},
+		{HomeAddress: "2001:db8::2", CareOfAddress: "2001:db8:1::2", Lifetime: 300, Sequence: 4},
+		{HomeAddress: "2001:db8::3", CareOfAddress: "2001:db8:1::3", Lifetime: 300, Sequence: 7},
+	}
+	current := []Binding{
+		{HomeAddress: "2001:db8::4", CareOfAddress: "2001:db8:1::4", Lifetime: 300, Sequence: 1},
+		{HomeAddress: "2001:db8::1", CareOfAddress: "2001:db8:1::1", Lifetime: 120, Sequence: 1},
+		{HomeAddress: "2001:db8::2", CareOfAddress: "2001:db8:2::2", Lifetime: 300, Sequence: 5},
+	}
+	got := DiffBindings(old, current)
+	expected := []BindingEvent{
+		{Type: BindingModified, Binding: current[2]},
+		{Type: BindingDeleted, Binding: old[2]},
+		{Type: BindingAdded, Binding: current[0]},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v", got)
+	}
+
+	if events := DiffBindings(nil, old); len(events) != 3 || events[0].Type != BindingAdded {
+		t.Errorf("got %+v", events)
+	}
+	if events := DiffBindings(old, old); len(events) != 0 {
+		t.Errorf("got %+v", events)
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package daemon implements the client side of mo-daemon's management API,
+// which every agent pod serves on ManagementPort. Besides reading the state
+// of an agent it is the control channel the operator pushes configuration
+// and triggers reloads through.
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ManagementPort is the port the management API listens on.
+	ManagementPort = 8700
+	// HealthPort serves health, readiness and the drain in plain HTTP
+	// while the management API requires mutual TLS, so the kubelet can
+	// reach them.
+	HealthPort = 8701
+
+	defaultTimeout = 5 * time.Second
+)
+
+// Binding is an entry of a home agent's binding cache
+type Binding struct {
+	HomeAddress   string   `json:"homeAddress"`
+	CareOfAddress string   `json:"careOfAddress"`
+	Lifetime      int32    `json:"lifetime"`
+	Flags         []string `json:"flags,omitempty"`
+	Sequence      int32    `json:"sequence,omitempty"`
+}
+
+// Session is a route optimization session with a correspondent node
+type Session struct {
+	Correspondent string `json:"correspondent"`
+	HomeAddress   string `json:"homeAddress"`
+	CareOfAddress string `json:"careOfAddress"`
+	State         string `json:"state"`
+}
+
+// Stats are the counters and identity of a running agent
+type Stats struct {
+	Version       string  `json:"version"`
+	ConfigHash    string  `json:"configHash,omitempty"`
+	SPIs          []int64 `json:"spis,omitempty"`
+	Bindings      int32   `json:"bindings"`
+	Registrations uint64  `json:"registrations"`
+	Rejected      uint64  `json:"rejected"`
+	UptimeSeconds int64   `json:"uptimeSeconds"`
+
+	// TunnelsUp and PeeringsUp report whether the tunnel interfaces and the
+	// peerings with the other replicas are established.
+	TunnelsUp  bool `json:"tunnelsUp"`
+	PeeringsUp bool `json:"peeringsUp"`
+
+	// Role is "active" or "standby" in active/standby mode.
+	Role string `json:"role,omitempty"`
+
+	// Peers is the state of the binding replication with every peer.
+	Peers []PeerStats `json:"peers,omitempty"`
+
+	// SAs are the IPsec SAs protecting the tunnels.
+	SAs []SAStats `json:"sas,omitempty"`
+
+	// AAA are the AAA servers the agent authenticates with.
+	AAA []AAAStats `json:"aaa,omitempty"`
+}
+
+// States of an IPsec SA
+const (
+	SAEstablished = "established"
+	SAConnecting  = "connecting"
+	SAFailed      = "failed"
+)
+
+// SAStats is the state of the IPsec SA with a peer
+type SAStats struct {
+	Peer  string `json:"peer"`
+	State string `json:"state"`
+}
+
+// AAAStats is the reachability of an AAA server
+type AAAStats struct {
+	Server    string `json:"server"`
+	Reachable bool   `json:"reachable"`
+}
+
+// PeerStats is the state of the binding replication with a peer
+type PeerStats struct {
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+	LagMillis int64  `json:"lagMillis"`
+}
+
+// BackupResult describes a snapshot written by an agent
+type BackupResult struct {
+	Location string `json:"location"`
+	Bindings int32  `json:"bindings"`
+}
+
+// Client talks to the management API of a single agent, addressed by the
+// IP of its pod.
+type Client interface {
+	// Bindings returns the binding cache of the agent.
+	Bindings(ctx context.Context, addr string) ([]Binding, error)
+
+	// Sessions returns the route optimization sessions of the agent.
+	Sessions(ctx context.Context, addr string) ([]Session, error)
+
+	// Stats returns the counters of the agent.
+	Stats(ctx context.Context, addr string) (*Stats, error)
+
+	// PushConfig hands a new configuration file to the agent. It is
+	// validated and staged, but only applied by Reload.
+	PushConfig(ctx context.Context, addr string, config []byte) error
+
+	// PushKeyring hands the keyring, SPI key files and the active SPI, to
+	// the agent instead of mounting it. Like a configuration it is only
+	// applied by Reload.
+	PushKeyring(ctx context.Context, addr string, keyring map[string][]byte) error
+
+	// Reload applies the staged configuration without restarting the agent.
+	Reload(ctx context.Context, addr string) error
+
+	// Drain makes the agent stop accepting registrations and hand its
+	// bindings over to the other replicas, or revoke them, within timeout.
+	Drain(ctx context.Context, addr string, timeout time.Duration) error
+
+	// Backup makes the agent write a snapshot of its binding state to
+	// location, a path or an object store URL.
+	Backup(ctx context.Context, addr, location string) (*BackupResult, error)
+
+	// Restore makes the agent import the bindings of the given snapshots.
+	Restore(ctx context.Context, addr string, locations []string) error
+
+	// SetRole makes the agent the active one, which takes over the
+	// advertised address, or a standby.
+	SetRole(ctx context.Context, addr, role string) error
+
+	// ExportBinding freezes the binding of a home address for a handoff to
+	// another agent and returns it. Packets for the mobile node are
+	// buffered until the binding is deleted or imported again.
+	ExportBinding(ctx context.Context, addr, homeAddress string) (*Binding, error)
+
+	// ImportBinding installs a binding exported by another agent.
+	ImportBinding(ctx context.Context, addr string, binding Binding) error
+
+	// DeleteBinding deregisters the binding of a home address.
+	DeleteBinding(ctx context.Context, addr, homeAddress string) error
+}
+
+// HTTPClient is a Client speaking JSON over HTTP
+type HTTPClient struct {
+	HTTP *http.Client
+	// Port overrides ManagementPort, e.g. for tests.
+	Port int
+	// Proxy is the URL of an API server the agents are reached through,
+	// for clusters whose pod network isn't routable from the operator.
+	// Agents are then addressed as "<namespace>/<pod>" instead of by IP.
+	Proxy string
+	// Secure speaks HTTPS to the agents, authenticated by the TLS
+	// configuration of the HTTP client.
+	Secure bool
+}
+
+// NewClient returns a Client with sensible timeouts.
+func NewClient() *HTTPClient {
+	return &HTTPClient{
+		HTTP: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// NewProxyClient returns a Client reaching the agents through the pods
+// proxy of the API server at host, using an HTTP client authenticated
+// against it.
+func NewProxyClient(client *http.Client, host string) *HTTPClient {
+	return &HTTPClient{HTTP: client, Proxy: strings.TrimSuffix(host, "/")}
+}
+
+func (c *HTTPClient) url(addr, path string) string {
+	port := c.Port
+	if port == 0 {
+		port = ManagementPort
+	}
+	if c.Proxy != "" {
+		namespace, pod, _ := strings.Cut(addr, "/")
+		return fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s:%d/proxy%s", c.Proxy, namespace, pod, port, path)
+	}
+	scheme := "http"
+	if c.Secure {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(port)) + path
+}
+
+// do sends a request to the agent and decodes the response into out, if
+// given. Errors reported by the agent are passed on with their message.
+func (c *HTTPClient) do(ctx context.Context, method, addr, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(addr, path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: unexpected status %s: %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *HTTPClient) get(ctx context.Context, addr, path string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, addr, path, nil, out)
+}
+
+// Bindings returns the binding cache of the agent.
+func (c *HTTPClient) Bindings(ctx context.Context, addr string) ([]Binding, error) {
+	bindings := []Binding{}
+	err := c.get(ctx, addr, "/v1/bindings", &bindings)
+	return bindings, err
+}
+
+// Sessions returns the route optimization sessions of the agent.
+func (c *HTTPClient) Sessions(ctx context.Context, addr string) ([]Session, error) {
+	sessions := []Session{}
+	err := c.get(ctx, addr, "/v1/sessions", &sessions)
+	return sessions, err
+}
+
+// Stats returns the counters of the agent.
+func (c *HTTPClient) Stats(ctx context.Context, addr string) (*Stats, error) {
+	stats := &Stats{}
+	err := c.get(ctx, addr, "/v1/stats", stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// PushConfig hands a new configuration file to the agent.
+func (c *HTTPClient) PushConfig(ctx context.Context, addr string, config []byte) error {
+	if config == nil {
+		config = []byte{}
+	}
+	return c.do(ctx, http.MethodPut, addr, "/v1/config", config, nil)
+}
+
+// PushKeyring hands the keyring to the agent.
+func (c *HTTPClient) PushKeyring(ctx context.Context, addr string, keyring map[string][]byte) error {
+	body, err := json.Marshal(keyring)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPut, addr, "/v1/keyring", body, nil)
+}
+
+// Reload applies the staged configuration.
+func (c *HTTPClient) Reload(ctx context.Context, addr string) error {
+	return c.do(ctx, http.MethodPost, addr, "/v1/reload", nil, nil)
+}
+
+// DrainPath is the management API path draining the agent. It accepts GET,
+// as that is all the kubelet sends for a preStop hook.
+func DrainPath(timeout time.Duration) string {
+	return "/v1/drain?timeout=" + strconv.Itoa(int(timeout.Seconds()))
+}
+
+// Drain makes the agent hand over or revoke its bindings.
+func (c *HTTPClient) Drain(ctx context.Context, addr string, timeout time.Duration) error {
+	return c.do(ctx, http.MethodGet, addr, DrainPath(timeout), nil, nil)
+}
+
+// Backup makes the agent write a snapshot of its binding state.
+func (c *HTTPClient) Backup(ctx context.Context, addr, location string) (*BackupResult, error) {
+	body, err := json.Marshal(map[string]string{"location": location})
+	if err != nil {
+		return nil, err
+	}
+	result := &BackupResult{}
+	err = c.do(ctx, http.MethodPost, addr, "/v1/backup", body, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Restore makes the agent import the bindings of the snapshots.
+func (c *HTTPClient) Restore(ctx context.Context, addr string, locations []string) error {
+	body, err := json.Marshal(map[string][]string{"locations": locations})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, addr, "/v1/restore", body, nil)
+}
+
+// SetRole switches the role of the agent.
+func (c *HTTPClient) SetRole(ctx context.Context, addr, role string) error {
+	body, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPut, addr, "/v1/role", body, nil)
+}
+
+func bindingPath(homeAddress string) string {
+	return "/v1/bindings/" + url.PathEscape(homeAddress)
+}
+
+// ExportBinding freezes the binding of a home address and returns it.
+func (c *HTTPClient) ExportBinding(ctx context.Context, addr, homeAddress string) (*Binding, error) {
+	binding := &Binding{}
+	err := c.do(ctx, http.MethodPost, addr, bindingPath(homeAddress)+"/export", nil, binding)
+	if err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// ImportBinding installs a binding exported by another agent.
+func (c *HTTPClient) ImportBinding(ctx context.Context, addr string, binding Binding) error {
+	body, err := json.Marshal(binding)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPut, addr, bindingPath(binding.HomeAddress), body, nil)
+}
+
+// DeleteBinding deregisters the binding of a home address.
+func (c *HTTPClient) DeleteBinding(ctx context.Context, addr, homeAddress string) error {
+	return c.do(ctx, http.MethodDelete, addr, bindingPath(homeAddress), nil, nil)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// agent starts a fake management API and returns a client pointed at it
+// together with the address to pass.
+func agent(t *testing.T, handler http.HandlerFunc) (*HTTPClient, string) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	client.Port, _ = strconv.Atoi(port)
+	return client, host
+}
+
+func TestStats(t *testing.T) {
+	client, addr := agent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/stats" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		io.WriteString(w, `{"version":"1.4.0","bindings":3,"registrations":10}`)
+	})
+
+	stats, err := client.Stats(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Version != "1.4.0" || stats.Bindings != 3 || stats.Registrations != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPushConfigAndReload(t *testing.T) {
+	var pushed string
+	reloaded := false
+	client, addr := agent(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/v1/config":
+			body, _ := io.ReadAll(r.Body)
+			pushed = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/reload":
+			reloaded = true
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	if err := client.PushConfig(context.Background(), addr, []byte("mtu = 1400")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Reload(context.Background(), addr); err != nil {
+		t.Fatal(err)
+	}
+	if pushed != "mtu = 1400" || !reloaded {
+		t.Errorf("expected the config to be pushed and reloaded, got %q, %v", pushed, reloaded)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	client, addr := agent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/backup" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"location":"s3://backups/ha-0.db"}` {
+			t.Errorf("unexpected body %s", body)
+		}
+		io.WriteString(w, `{"location":"s3://backups/ha-0.db","bindings":7}`)
+	})
+
+	result, err := client.Backup(context.Background(), addr, "s3://backups/ha-0.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Location != "s3://backups/ha-0.db" || result.Bindings != 7 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/namespaces/mobility/pods/ha-0:8700/proxy/v1/bindings/2001:db8::1/export" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		io.WriteString(w, `{"homeAddress":"2001:db8::1","careOfAddress":"2001:db8:1::5","lifetime":60,"sequence":4}`)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewProxyClient(server.Client(), server.URL+"/")
+	binding, err := client.ExportBinding(context.Background(), "mobility/ha-0", "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.CareOfAddress != "2001:db8:1::5" || binding.Sequence != 4 {
+		t.Errorf("unexpected binding %+v", binding)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	client, addr := agent(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid mtu", http.StatusBadRequest)
+	})
+
+	err := client.PushConfig(context.Background(), addr, []byte("mtu = 1"))
+	if err == nil || !strings.Contains(err.Error(), "invalid mtu") {
+		t.Errorf("expected the error of the agent to be passed on, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+// Policy is the compiled registration policy mo-daemon enforces. Rules are
+// evaluated in order and the first match decides, registrations matching no
+// rule get the Default action.
+type Policy struct {
+	Default     string       `json:"default"`
+	MaxBindings int32        `json:"maxBindings,omitempty"`
+	Rules       []PolicyRule `json:"rules,omitempty"`
+}
+
+// PolicyRule is a single rule of a Policy. Empty match fields match anything.
+type PolicyRule struct {
+	Action string `json:"action"`
+	NAI    string `json:"nai,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// RateBPS and BurstBytes limit the traffic of the mobile nodes an
+	// allowing rule matches, zero keeps the limit of the agent.
+	RateBPS    int64 `json:"rateBps,omitempty"`
+	BurstBytes int64 `json:"burstBytes,omitempty"`
+	// Source names the BindingPolicy the rule was compiled from.
+	Source string `json:"source"`
+}
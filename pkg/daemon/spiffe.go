@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The X.509 SVID of the operator as written by spiffe-helper. The files are
+// read on every handshake, so rotated SVIDs are picked up right away.
+const (
+	SVIDFile       = "svid.pem"
+	SVIDKeyFile    = "svid_key.pem"
+	SVIDBundleFile = "svid_bundle.pem"
+)
+
+// NewSPIFFEClient returns a Client authenticating the control channel with
+// the SVID in dir. Agents have to present an SVID of the trust domain.
+func NewSPIFFEClient(dir, trustDomain string) *HTTPClient {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			certificate, err := tls.LoadX509KeyPair(filepath.Join(dir, SVIDFile), filepath.Join(dir, SVIDKeyFile))
+			return &certificate, err
+		},
+		// SVIDs carry no DNS names, the peer is verified against the
+		// bundle and the trust domain instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			bundle, err := os.ReadFile(filepath.Join(dir, SVIDBundleFile))
+			if err != nil {
+				return err
+			}
+			return verifySVID(raw, bundle, trustDomain)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &HTTPClient{
+		HTTP:   &http.Client{Timeout: defaultTimeout, Transport: transport},
+		Secure: true,
+	}
+}
+
+// CheckSVID tells whether dir holds a usable SVID and trust bundle.
+func CheckSVID(dir string) error {
+	_, err := tls.LoadX509KeyPair(filepath.Join(dir, SVIDFile), filepath.Join(dir, SVIDKeyFile))
+	if err != nil {
+		return err
+	}
+	bundle, err := os.ReadFile(filepath.Join(dir, SVIDBundleFile))
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return errors.New("trust bundle holds no certificate")
+	}
+	return nil
+}
+
+// verifySVID checks that the certificate chain is signed by the bundle and
+// that the leaf carries a SPIFFE ID of the trust domain.
+func verifySVID(raw [][]byte, bundle []byte, trustDomain string) error {
+	if len(raw) == 0 {
+		return errors.New("no certificate presented")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return errors.New("trust bundle holds no certificate")
+	}
+
+	certificates := make([]*x509.Certificate, len(raw))
+	for idx, der := range raw {
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certificates[idx] = certificate
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	leaf := certificates[0]
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(leaf.URIs) != 1 || leaf.URIs[0].Scheme != "spiffe" {
+		return errors.New("certificate carries no SPIFFE ID")
+	}
+	if leaf.URIs[0].Host != trustDomain {
+		return fmt.Errorf("SPIFFE ID %s is not part of trust domain %s", leaf.URIs[0], trustDomain)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package daemon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// certificate issues a certificate for the SPIFFE ID, self-signed if no
+// parent is given.
+func certificate(t *testing.T, id string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		uri, _ := url.Parse(id)
+		template.URIs = []*url.URL{uri}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed, key
+}
+
+func TestVerifySVID(t *testing.T) {
+	ca, caKey := certificate(t, "ca", nil, nil)
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	other, otherKey := certificate(t, "other", nil, nil)
+
+	agent, _ := certificate(t, "spiffe://prairie.example/ns/default/sa/ha-sample", ca, caKey)
+	if err := verifySVID([][]byte{agent.Raw}, bundle, "prairie.example"); err != nil {
+		t.Errorf("SVID rejected: %v", err)
+	}
+	if err := verifySVID([][]byte{agent.Raw}, bundle, "other.example"); err == nil {
+		t.Error("SVID of another trust domain accepted")
+	}
+
+	foreign, _ := certificate(t, "spiffe://prairie.example/ns/default/sa/ha-sample", other, otherKey)
+	if err := verifySVID([][]byte{foreign.Raw}, bundle, "prairie.example"); err == nil {
+		t.Error("SVID signed outside the bundle accepted")
+	}
+}
+
+func TestCheckSVID(t *testing.T) {
+	ca, caKey := certificate(t, "ca", nil, nil)
+	svid, svidKey := certificate(t, "spiffe://prairie.example/ns/prairie-operator-system/sa/controller-manager", ca, caKey)
+	der, err := x509.MarshalECPrivateKey(svidKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := CheckSVID(dir); err == nil {
+		t.Error("empty directory accepted")
+	}
+	write := func(name string, block *pem.Block) {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(SVIDFile, &pem.Block{Type: "CERTIFICATE", Bytes: svid.Raw})
+	write(SVIDKeyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := CheckSVID(dir); err == nil {
+		t.Error("SVID without bundle accepted")
+	}
+	write(SVIDBundleFile, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	if err := CheckSVID(dir); err != nil {
+		t.Errorf("SVID rejected: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewServer(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:6060": true,
+		"[::1]:6060":     true,
+		"localhost:6060": true,
+		":6060":          false,
+		"0.0.0.0:6060":   false,
+		"10.0.0.1:6060":  false,
+	} {
+		if _, err := NewServer(addr, false); (err == nil) != ok {
+			t.Errorf("%s: got error %v", addr, err)
+		}
+	}
+	if _, err := NewServer(":6060", true); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("pprof: got status %s", resp.Status)
+	}
+
+	resp, err = http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	vars := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vars["goroutines"]; !ok {
+		t.Error("goroutines are missing")
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("memstats are missing")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Handle("/bindings/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	server := httptest.NewServer(s.mux)
+	defer server.Close()
+
+	for path, expected := range map[string]int{"/bindings/default/ha/ha-0": http.StatusTeapot, "/debug/vars": http.StatusOK} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("%s: got status %s", path, resp.Status)
+		}
+	}
+}
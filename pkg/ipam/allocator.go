@@ -0,0 +1,276 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ipam hands out addresses from a set of CIDR ranges. It keeps no
+// state of its own: callers load the current allocations, allocate or
+// release, and persist the result.
+package ipam
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// ErrExhausted is returned when every address of the pool is in use.
+var ErrExhausted = errors.New("address pool exhausted")
+
+// randomProbes bounds the number of random picks before falling back to a
+// sequential scan, which keeps nearly full pools from spinning.
+const randomProbes = 64
+
+// Allocator tracks the addresses in use within a set of ranges
+type Allocator struct {
+	ranges   []*net.IPNet
+	reserved map[string]bool
+	used     map[string]string
+}
+
+// New returns an Allocator for the given CIDR ranges which never hands out
+// the reserved addresses.
+func New(cidrs []string, reserved []string) (*Allocator, error) {
+	a := &Allocator{
+		reserved: map[string]bool{},
+		used:     map[string]string{},
+	}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		a.ranges = append(a.ranges, ipnet)
+	}
+	for _, addr := range reserved {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid reserved address %q", addr)
+		}
+		a.reserved[ip.String()] = true
+	}
+	return a, nil
+}
+
+// Contains reports whether the address lies within one of the ranges.
+func (a *Allocator) Contains(ip net.IP) bool {
+	for _, ipnet := range a.ranges {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Use marks an address as allocated to owner, e.g. when loading existing
+// allocations. It fails if the address is outside the ranges, a network or
+// broadcast address, reserved or already held by someone else.
+func (a *Allocator) Use(addr, owner string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", addr)
+	}
+	if !a.Contains(ip) {
+		return fmt.Errorf("address %s is not part of the pool", ip)
+	}
+	if !a.assignable(ip) {
+		return fmt.Errorf("address %s is the network or broadcast address of its range", ip)
+	}
+	key := ip.String()
+	if a.reserved[key] {
+		return fmt.Errorf("address %s is reserved", ip)
+	}
+	if holder, ok := a.used[key]; ok && holder != owner {
+		return fmt.Errorf("address %s is already allocated to %s", ip, holder)
+	}
+	a.used[key] = owner
+	return nil
+}
+
+// Lookup returns the address allocated to owner, if any.
+func (a *Allocator) Lookup(owner string) (string, bool) {
+	for addr, holder := range a.used {
+		if holder == owner {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// Allocate hands out a free address to owner. Owners hold at most one
+// address, an existing allocation is returned as is. With random set the
+// address is picked at random, otherwise the lowest free address is used.
+func (a *Allocator) Allocate(owner string, random bool) (string, error) {
+	if addr, ok := a.Lookup(owner); ok {
+		return addr, nil
+	}
+
+	if random {
+		for i := 0; i < randomProbes; i++ {
+			ip, err := a.randomAddress()
+			if err != nil {
+				return "", err
+			}
+			if a.free(ip) {
+				a.used[ip.String()] = owner
+				return ip.String(), nil
+			}
+		}
+	}
+
+	for _, ipnet := range a.ranges {
+		first, end := hostOffsets(ipnet)
+		for offset := first; offset.Cmp(end) < 0; offset.Add(offset, big.NewInt(1)) {
+			ip := addressAt(ipnet, offset)
+			if a.free(ip) {
+				a.used[ip.String()] = owner
+				return ip.String(), nil
+			}
+		}
+	}
+	return "", ErrExhausted
+}
+
+// Release frees the address held by owner.
+func (a *Allocator) Release(owner string) {
+	for addr, holder := range a.used {
+		if holder == owner {
+			delete(a.used, addr)
+		}
+	}
+}
+
+// Allocations returns the allocated addresses mapped to their owners.
+func (a *Allocator) Allocations() map[string]string {
+	allocations := make(map[string]string, len(a.used))
+	for addr, owner := range a.used {
+		allocations[addr] = owner
+	}
+	return allocations
+}
+
+// Capacity returns the number of addresses that can be handed out. The
+// network address of every range and the broadcast address of IPv4 ranges
+// are never used.
+func (a *Allocator) Capacity() *big.Int {
+	capacity := big.NewInt(0)
+	for _, ipnet := range a.ranges {
+		first, end := hostOffsets(ipnet)
+		capacity.Add(capacity, end.Sub(end, first))
+	}
+	for addr := range a.reserved {
+		if a.assignable(net.ParseIP(addr)) {
+			capacity.Sub(capacity, big.NewInt(1))
+		}
+	}
+	return capacity
+}
+
+// assignable reports whether the address lies within one of the ranges and
+// can be handed out.
+func (a *Allocator) assignable(ip net.IP) bool {
+	for _, ipnet := range a.ranges {
+		if !ipnet.Contains(ip) {
+			continue
+		}
+		if len(ipnet.IP) == net.IPv4len {
+			ip = ip.To4()
+		}
+		offset := new(big.Int).SetBytes(ip)
+		offset.Sub(offset, new(big.Int).SetBytes(ipnet.IP))
+		first, end := hostOffsets(ipnet)
+		return offset.Cmp(first) >= 0 && offset.Cmp(end) < 0
+	}
+	return false
+}
+
+func (a *Allocator) free(ip net.IP) bool {
+	key := ip.String()
+	_, used := a.used[key]
+	return !used && !a.reserved[key]
+}
+
+func (a *Allocator) randomAddress() (net.IP, error) {
+	if len(a.ranges) == 0 {
+		return nil, ErrExhausted
+	}
+	idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(a.ranges))))
+	if err != nil {
+		return nil, err
+	}
+	ipnet := a.ranges[idx.Int64()]
+
+	first, end := hostOffsets(ipnet)
+	hosts := new(big.Int).Sub(end, first)
+	if hosts.Sign() <= 0 {
+		return nil, ErrExhausted
+	}
+	offset, err := rand.Int(rand.Reader, hosts)
+	if err != nil {
+		return nil, err
+	}
+	return addressAt(ipnet, offset.Add(offset, first)), nil
+}
+
+// Overlaps reports whether any range of a overlaps any range of b.
+func Overlaps(a, b []string) (bool, error) {
+	for _, x := range a {
+		_, xnet, err := net.ParseCIDR(x)
+		if err != nil {
+			return false, err
+		}
+		for _, y := range b {
+			_, ynet, err := net.ParseCIDR(y)
+			if err != nil {
+				return false, err
+			}
+			if xnet.Contains(ynet.IP) || ynet.Contains(xnet.IP) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func rangeSize(ipnet *net.IPNet) *big.Int {
+	ones, bits := ipnet.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// hostOffsets returns the offsets of the first address of the range which
+// can be handed out and of the one past the last. The network address is
+// never used, nor is the broadcast address of IPv4 ranges larger than a /31.
+// Point-to-point ranges, /31 and /127, and single addresses have no network
+// address, every address of them is used.
+func hostOffsets(ipnet *net.IPNet) (*big.Int, *big.Int) {
+	end := rangeSize(ipnet)
+	if end.Cmp(big.NewInt(2)) <= 0 {
+		return big.NewInt(0), end
+	}
+	if len(ipnet.IP) == net.IPv4len {
+		end.Sub(end, big.NewInt(1))
+	}
+	return big.NewInt(1), end
+}
+
+func addressAt(ipnet *net.IPNet, offset *big.Int) net.IP {
+	base := new(big.Int).SetBytes(ipnet.IP)
+	base.Add(base, offset)
+
+	ip := make(net.IP, len(ipnet.IP))
+	base.FillBytes(ip)
+	return ip
+}
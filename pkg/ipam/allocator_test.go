@@ -0,0 +1,249 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAllocateSequential(t *testing.T) {
+	a, err := New([]string{"2001:db8::/126"}, []string{"2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := a.Allocate("MobileNode/a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != "2001:db8::2" {
+		t.Errorf("expected 2001:db8::2, got %s", first)
+	}
+
+	again, _ := a.Allocate("MobileNode/a", false)
+	if again != first {
+		t.Errorf("expected the existing allocation %s, got %s", first, again)
+	}
+
+	second, err := a.Allocate("MobileNode/b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != "2001:db8::3" {
+		t.Errorf("expected 2001:db8::3, got %s", second)
+	}
+
+	if _, err := a.Allocate("MobileNode/c", false); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected the pool to be exhausted, got %v", err)
+	}
+
+	a.Release("MobileNode/a")
+	third, err := a.Allocate("MobileNode/c", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != first {
+		t.Errorf("expected the released address %s, got %s", first, third)
+	}
+}
+
+func TestAllocateRandom(t *testing.T) {
+	a, err := New([]string{"10.0.0.0/24"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 254; i++ {
+		addr, err := a.Allocate(string(rune('a'+i)), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[addr] {
+			t.Fatalf("address %s handed out twice", addr)
+		}
+		if !a.Contains(net.ParseIP(addr)) {
+			t.Fatalf("address %s outside of the pool", addr)
+		}
+		seen[addr] = true
+	}
+	if a.Capacity().Int64() != 254 {
+		t.Errorf("expected a capacity of 254, got %s", a.Capacity())
+	}
+	if _, err := a.Allocate("last", true); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected the pool to be exhausted, got %v", err)
+	}
+	if seen["10.0.0.255"] {
+		t.Error("broadcast address handed out")
+	}
+}
+
+func TestAllocateSkipsBroadcast(t *testing.T) {
+	tests := []struct {
+		cidr      string
+		addresses []string
+	}{
+		{"10.0.0.0/30", []string{"10.0.0.1", "10.0.0.2"}},
+		{"10.0.0.0/31", []string{"10.0.0.0", "10.0.0.1"}},
+		{"10.0.0.5/32", []string{"10.0.0.5"}},
+		{"2001:db8::/126", []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{"2001:db8::/127", []string{"2001:db8::", "2001:db8::1"}},
+		{"2001:db8::5/128", []string{"2001:db8::5"}},
+	}
+
+	for _, test := range tests {
+		a, err := New([]string{test.cidr}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range test.addresses {
+			addr, err := a.Allocate(string(rune('a'+i)), false)
+			if err != nil {
+				t.Fatalf("%s: %v", test.cidr, err)
+			}
+			if addr != expected {
+				t.Errorf("%s: expected %s, got %s", test.cidr, expected, addr)
+			}
+		}
+		if _, err := a.Allocate("last", false); !errors.Is(err, ErrExhausted) {
+			t.Errorf("%s: expected the pool to be exhausted, got %v", test.cidr, err)
+		}
+		if a.Capacity().Int64() != int64(len(test.addresses)) {
+			t.Errorf("%s: expected a capacity of %d, got %s", test.cidr, len(test.addresses), a.Capacity())
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	tests := []struct {
+		addr  string
+		owner string
+		valid bool
+	}{
+		{"2001:db8::10", "a", true},
+		{"2001:db8::10", "a", true},
+		{"2001:db8::10", "b", false},
+		{"2001:db8::1", "b", false},
+		{"2001:db9::1", "b", false},
+		{"2001:db8::", "b", false},
+		{"10.0.0.1", "b", true},
+		{"10.0.0.0", "c", false},
+		{"10.0.0.255", "c", false},
+		{"10.0.1.1", "c", false},
+		{"not-an-address", "c", false},
+	}
+
+	a, err := New([]string{"2001:db8::/64", "10.0.0.0/24"}, []string{"2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		err := a.Use(test.addr, test.owner)
+		if test.valid && err != nil {
+			t.Errorf("Use(%s, %s) failed: %v", test.addr, test.owner, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Use(%s, %s) succeeded, expected it to be refused", test.addr, test.owner)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b    []string
+		overlap bool
+	}{
+		{[]string{"2001:db8::/48"}, []string{"2001:db8:0:1::/64"}, true},
+		{[]string{"2001:db8:0:1::/64"}, []string{"2001:db8::/48"}, true},
+		{[]string{"2001:db8::/64"}, []string{"2001:db8:0:1::/64"}, false},
+		{[]string{"10.0.0.0/24", "10.1.0.0/24"}, []string{"10.1.0.128/25"}, true},
+	}
+
+	for _, test := range tests {
+		overlap, err := Overlaps(test.a, test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if overlap != test.overlap {
+			t.Errorf("Overlaps(%v, %v) = %v, expected %v", test.a, test.b, overlap, test.overlap)
+		}
+	}
+}
+
+func TestSubnetAt(t *testing.T) {
+	tests := []struct {
+		cidr   string
+		length int
+		index  int64
+		subnet string
+	}{
+		{"2001:db8::/48", 64, 0, "2001:db8::/64"},
+		{"2001:db8::/48", 64, 1, "2001:db8:0:1::/64"},
+		{"2001:db8::/48", 56, 2, "2001:db8:0:200::/56"},
+		{"10.0.0.0/16", 24, 255, "10.0.255.0/24"},
+	}
+
+	for _, test := range tests {
+		subnet, err := SubnetAt(test.cidr, test.length, test.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subnet != test.subnet {
+			t.Errorf("SubnetAt(%s, %d, %d) = %s, expected %s", test.cidr, test.length, test.index, subnet, test.subnet)
+		}
+	}
+
+	if _, err := SubnetAt("10.0.0.0/16", 24, 256); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected the range to be exhausted, got %v", err)
+	}
+	if _, err := SubnetAt("10.0.0.0/16", 8, 0); err == nil {
+		t.Error("expected a shorter prefix length to be refused")
+	}
+}
+
+func TestAllocateRandomSmallRanges(t *testing.T) {
+	tests := map[string][]string{
+		"10.0.0.0/31":     {"10.0.0.0", "10.0.0.1"},
+		"10.0.0.5/32":     {"10.0.0.5"},
+		"2001:db8::/127":  {"2001:db8::", "2001:db8::1"},
+		"2001:db8::5/128": {"2001:db8::5"},
+	}
+	for cidr, addresses := range tests {
+		a, err := New([]string{cidr}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[string]bool{}
+		for i := range addresses {
+			addr, err := a.Allocate(string(rune('a'+i)), true)
+			if err != nil {
+				t.Fatalf("%s: %v", cidr, err)
+			}
+			seen[addr] = true
+		}
+		for _, expected := range addresses {
+			if !seen[expected] {
+				t.Errorf("%s: %s was not handed out, got %v", cidr, expected, seen)
+			}
+		}
+		if _, err := a.Allocate("last", true); !errors.Is(err, ErrExhausted) {
+			t.Errorf("%s: expected the pool to be exhausted, got %v", cidr, err)
+		}
+	}
+}
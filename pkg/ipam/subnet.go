@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// SubnetCount returns the number of subnets of the given prefix length
+// within cidr.
+func SubnetCount(cidr string, prefixLength int) (*big.Int, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipnet.Mask.Size()
+	if prefixLength < ones || prefixLength > bits {
+		return nil, fmt.Errorf("prefix length %d does not fit into %s", prefixLength, cidr)
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(prefixLength-ones)), nil
+}
+
+// SubnetAt returns the index-th subnet of the given prefix length within
+// cidr, e.g. SubnetAt("2001:db8::/48", 64, 1) is 2001:db8:0:1::/64.
+func SubnetAt(cidr string, prefixLength int, index int64) (string, error) {
+	count, err := SubnetCount(cidr, prefixLength)
+	if err != nil {
+		return "", err
+	}
+	if index < 0 || big.NewInt(index).Cmp(count) >= 0 {
+		return "", ErrExhausted
+	}
+
+	_, ipnet, _ := net.ParseCIDR(cidr)
+	_, bits := ipnet.Mask.Size()
+	offset := new(big.Int).Lsh(big.NewInt(index), uint(bits-prefixLength))
+	subnet := &net.IPNet{
+		IP:   addressAt(ipnet, offset),
+		Mask: net.CIDRMask(prefixLength, bits),
+	}
+	return subnet.String(), nil
+}
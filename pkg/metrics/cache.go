@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// informerTimeout bounds the wait for an informer on collection.
+const informerTimeout = time.Second
+
+var cacheObjectsDesc = prometheus.NewDesc("prairie_cache_objects",
+	"Objects held in the cache of the operator, broken down by kind.", []string{"kind"}, nil)
+
+// CacheCollector reports the number of cached objects of each kind.
+type CacheCollector struct {
+	cache   cache.Cache
+	scheme  *runtime.Scheme
+	elected <-chan struct{}
+	objects []client.Object
+}
+
+// NewCacheCollector returns a collector of the objects of the kinds in the
+// cache. The informers are started by the controllers once elected, so the
+// objects are only collected after elected is closed, which keeps a
+// standby from starting them.
+func NewCacheCollector(c cache.Cache, scheme *runtime.Scheme, elected <-chan struct{}, objects ...client.Object) *CacheCollector {
+	return &CacheCollector{cache: c, scheme: scheme, elected: elected, objects: objects}
+}
+
+func (c *CacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheObjectsDesc
+}
+
+func (c *CacheCollector) Collect(ch chan<- prometheus.Metric) {
+	select {
+	case <-c.elected:
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), informerTimeout)
+	defer cancel()
+	for _, obj := range c.objects {
+		gvk, err := apiutil.GVKForObject(obj, c.scheme)
+		if err != nil {
+			continue
+		}
+		informer, err := c.cache.GetInformer(ctx, obj)
+		if err != nil {
+			continue
+		}
+		// Informers of several namespaces don't expose a single store
+		indexer, ok := informer.(interface{ GetStore() toolscache.Store })
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(cacheObjectsDesc, prometheus.GaugeValue,
+			float64(len(indexer.GetStore().ListKeys())), gvk.Kind)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exports the metrics of the requests of the operator to
+// the API server and of its cache, next to the workqueue and reconcile
+// metrics of controller-runtime.
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rateLimiterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "rest_client",
+		Name:      "rate_limiter_duration_seconds",
+		Help:      "Time requests waited for the client side rate limiter, broken down by verb.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"verb"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "rest_client",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the requests to the API server, broken down by verb.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"verb"})
+
+	clientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "prairie",
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "Requests of the controllers through their client, broken down by controller, verb and kind. Reads are served from the cache.",
+	}, []string{"controller", "verb", "kind"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(rateLimiterDuration, requestDuration, clientRequests)
+
+	// client-go only takes the first registration of its metrics, which
+	// controller-runtime already made.
+	clientmetrics.RateLimiterLatency = latencyAdapter{rateLimiterDuration}
+	clientmetrics.RequestLatency = latencyAdapter{requestDuration}
+}
+
+// latencyAdapter observes the latencies reported by client-go. The URL is
+// left out, it would make a series of every object.
+type latencyAdapter struct {
+	metric *prometheus.HistogramVec
+}
+
+func (a latencyAdapter) Observe(_ context.Context, verb string, _ url.URL, latency time.Duration) {
+	a.metric.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// Client counts the requests a controller makes through it.
+type Client struct {
+	client.Client
+	controller string
+}
+
+// NewClient returns a client counting the requests of the controller made
+// through c.
+func NewClient(c client.Client, controller string) *Client {
+	return &Client{Client: c, controller: controller}
+}
+
+func (c *Client) count(verb string, obj runtime.Object) {
+	kind := ""
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	clientRequests.WithLabelValues(c.controller, verb, kind).Inc()
+}
+
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.count("get", obj)
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.count("list", list)
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *Client) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.count("create", obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *Client) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.count("update", obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *Client) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.count("patch", obj)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *Client) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.count("delete", obj)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *Client) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.count("deletecollection", obj)
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns a writer counting the updates of status subresources.
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type statusWriter struct {
+	client.StatusWriter
+	c *Client
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	w.c.count("update/status", obj)
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.c.count("patch/status", obj)
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
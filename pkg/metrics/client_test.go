@@ -0,0 +1,61 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClient(t *testing.T) {
+	c := NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build(), "homeagent")
+	ctx := context.Background()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-0", Namespace: "default"}}
+	if err := c.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.List(ctx, &corev1.PodList{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Status().Update(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	for verb, expected := range map[string]float64{"create": 1, "list": 1, "update/status": 1, "delete": 0} {
+		count := testutil.ToFloat64(clientRequests.WithLabelValues("homeagent", verb, "Pod"))
+		if count != expected {
+			t.Errorf("counted %v %s requests, expected %v", count, verb, expected)
+		}
+	}
+}
+
+func TestRateLimiterLatency(t *testing.T) {
+	clientmetrics.RateLimiterLatency.Observe(context.Background(), "GET", url.URL{}, 20*time.Millisecond)
+	if count := testutil.CollectAndCount(rateLimiterDuration); count != 1 {
+		t.Errorf("got %d series", count)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Results of a reconcile
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultRequeue = "requeue"
+)
+
+// SlowReconcile is the duration beyond which a reconcile is logged, to
+// spot pathological objects.
+const SlowReconcile = 10 * time.Second
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "prairie",
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciles of an object, broken down by kind and result.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"kind", "result"})
+
+	readyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "prairie",
+		Name:      "ready_duration_seconds",
+		Help:      "Time from a change of the spec of an object until it is ready again, broken down by kind.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 3600},
+	}, []string{"kind"})
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(reconcileDuration, readyDuration)
+}
+
+// Reconciler observes the duration and the result of the reconciles of a
+// kind.
+type Reconciler struct {
+	reconcile.Reconciler
+	kind string
+}
+
+// NewReconciler returns a reconciler observing the reconciles of r.
+func NewReconciler(kind string, r reconcile.Reconciler) *Reconciler {
+	return &Reconciler{Reconciler: r, kind: kind}
+}
+
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	start := time.Now()
+	result, err := r.Reconciler.Reconcile(ctx, req)
+	duration := time.Since(start)
+
+	outcome := ResultSuccess
+	if err != nil {
+		outcome = ResultError
+	} else if result.Requeue || result.RequeueAfter > 0 {
+		outcome = ResultRequeue
+	}
+	reconcileDuration.WithLabelValues(r.kind, outcome).Observe(duration.Seconds())
+	if duration > SlowReconcile {
+		log.FromContext(ctx).Info("Reconcile was slow.", "duration", duration.String(), "result", outcome)
+	}
+	return result, err
+}
+
+// ObserveReady records the time an object of the kind took to become ready
+// after a change of its spec.
+func ObserveReady(kind string, duration time.Duration) {
+	readyDuration.WithLabelValues(kind).Observe(duration.Seconds())
+}
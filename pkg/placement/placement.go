@@ -0,0 +1,48 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package placement tells whether pods can be scheduled to a node.
+package placement
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+)
+
+// Fits reports whether the scheduler may place a pod with the spec on the
+// node: the node is schedulable, matches the node selector and the required
+// node affinity of the spec, and the spec tolerates the taints keeping pods
+// off it. Resources and inter-pod affinity are left out, they change with
+// every pod scheduled.
+func Fits(node *corev1.Node, spec *corev1.PodSpec) bool {
+	if node.Spec.Unschedulable {
+		taint := corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}
+		if !corev1helpers.TolerationsTolerateTaint(spec.Tolerations, &taint) {
+			return false
+		}
+	}
+
+	matches, err := nodeaffinity.GetRequiredNodeAffinity(&corev1.Pod{Spec: *spec}).Match(node)
+	if err != nil || !matches {
+		return false
+	}
+
+	_, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, spec.Tolerations, func(taint *corev1.Taint) bool {
+		return taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute
+	})
+	return !untolerated
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package placement
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFits(t *testing.T) {
+	node := func(labels map[string]string, unschedulable bool, taints ...corev1.Taint) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+		}
+	}
+	zone := func(zones ...string) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      corev1.LabelTopologyZone,
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   zones,
+					}},
+				}},
+			},
+		}}
+	}
+	edge := corev1.Taint{Key: "edge", Value: "true", Effect: corev1.TaintEffectNoSchedule}
+	preferred := corev1.Taint{Key: "spare", Effect: corev1.TaintEffectPreferNoSchedule}
+	tolerateEdge := []corev1.Toleration{{Key: "edge", Operator: corev1.TolerationOpExists}}
+
+	for _, test := range []struct {
+		name string
+		node *corev1.Node
+		spec corev1.PodSpec
+		want bool
+	}{
+		{
+			name: "any node",
+			node: node(nil, false),
+			want: true,
+		},
+		{
+			name: "unschedulable",
+			node: node(nil, true),
+		},
+		{
+			name: "unschedulable tolerated",
+			node: node(nil, true),
+			spec: corev1.PodSpec{Tolerations: []corev1.Toleration{{
+				Key:      corev1.TaintNodeUnschedulable,
+				Operator: corev1.TolerationOpExists,
+				Effect:   corev1.TaintEffectNoSchedule,
+			}}},
+			want: true,
+		},
+		{
+			name: "node selector",
+			node: node(map[string]string{corev1.LabelArchStable: "arm64"}, false),
+			spec: corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelArchStable: "arm64"}},
+			want: true,
+		},
+		{
+			name: "other architecture",
+			node: node(map[string]string{corev1.LabelArchStable: "amd64"}, false),
+			spec: corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelArchStable: "arm64"}},
+		},
+		{
+			name: "zone",
+			node: node(map[string]string{corev1.LabelTopologyZone: "b"}, false),
+			spec: corev1.PodSpec{Affinity: zone("a", "b")},
+			want: true,
+		},
+		{
+			name: "other zone",
+			node: node(map[string]string{corev1.LabelTopologyZone: "c"}, false),
+			spec: corev1.PodSpec{Affinity: zone("a", "b")},
+		},
+		{
+			name: "taint",
+			node: node(nil, false, edge),
+		},
+		{
+			name: "taint tolerated",
+			node: node(nil, false, edge),
+			spec: corev1.PodSpec{Tolerations: tolerateEdge},
+			want: true,
+		},
+		{
+			name: "preferred taint",
+			node: node(nil, false, preferred),
+			want: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Fits(test.node, &test.spec); got != test.want {
+				t.Errorf("Fits() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
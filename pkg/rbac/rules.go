@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rbac checks RBAC rules against an allowlist of rules.
+package rbac
+
+import (
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Uncovered returns the rules, or parts of them, which no rule of allowed
+// grants. Every verb, group, resource and resource name of a rule has to be
+// granted by a single allowed rule, like the API server checks a Role
+// before it's created.
+func Uncovered(allowed, rules []rbacv1.PolicyRule) []string {
+	var uncovered []string
+	for _, rule := range rules {
+		if len(rule.NonResourceURLs) > 0 {
+			for _, url := range rule.NonResourceURLs {
+				for _, verb := range rule.Verbs {
+					if !coversURL(allowed, verb, url) {
+						uncovered = append(uncovered, fmt.Sprintf("%s %s", verb, url))
+					}
+				}
+			}
+			continue
+		}
+		names := rule.ResourceNames
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					for _, name := range names {
+						if !coversResource(allowed, verb, group, resource, name) {
+							uncovered = append(uncovered, describe(verb, group, resource, name))
+						}
+					}
+				}
+			}
+		}
+	}
+	return uncovered
+}
+
+func coversResource(allowed []rbacv1.PolicyRule, verb, group, resource, name string) bool {
+	for _, rule := range allowed {
+		if matches(rule.Verbs, verb) && matches(rule.APIGroups, group) &&
+			matchesResource(rule.Resources, resource) &&
+			(len(rule.ResourceNames) == 0 || (name != "" && contains(rule.ResourceNames, name))) {
+			return true
+		}
+	}
+	return false
+}
+
+func coversURL(allowed []rbacv1.PolicyRule, verb, url string) bool {
+	for _, rule := range allowed {
+		if !matches(rule.Verbs, verb) {
+			continue
+		}
+		for _, allowedURL := range rule.NonResourceURLs {
+			if allowedURL == url || allowedURL == rbacv1.NonResourceAll ||
+				(strings.HasSuffix(allowedURL, "*") && strings.HasPrefix(url, strings.TrimSuffix(allowedURL, "*"))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches tells whether values grant value. A wildcard is only granted by
+// a wildcard.
+func matches(values []string, value string) bool {
+	return contains(values, rbacv1.ResourceAll) || contains(values, value)
+}
+
+// matchesResource also grants the subresources of "resource/*".
+func matchesResource(resources []string, resource string) bool {
+	if matches(resources, resource) {
+		return true
+	}
+	if idx := strings.Index(resource, "/"); idx > 0 {
+		return contains(resources, resource[:idx]+"/*")
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(verb, group, resource, name string) string {
+	if group != "" {
+		resource += "." + group
+	}
+	if name != "" {
+		resource += "/" + name
+	}
+	return verb + " " + resource
+}
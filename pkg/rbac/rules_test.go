@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestUncovered(t *testing.T) {
+	allowed := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "list", "watch"}},
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"plugin"}, Verbs: []string{"get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"get"}},
+		{APIGroups: []string{""}, Resources: []string{"pods/*"}, Verbs: []string{"get"}},
+		{NonResourceURLs: []string{"/healthz/*"}, Verbs: []string{"get"}},
+	}
+	for _, test := range []struct {
+		name  string
+		rules []rbacv1.PolicyRule
+		want  []string
+	}{
+		{
+			name:  "covered",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "watch"}}},
+		},
+		{
+			name:  "verb",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "delete"}}},
+			want:  []string{"delete configmaps"},
+		},
+		{
+			name:  "wildcard verb",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"*"}}},
+			want:  []string{"* configmaps"},
+		},
+		{
+			name:  "resource name",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"plugin"}, Verbs: []string{"get"}}},
+		},
+		{
+			name:  "all resource names",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}},
+			want:  []string{"get secrets"},
+		},
+		{
+			name:  "wildcard resource",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{"apps"}, Resources: []string{"deployments", "statefulsets"}, Verbs: []string{"get"}}},
+		},
+		{
+			name:  "subresource",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods/log", "pods"}, Verbs: []string{"get"}}},
+			want:  []string{"get pods"},
+		},
+		{
+			name:  "group",
+			rules: []rbacv1.PolicyRule{{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"get"}}},
+			want:  []string{"get jobs.batch"},
+		},
+		{
+			name:  "url",
+			rules: []rbacv1.PolicyRule{{NonResourceURLs: []string{"/healthz/ready", "/metrics"}, Verbs: []string{"get"}}},
+			want:  []string{"get /metrics"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := Uncovered(allowed, test.rules)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
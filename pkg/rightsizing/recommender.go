@@ -0,0 +1,164 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rightsizing recommends container requests from the usage
+// observed over a window, the way the vertical pod autoscaler does in
+// simple: the CPU request covers most samples and the memory request the
+// peak, each with a margin.
+package rightsizing
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// cpuPercentile of the CPU samples is covered by the CPU request.
+	cpuPercentile = 0.9
+	// margin is added on top of the observed usage.
+	margin = 0.15
+
+	minCPU    = 10       // millicores
+	minMemory = 32 << 20 // bytes
+)
+
+// Sample is the usage of a container at a time
+type Sample struct {
+	Time   time.Time
+	CPU    resource.Quantity
+	Memory resource.Quantity
+}
+
+// Recommender keeps the usage samples of a window per key, e.g. per
+// workload, and recommends requests from them. It is safe for concurrent
+// use.
+type Recommender struct {
+	// Window is how long samples are kept.
+	Window time.Duration
+	// MinSamples is the number of samples needed for a recommendation.
+	MinSamples int
+
+	mu      sync.Mutex
+	samples map[string][]Sample
+}
+
+// Add records a sample of key and drops those which left the window.
+func (r *Recommender) Add(key string, sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.samples == nil {
+		r.samples = map[string][]Sample{}
+	}
+	samples := append(r.samples[key], sample)
+	cutoff := sample.Time.Add(-r.Window)
+	first := 0
+	for first < len(samples) && samples[first].Time.Before(cutoff) {
+		first++
+	}
+	r.samples[key] = samples[first:]
+}
+
+// Forget drops the samples of key.
+func (r *Recommender) Forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.samples, key)
+}
+
+// Keys returns the keys with samples.
+func (r *Recommender) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.samples))
+	for key := range r.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Recommend returns the recommended requests of key and the number of
+// samples they are based on, or false while there are too few samples.
+func (r *Recommender) Recommend(key string) (corev1.ResourceList, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := r.samples[key]
+	if len(samples) == 0 || len(samples) < r.MinSamples {
+		return nil, len(samples), false
+	}
+
+	cpu := make([]int64, len(samples))
+	var memory int64
+	for idx, sample := range samples {
+		cpu[idx] = sample.CPU.MilliValue()
+		if value := sample.Memory.Value(); value > memory {
+			memory = value
+		}
+	}
+	sort.Slice(cpu, func(i, j int) bool { return cpu[i] < cpu[j] })
+	millis := withMargin(cpu[int(float64(len(cpu)-1)*cpuPercentile)], minCPU)
+	bytes := withMargin(memory, minMemory)
+
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewMilliQuantity(millis, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(roundMiB(bytes), resource.BinarySI),
+	}, len(samples), true
+}
+
+func withMargin(value, min int64) int64 {
+	value += int64(float64(value) * margin)
+	if value < min {
+		return min
+	}
+	return value
+}
+
+// roundMiB rounds up to whole MiB, which keeps recommendations readable.
+func roundMiB(bytes int64) int64 {
+	const mib = 1 << 20
+	return (bytes + mib - 1) / mib * mib
+}
+
+// overProvisioned is the factor beyond the recommendation from which a
+// request is considered wasteful.
+const overProvisioned = 2
+
+// Compare returns the requests which are missing, below the recommendation
+// or more than twice as large, as human readable findings.
+func Compare(requests, recommended corev1.ResourceList) []string {
+	findings := []string{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		want, ok := recommended[name]
+		if !ok {
+			continue
+		}
+		have, ok := requests[name]
+		switch {
+		case !ok:
+			findings = append(findings, fmt.Sprintf("no %s request, %s recommended", name, want.String()))
+		case have.Cmp(want) < 0:
+			findings = append(findings, fmt.Sprintf("%s request %s is below the recommended %s", name, have.String(), want.String()))
+		case have.MilliValue() > overProvisioned*want.MilliValue():
+			findings = append(findings, fmt.Sprintf("%s request %s is more than twice the recommended %s", name, have.String(), want.String()))
+		}
+	}
+	return findings
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rightsizing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestRecommend(t *testing.T) {
+	r := &Recommender{Window: time.Hour, MinSamples: 3}
+	start := time.Date(2022, 10, 14, 12, 0, 0, 0, time.UTC)
+	add := func(minute int, cpu, memory string) {
+		r.Add("default/ha", Sample{
+			Time:   start.Add(time.Duration(minute) * time.Minute),
+			CPU:    resource.MustParse(cpu),
+			Memory: resource.MustParse(memory),
+		})
+	}
+
+	add(0, "1", "1Gi")
+	add(1, "100m", "100Mi")
+	if _, samples, ok := r.Recommend("default/ha"); ok || samples != 2 {
+		t.Fatalf("recommended with %d samples", samples)
+	}
+
+	// The first sample leaves the window
+	for minute := 2; minute <= 61; minute++ {
+		add(minute, "100m", "100Mi")
+	}
+	add(62, "200m", "200Mi")
+	requests, samples, ok := r.Recommend("default/ha")
+	if !ok || samples != 61 {
+		t.Fatalf("got %d samples, ok %v", samples, ok)
+	}
+	if cpu := requests[corev1.ResourceCPU]; cpu.String() != "115m" {
+		t.Errorf("got cpu %s", cpu.String())
+	}
+	if memory := requests[corev1.ResourceMemory]; memory.String() != "230Mi" {
+		t.Errorf("got memory %s", memory.String())
+	}
+
+	r.Forget("default/ha")
+	if keys := r.Keys(); len(keys) != 0 {
+		t.Errorf("got keys %v", keys)
+	}
+}
+
+func TestRecommendMinimum(t *testing.T) {
+	r := &Recommender{Window: time.Hour}
+	r.Add("idle", Sample{Time: time.Now(), CPU: resource.MustParse("1m"), Memory: resource.MustParse("1Mi")})
+	requests, _, ok := r.Recommend("idle")
+	if !ok {
+		t.Fatal("no recommendation")
+	}
+	if cpu := requests[corev1.ResourceCPU]; cpu.String() != "10m" {
+		t.Errorf("got cpu %s", cpu.String())
+	}
+	if memory := requests[corev1.ResourceMemory]; memory.String() != "32Mi" {
+		t.Errorf("got memory %s", memory.String())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	recommended := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("128Mi"),
+	}
+	for _, tc := range []struct {
+		requests corev1.ResourceList
+		expected []string
+	}{
+		{corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("150m"), corev1.ResourceMemory: resource.MustParse("256Mi")}, nil},
+		{corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			[]string{"cpu request 50m is below the recommended 100m", "memory request 1Gi is more than twice the recommended 128Mi"}},
+		{nil, []string{"no cpu request, 100m recommended", "no memory request, 128Mi recommended"}},
+	} {
+		findings := Compare(tc.requests, recommended)
+		if strings.Join(findings, "; ") != strings.Join(tc.expected, "; ") {
+			t.Errorf("%v: got %v", tc.requests, findings)
+		}
+	}
+}
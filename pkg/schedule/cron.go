@@ -0,0 +1,143 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schedule reads cron schedules and tells when recurring windows
+// are open.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a schedule in the five field cron format: minute, hour, day of
+// month, month and day of week. Fields are "*", numbers, ranges like
+// "1-5", steps like "*/15" or "0-30/10", and lists of them.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// Either day field matches if both are restricted, like cron does.
+	anyDay bool
+}
+
+type field struct {
+	min, max int
+}
+
+var fields = []field{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Parse reads a cron schedule, e.g. "0 22 * * 1-5".
+func Parse(spec string) (*Cron, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("schedule %q has %d fields, expected %d", spec, len(parts), len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	for idx, part := range parts {
+		var err error
+		bits[idx], err = parseField(part, fields[idx])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", spec, err)
+		}
+	}
+	// Sunday is 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		// Like cron, a day field starting with "*", e.g. "*/2", is not a
+		// restriction
+		anyDay: !strings.HasPrefix(parts[2], "*") && !strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(value string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		spec, step := item, 1
+		if before, after, ok := strings.Cut(item, "/"); ok {
+			n, err := strconv.Atoi(after)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			spec, step = before, n
+		}
+		low, high := f.min, f.max
+		if spec != "*" {
+			from, to, isRange := strings.Cut(spec, "-")
+			var err error
+			low, err = strconv.Atoi(from)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(to)
+				if err != nil {
+					return 0, fmt.Errorf("invalid range %q", item)
+				}
+			} else if step > 1 {
+				high = f.max
+			}
+		}
+		if low < f.min || high > f.max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", item, f.min, f.max)
+		}
+		for n := low; n <= high; n += step {
+			bits |= 1 << n
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t the schedule matches, in the
+// location of t. It returns the zero time if it never matches, e.g. on the
+// 31st of February.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// Truncate works in UTC, which is off for half-hour zones
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "0 22 * * 1-5", "*/15 0-6/2 1,15 * 7", "30 6 * 12 *"} {
+		if _, err := Parse(spec); err != nil {
+			t.Errorf("%q: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "0 22 * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q is accepted", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2022, 10, 14, 21, 30, 0, 0, time.UTC) // Friday
+	for spec, expected := range map[string]time.Time{
+		"* * * * *":     time.Date(2022, 10, 14, 21, 31, 0, 0, time.UTC),
+		"0 22 * * *":    time.Date(2022, 10, 14, 22, 0, 0, 0, time.UTC),
+		"0 6 * * 1-5":   time.Date(2022, 10, 17, 6, 0, 0, 0, time.UTC),
+		"0 0 * * 7":     time.Date(2022, 10, 16, 0, 0, 0, 0, time.UTC),
+		"*/20 21 * * *": time.Date(2022, 10, 14, 21, 40, 0, 0, time.UTC),
+		"0 0 1 1 *":     time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":    time.Date(2022, 10, 21, 0, 0, 0, 0, time.UTC),
+		"0 3 */2 * 1":   time.Date(2022, 10, 17, 3, 0, 0, 0, time.UTC),
+		"0 3 14 * */2":  time.Date(2023, 1, 14, 3, 0, 0, 0, time.UTC),
+		"0 0 31 2 *":    {},
+	} {
+		cron, err := Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := cron.Next(from); !next.Equal(expected) {
+			t.Errorf("%q: got %v, expected %v", spec, next, expected)
+		}
+	}
+}
+
+func TestNextHalfHourZone(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2022, 10, 14, 21, 30, 0, 0, kolkata)
+	for spec, expected := range map[string]time.Time{
+		"0 23 * * *":  time.Date(2022, 10, 14, 23, 0, 0, 0, kolkata),
+		"15 6 * * 1":  time.Date(2022, 10, 17, 6, 15, 0, 0, kolkata),
+		"45 21 * * *": time.Date(2022, 10, 14, 21, 45, 0, 0, kolkata),
+	} {
+		cron, err := Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := cron.Next(from); !next.Equal(expected) {
+			t.Errorf("%q: got %v, expected %v", spec, next, expected)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	cron, _ := Parse("0 22 * * *")
+	window := &Window{Start: cron, Duration: 8 * time.Hour}
+
+	for _, tc := range []struct {
+		at     time.Time
+		opened time.Time
+		next   time.Time
+	}{
+		{time.Date(2022, 10, 14, 12, 0, 0, 0, time.UTC), time.Time{}, time.Date(2022, 10, 14, 22, 0, 0, 0, time.UTC)},
+		{time.Date(2022, 10, 14, 22, 0, 0, 0, time.UTC), time.Date(2022, 10, 14, 22, 0, 0, 0, time.UTC), time.Date(2022, 10, 15, 6, 0, 0, 0, time.UTC)},
+		{time.Date(2022, 10, 15, 5, 59, 0, 0, time.UTC), time.Date(2022, 10, 14, 22, 0, 0, 0, time.UTC), time.Date(2022, 10, 15, 6, 0, 0, 0, time.UTC)},
+		{time.Date(2022, 10, 15, 6, 0, 0, 0, time.UTC), time.Time{}, time.Date(2022, 10, 15, 22, 0, 0, 0, time.UTC)},
+	} {
+		opened, open := window.Opened(tc.at)
+		if open != !tc.opened.IsZero() || !opened.Equal(tc.opened) {
+			t.Errorf("%v: got opened %v, expected %v", tc.at, opened, tc.opened)
+		}
+		if next := window.NextChange(tc.at); !next.Equal(tc.next) {
+			t.Errorf("%v: got next change %v, expected %v", tc.at, next, tc.next)
+		}
+	}
+}
+
+func TestWindowLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	cron, _ := Parse("0 22 * * *")
+	window := &Window{Start: cron, Duration: time.Hour, Location: berlin}
+	if _, open := window.Opened(time.Date(2022, 10, 14, 20, 30, 0, 0, time.UTC)); !open {
+		t.Error("window is not open at 22:30 in Berlin")
+	}
+}
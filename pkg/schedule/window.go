@@ -0,0 +1,61 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"time"
+)
+
+// Window is a recurring window, opening on a cron schedule and staying
+// open for a duration.
+type Window struct {
+	Start    *Cron
+	Duration time.Duration
+	Location *time.Location
+}
+
+// Opened returns when the window last opened if it is open at t.
+func (w *Window) Opened(t time.Time) (time.Time, bool) {
+	t = t.In(w.location())
+	var opened time.Time
+	// The window is open if it opened within the duration before t
+	for start := w.Start.Next(t.Add(-w.Duration).Add(-time.Minute)); !start.IsZero() && !start.After(t); start = w.Start.Next(start) {
+		if t.Before(start.Add(w.Duration)) {
+			opened = start
+		}
+	}
+	return opened, !opened.IsZero()
+}
+
+// NextChange returns the next time after t the window opens or closes.
+func (w *Window) NextChange(t time.Time) time.Time {
+	t = t.In(w.location())
+	next := w.Start.Next(t)
+	if opened, open := w.Opened(t); open {
+		if closes := opened.Add(w.Duration); next.IsZero() || closes.Before(next) {
+			next = closes
+		}
+	}
+	return next
+}
+
+func (w *Window) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
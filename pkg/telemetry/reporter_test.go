@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	prairiev1 "github.com/Tenacher/prairie-operator/api/v1"
+)
+
+func TestReport(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = prairiev1.AddToScheme(scheme)
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "3b1c"}},
+		&prairiev1.HomeAgent{ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "default"}},
+		&prairiev1.HomeAgent{ObjectMeta: metav1.ObjectMeta{Name: "ha", Namespace: "edge"}},
+	).Build()
+
+	reports := make(chan Report, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var report Report
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reports <- report
+	}))
+	defer server.Close()
+
+	reporter := &Reporter{
+		Endpoint: server.URL,
+		Version:  "0.1.0",
+		Features: map[string]bool{"Vault": true},
+		Reader:   reader,
+		Scheme:   scheme,
+		Group:    prairiev1.GroupVersion.Group,
+	}
+	if err := reporter.report(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	report := <-reports
+	if report.Installation == "" || report.Installation == "3b1c" {
+		t.Errorf("got installation %q", report.Installation)
+	}
+	if report.Version != "0.1.0" || !report.Features["Vault"] {
+		t.Errorf("got %+v", report)
+	}
+	if report.Resources["HomeAgent"] != 2 || report.Resources["MobileNode"] != 0 {
+		t.Errorf("got resources %v", report.Resources)
+	}
+	if _, ok := report.Resources["Pod"]; ok {
+		t.Errorf("resources of other groups are reported: %v", report.Resources)
+	}
+}
+
+func TestReportRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	reporter := &Reporter{
+		Endpoint: server.URL,
+		Reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		).Build(),
+		Scheme: scheme,
+		Group:  prairiev1.GroupVersion.Group,
+	}
+	if err := reporter.report(context.Background()); err == nil {
+		t.Error("expected an error")
+	}
+}
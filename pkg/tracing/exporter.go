@@ -0,0 +1,96 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	exportInterval  = 5 * time.Second
+	batchSize       = 512
+	queueSize       = 4096
+	shutdownTimeout = 10 * time.Second
+)
+
+// Exporter sends the finished spans in batches to the OTLP/HTTP endpoint of
+// a collector, e.g. http://otel-collector:4318, with the OpenTelemetry SDK.
+// Spans are dropped while the queue is full rather than slowing down the
+// reconciles.
+type Exporter struct {
+	Endpoint string
+	Service  string
+	// Log receives the export errors, defaults to the logger of the context
+	// the Exporter is started with.
+	Log logr.Logger
+}
+
+// NewExporter returns an Exporter for the endpoint.
+func NewExporter(endpoint, service string) *Exporter {
+	return &Exporter{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Service:  service,
+	}
+}
+
+// Start records spans and exports them until the context is done. It makes
+// the Exporter a Runnable of the controller manager.
+func (e *Exporter) Start(ctx context.Context) error {
+	logger := e.Log
+	if logger.GetSink() == nil {
+		logger = log.FromContext(ctx).WithName("tracing")
+	}
+	// Failed exports are logged, the SDK would print them to stderr
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Error(err, "Spans could not be exported.", "endpoint", e.Endpoint)
+	}))
+
+	client, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(e.Endpoint+"/v1/traces"))
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(client,
+			sdktrace.WithBatchTimeout(exportInterval),
+			sdktrace.WithMaxExportBatchSize(batchSize),
+			sdktrace.WithMaxQueueSize(queueSize)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(e.Service))),
+	)
+	active.Store(&tracer{provider.Tracer(scopeName)})
+	<-ctx.Done()
+	active.Store(&tracer{})
+
+	// The spans still queued are flushed before the operator exits
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return provider.Shutdown(shutdown)
+}
+
+// NeedLeaderElection is false, standby replicas don't record spans anyway.
+func (e *Exporter) NeedLeaderElection() bool {
+	return false
+}
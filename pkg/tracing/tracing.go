@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records spans of the reconcile operations and exports
+// them to an OpenTelemetry collector over OTLP/HTTP. Spans are only
+// recorded once an Exporter is running, otherwise they cost next to
+// nothing.
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scopeName = "github.com/Tenacher/prairie-operator"
+
+// tracer wraps the tracer of the running Exporter, nil if there is none.
+type tracer struct {
+	trace.Tracer
+}
+
+var active atomic.Value
+
+// Attribute is a key-value pair describing a span.
+type Attribute = attribute.KeyValue
+
+// String returns an Attribute.
+func String(key, value string) Attribute {
+	return attribute.String(key, value)
+}
+
+// Span is a timed operation. The methods of a nil Span do nothing.
+type Span struct {
+	span trace.Span
+}
+
+// Start starts a span, as child of the span of the context if there is
+// one.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	current, _ := active.Load().(*tracer)
+	if current == nil || current.Tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, span := current.Start(ctx, name, trace.WithAttributes(attributes...))
+	return ctx, &Span{span: span}
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributes...)
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Finish ends the span and hands it to the exporter.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// Steps traces consecutive steps of an operation, each step ends when the
+// next one starts. It keeps early returns from leaving steps open.
+type Steps struct {
+	parent  trace.Span
+	current *Span
+}
+
+// NewSteps returns the steps of the operation traced by the context.
+func NewSteps(ctx context.Context) *Steps {
+	return &Steps{parent: trace.SpanFromContext(ctx)}
+}
+
+// Next ends the current step and starts the next one, as child of the
+// operation rather than of the step before. The returned context carries
+// the values of ctx and the step, so that the spans started with it nest
+// below the step.
+func (s *Steps) Next(ctx context.Context, name string) context.Context {
+	s.current.Finish()
+	ctx, span := Start(trace.ContextWithSpan(ctx, s.parent), name)
+	s.current = span
+	return ctx
+}
+
+// Finish ends the current step.
+func (s *Steps) Finish() {
+	s.current.Finish()
+	s.current = nil
+}
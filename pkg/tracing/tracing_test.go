@@ -0,0 +1,145 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// run starts the exporter and returns a function stopping it.
+func run(t *testing.T, exporter *Exporter) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- exporter.Start(ctx) }()
+	for {
+		if current, _ := active.Load().(*tracer); current != nil && current.Tracer != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestExport(t *testing.T) {
+	requests := make(chan *collectorv1.ExportTraceServiceRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		request := &collectorv1.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request
+	}))
+	defer collector.Close()
+
+	if _, span := Start(context.Background(), "disabled"); span != nil {
+		t.Fatal("recorded a span without an exporter")
+	}
+
+	stop := run(t, NewExporter(collector.URL+"/", "prairie-operator"))
+	traced, reconcile := Start(context.Background(), "Reconcile", String("namespace", "default"))
+	steps := NewSteps(traced)
+	get := steps.Next(traced, "get")
+	_, client := Start(get, "client")
+	client.Finish()
+	steps.Next(get, "render")
+	steps.Finish()
+	reconcile.SetError(errors.New("conflict"))
+	reconcile.Finish()
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %v", request)
+	}
+	service := ""
+	for _, attr := range request.ResourceSpans[0].Resource.Attributes {
+		if attr.Key == "service.name" {
+			service = attr.Value.GetStringValue()
+		}
+	}
+	if service != "prairie-operator" {
+		t.Errorf("got service %q", service)
+	}
+	spans := map[string]*tracev1.Span{}
+	for _, span := range request.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+	root := spans["Reconcile"]
+	if root == nil || len(root.ParentSpanId) != 0 || root.Status.Code != tracev1.Status_STATUS_CODE_ERROR || root.Status.Message != "conflict" {
+		t.Fatalf("unexpected root span %v", root)
+	}
+	for _, name := range []string{"get", "render"} {
+		step := spans[name]
+		if step == nil || string(step.TraceId) != string(root.TraceId) || string(step.ParentSpanId) != string(root.SpanId) {
+			t.Errorf("step %s is not a child of the reconcile: %v", name, step)
+		}
+	}
+	if nested := spans["client"]; nested == nil || string(nested.ParentSpanId) != string(spans["get"].SpanId) {
+		t.Errorf("span started with the step context is not a child of the step: %v", nested)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	var lock sync.Mutex
+	logged := []string{}
+	exporter := NewExporter(collector.URL, "prairie-operator")
+	exporter.Log = funcr.New(func(prefix, args string) {
+		lock.Lock()
+		defer lock.Unlock()
+		logged = append(logged, args)
+	}, funcr.Options{})
+
+	stop := run(t, exporter)
+	_, span := Start(context.Background(), "Reconcile")
+	span.Finish()
+	stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(logged) == 0 || !strings.Contains(logged[0], "Spans could not be exported.") {
+		t.Errorf("the failed export was not logged: %v", logged)
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault is a minimal client of HashiCorp Vault built on its API
+// package. It logs in through the Kubernetes auth method, once per role,
+// reads and writes KV version 2 secrets and keeps the tokens of the roles
+// renewed in the background.
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// DefaultAuthMount is the mount path of the Kubernetes auth method.
+	DefaultAuthMount = "kubernetes"
+	// DefaultJWTPath is the service account token the operator logs in with.
+	DefaultJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	defaultTimeout = 10 * time.Second
+	renewInterval  = 30 * time.Second
+)
+
+// token is the Vault token of a role and its lease.
+type token struct {
+	Value     string
+	Renewable bool
+	TTL       time.Duration
+	Expires   time.Time
+}
+
+// due reports whether the token has less than a third of its lease left.
+func (t *token) due(now time.Time) bool {
+	return now.Add(t.TTL / 3).After(t.Expires)
+}
+
+// Client talks to a single Vault server
+type Client struct {
+	AuthMount string
+	JWTPath   string
+
+	api *api.Client
+
+	mu     sync.Mutex
+	tokens map[string]*token
+}
+
+// NewClient returns a Client for the Vault server at address. The keys
+// only travel over TLS, so the address has to be an https URL. The server
+// certificate is verified with the CA certificates in caFile, or the
+// system roots if empty.
+func NewClient(address, caFile string) (*Client, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "https" {
+		return nil, fmt.Errorf("vault address %s does not use https", address)
+	}
+
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	config.Address = strings.TrimSuffix(address, "/")
+	config.Timeout = defaultTimeout
+	if caFile != "" {
+		if err := config.ConfigureTLS(&api.TLSConfig{CACert: caFile}); err != nil {
+			return nil, err
+		}
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	// The token of a role is set per request
+	client.ClearToken()
+	return &Client{
+		AuthMount: DefaultAuthMount,
+		JWTPath:   DefaultJWTPath,
+		api:       client,
+		tokens:    map[string]*token{},
+	}, nil
+}
+
+// as returns a client authenticated with the token value.
+func (c *Client) as(value string) (*api.Client, error) {
+	client, err := c.api.Clone()
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken(value)
+	return client, nil
+}
+
+// login authenticates as the role with the service account token.
+func (c *Client) login(ctx context.Context, role string) (*token, error) {
+	jwt, err := os.ReadFile(c.JWTPath)
+	if err != nil {
+		return nil, err
+	}
+	client, err := c.as("")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.Logical().WriteWithContext(ctx, "auth/"+c.AuthMount+"/login", map[string]interface{}{
+		"role": role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("login as %s returned no token", role)
+	}
+	return newToken(secret.Auth), nil
+}
+
+func newToken(auth *api.SecretAuth) *token {
+	ttl := time.Duration(auth.LeaseDuration) * time.Second
+	return &token{
+		Value:     auth.ClientToken,
+		Renewable: auth.Renewable,
+		TTL:       ttl,
+		Expires:   time.Now().Add(ttl),
+	}
+}
+
+// token returns a client with the token of the role, logging in if there
+// is none or it expired. The lock isn't held while logging in, concurrent
+// logins of a role keep the token of the last one.
+func (c *Client) token(ctx context.Context, role string) (*api.Client, error) {
+	c.mu.Lock()
+	t, ok := c.tokens[role]
+	c.mu.Unlock()
+	if ok && time.Now().Before(t.Expires) {
+		return c.as(t.Value)
+	}
+
+	t, err := c.login(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[role] = t
+	c.mu.Unlock()
+	return c.as(t.Value)
+}
+
+// splitPath splits a KV version 2 path, e.g. secret/prairie/ha, into the
+// mount of the engine and the path of the secret.
+func splitPath(path string) (string, string) {
+	mount, rest, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	return mount, rest
+}
+
+// Read returns the latest version of the KV secret at path, nil if there is
+// none.
+func (c *Client) Read(ctx context.Context, role, path string) (map[string]string, error) {
+	client, err := c.token(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+	mount, rest := splitPath(path)
+	secret, err := client.KVv2(mount).Get(ctx, rest)
+	if errors.Is(err, api.ErrSecretNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{}
+	for key, value := range secret.Data {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of %s in %s is not a string", key, path)
+		}
+		data[key] = text
+	}
+	return data, nil
+}
+
+// Write stores data as the new version of the KV secret at path.
+func (c *Client) Write(ctx context.Context, role, path string, data map[string]string) error {
+	client, err := c.token(ctx, role)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		values[key] = value
+	}
+	mount, rest := splitPath(path)
+	_, err = client.KVv2(mount).Put(ctx, rest, values)
+	return err
+}
+
+// RenewTokens renews the tokens whose lease is running out. Tokens which
+// can't be renewed are dropped, the next request logs in again.
+func (c *Client) RenewTokens(ctx context.Context) {
+	now := time.Now()
+	due := map[string]*token{}
+	c.mu.Lock()
+	for role, t := range c.tokens {
+		if t.due(now) {
+			due[role] = t
+		}
+	}
+	c.mu.Unlock()
+
+	for role, t := range due {
+		var renewed *token
+		if t.Renewable {
+			renewed = c.renew(ctx, t)
+		}
+		c.mu.Lock()
+		// A token replaced by a login in the meantime is kept
+		if c.tokens[role] == t {
+			if renewed != nil {
+				c.tokens[role] = renewed
+			} else {
+				delete(c.tokens, role)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// renew extends the lease of t, nil if it can't be renewed.
+func (c *Client) renew(ctx context.Context, t *token) *token {
+	client, err := c.as(t.Value)
+	if err != nil {
+		return nil
+	}
+	secret, err := client.Auth().Token().RenewSelfWithContext(ctx, 0)
+	if err != nil || secret == nil || secret.Auth == nil {
+		return nil
+	}
+	return newToken(secret.Auth)
+}
+
+// Start renews the tokens until the context is done. It makes the Client a
+// Runnable of the controller manager.
+func (c *Client) Start(ctx context.Context) error {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.RenewTokens(ctx)
+		}
+	}
+}
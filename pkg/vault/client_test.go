@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// server starts a fake Vault holding KV secrets in memory and returns a
+// client logged in through it.
+func server(t *testing.T, lease int64) (*Client, *int) {
+	secrets := map[string]map[string]string{}
+	logins := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/kubernetes/login", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["role"] != "prairie" || body["jwt"] != "sa-token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		logins++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": "s.token", "lease_duration": lease, "renewable": true},
+		})
+	})
+	mux.HandleFunc("/v1/secret/data/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := secrets[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 1},
+			}})
+		case http.MethodPost, http.MethodPut:
+			body := struct {
+				Data map[string]string `json:"data"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			secrets[r.URL.Path] = body.Data
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"version": 1}})
+		}
+	})
+	vault := httptest.NewTLSServer(mux)
+	t.Cleanup(vault.Close)
+
+	dir := t.TempDir()
+	jwt := filepath.Join(dir, "token")
+	if err := os.WriteFile(jwt, []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ca := filepath.Join(dir, "ca.crt")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vault.Certificate().Raw})
+	if err := os.WriteFile(ca, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(vault.URL, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.JWTPath = jwt
+	return client, &logins
+}
+
+func TestReadWrite(t *testing.T) {
+	client, logins := server(t, 3600)
+	ctx := context.Background()
+
+	data, err := client.Read(ctx, "prairie", "secret/prairie/ha-sample")
+	if err != nil || data != nil {
+		t.Fatalf("expected no secret, got %v, %v", data, err)
+	}
+	if err := client.Write(ctx, "prairie", "secret/prairie/ha-sample", map[string]string{"active": "300"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = client.Read(ctx, "prairie", "secret/prairie/ha-sample")
+	if err != nil || data["active"] != "300" {
+		t.Errorf("unexpected secret %v, %v", data, err)
+	}
+	if *logins != 1 {
+		t.Errorf("expected a single login, got %d", *logins)
+	}
+
+	if _, err := client.Read(ctx, "other", "secret/prairie/ha-sample"); err == nil {
+		t.Error("login with an unknown role succeeded")
+	}
+}
+
+func TestRenewTokens(t *testing.T) {
+	client, _ := server(t, 1)
+	ctx := context.Background()
+	if _, err := client.Read(ctx, "prairie", "secret/prairie/ha-sample"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake server has no renew endpoint, the token is dropped
+	time.Sleep(time.Second)
+	client.RenewTokens(ctx)
+	if _, ok := client.tokens["prairie"]; ok {
+		t.Error("token which could not be renewed was kept")
+	}
+}
+
+func TestNewClientRequiresTLS(t *testing.T) {
+	if _, err := NewClient("http://vault.example:8200", ""); err == nil {
+		t.Error("plain HTTP address accepted")
+	}
+	if _, err := NewClient("https://vault.example:8200", ""); err != nil {
+		t.Errorf("HTTPS address rejected: %v", err)
+	}
+}
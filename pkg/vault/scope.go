@@ -0,0 +1,42 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckScope makes sure a HomeAgent only uses the Vault role and path of
+// its own namespace, so it can't make the operator read or overwrite the
+// keys of another namespace: the role has to be the namespace or start with
+// "<namespace>-", the path has to lie below "<mount>/<namespace>/".
+func CheckScope(namespace, role, path string) error {
+	if role != namespace && !strings.HasPrefix(role, namespace+"-") {
+		return fmt.Errorf("role %s is not a role of namespace %s, it has to start with %s-", role, namespace, namespace)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || parts[1] != namespace {
+		return fmt.Errorf("path %s does not lie below <mount>/%s/", path, namespace)
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("path %s is not clean", path)
+		}
+	}
+	return nil
+}
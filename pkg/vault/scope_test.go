@@ -0,0 +1,40 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import "testing"
+
+func TestCheckScope(t *testing.T) {
+	for _, test := range []struct {
+		role, path string
+		valid      bool
+	}{
+		{"prairie", "secret/prairie/ha-sample", true},
+		{"prairie-ha", "secret/prairie/agents/ha-sample", true},
+		{"prairie2", "secret/prairie/ha-sample", false},
+		{"other", "secret/prairie/ha-sample", false},
+		{"prairie", "secret/other/ha-sample", false},
+		{"prairie", "secret/prairie", false},
+		{"prairie", "secret/prairie/../other/ha-sample", false},
+		{"prairie", "secret/prairie//ha-sample", false},
+	} {
+		err := CheckScope("prairie", test.role, test.path)
+		if (err == nil) != test.valid {
+			t.Errorf("role %s, path %s: unexpected result %v", test.role, test.path, err)
+		}
+	}
+}